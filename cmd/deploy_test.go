@@ -1768,6 +1768,48 @@ func TestDeploy_RemotePersists(t *testing.T) {
 	}
 }
 
+// TestDeploy_RemoteBuildType ensures that functions configured to be built
+// on-cluster (build.type) are deployed remotely by default, and that
+// --remote=false then fails instead of building them locally.
+func TestDeploy_RemoteBuildType(t *testing.T) {
+	root := FromTempDirectory(t)
+
+	f, err := fn.New().Init(fn.Function{Runtime: "go", Root: root, Registry: TestRegistry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Build.Type = fn.BuildTypeOnCluster
+	if err = f.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		builder   = mock.NewBuilder()
+		pipeliner = mock.NewPipelinesProvider()
+	)
+	cmd := NewDeployCmd(NewTestClient(
+		fn.WithBuilder(builder),
+		fn.WithPipelinesProvider(pipeliner),
+		fn.WithRegistry(TestRegistry),
+	))
+	cmd.SetArgs([]string{})
+	if err = cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !pipeliner.RunInvoked {
+		t.Fatal("expected the function to be deployed remotely")
+	}
+	if builder.BuildInvoked {
+		t.Fatal("expected the function not to be built locally")
+	}
+
+	viper.Reset()
+	cmd.SetArgs([]string{"--remote=false"})
+	if err = cmd.Execute(); !errors.Is(err, fn.ErrBuildOnCluster) {
+		t.Fatalf("expected ErrBuildOnCluster for a local deployment, got %v", err)
+	}
+}
+
 // TestDeploy_UnsetFlag ensures that unsetting a flag on the command
 // line causes the pertinent value to be zeroed out.
 func TestDeploy_UnsetFlag(t *testing.T) {
@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -19,7 +20,6 @@ import (
 	fn "knative.dev/func/pkg/functions"
 	"knative.dev/func/pkg/k8s"
 	"knative.dev/func/pkg/knative"
-	"knative.dev/func/pkg/scaffolding"
 )
 
 func main() {
@@ -78,33 +78,12 @@ func scaffold(ctx context.Context) error {
 		return fmt.Errorf("cannot load func project: %w", err)
 	}
 
-	if f.Runtime != "go" {
-		// Scaffolding is for now supported/needed only for Go.
+	err = s2i.Scaffold(f, filepath.Join(f.Root, ".s2i"))
+	if errors.Is(err, s2i.ErrScaffoldingNotSupported) {
+		// Functions of runtimes which are not scaffolded are built as-is.
 		return nil
 	}
-
-	embeddedRepo, err := fn.NewRepository("", "")
-	if err != nil {
-		return fmt.Errorf("cannot initialize repository: %w", err)
-	}
-
-	appRoot := filepath.Join(f.Root, ".s2i", "builds", "last")
-	_ = os.RemoveAll(appRoot)
-
-	err = scaffolding.Write(appRoot, f.Root, f.Runtime, f.Invoke, embeddedRepo.FS())
-	if err != nil {
-		return fmt.Errorf("cannot write the scaffolding: %w", err)
-	}
-
-	if err := os.MkdirAll(filepath.Join(f.Root, ".s2i", "bin"), 0755); err != nil {
-		return fmt.Errorf("unable to create .s2i bin dir. %w", err)
-	}
-
-	if err := os.WriteFile(filepath.Join(f.Root, ".s2i", "bin", "assemble"), []byte(s2i.GoAssembler), 0755); err != nil {
-		return fmt.Errorf("unable to write go assembler. %w", err)
-	}
-
-	return nil
+	return err
 }
 
 func s2iCmd(ctx context.Context) error {
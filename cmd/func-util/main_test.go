@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestScaffold ensures that the assemble script scaffolded for builds on
+// cluster is executable by all users, as the build runs it as a user other
+// than that which scaffolded it.
+func TestScaffold(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not of Windows")
+	}
+	tests := []struct {
+		runtime string
+		files   map[string]string
+	}{
+		{runtime: "go", files: map[string]string{"f.go": "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"}},
+		{runtime: "typescript", files: map[string]string{"package.json": "{}\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.runtime, func(t *testing.T) {
+			root := t.TempDir()
+			if err := (fn.Function{Root: root, Name: "f", Runtime: tt.runtime}).Write(); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			args := os.Args
+			t.Cleanup(func() { os.Args = args })
+			os.Args = []string{"scaffold", root}
+			if err := scaffold(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			fi, err := os.Stat(filepath.Join(root, ".s2i", "bin", "assemble"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0755 {
+				t.Fatalf("expected the assemble script to be of mode 0755, got %v", fi.Mode().Perm())
+			}
+		})
+	}
+}
@@ -19,6 +19,17 @@ const (
 	Default = Pack
 )
 
+// ImageFormat is the format, in terms of media types, of built images.
+type ImageFormat string
+
+const (
+	// DockerV2 images use the media types of the Docker image manifest
+	// v2, schema 2.
+	DockerV2 ImageFormat = "docker"
+	// OCI images use the media types of the OCI image spec.
+	OCI ImageFormat = "oci"
+)
+
 // Known builder names with a pretty-printed string representation
 type Known []string
 
@@ -0,0 +1,160 @@
+package s2i
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// artifact to be extracted from the built image.
+type artifact struct {
+	imagePath string // absolute path within the image
+	hostPath  string // destination on the host
+}
+
+// WithArtifactExtract copies the file or directory at imagePath of the built
+// image to hostPath once the build succeeds, as would `docker create`
+// followed by `docker cp`.  A directory is copied as hostPath itself, not
+// into it.  May be given multiple times.  Requires a tagged image and a
+// docker client capable of creating containers.
+func WithArtifactExtract(imagePath, hostPath string) Option {
+	return func(b *Builder) {
+		b.artifacts = append(b.artifacts, artifact{imagePath: imagePath, hostPath: hostPath})
+	}
+}
+
+// containerClient is implemented by docker clients capable of creating
+// containers, as is required to extract artifacts.
+type containerClient interface {
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerRemove(ctx context.Context, container string, options container.RemoveOptions) error
+	CopyFromContainer(ctx context.Context, container, srcPath string) (io.ReadCloser, container.PathStat, error)
+}
+
+// validateArtifacts returns an error if any artifact is not extractable.
+func (b *Builder) validateArtifacts() error {
+	for _, a := range b.artifacts {
+		if !path.IsAbs(a.imagePath) || path.Clean(a.imagePath) == "/" {
+			return fmt.Errorf("invalid artifact %q: must be an absolute path other than /", a.imagePath)
+		}
+		if a.hostPath == "" {
+			return fmt.Errorf("invalid artifact %q: a host path is required", a.imagePath)
+		}
+	}
+	return nil
+}
+
+// extractArtifacts of the builder from the image via a container created,
+// but not started, for the purpose.
+func (b *Builder) extractArtifacts(ctx context.Context, client DockerClient, image string) (err error) {
+	cc, ok := client.(containerClient)
+	if !ok {
+		return errors.New("cannot extract artifacts: the docker client does not support containers")
+	}
+	c, err := cc.ContainerCreate(ctx, &container.Config{Image: image}, nil, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("cannot create a container of the image %q: %w", image, err)
+	}
+	defer func() {
+		if e := cc.ContainerRemove(context.WithoutCancel(ctx), c.ID, container.RemoveOptions{Force: true}); e != nil && err == nil {
+			err = fmt.Errorf("cannot remove the container %q: %w", c.ID, e)
+		}
+	}()
+
+	for _, a := range b.artifacts {
+		if err = extractArtifact(ctx, cc, c.ID, a); err != nil {
+			return
+		}
+		b.logf(LogLevelInfo, "Extracted %s to %s", a.imagePath, a.hostPath)
+	}
+	return
+}
+
+// extractArtifact from the container.
+func extractArtifact(ctx context.Context, cc containerClient, id string, a artifact) error {
+	rc, _, err := cc.CopyFromContainer(ctx, id, a.imagePath)
+	if err != nil {
+		return fmt.Errorf("cannot copy %q from the image: %w", a.imagePath, err)
+	}
+	defer rc.Close()
+	if err = untarArtifact(rc, path.Base(path.Clean(a.imagePath)), a.hostPath); err != nil {
+		return fmt.Errorf("cannot extract %q to %q: %w", a.imagePath, a.hostPath, err)
+	}
+	return nil
+}
+
+// untarArtifact writes the archive of the artifact named base, as produced by
+// the copy API, to dst.  Symlinks are created last, such that no entry is
+// written through a link of the archive.
+func untarArtifact(r io.Reader, base, dst string) error {
+	var links []*tar.Header
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		rel, ok := strings.CutPrefix(path.Clean(hdr.Name), base)
+		if !ok || (rel != "" && rel[0] != '/') {
+			return fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+		target := filepath.Join(dst, filepath.FromSlash(rel))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, hdr.FileInfo().Mode().Perm()|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = writeArtifactFile(tr, target, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			hdr.Name = target
+			links = append(links, hdr)
+		default:
+			return fmt.Errorf("unsupported archive entry %q of type %c", hdr.Name, hdr.Typeflag)
+		}
+	}
+	for _, hdr := range links {
+		if err := os.Remove(hdr.Name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, hdr.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeArtifactFile replaces the file at target with the contents of r.
+func writeArtifactFile(r io.Reader, target string, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
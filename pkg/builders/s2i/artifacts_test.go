@@ -0,0 +1,164 @@
+package s2i_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// mockContainerDocker is a mock docker client capable of creating containers,
+// whose files are the archives of copy and the paths of paths.
+type mockContainerDocker struct {
+	mockDocker
+	copy    map[string][]byte // archive by path
+	paths   []string          // paths which exist
+	created []string          // images of the containers created
+	removed []string          // ids of the containers removed
+}
+
+func (m *mockContainerDocker) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	m.created = append(m.created, config.Image)
+	return container.CreateResponse{ID: "c1"}, nil
+}
+
+func (m *mockContainerDocker) ContainerRemove(ctx context.Context, id string, options container.RemoveOptions) error {
+	m.removed = append(m.removed, id)
+	return nil
+}
+
+func (m *mockContainerDocker) CopyFromContainer(ctx context.Context, id, srcPath string) (io.ReadCloser, container.PathStat, error) {
+	return io.NopCloser(bytes.NewReader(m.copy[srcPath])), container.PathStat{}, nil
+}
+
+func (m *mockContainerDocker) ContainerStatPath(ctx context.Context, id, path string) (container.PathStat, error) {
+	if !slices.Contains(m.paths, path) {
+		return container.PathStat{}, notFoundErr{}
+	}
+	return container.PathStat{Name: filepath.Base(path)}, nil
+}
+
+// tarOf returns an archive of the files, directories (names ending in /) and
+// symlinks (contents starting with ->) given.
+func tarOf(t *testing.T, entries ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e[0], Mode: 0755, Typeflag: tar.TypeReg, Size: int64(len(e[1]))}
+		switch {
+		case strings.HasSuffix(e[0], "/"):
+			hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+		case strings.HasPrefix(e[1], "->"):
+			hdr.Typeflag, hdr.Size, hdr.Linkname = tar.TypeSymlink, 0, strings.TrimPrefix(e[1], "->")
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e[1])); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestBuildArtifactExtract ensures that artifacts are copied from a container
+// of the built image to the host, and that the container is removed.
+func TestBuildArtifactExtract(t *testing.T) {
+	dst := t.TempDir()
+	cli := &mockContainerDocker{copy: map[string][]byte{
+		"/workspace/bin": tarOf(t,
+			[2]string{"bin/", ""},
+			[2]string{"bin/app", "binary"},
+			[2]string{"bin/lib/", ""},
+			[2]string{"bin/lib/data.txt", "data"},
+			[2]string{"bin/current", "->app"}),
+		"/workspace/VERSION": tarOf(t, [2]string{"VERSION", "1.0.0"}),
+	}}
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli),
+		s2i.WithArtifactExtract("/workspace/bin", filepath.Join(dst, "out")),
+		s2i.WithArtifactExtract("/workspace/VERSION", filepath.Join(dst, "VERSION")))
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/fn:latest"}}
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]string{"out/app": "binary", "out/lib/data.txt": "data", "out/current": "binary", "VERSION": "1.0.0"} {
+		bb, err := os.ReadFile(filepath.Join(dst, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bb) != want {
+			t.Errorf("expected %s to contain %q, got %q", p, want, bb)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dst, "out/app")); err != nil || fi.Mode().Perm()&0100 == 0 {
+		t.Errorf("expected the extracted binary to be executable, got %v", err)
+	}
+	if len(cli.created) != 1 || cli.created[0] != f.Build.Image {
+		t.Errorf("expected a container of %s to be created, got %v", f.Build.Image, cli.created)
+	}
+	if len(cli.removed) != 1 || cli.removed[0] != "c1" {
+		t.Errorf("expected the container to be removed, got %v", cli.removed)
+	}
+}
+
+// TestBuildArtifactExtractInvalid ensures that archive entries outside of the
+// artifact are rejected, as are invalid artifact paths.
+func TestBuildArtifactExtractInvalid(t *testing.T) {
+	dst := t.TempDir()
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/fn:latest"}}
+
+	cli := &mockContainerDocker{copy: map[string][]byte{
+		"/workspace/bin": tarOf(t, [2]string{"bin/../../escaped", "x"}),
+	}}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli),
+		s2i.WithArtifactExtract("/workspace/bin", filepath.Join(dst, "out")))
+	if err := b.Build(context.Background(), f, nil); err == nil {
+		t.Fatal("expected an error extracting an entry outside of the artifact")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("expected no file outside of the artifact, got %v", err)
+	}
+	if len(cli.removed) != 1 {
+		t.Errorf("expected the container to be removed, got %v", cli.removed)
+	}
+
+	for _, p := range []string{"workspace/bin", "/"} {
+		b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithArtifactExtract(p, dst))
+		if err := b.Build(context.Background(), f, nil); err == nil {
+			t.Errorf("expected an error extracting %q", p)
+		}
+	}
+	// Untagged images are rejected before they are built.
+	built := false
+	untagged := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { built = true; return nil, nil }}
+	b = s2i.NewBuilder(s2i.WithImpl(untagged), s2i.WithDockerClient(cli), s2i.WithArtifactExtract("/workspace/bin", dst))
+	if err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error extracting artifacts of an untagged image, got %v", err)
+	}
+	if built {
+		t.Error("expected the untagged image not to be built")
+	}
+}
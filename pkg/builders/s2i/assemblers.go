@@ -1,21 +1,127 @@
 package s2i
 
 import (
+	"context"
 	"fmt"
 
-	fn "knative.dev/func/pkg/functions"
+	"github.com/docker/docker/api/types/container"
+	dockerClient "github.com/docker/docker/client"
 )
 
+// DefaultAssembleShell is the shell of the assemble scripts written by
+// scaffolding, which are POSIX shell scripts such that they run in minimal
+// builder images lacking bash.
+const DefaultAssembleShell = "/bin/sh"
+
+// WithAssembleShell sets the path of the shell, within the builder image, of
+// the assemble scripts written by scaffolding (default DefaultAssembleShell).
+// The shell must be POSIX compliant, such as /bin/bash or /bin/ash.  If the
+// builder image is present locally and the docker client supports
+// containers, the shell is verified to exist in it before the build.
+func WithAssembleShell(shell string) Option {
+	return func(b *Builder) {
+		b.assembleShell = shell
+	}
+}
+
+// verifyAssembleShell returns an error if the builder image lacks the
+// assemble shell.  The shell is verified only if the image is present locally
+// and the docker client supports containers, via a container created, but not
+// started, for the purpose.
+func (b *Builder) verifyAssembleShell(ctx context.Context, client DockerClient, image string) error {
+	c, ok := client.(interface {
+		containerClient
+		ContainerStatPath(ctx context.Context, container, path string) (container.PathStat, error)
+	})
+	if !ok {
+		b.logf(LogLevelDebug, "Not verifying the assemble shell: the docker client does not support containers")
+		return nil
+	}
+	if _, _, err := client.ImageInspectWithRaw(ctx, image); err != nil {
+		b.logf(LogLevelDebug, "Not verifying the assemble shell: builder image %q is not present locally", image)
+		return nil
+	}
+	created, err := c.ContainerCreate(ctx, &container.Config{Image: image}, nil, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("cannot verify the assemble shell: %w", err)
+	}
+	defer func() {
+		_ = c.ContainerRemove(context.WithoutCancel(ctx), created.ID, container.RemoveOptions{Force: true})
+	}()
+	if _, err = c.ContainerStatPath(ctx, created.ID, b.assembleShell); dockerClient.IsErrNotFound(err) {
+		return wrap(ErrInvalidBuilderImage, fmt.Errorf("builder image %q lacks the assemble shell %s (see WithAssembleShell)", image, b.assembleShell))
+	} else if err != nil {
+		return fmt.Errorf("cannot verify the assemble shell: %w", err)
+	}
+	return nil
+}
+
 // GoAssembler
 //
 // Adapted from /usr/libexec/s2i/assemble within the UBI-8 go-toolchain
 // such that the "go build" command builds subdirectory .s2i/builds/last
-// (where main resides) rather than the root.
+// (where main resides) rather than the root, and such that it is a POSIX
+// shell script.
 // TODO: many apps use the pattern of having main in a subdirectory, for
 // example the idiomatic "./cmd/myapp/main.go".  It would therefore be
 // beneficial to submit a patch to the go-toolchain source allowing this
 // path to be customized with an environment variable instead
-const GoAssembler = `
+const GoAssembler = `#!/bin/sh
+set -e
+cd /tmp/src
+if [ "$(go list -f {{.Incomplete}})" = "true" ]; then
+    INSTALL_URL=${INSTALL_URL:-$IMPORT_URL}
+    if [ -n "$IMPORT_URL" ]; then
+        echo "Assembling GOPATH"
+        export GOPATH=$(realpath $HOME/go)
+        mkdir -p $GOPATH/src/$IMPORT_URL
+        mv /tmp/src/* $GOPATH/src/$IMPORT_URL
+        if [ -d /tmp/artifacts/pkg ]; then
+            echo "Restoring previous build artifacts"
+            mv /tmp/artifacts/pkg $GOPATH
+        fi
+        # Resolve dependencies, ignore if vendor present
+        if [ ! -d $GOPATH/src/$INSTALL_URL/vendor ]; then
+            echo "Resolving dependencies"
+            (cd $GOPATH/src/$INSTALL_URL && go get)
+        fi
+        # lets build
+        echo "Building"
+        (cd $GOPATH/src/$INSTALL_URL && go install -i $INSTALL_URL)
+        mv $GOPATH/bin/* /opt/app-root/gobinary
+        exit
+    fi
+    exec /$STI_SCRIPTS_PATH/usage
+else
+    cd .s2i/builds/last
+    go build -o /opt/app-root/gobinary
+fi
+`
+
+// TypeScriptAssembler
+//
+// Assembles the function per the assemble script of the Node.js builder image,
+// which runs the build script of package.json if defined, and otherwise
+// compiles it with tsc, as TypeScript functions are run from their compiled
+// output.
+const TypeScriptAssembler = `#!/bin/sh
+# func: typescript assembler
+set -e
+${STI_SCRIPTS_PATH:-/usr/libexec/s2i}/assemble
+if ! node -e 'process.exit(require("./package.json").scripts?.build ? 0 : 1)'; then
+    echo "---> Compiling TypeScript"
+    npx -p typescript tsc
+fi
+`
+
+// typeScriptAssemblerMarker identifies assemble scripts written by func, as
+// opposed to those of the function.
+const typeScriptAssemblerMarker = "# func: typescript assembler"
+
+// legacyGoAssembler is the GoAssembler written by earlier versions of func,
+// which begins with a newline rather than its shebang, such that those left
+// in functions are recognized as written by func.
+const legacyGoAssembler = `
 #!/bin/bash
 set -e
 pushd /tmp/src
@@ -54,12 +160,3 @@ else
     popd
 fi
 `
-
-func assembler(f fn.Function) (string, error) {
-	switch f.Runtime {
-	case "go":
-		return GoAssembler, nil
-	default:
-		return "", fmt.Errorf("no assembler defined for runtime %q", f.Runtime)
-	}
-}
@@ -0,0 +1,84 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestAssembleShell ensures that the assemble scripts written are run by the
+// assemble shell and, by default, are POSIX shell scripts.
+func TestAssembleShell(t *testing.T) {
+	bashisms := []string{"[[", "]]", "==", "pushd", "popd", "function ", "source ", "$'"}
+	for _, script := range []string{s2i.GoAssembler, s2i.TypeScriptAssembler} {
+		if !strings.HasPrefix(script, "#!"+s2i.DefaultAssembleShell+"\n") {
+			t.Errorf("expected the assembler to be run by %s, got:\n%s", s2i.DefaultAssembleShell, script)
+		}
+		for _, bashism := range bashisms {
+			if strings.Contains(script, bashism) {
+				t.Errorf("expected a POSIX shell script, got %q in:\n%s", bashism, script)
+			}
+		}
+	}
+
+	for _, shell := range []string{"", "/bin/bash"} {
+		root := t.TempDir()
+		impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+		if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+			t.Fatal(err)
+		}
+		i := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		b := s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(mockDocker{}), s2i.WithAssembleShell(shell))
+		if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(root, ".s2i", "bin", "assemble"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := s2i.GoAssembler
+		if shell != "" {
+			want = "#!" + shell + strings.TrimPrefix(s2i.GoAssembler, "#!"+s2i.DefaultAssembleShell)
+		}
+		if string(got) != want {
+			t.Errorf("shell %q: expected the assemble script:\n%s\ngot:\n%s", shell, want, got)
+		}
+	}
+}
+
+// TestAssembleShellVerify ensures that a builder image lacking the assemble
+// shell is rejected, and that invalid shells are.
+func TestAssembleShellVerify(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "handle.js"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := fn.Function{Root: root, Runtime: "typescript"}
+	i := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	cli := &mockContainerDocker{paths: []string{"/bin/sh"}}
+
+	b := s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(cli), s2i.WithAssembleShell("/bin/sh"))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	b = s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(cli), s2i.WithAssembleShell("/bin/bash"))
+	if err := b.Build(context.Background(), f, nil); !errors.Is(err, s2i.ErrInvalidBuilderImage) {
+		t.Fatalf("expected an invalid builder image error, got %v", err)
+	}
+	if len(cli.removed) != len(cli.created) || len(cli.created) != 2 {
+		t.Errorf("expected each container created to be removed, got %v of %v", cli.removed, cli.created)
+	}
+
+	b = s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(cli), s2i.WithAssembleShell("bash"))
+	if err := b.Build(context.Background(), f, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
@@ -2,40 +2,53 @@ package s2i
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
-	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/system"
 	dockerClient "github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/go-units"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
 	"github.com/openshift/source-to-image/pkg/api/validation"
 	"github.com/openshift/source-to-image/pkg/build"
 	"github.com/openshift/source-to-image/pkg/build/strategies"
-	s2idocker "github.com/openshift/source-to-image/pkg/docker"
 	"github.com/openshift/source-to-image/pkg/scm/git"
+	"github.com/openshift/source-to-image/pkg/util/user"
 	"golang.org/x/exp/maps"
 	"golang.org/x/term"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/ptr"
 
 	"knative.dev/func/pkg/builders"
 	"knative.dev/func/pkg/docker"
 	fn "knative.dev/func/pkg/functions"
-	"knative.dev/func/pkg/scaffolding"
 )
 
 // DefaultName when no WithName option is provided to NewBuilder
@@ -59,18 +72,153 @@ var DefaultBuilderImages = map[string]string{
 	"typescript": DefaultNodeBuilder,
 }
 
+// DefaultBuildEnvs for s2i builders indexed by Runtime Language.  These are
+// set when building functions of the given runtime unless overridden by the
+// function's own build envs.
+var DefaultBuildEnvs = map[string]map[string]string{
+	"go":     {"CGO_ENABLED": "0"},
+	"node":   {"NODE_ENV": "production"},
+	"nodejs": {"NODE_ENV": "production"},
+}
+
+// excludeRegExp matches the paths excluded from the build context.
+// Do not include .git, .env, .func or any language-specific cache directories
+// (node_modules, etc) in the tar file sent to the builder, as this both
+// bloats the build process and can cause unexpected errors in the resultant
+// function.
+const excludeRegExp = "(^|/)\\.git|\\.env|\\.func|node_modules(/|$)"
+
 // DockerClient is subset of dockerClient.CommonAPIClient required by this package
 type DockerClient interface {
 	ImageBuild(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
 	ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error)
+	ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error)
 }
 
+// FileFilter is consulted for each entry of the build context.  The path is
+// relative to the context root and uses forward slashes.  Returning false
+// excludes the entry (and, for directories, everything beneath it).
+type FileFilter func(path string, info fs.FileInfo) bool
+
 // Builder of functions using the s2i subsystem.
 type Builder struct {
-	name    string
-	verbose bool
-	impl    build.Builder // S2I builder implementation (aka "Strategy")
-	cli     DockerClient
+	name     string
+	verbose  bool
+	logLevel LogLevel
+	jsonLogs *jsonLogger   // replaces stderr if set
+	logFile  *rotatingFile // receives all messages and output if set
+	phase    Phase         // of the build, for JSON logs
+	impl     build.Builder // S2I builder implementation (aka "Strategy")
+	cli      DockerClient
+	filters  []FileFilter
+
+	imageResolver BuilderImageResolver // resolves builder images not in func.yaml
+	eolWarning    bool                 // warn of builder images of EOL runtimes
+
+	exposedPort int          // port declared by the resulting image
+	entrypoint  []string     // entrypoint of the resulting image
+	workdir     string       // working directory of the resulting image
+	runtimeUser string       // user of the resulting image
+	healthcheck *healthcheck // of the resulting image
+
+	dockerfileWriter io.Writer // receives the final Dockerfile
+	dockerfileSyntax string    // frontend of the syntax directive of the Dockerfile
+	target           string    // stage of the Dockerfile to build
+
+	configFns   []func(*api.Config) // S2I config mutators
+	allowedUIDs *string             // uids permitted to run assemble
+
+	maxImageSize int64 // size budget of the resulting image in bytes
+
+	defaultPlatforms []fn.Platform             // targeted by builds which request none
+	cluster          kubernetes.Interface      // whose nodes' platforms builds target
+	platformResolved func(fn.Platform, string) // notified of the builder image resolved
+
+	buildMemory int64               // memory limit of the build in bytes
+	buildCPUs   string              // cpuset of the build
+	ulimits     []*container.Ulimit // resource limits of the build
+
+	defaultBuildEnvs map[string]map[string]string // overrides DefaultBuildEnvs
+	profile          string                       // build profile to apply
+
+	skipStatePath string // state of the last build; skip if unchanged
+	historyPath   string // durations of builds; see EstimateDuration
+
+	builderPullPolicy  api.PullPolicy     // pull policy of the builder image
+	pullMirror         string             // registry mirror of the base images
+	pullMirrorAll      bool               // mirror the images configured by the user
+	signatureVerifier  SignatureVerifier  // verifies the builder image
+	builderImagePolicy func(string) error // approves the builder image
+
+	scaffolding        bool                    // scaffold runtimes which support it
+	scaffoldRepository string                  // template repository of the scaffolding
+	assembleShell      string                  // shell of the assemble scripts written
+	scaffoldTransforms []ScaffoldTransform     // applied to the glue code written
+	scaffoldOverwrite  ScaffoldOverwritePolicy // of files not scaffolded
+	invoke             string                  // overrides the invocation of the function scaffolded
+
+	ignoreLinkMode IgnoreLinkMode // how .funcignore is provided as .s2iignore
+
+	scriptsRepository string            // template repository of shared .s2i scripts
+	normalizeScripts  bool              // convert CRLF line endings of scripts to LF
+	dockerConfig      string            // directory of the docker config
+	transport         http.RoundTripper // transport of requests to registries and of http(s) scripts
+	proxy             *ProxyConfig      // overrides the proxy of the environment
+
+	timeout time.Duration // limit of the duration of the entire build
+
+	provenancePath string // path at which to write SLSA provenance
+	configDumpPath string // path at which to write the S2I config
+
+	artifacts []artifact // extracted from the image after the build
+	smokeTest *smokeTest // of the image after the build
+
+	cacheMount   bool         // mount caches in the assemble step
+	cacheRoot    string       // keys the cache mount in place of the function's root
+	cacheSharing CacheSharing // sharing mode of the assemble cache mount
+	tmpfsSize    string       // size of the tmpfs of the artifacts, if any
+
+	imageFormat builders.ImageFormat // media types of the image
+	load        bool                 // load the image into the daemon
+	push        bool                 // push the image as part of the build
+
+	buildKit BuildKitMode // whether the image is built with BuildKit
+
+	assembleRunPattern string // matches the assemble step of the Dockerfile
+
+	strictBuildEnvs  bool            // unresolved references in build envs are errors
+	builderImageEnvs bool            // add the envs of the builder image to the build envs
+	hostEnvAllowlist map[string]bool // host envs build envs may reference if set
+	useGitignore     bool            // exclude the source ignored by .gitignore files
+	sourceSBOM       string          // format of the SBOM of the dependencies, if any
+	sourceMount      bool            // bind-mount the source rather than copy it
+
+	requiredBuilderLabels map[string]string // labels the builder image must have
+
+	goModuleCache *goModuleCache // persistent Go module cache
+	goPrivate     string         // GOPRIVATE of Go builds; enables the netrc secret
+	session       string         // id of the BuildKit session of the build
+	extraHosts    []string       // host:ip entries of /etc/hosts of the build
+
+	runtimeImage     string   // base of the final image, if not the builder
+	runtimeArtifacts []string // files copied from the builder to the runtime image
+
+	labelsTemplate string // template of the labels of the image
+	tagGitSha      bool   // tag the image with the git commit of the function
+
+	requireCleanTree    bool // fail builds of uncommitted changes
+	cleanTreeUntracked  bool // untracked files are uncommitted changes
+	cleanTreeRepository bool // fail builds outside of a git repository
+
+	sbom, provenance bool // BuildKit attestations to attach to the image
+
+	preBuild  func(context.Context, fn.Function) error              // invoked before the build
+	postBuild func(context.Context, fn.Function, BuildResult) error // invoked after a successful build
+
+	overrides build.Overrides // passed to the S2I build strategy
+
+	buildKitAddr string       // address of a remote BuildKit daemon
+	buildKitTLS  *BuildKitTLS // of the connection to the remote BuildKit daemon
 }
 
 type Option func(*Builder)
@@ -103,16 +251,602 @@ func WithDockerClient(cli DockerClient) Option {
 	}
 }
 
+// WithFileFilter adds a filter which is consulted during the creation of the
+// build context, after the default exclusions have been applied.  Filters
+// compose: an entry is included only if all filters return true.
+func WithFileFilter(f FileFilter) Option {
+	return func(b *Builder) {
+		b.filters = append(b.filters, f)
+	}
+}
+
+// WithExposedPort declares the port on which the resulting image listens
+// using an EXPOSE instruction, unless the generated Dockerfile already
+// declares one.
+func WithExposedPort(port int) Option {
+	return func(b *Builder) {
+		b.exposedPort = port
+	}
+}
+
+// WithEntrypoint sets the entrypoint of the resulting image, unless the
+// generated Dockerfile already declares one.  The entrypoint replaces the
+// run script of the builder image as the command of the container.
+func WithEntrypoint(entrypoint []string) Option {
+	return func(b *Builder) {
+		b.entrypoint = entrypoint
+	}
+}
+
+// WithWorkdir sets the working directory of the resulting image, from which
+// the function runs, which must be an absolute path.
+func WithWorkdir(dir string) Option {
+	return func(b *Builder) {
+		b.workdir = dir
+	}
+}
+
+// WithRuntimeUser sets the user as which the resulting image runs, as a
+// numeric uid, optionally with a gid ("1001" or "1001:0"), which must not be
+// root.  Unlike the uids permitted to run assemble (see WithAllowedUIDs), it
+// affects only the running function, such that it can satisfy a restricted
+// security context requiring a non-root user.
+func WithRuntimeUser(uid string) Option {
+	return func(b *Builder) {
+		b.runtimeUser = uid
+	}
+}
+
+// WithDockerfileWriter streams the final Dockerfile of each build, as
+// patched by func, to w before the image is built, such that it can be
+// piped to other tools.
+func WithDockerfileWriter(w io.Writer) Option {
+	return func(b *Builder) {
+		b.dockerfileWriter = w
+	}
+}
+
+// WithDockerfileSyntax adds a syntax directive to the generated Dockerfile,
+// such that BuildKit builds it with the given frontend image, for example
+// "docker/dockerfile:1.7" to use features newer than those of the daemon's
+// built-in frontend.  By default the Dockerfile has no directive.  The
+// classic builder ignores it.
+func WithDockerfileSyntax(frontend string) Option {
+	return func(b *Builder) {
+		b.dockerfileSyntax = frontend
+	}
+}
+
+// WithTarget builds the given stage of the Dockerfile rather than its final
+// stage, for Dockerfiles with multiple stages, such as those with a runtime
+// image (whose builder stage is "builder") or with stages added by S2I
+// config mutators.  A target which is not a stage of the Dockerfile is noted
+// as a warning.
+func WithTarget(stage string) Option {
+	return func(b *Builder) {
+		b.target = stage
+	}
+}
+
+// WithAssembleRunPattern sets the regular expression matching the RUN
+// instruction of the assemble step of the Dockerfile generated by S2I, to
+// which the cache mount is added, for builder images which invoke their
+// assemble script differently (default DefaultAssembleRunPattern).  Its
+// first group must match the command of the instruction.
+func WithAssembleRunPattern(pattern string) Option {
+	return func(b *Builder) {
+		b.assembleRunPattern = pattern
+	}
+}
+
+// WithTransport sets the transport of the requests of func: to registries,
+// such as of the labels, platforms and signatures of builder images, and
+// fetching the scripts of builder images whose scripts-url is http(s).  It
+// is used as-is, in place of the proxy of the build (see WithProxy).
+func WithTransport(transport http.RoundTripper) Option {
+	return func(b *Builder) {
+		b.transport = transport
+	}
+}
+
+// WithS2IConfig adds a function which may mutate the S2I build config after
+// it has been populated by func, but before it is validated.  This is an
+// escape hatch for S2I features not otherwise exposed by this builder.
+// Note that some fields are managed by func and changing them may break the
+// build (for example AsDockerfile, which must remain a path within the build
+// directory) or be ignored (for example Tag).  Mutators are applied in the
+// order provided.
+func WithS2IConfig(mutate func(*api.Config)) Option {
+	return func(b *Builder) {
+		b.configFns = append(b.configFns, mutate)
+	}
+}
+
+// WithAllowedUIDs restricts the users which may run the assemble script of
+// the builder image to the given list of uid ranges in S2I's syntax, for
+// example "1-" (any non-root uid) or "1000-2000,5000".  Builder images which
+// would run assemble as a user outside these ranges are rejected.
+func WithAllowedUIDs(ranges string) Option {
+	return func(b *Builder) {
+		b.allowedUIDs = &ranges
+	}
+}
+
+// WithMaxImageSize sets a size budget, in bytes, for the resulting image.
+// Builds producing a larger image fail with ErrImageTooLarge.
+func WithMaxImageSize(bytes int64) Option {
+	return func(b *Builder) {
+		b.maxImageSize = bytes
+	}
+}
+
+// WithDefaultBuildEnvs overrides DefaultBuildEnvs, the per-runtime build
+// envs set unless overridden by the function's own build envs.  An empty map
+// disables the defaults.
+func WithDefaultBuildEnvs(envs map[string]map[string]string) Option {
+	return func(b *Builder) {
+		b.defaultBuildEnvs = envs
+	}
+}
+
+// WithProfile selects a build profile of the function (see
+// fn.BuildSpec.Profiles) whose overrides are layered over the function's
+// build settings.  Selecting a profile the function does not define is an
+// error.
+func WithProfile(name string) Option {
+	return func(b *Builder) {
+		b.profile = name
+	}
+}
+
+// WithSkipIfUnchanged enables skipping builds whose inputs are unchanged
+// since the last successful build.  A hash over the function's source (as
+// filtered for the build context), the S2I config (including the build envs
+// and labels), the digests of the builder and runtime images and the
+// options of the builder which affect the image is recorded at statePath
+// after each successful build.  A subsequent build with the same hash is
+// skipped, and reported as up to date, if the image it produced still exists:
+// in the daemon or, for images which are not loaded (see WithLoad), in its
+// registry.
+func WithSkipIfUnchanged(statePath string) Option {
+	return func(b *Builder) {
+		b.skipStatePath = statePath
+	}
+}
+
+// WithVerifyBuilderSignature requires the builder image, as resolved for the
+// build, to be verified by the given verifier (for example a CosignVerifier)
+// before it is used.  The image is resolved to its digest in its registry,
+// which is verified and built with, such that a tag moved after verification
+// is of no effect.  Builds fail if verification fails.  A CosignVerifier
+// created without options uses the registry credentials of the builder (see
+// WithDockerConfigDir).
+func WithVerifyBuilderSignature(v SignatureVerifier) Option {
+	return func(b *Builder) {
+		b.signatureVerifier = v
+	}
+}
+
+// WithBuilderImagePolicy sets a policy which approves or rejects the builder
+// image.  It is invoked with the reference of the builder image as resolved
+// for the build, including any platform-specific digest, before the image is
+// used.  An error returned aborts the build with its message.  For example, a
+// policy may permit only images of a given registry referenced by digest.
+// By default all builder images are permitted.
+func WithBuilderImagePolicy(policy func(ref string) error) Option {
+	return func(b *Builder) {
+		b.builderImagePolicy = policy
+	}
+}
+
+// WithPullPolicy sets when the builder image is pulled: api.PullAlways, on
+// every build, api.PullNever, failing builds if it is not present, or
+// api.PullIfNotPresent (the default).  The runtime image, if any, is pulled
+// alike.  Unless a policy is chosen, by this option or EnvBuildPullPolicy,
+// the daemon pulls the images the image is built with on every build.  See
+// also EnvBuildPullPolicy.
+func WithPullPolicy(p api.PullPolicy) Option {
+	return func(b *Builder) {
+		b.builderPullPolicy = p
+	}
+}
+
+// WithScaffolding toggles scaffolding (default true).  When disabled, the
+// source of functions of runtimes which are otherwise scaffolded is built
+// as-is, using the assemble script of the builder image, so it must provide
+// its own main.  Existing scaffolding in the source is neither written nor
+// removed.
+func WithScaffolding(scaffolding bool) Option {
+	return func(b *Builder) {
+		b.scaffolding = scaffolding
+	}
+}
+
+// WithTimeout limits the duration of the entire build.  Builds which do not
+// complete in time fail with an error wrapping context.DeadlineExceeded.
+func WithTimeout(timeout time.Duration) Option {
+	return func(b *Builder) {
+		b.timeout = timeout
+	}
+}
+
+// WithProvenance writes a SLSA (v0.2) provenance statement of each
+// successful build to path.  Its subject is the built image, by the digest
+// of its manifest, and its materials are the commit of the function's
+// source, if within a git repository, and the builder image.  Untagged
+// images have no provenance, and images of daemons of the classic image
+// store only have a manifest digest, and thus provenance, once pushed.
+func WithProvenance(path string) Option {
+	return func(b *Builder) {
+		b.provenancePath = path
+	}
+}
+
+// WithCacheMount toggles the cache mount of the assemble step of builds with
+// BuildKit (default true), which reuses the artifacts of previous builds.
+// Daemons whose BuildKit lacks cache mounts require it be disabled.  The Go
+// module cache (see WithGoModuleCache) is independent of it.
+func WithCacheMount(enabled bool) Option {
+	return func(b *Builder) {
+		b.cacheMount = enabled
+	}
+}
+
+// WithArtifactsTmpfs mounts the artifacts directory of the assemble step
+// (/tmp/artifacts) as a tmpfs of the given size, such as "2g" or "512MiB", in
+// place of the cache mount, for assemble scripts whose intermediate artifacts
+// exceed the space otherwise available.  The artifacts of previous builds
+// are then not reused.  As with cache mounts, it requires BuildKit.
+func WithArtifactsTmpfs(size string) Option {
+	return func(b *Builder) {
+		b.tmpfsSize = size
+	}
+}
+
+// WithCacheSharing sets the sharing mode of the cache mount of the assemble
+// step.  By default the cache is shared by concurrent builds of a function,
+// which can corrupt caches which are not safe for concurrent use; such
+// builds should use CacheSharingLocked.
+func WithCacheSharing(mode CacheSharing) Option {
+	return func(b *Builder) {
+		b.cacheSharing = mode
+	}
+}
+
+// WithStrategyOverride sets the overrides passed to S2I when it creates the
+// builder implementation ("strategy") for the build config.  S2I currently
+// supports a single override: Downloader, which replaces the means by which
+// the source is obtained (by default a copy of the function's directory).
+// Functions are always built as a Dockerfile, so the strategy itself is not
+// selectable, and as the Dockerfile strategy does not consult overrides, the
+// Downloader is invoked by the builder, prior to the implementation (be it
+// S2I's or that of WithImpl), to place the source in the build context.
+func WithStrategyOverride(overrides build.Overrides) Option {
+	return func(b *Builder) {
+		b.overrides = overrides
+	}
+}
+
+// WithImageFormat requests the media types of the built image: those of the
+// OCI image spec (builders.OCI) or of the Docker image manifest v2, schema 2
+// (builders.DockerV2).  By default the daemon chooses.  This is honored by
+// daemons using BuildKit with the containerd image store; the classic image
+// store of Docker always uses Docker media types.
+func WithImageFormat(format builders.ImageFormat) Option {
+	return func(b *Builder) {
+		b.imageFormat = format
+	}
+}
+
+// WithStrictBuildEnvs treats build envs whose values still contain ${...}
+// references after interpolation as an error rather than a warning.  Such
+// references are usually a mistake, as build envs reference local
+// environment variables using {{ env:NAME }}.
+func WithStrictBuildEnvs(strict bool) Option {
+	return func(b *Builder) {
+		b.strictBuildEnvs = strict
+	}
+}
+
+// WithGoModuleCache persists the module cache of Go functions' builds in a
+// BuildKit cache mount at GoModuleCacheDir which is shared by the builds of
+// all functions.  The module proxy and flags used by the go command may be
+// set via goproxy and goflags (GOPROXY and GOFLAGS), for example to use a
+// proxy shared by CI machines; empty values leave those of the builder image
+// in place.  Build envs of the function take precedence.
+func WithGoModuleCache(goproxy, goflags string) Option {
+	return func(b *Builder) {
+		b.goModuleCache = &goModuleCache{proxy: goproxy, flags: goflags}
+	}
+}
+
+// WithGoPrivate sets GOPRIVATE to the given pattern when building Go
+// functions, such that modules matching it are fetched directly rather than
+// via the module proxy, and makes the BuildKit secret GoNetrcSecret available
+// to the assemble step as the .netrc of its user for authenticating to the
+// hosts of those modules.  The secret is mounted for that step only and is
+// never part of the image.  It is provided by the BuildKit session of the
+// build (see WithBuildKitSession), which is thus required, and builds fail
+// if the session does not provide it.
+func WithGoPrivate(pattern string) Option {
+	return func(b *Builder) {
+		b.goPrivate = pattern
+	}
+}
+
+// WithBuildKitSession attaches the build to the BuildKit session of the given
+// id, established with the daemon by the caller, which provides secrets (such
+// as GoNetrcSecret) and other resources to the build.
+func WithBuildKitSession(id string) Option {
+	return func(b *Builder) {
+		b.session = id
+	}
+}
+
+// WithExtraHosts adds entries to /etc/hosts of the build containers, such that
+// the assemble step can reach internal services by name where the build
+// network lacks DNS for them.  Each is of the form "host:ip", where ip may
+// also be "host-gateway" for the host of the daemon.
+func WithExtraHosts(hosts []string) Option {
+	return func(b *Builder) {
+		b.extraHosts = hosts
+	}
+}
+
+// WithRuntimeImage builds the final image on the given runtime image rather
+// than on the builder image, such that the tooling of the builder image is
+// not part of the result.  Artifacts are the files produced by the assemble
+// script to copy to the runtime image, each of the form "source:destination"
+// where a relative destination is relative to the working directory of the
+// runtime image.  If none are given, those declared by the runtime image
+// (its io.openshift.s2i.assemble-input-files label) are used; runtime images
+// which declare none are rejected.
+func WithRuntimeImage(ref string, artifacts ...string) Option {
+	return func(b *Builder) {
+		b.runtimeImage = ref
+		b.runtimeArtifacts = artifacts
+	}
+}
+
+// WithLabelsTemplate sets the labels of the image from the template at path,
+// which may be shared by many functions, such as a file at the root of their
+// repository.  A relative path is relative to the function's root.  The
+// template is a YAML map of label keys to values, which may contain the
+// placeholders {{ git.sha }}, {{ git.branch }}, {{ date }} and
+// {{ env:NAME }}.  Labels of the function (deploy.labels) override those of
+// the template.
+func WithLabelsTemplate(path string) Option {
+	return func(b *Builder) {
+		b.labelsTemplate = path
+	}
+}
+
+// WithAttestations requests that BuildKit attach an SBOM and/or provenance
+// attestation to the resulting image.  This is the native alternative to
+// WithProvenance, and requires a daemon which stores images in containerd
+// (the containerd image store), as images of the classic store can not hold
+// attestations.  Builds using a daemon without such support fail.
+func WithAttestations(sbom, provenance bool) Option {
+	return func(b *Builder) {
+		b.sbom = sbom
+		b.provenance = provenance
+	}
+}
+
+// WithPreBuild registers a hook invoked before the function is built, for
+// example to generate code.  An error returned by the hook aborts the build.
+func WithPreBuild(hook func(ctx context.Context, f fn.Function) error) Option {
+	return func(b *Builder) {
+		b.preBuild = hook
+	}
+}
+
+// WithPostBuild registers a hook invoked after the function was built
+// successfully (including builds skipped as up-to-date), for example to
+// clean up generated code.  An error returned by the hook is returned by the
+// build along with its result.
+func WithPostBuild(hook func(ctx context.Context, f fn.Function, result BuildResult) error) Option {
+	return func(b *Builder) {
+		b.postBuild = hook
+	}
+}
+
 // NewBuilder creates a new instance of a Builder with static defaults.
 func NewBuilder(options ...Option) *Builder {
-	b := &Builder{name: DefaultName}
+	b := &Builder{name: DefaultName, logLevel: LogLevelWarn, scaffolding: true, cacheMount: true, normalizeScripts: true, load: true}
 	for _, o := range options {
 		o(b)
 	}
 	return b
 }
 
+// validate the options with which the builder was configured.
+func (b *Builder) validate() error {
+	if b.exposedPort != 0 && (b.exposedPort < 1 || b.exposedPort > 65535) {
+		return fmt.Errorf("invalid exposed port %d: must be between 1 and 65535", b.exposedPort)
+	}
+	if len(b.defaultPlatforms) > 1 {
+		return errors.New("invalid default platforms: the S2I builder currently only supports a single target platform")
+	}
+	if b.assembleShell != "" && (!path.IsAbs(b.assembleShell) || strings.ContainsAny(b.assembleShell, " \t\n")) {
+		return fmt.Errorf("invalid assemble shell %q: must be an absolute path", b.assembleShell)
+	}
+	if b.workdir != "" && !path.IsAbs(b.workdir) {
+		return fmt.Errorf("invalid workdir %q: must be an absolute path", b.workdir)
+	}
+	if b.runtimeUser != "" {
+		if err := validateRuntimeUser(b.runtimeUser); err != nil {
+			return err
+		}
+	}
+	if b.sourceSBOM != "" && b.sourceSBOM != SourceSBOMCycloneDX && b.sourceSBOM != SourceSBOMSPDX {
+		return fmt.Errorf("invalid source SBOM format %q: must be %s or %s", b.sourceSBOM, SourceSBOMCycloneDX, SourceSBOMSPDX)
+	}
+	if b.scaffoldOverwrite != "" && !slices.Contains(scaffoldOverwritePolicies, b.scaffoldOverwrite) {
+		return fmt.Errorf("invalid scaffold overwrite policy %q: must be one of %s, %s or %s", b.scaffoldOverwrite,
+			ScaffoldOverwriteAlways, ScaffoldOverwriteFail, ScaffoldOverwriteSkip)
+	}
+	if b.invoke != "" && !slices.Contains(invokeModes, b.invoke) {
+		return fmt.Errorf("invalid invoke mode %q: must be one of %s", b.invoke, strings.Join(invokeModes, ", "))
+	}
+	if b.goPrivate != "" && b.session == "" {
+		return fmt.Errorf("GOPRIVATE %q requires a BuildKit session providing the %s secret (see WithBuildKitSession)", b.goPrivate, GoNetrcSecret)
+	}
+	if b.healthcheck != nil {
+		if err := b.healthcheck.validate(); err != nil {
+			return err
+		}
+	}
+	switch b.imageFormat {
+	case "", builders.DockerV2, builders.OCI:
+	default:
+		return fmt.Errorf("invalid image format %q: must be %q or %q", b.imageFormat, builders.DockerV2, builders.OCI)
+	}
+	if b.assembleRunPattern != "" {
+		re, err := regexp.Compile(b.assembleRunPattern)
+		if err != nil {
+			return fmt.Errorf("invalid assemble run pattern %q: %w", b.assembleRunPattern, err)
+		}
+		if re.NumSubexp() < 1 {
+			return fmt.Errorf("invalid assemble run pattern %q: a group matching the command is required", b.assembleRunPattern)
+		}
+	}
+	switch b.ignoreLinkMode {
+	case "", IgnoreLinkSymlink, IgnoreLinkCopy, IgnoreLinkNone:
+	default:
+		return fmt.Errorf("invalid ignore link mode %q: must be one of %q, %q or %q", b.ignoreLinkMode, IgnoreLinkSymlink, IgnoreLinkCopy, IgnoreLinkNone)
+	}
+	switch b.buildKit {
+	case "", BuildKitAuto, BuildKitOn, BuildKitOff:
+	default:
+		return fmt.Errorf("invalid BuildKit mode %q: must be one of %q, %q or %q", b.buildKit, BuildKitAuto, BuildKitOn, BuildKitOff)
+	}
+	switch b.cacheSharing {
+	case "", CacheSharingShared, CacheSharingPrivate, CacheSharingLocked:
+	default:
+		return fmt.Errorf("invalid cache sharing mode %q: must be one of %q, %q or %q",
+			b.cacheSharing, CacheSharingShared, CacheSharingPrivate, CacheSharingLocked)
+	}
+	if b.dockerfileSyntax != "" {
+		if _, err := name.ParseReference(b.dockerfileSyntax); err != nil {
+			return fmt.Errorf("invalid Dockerfile syntax %q: must be the reference of a frontend image: %w", b.dockerfileSyntax, err)
+		}
+	}
+	for _, h := range b.extraHosts {
+		host, ip, ok := strings.Cut(h, ":")
+		if !ok || !hostnamePattern.MatchString(host) || (ip != "host-gateway" && net.ParseIP(ip) == nil) {
+			return fmt.Errorf("invalid extra host %q: must be of the form host:ip", h)
+		}
+	}
+	if b.target != "" && !stageNamePattern.MatchString(b.target) {
+		return fmt.Errorf("invalid target %q: must be a stage name of letters, digits, '_', '-' and '.'", b.target)
+	}
+	if b.runtimeImage != "" {
+		if _, err := name.ParseReference(b.runtimeImage); err != nil {
+			return fmt.Errorf("invalid runtime image %q: %w", b.runtimeImage, err)
+		}
+		var artifacts api.VolumeList
+		for _, a := range b.runtimeArtifacts {
+			if err := artifacts.Set(a); err != nil {
+				return fmt.Errorf("invalid runtime artifact %q: %w", a, err)
+			}
+		}
+	}
+	if b.pullMirror != "" {
+		if _, err := name.NewRegistry(b.pullMirror, name.StrictValidation); err != nil || strings.Contains(b.pullMirror, "/") {
+			return fmt.Errorf("invalid registry mirror %q: must be a host, optionally with a port", b.pullMirror)
+		}
+	}
+	switch b.builderPullPolicy {
+	case "", api.PullAlways, api.PullNever, api.PullIfNotPresent:
+	default:
+		return fmt.Errorf("invalid pull policy %q: must be one of %q, %q or %q",
+			b.builderPullPolicy, api.PullAlways, api.PullNever, api.PullIfNotPresent)
+	}
+	if b.allowedUIDs != nil {
+		if _, err := parseAllowedUIDs(*b.allowedUIDs); err != nil {
+			return err
+		}
+	}
+	if err := b.validateBuildResources(); err != nil {
+		return err
+	}
+	if b.tmpfsSize != "" {
+		if size, err := units.RAMInBytes(b.tmpfsSize); err != nil || size <= 0 {
+			return fmt.Errorf("invalid artifacts tmpfs size %q: must be a positive size such as 2g or 512MiB", b.tmpfsSize)
+		}
+	}
+	if b.logFile != nil {
+		if err := b.logFile.validate(); err != nil {
+			return err
+		}
+	}
+	if b.smokeTest != nil {
+		if err := b.smokeTest.validate(b.load); err != nil {
+			return err
+		}
+	}
+	if err := b.validateLoad(); err != nil {
+		return err
+	}
+	if err := b.validateBuildKitAddr(); err != nil {
+		return err
+	}
+	return b.validateArtifacts()
+}
+
+// validateRuntimeUser returns an error unless user is a non-root numeric uid,
+// optionally with a numeric gid.
+func validateRuntimeUser(user string) error {
+	uid, gid, hasGID := strings.Cut(user, ":")
+	id, err := strconv.ParseUint(uid, 10, 32)
+	if err == nil && hasGID {
+		_, err = strconv.ParseUint(gid, 10, 32)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid runtime user %q: must be a numeric uid, optionally with a numeric gid (uid:gid)", user)
+	}
+	if id == 0 {
+		return fmt.Errorf("invalid runtime user %q: must not be root", user)
+	}
+	return nil
+}
+
+// hostnamePattern matches host names.
+var hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+// parseAllowedUIDs parses a non-empty list of uid ranges.
+func parseAllowedUIDs(ranges string) (user.RangeList, error) {
+	rl, err := user.ParseRangeList(ranges)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed uids %q: %w", ranges, err)
+	}
+	if rl.Empty() {
+		return nil, fmt.Errorf("invalid allowed uids %q: at least one uid range is required", ranges)
+	}
+	return *rl, nil
+}
+
+// BuildResult describes the image produced by a successful build.
+type BuildResult struct {
+	// Image is the tag of the built image.
+	Image string
+	// Size of the built image in bytes.  Zero if the image is untagged.
+	Size int64
+	// UpToDate indicates that the build was skipped because its inputs were
+	// unchanged since the last build (see WithSkipIfUnchanged).
+	UpToDate bool
+}
+
 // Build the function using the S2I builder.
+// See BuildWithResult for details.
+func (b *Builder) Build(ctx context.Context, f fn.Function, platforms []fn.Platform) error {
+	_, err := b.BuildWithResult(ctx, f, platforms)
+	return err
+}
+
+// BuildWithResult builds the function using the S2I builder, returning a
+// description of the built image.
 //
 // Platforms:
 // The S2I builder supports at most a single platform to target, and the
@@ -121,57 +855,227 @@ func NewBuilder(options ...Option) *Builder {
 // container, specifying a target platform is redundant, so if provided it
 // must match that of the single-architecture container or the request is
 // invalid.
-func (b *Builder) Build(ctx context.Context, f fn.Function, platforms []fn.Platform) (err error) {
+func (b *Builder) BuildWithResult(ctx context.Context, f fn.Function, platforms []fn.Platform) (result BuildResult, err error) {
+	started := time.Now()
+	b = b.withPhase()
+	if err = b.validate(); err != nil {
+		return result, wrap(ErrValidation, err)
+	}
+	if b, err = b.withGitignore(f.Root); err != nil {
+		return
+	}
+
+	// Artifacts and smoke tests are of the built image, which is thus
+	// required to be tagged.
+	if f.Build.Image == "" {
+		if len(b.artifacts) > 0 {
+			return result, wrap(ErrValidation, errors.New("cannot extract artifacts of an untagged image"))
+		}
+		if b.smokeTest != nil {
+			return result, wrap(ErrValidation, errors.New("cannot smoke test an untagged image"))
+		}
+	}
+
+	// Functions configured to be built on-cluster are not built locally.
+	switch f.Build.Type {
+	case "", fn.BuildTypeLocal:
+	case fn.BuildTypeOnCluster:
+		return result, wrap(ErrValidation, fmt.Errorf("%w: %q (build.type: %s): build it remotely or set build.type to %s", fn.ErrBuildOnCluster, f.Name, f.Build.Type, fn.BuildTypeLocal))
+	default:
+		return result, wrap(ErrValidation, fmt.Errorf("unknown build type %q", f.Build.Type))
+	}
 
-	// Builder image from the function if defined, default otherwise.
-	builderImage, err := BuilderImage(f, b.name)
+	// Timeout
+	// Errors of steps interrupted by the deadline are replaced with one
+	// stating as much.
+	if b.timeout > 0 {
+		timedOut := fmt.Errorf("build timed out after %s: %w", b.timeout, context.DeadlineExceeded)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, b.timeout, timedOut)
+		defer cancel()
+		defer func() {
+			if err != nil && context.Cause(ctx) == timedOut {
+				err = timedOut
+			}
+		}()
+	}
+
+	// Layer the selected build profile over the function's build settings.
+	if b.profile != "" {
+		if f.Build, err = f.Build.WithProfile(b.profile); err != nil {
+			return result, wrap(ErrValidation, err)
+		}
+	}
+
+	// Clean tree
+	if b.requireCleanTree {
+		if err = b.checkCleanTree(f.Root); err != nil {
+			return result, wrap(ErrValidation, err)
+		}
+	}
+
+	// Hooks
+	if b.preBuild != nil {
+		if err = b.preBuild(ctx, f); err != nil {
+			return result, fmt.Errorf("pre-build hook failed: %w", err)
+		}
+	}
+	if b.postBuild != nil {
+		defer func() {
+			if err != nil {
+				return
+			}
+			if err = b.postBuild(ctx, f, result); err != nil {
+				err = fmt.Errorf("post-build hook failed: %w", err)
+			}
+		}()
+	}
+
+	// Platforms from the environment or the defaults if none were requested.
+	if platforms, err = b.targetPlatforms(ctx, platforms); err != nil {
+		return result, wrap(ErrValidation, err)
+	}
+
+	// Builder image from the function if defined, from the environment or
+	// resolved for the platform otherwise.
+	builderImage, err := b.builderImage(f, targetPlatform(platforms))
 	if err != nil {
 		return
 	}
+	requestedBuilderImage := builderImage // before resolution for the platform
+	pullPolicy, err := b.pullPolicy()
+	if err != nil {
+		return result, wrap(ErrValidation, err)
+	}
 
 	// Validate Platforms
+	// S2I only produces linux images; the architecture is not restricted.
+	for _, p := range platforms {
+		if !strings.EqualFold(p.OS, "linux") {
+			return result, wrap(ErrUnsupportedPlatform, fmt.Errorf("the S2I builder only supports linux images; requested OS: %s", p.OS))
+		}
+	}
 	if len(platforms) == 1 {
 		platform := strings.ToLower(platforms[0].OS + "/" + platforms[0].Architecture)
-		// Try to get the platform image from within the builder image
-		// Will also succeed if the builder image is a single-architecture image
-		// and the requested platform matches.
-		if builderImage, err = docker.GetPlatformImage(builderImage, platform); err != nil {
-			return fmt.Errorf("cannot get platform image reference for %q: %w", platform, err)
+		if pinned, ok := pinnedBuilderImage(f, b.name, platform); ok {
+			// An image pinned for this platform in func.yaml is used as-is.
+			builderImage = pinned
+		} else if ref, err := docker.GetPlatformImageContext(ctx, builderImage, platform, b.remoteOptions(ctx)...); err != nil {
+			// Try to get the platform image from within the builder image
+			// Will also succeed if the builder image is a single-architecture image
+			// and the requested platform matches.
+			var (
+				errMismatch   docker.ErrPlatformMismatch
+				errNotInIndex docker.ErrPlatformNotInIndex
+			)
+			if errors.As(err, &errMismatch) {
+				return result, wrap(ErrUnsupportedPlatform, fmt.Errorf("cannot build %s with builder image %q: %w", platform, builderImage, err))
+			} else if errors.As(err, &errNotInIndex) {
+				return result, wrap(ErrUnsupportedPlatform, fmt.Errorf("this builder image does not provide %s: %w", platform, err))
+			}
+			if e := builderImageError(builderImage, err); e != nil {
+				return result, wrap(ErrInvalidBuilderImage, e)
+			}
+			return result, fmt.Errorf("cannot get platform image reference for %q: %w", platform, err)
+		} else {
+			builderImage = ref
+		}
+		if b.platformResolved != nil {
+			b.platformResolved(platforms[0], builderImage)
 		}
 	} else if len(platforms) > 1 {
 		// Only a single requestd platform supported.
-		return errors.New("the S2I builder currently only supports specifying a single target platform")
+		return result, wrap(ErrUnsupportedPlatform, errors.New("the S2I builder currently only supports specifying a single target platform"))
 	}
 
-	var client = b.cli
-	if client == nil {
-		var c dockerClient.CommonAPIClient
-		c, _, err = docker.NewClient(dockerClient.DefaultDockerHost)
-		if err != nil {
-			return fmt.Errorf("cannot create docker client: %w", err)
+	// Builder image policy
+	if b.builderImagePolicy != nil {
+		if err = b.builderImagePolicy(builderImage); err != nil {
+			return result, wrap(ErrInvalidBuilderImage, err)
 		}
-		defer c.Close()
-		client = c
 	}
 
-	// Link .s2iignore -> .funcignore
-	funcignorePath := filepath.Join(f.Root, ".funcignore")
-	s2iignorePath := filepath.Join(f.Root, ".s2iignore")
-	if _, err := os.Stat(funcignorePath); err == nil {
-		if _, err := os.Stat(s2iignorePath); err == nil {
-			fmt.Fprintln(os.Stderr, "Warning: an existing .s2iignore was detected.  Using this with preference over .funcignore")
-		} else {
-			if err = os.Symlink("./.funcignore", s2iignorePath); err != nil {
-				return err
+	// Verify the signature of the builder image
+	// The image is pinned to its digest such that the image built with is
+	// that verified, even should its tag be moved meanwhile.
+	if b.signatureVerifier != nil {
+		if builderImage, err = b.pinImage(ctx, builderImage); err != nil {
+			if e := builderImageError(builderImage, err); e != nil {
+				return result, wrap(ErrInvalidBuilderImage, e)
+			}
+			return result, fmt.Errorf("cannot get the digest of builder image %q: %w", builderImage, err)
+		}
+		if err = b.verifier(ctx).Verify(ctx, builderImage); err != nil {
+			return result, wrap(ErrInvalidBuilderImage, fmt.Errorf("cannot verify the signature of builder image %q: %w", builderImage, err))
+		}
+	}
+
+	client, done, err := b.dockerClient()
+	if err != nil {
+		return
+	}
+	defer done()
+
+	if b.historyPath != "" {
+		defer func() {
+			if err != nil || result.UpToDate {
+				return
+			}
+			if e := b.recordDuration(ctx, client, f, targetPlatform(platforms), requestedBuilderImage, time.Since(started)); e != nil {
+				b.logf(LogLevelWarn, "Warning: the duration of the build is not recorded: %v", e)
 			}
-			defer os.Remove(s2iignorePath)
+		}()
+	}
+
+	buildKit, err := b.useBuildKit(ctx, client)
+	if err != nil {
+		return
+	}
+	if !buildKit {
+		if err = b.classicBuildSupported(); err != nil {
+			return result, wrap(ErrValidation, fmt.Errorf("cannot build with the classic builder: %w", err))
 		}
 	}
 
+	if b.sbom || b.provenance {
+		if err = supportsAttestations(ctx, client); err != nil {
+			return
+		}
+	}
+	if b.push {
+		if err = checkPush(ctx, client, buildKit, f.Build.Image); err != nil {
+			return result, wrap(ErrValidation, err)
+		}
+	}
+
+	// Lock the function's root
+	// The root is written to, and read by S2I, by concurrent builds of the
+	// function only in turn.  The lock is released, and the files written to
+	// the root for the build are removed, once S2I has copied the source.
+	releaseRoot, err := lockRoot(ctx, f.Root)
+	if err != nil {
+		return
+	}
+	defer func() { releaseRoot() }()
+
+	// Link .s2iignore -> .funcignore
+	unlinkIgnoreFile, err := b.linkIgnoreFile(f.Root)
+	if err != nil {
+		return
+	}
+	releaseRoot = chain(unlinkIgnoreFile, releaseRoot)
+
+	// Overlay the .s2i directory of the scripts repository
+	undoScripts, err := b.overlayScripts(f)
+	if err != nil {
+		return
+	}
+	releaseRoot = chain(undoScripts, releaseRoot)
+
 	// Build directory
 	tmp, err := os.MkdirTemp("", "func-s2i-build")
 	if err != nil {
-		return fmt.Errorf("cannot create temporary dir for s2i build: %w", err)
+		return result, fmt.Errorf("cannot create temporary dir for s2i build: %w", err)
 	}
 	defer os.RemoveAll(tmp)
 
@@ -181,133 +1085,270 @@ func (b *Builder) Build(ctx context.Context, f fn.Function, platforms []fn.Platf
 			Type: git.URLTypeLocal,
 			URL:  url.URL{Path: f.Root},
 		},
-		Quiet:                   !b.verbose,
+		Quiet:                   !b.logs(LogLevelDebug),
 		Tag:                     f.Build.Image,
 		BuilderImage:            builderImage,
-		BuilderPullPolicy:       api.DefaultBuilderPullPolicy,
+		BuilderPullPolicy:       pullPolicy,
 		PreviousImagePullPolicy: api.DefaultPreviousImagePullPolicy,
 		RuntimeImagePullPolicy:  api.DefaultRuntimeImagePullPolicy,
-		DockerConfig:            s2idocker.GetDefaultDockerConfig(),
+		DockerConfig:            b.s2iDockerConfig(),
 		AsDockerfile:            filepath.Join(tmp, "Dockerfile"),
 	}
 
+	// Builder images which are never pulled must be present, the daemon
+	// otherwise pulling those missing.
+	if pullPolicy == api.PullNever {
+		if _, _, err = client.ImageInspectWithRaw(ctx, builderImage); dockerClient.IsErrNotFound(err) {
+			return result, wrap(ErrInvalidBuilderImage, fmt.Errorf("builder image %q is not present and its pull policy is %s", builderImage, api.PullNever))
+		} else if err != nil {
+			return result, fmt.Errorf("cannot inspect builder image %q: %w", builderImage, err)
+		}
+	}
+
+	// Registry credentials
+	// Resolved via the credential helpers of the docker config as the docker
+	// CLI does, such that the daemon can pull the builder and runtime images
+	// from registries which require them (ECR, GCR, ACR etc).  Failures to
+	// resolve credentials leave pulls to the daemon's own configuration.
+	images := []string{builderImage}
+	if b.runtimeImage != "" {
+		images = append(images, b.runtimeImage)
+	}
+	auths, authErr := b.registryAuth(images...)
+	if authErr != nil {
+		b.logf(LogLevelWarn, "Warning: %v", authErr)
+	}
+	if ref, err := name.ParseReference(builderImage); err == nil {
+		if ac, ok := auths[authKey(ref)]; ok {
+			cfg.PullAuthentication = api.AuthConfig{Username: ac.Username, Password: ac.Password, ServerAddress: ac.ServerAddress}
+		}
+	}
+
 	// Scaffold
-	if cfg, err = scaffold(cfg, f); err != nil {
+	b.setPhase(PhaseScaffold)
+	if cfg, err = b.scaffold(cfg, f); err != nil {
 		return
 	}
+	if b.assembleShell != "" && b.scaffolding && scaffolderOf(f.Runtime) != nil {
+		if err = b.verifyAssembleShell(ctx, client, cfg.BuilderImage); err != nil {
+			return
+		}
+	}
 
 	// Extract a an S2I script url from the image if provided and use
 	// this in the build config.
-	scriptURL, err := s2iScriptURL(ctx, client, cfg.BuilderImage)
-	if err != nil {
-		return fmt.Errorf("cannot get s2i script url: %w", err)
-	} else if scriptURL != "image:///usr/libexec/s2i" {
+	builderLabels, err := b.imageLabels(ctx, client, cfg.BuilderImage)
+	if e := builderImageError(cfg.BuilderImage, err); e != nil {
+		return result, wrap(ErrInvalidBuilderImage, e)
+	} else if err != nil {
+		return result, fmt.Errorf("cannot get s2i script url: %w", err)
+	}
+	if err = b.checkBuilderLabels(cfg.BuilderImage, builderLabels); err != nil {
+		return result, wrap(ErrInvalidBuilderImage, err)
+	}
+	if scriptURL := builderLabels[s2iScriptsURLLabel]; scriptURL != "" && scriptURL != "image:///usr/libexec/s2i" {
 		// Only set if the label found on the image is NOT the default.
 		// Otherwise this label, which is essentially a default fallback, will
 		// take precidence over any scripts provided in ./.s2i/bin, which are
 		// supposed to be the override to that default.
-		cfg.ScriptsURL = scriptURL
+		scriptsDir, err := os.MkdirTemp("", "func-s2i-scripts")
+		if err != nil {
+			return result, fmt.Errorf("cannot create temporary dir for scripts: %w", err)
+		}
+		defer os.RemoveAll(scriptsDir)
+		if cfg.ScriptsURL, err = b.resolveScriptsURL(ctx, scriptURL, scriptsDir); err != nil {
+			return result, wrap(ErrInvalidBuilderImage, err)
+		}
+	}
+
+	// Runtime image
+	// The artifacts to copy from the builder are those provided, or else
+	// those declared by the runtime image itself.
+	if b.runtimeImage != "" {
+		cfg.RuntimeImage = b.runtimeImage
+		artifacts := strings.Join(b.runtimeArtifacts, ";")
+		if artifacts == "" {
+			labels, err := b.imageLabels(ctx, client, b.runtimeImage)
+			if err != nil {
+				return result, fmt.Errorf("cannot inspect runtime image %q: %w", b.runtimeImage, err)
+			}
+			if artifacts = labels[constants.AssembleInputFilesLabel]; artifacts == "" {
+				return result, wrap(ErrValidation, fmt.Errorf("runtime image %q declares no files to copy from the builder (label %s): runtime artifacts are required",
+					b.runtimeImage, constants.AssembleInputFilesLabel))
+			}
+		}
+		if err = cfg.RuntimeArtifacts.Set(artifacts); err != nil {
+			return result, wrap(ErrValidation, fmt.Errorf("invalid runtime artifacts %q: %w", artifacts, err))
+		}
 	}
 
 	// Excludes
-	// Do not include .git, .env, .func or any language-specific cache directories
-	// (node_modules, etc) in the tar file sent to the builder, as this both
-	// bloats the build process and can cause unexpected errors in the resultant
-	// function.
-	cfg.ExcludeRegExp = "(^|/)\\.git|\\.env|\\.func|node_modules(/|$)"
+	cfg.ExcludeRegExp = excludeRegExp
 
 	// Environment variables
 	// Build Envs have local env var references interpolated then added to the
-	// config as an S2I EnvironmentList struct
+	// config as an S2I EnvironmentList struct, taking precedence over the
+	// runtime's default build envs.
+	if err = b.checkHostEnvs(f.Build.BuildEnvs); err != nil {
+		return result, wrap(ErrValidation, err)
+	}
 	buildEnvs, err := fn.Interpolate(f.Build.BuildEnvs)
 	if err != nil {
-		return err
+		return result, err
 	}
-	for k, v := range buildEnvs {
+	if unresolved := unresolvedReferences(buildEnvs); len(unresolved) > 0 {
+		msg := fmt.Sprintf("build envs %s contain unresolved ${...} references", strings.Join(unresolved, ", "))
+		if b.strictBuildEnvs {
+			return result, wrap(ErrValidation, errors.New(msg))
+		}
+		b.logf(LogLevelWarn, "Warning: %s", msg)
+	}
+	envs := maps.Clone(b.runtimeBuildEnvs(f.Runtime))
+	if envs == nil {
+		envs = make(map[string]string, len(buildEnvs))
+	}
+	if b.goModuleCache != nil && f.Runtime == "go" {
+		maps.Copy(envs, b.goModuleCache.envs())
+	}
+	if b.goPrivate != "" && f.Runtime == "go" {
+		envs["GOPRIVATE"] = b.goPrivate
+	}
+	maps.Copy(envs, buildEnvs)
+	for k, v := range envs {
 		cfg.Environment = append(cfg.Environment, api.EnvironmentSpec{Name: k, Value: v})
 	}
 
-	// Validate the config
-	if errs := validation.ValidateConfig(cfg); len(errs) > 0 {
-		for _, e := range errs {
-			fmt.Fprintf(os.Stderr, "ERROR: %s\n", e)
+	// Image labels
+	if b.labelsTemplate != "" {
+		if cfg.Labels, err = imageLabelsOf(b.labelsTemplate, f, started); err != nil {
+			return result, wrap(ErrValidation, err)
+		}
+	}
+
+	// Source SBOM
+	if b.sourceSBOM != "" {
+		var digest string
+		if digest, err = b.writeSourceSBOM(f, started); err != nil {
+			return
+		}
+		if digest != "" {
+			if cfg.Labels == nil {
+				cfg.Labels = map[string]string{}
+			}
+			cfg.Labels[SourceSBOMLabel] = digest
+		}
+	}
+
+	// Allowed UIDs
+	if b.allowedUIDs != nil {
+		if cfg.AllowedUIDs, err = parseAllowedUIDs(*b.allowedUIDs); err != nil {
+			return
+		}
+	}
+
+	// Apply any user-provided config mutations
+	for _, configFn := range b.configFns {
+		configFn(cfg)
+	}
+
+	// Envs of the builder image
+	if err = b.checkBuilderImageEnvs(ctx, client, cfg); err != nil {
+		if e := builderImageError(cfg.BuilderImage, err); e != nil {
+			return result, wrap(ErrInvalidBuilderImage, e)
+		}
+		return result, err
+	}
+	b.warnEOLRuntime(ctx, client, requestedBuilderImage, cfg.BuilderImage, started)
+
+	// Validate the config
+	if errs := validation.ValidateConfig(cfg); len(errs) > 0 {
+		for _, e := range errs {
+			b.logf(LogLevelError, "ERROR: %s", e)
+		}
+		return result, wrap(ErrValidation, errors.New("Unable to build via the s2i builder."))
+	}
+
+	if b.configDumpPath != "" {
+		if err = b.dumpConfig(cfg, interpolatedEnvs(f, buildEnvs)); err != nil {
+			return
+		}
+	}
+
+	// Skip the build if its inputs are unchanged since the last successful
+	// build and the image it produced still exists.
+	var hash string
+	if b.skipStatePath != "" && f.Build.Image != "" {
+		if hash, err = b.buildHash(ctx, client, f, cfg); err != nil {
+			return
+		}
+		var ok bool
+		if result, ok, err = b.upToDate(ctx, client, hash, f.Build.Image); err != nil || ok {
+			if ok {
+				b.logf(LogLevelInfo, "Image %q is up to date", f.Build.Image)
+				if b.tagGitSha {
+					err = b.tagUpToDate(ctx, client, f.Build.Image, f.Root)
+				}
+			}
+			return
 		}
-		return errors.New("Unable to build via the s2i builder.")
 	}
 
 	// Create the S2I builder instance if not overridden
 	var impl = b.impl
 	if impl == nil {
-		impl, _, err = strategies.Strategy(nil, cfg, build.Overrides{})
+		impl, _, err = strategies.Strategy(nil, cfg, b.overrides)
 		if err != nil {
-			return fmt.Errorf("cannot create s2i builder: %w", err)
+			return result, fmt.Errorf("cannot create s2i builder: %w", err)
 		}
 	}
 
+	if b.overrides.Downloader != nil {
+		impl = downloadingBuilder{Builder: impl, downloader: b.overrides.Downloader}
+	}
+
 	// Perform the build
-	result, err := impl.Build(cfg)
+	b.setPhase(PhaseGenerate)
+	s2iResult, err := impl.Build(cfg)
+	releaseRoot()
+	releaseRoot = func() {}
 	if err != nil {
 		return
 	}
 
-	if b.verbose {
-		for _, message := range result.Messages {
-			fmt.Fprintln(os.Stderr, message)
+	if s2iResult != nil && b.logs(LogLevelDebug) {
+		for _, message := range s2iResult.Messages {
+			b.logf(LogLevelDebug, "%s", message)
 		}
 	}
 
 	pr, pw := io.Pipe()
+	defer pr.Close() // unblocks the writer should the build end early
+
+	b.setPhase(PhaseContext)
 
 	// s2i apparently is not excluding the files in --as-dockerfile mode
 	exclude := regexp.MustCompile(cfg.ExcludeRegExp)
 
 	// if exists, patch dockerfile to using cache mount
 	if _, e := os.Stat(cfg.AsDockerfile); e == nil {
-		err = patchDockerfile(cfg.AsDockerfile, f)
+		err = b.patchDockerfile(cfg, f, buildKit)
 		if err != nil {
-			return err
+			return result, err
+		}
+		if b.dockerfileWriter != nil {
+			if err = writeDockerfile(b.dockerfileWriter, cfg.AsDockerfile); err != nil {
+				return result, err
+			}
 		}
 	}
 
-	const up = ".." + string(os.PathSeparator)
 	go func() {
 		tw := tar.NewWriter(pw)
-		err := filepath.Walk(tmp, func(path string, fi fs.FileInfo, err error) error {
-			if err != nil {
+		err := b.walkContext(tmp, "", exclude, func(p, path string, fi fs.FileInfo, lnk string) error {
+			if err := ctx.Err(); err != nil {
 				return err
 			}
 
-			p, err := filepath.Rel(tmp, path)
-			if err != nil {
-				return fmt.Errorf("cannot get relative path: %w", err)
-			}
-			if p == "." {
-				return nil
-			}
-
-			p = filepath.ToSlash(p)
-
-			if exclude.MatchString(p) {
-				return nil
-			}
-
-			lnk := ""
-			if fi.Mode()&fs.ModeSymlink != 0 {
-				lnk, err = os.Readlink(path)
-				if err != nil {
-					return fmt.Errorf("cannot read link: %w", err)
-				}
-				if filepath.IsAbs(lnk) {
-					lnk, err = filepath.Rel(tmp, lnk)
-					if err != nil {
-						return fmt.Errorf("cannot get relative path for symlink: %w", err)
-					}
-					if strings.HasPrefix(lnk, up) || lnk == ".." {
-						return fmt.Errorf("link %q points outside source root", p)
-					}
-				}
-			}
-
 			hdr, err := tar.FileInfoHeader(fi, filepath.ToSlash(lnk))
 			if err != nil {
 				return fmt.Errorf("cannot create tar header: %w", err)
@@ -318,12 +1359,29 @@ func (b *Builder) Build(ctx context.Context, f fn.Function, platforms []fn.Platf
 				// Windows does not have execute permission, we assume that all files are executable.
 				hdr.Mode |= 0111
 			}
+			if b.sourceMount && buildKit {
+				ownSource(hdr)
+			}
+
+			// Scripts are normalized in full, their size being that of the
+			// normalized content.
+			var script []byte
+			if b.normalizeScripts && fi.Mode().IsRegular() && isScript(p) {
+				if script, err = normalizedScript(path); err != nil {
+					return err
+				}
+				hdr.Size = int64(len(script))
+			}
 
 			err = tw.WriteHeader(hdr)
 			if err != nil {
 				return fmt.Errorf("cannot write header to thar stream: %w", err)
 			}
-			if fi.Mode().IsRegular() {
+			if script != nil {
+				if _, err = tw.Write(script); err != nil {
+					return fmt.Errorf("cannot copy file to tar stream :%w", err)
+				}
+			} else if fi.Mode().IsRegular() {
 				var r io.ReadCloser
 				r, err = os.Open(path)
 				if err != nil {
@@ -339,24 +1397,306 @@ func (b *Builder) Build(ctx context.Context, f fn.Function, platforms []fn.Platf
 
 			return nil
 		})
-		_ = tw.Close()
+		// Only finish the archive if the walk succeeded so that the reader does
+		// not mistake a partial context for a complete one.
+		if err == nil {
+			err = tw.Close()
+		}
 		_ = pw.CloseWithError(err)
 	}()
 
 	opts := types.ImageBuildOptions{
-		Tags:       []string{f.Build.Image},
-		PullParent: true,
-		Version:    types.BuilderBuildKit,
+		Tags:        []string{f.Build.Image},
+		PullParent:  pullPolicy == api.PullAlways || !b.pullPolicySet(),
+		Version:     types.BuilderV1,
+		AuthConfigs: auths,
+		SessionID:   b.session,
+		CPUSetCPUs:  b.buildCPUs,
+		Memory:      b.buildMemory,
+		Target:      b.target,
+		ExtraHosts:  b.extraHosts,
+		Ulimits:     b.ulimits,
+	}
+	if b.buildMemory > 0 {
+		opts.MemorySwap = b.buildMemory // no swap beyond the memory limit
+	}
+	if b.tagGitSha && f.Build.Image != "" {
+		var tag string
+		if tag, err = b.gitShaTag(f.Build.Image, f.Root); err != nil {
+			return
+		}
+		if tag != "" {
+			opts.Tags = append(opts.Tags, tag)
+		}
+	}
+	if buildKit {
+		opts.Version = types.BuilderBuildKit
+	}
+	opts.BuildArgs = b.proxyBuildArgs()
+	// Attestations are requested via the build args recognized by BuildKit,
+	// as the build API provides no dedicated options.
+	if b.sbom {
+		opts.BuildArgs["BUILDKIT_ATTEST_SBOM"] = ptr.String("")
+	}
+	if b.provenance {
+		opts.BuildArgs["BUILDKIT_ATTEST_PROVENANCE"] = ptr.String("mode=min")
+	}
+	if b.imageFormat != "" {
+		opts.Outputs = []types.ImageBuildOutput{{
+			Type: "moby",
+			Attrs: map[string]string{
+				"name":           f.Build.Image,
+				"oci-mediatypes": strconv.FormatBool(b.imageFormat == builders.OCI),
+			},
+		}}
+	}
+	if b.push {
+		opts.Outputs = []types.ImageBuildOutput{b.pushOutput(opts.Tags)}
 	}
 
 	resp, err := client.ImageBuild(ctx, pr, opts)
 	if err != nil {
-		return fmt.Errorf("cannot build the app image: %w", err)
+		return result, fmt.Errorf("cannot build the app image: %w", err)
 	}
 	defer resp.Body.Close()
+	b.setPhase(PhaseBuild)
+
+	stream := &tailBuffer{max: assembleOutputMax}
+	if err = b.displayJSONMessages(io.TeeReader(resp.Body, stream)); err != nil {
+		if buildKit && b.cacheMount {
+			if e := cacheMountError(err); e != nil {
+				return result, e
+			}
+		}
+		return result, assembleError(err, stream.buf)
+	}
+
+	b.setPhase(PhaseFinish)
+
+	// Image size
+	// Reported for tagged images, and checked against the size budget if
+	// one was provided.
+	result.Image = f.Build.Image
+	if result.Image == "" {
+		return
+	}
+	if !b.load {
+		// The image is only in its registry.
+		if hash != "" {
+			if err = writeBuildState(b.skipStatePath, buildState{Hash: hash, Image: result.Image}); err != nil {
+				return result, fmt.Errorf("cannot record build state: %w", err)
+			}
+		}
+		return
+	}
+	img, _, err := client.ImageInspectWithRaw(ctx, result.Image)
+	if err != nil {
+		return result, fmt.Errorf("cannot inspect the built image: %w", err)
+	}
+	result.Size = img.Size
+	if b.maxImageSize > 0 && result.Size > b.maxImageSize {
+		return result, ErrImageTooLarge{Image: result.Image, Size: result.Size, Max: b.maxImageSize}
+	}
+
+	if b.smokeTest != nil {
+		if err = b.runSmokeTest(ctx, client, result.Image); err != nil {
+			return
+		}
+	}
+
+	if len(b.artifacts) > 0 {
+		if err = b.extractArtifacts(ctx, client, result.Image); err != nil {
+			return
+		}
+	}
+
+	if b.provenancePath != "" {
+		var builderDigest string
+		if builderDigest, err = b.builderImageDigest(ctx, client, cfg.BuilderImage); err != nil {
+			return result, fmt.Errorf("cannot get the digest of the builder image: %w", err)
+		}
+		var imageDigest string
+		if imageDigest, err = manifestDigest(ctx, client, result.Image, img); err != nil {
+			return result, fmt.Errorf("cannot get the digest of the built image: %w", err)
+		}
+		p := b.newProvenance(f, result.Image, imageDigest, cfg.BuilderImage, builderDigest, started)
+		if err = writeProvenance(b.provenancePath, p); err != nil {
+			return
+		}
+	}
+
+	if hash != "" {
+		if err = writeBuildState(b.skipStatePath, buildState{Hash: hash, Image: result.Image}); err != nil {
+			return result, fmt.Errorf("cannot record build state: %w", err)
+		}
+	}
+	return
+}
+
+// Warm prepares for a subsequent build of the function by pulling its
+// builder image ahead of time and, for Go functions built with the Go module
+// cache (see WithGoModuleCache) and BuildKit, by downloading their modules
+// into it.  The dependencies of other runtimes are installed by the assemble
+// scripts of their builder images, and are thus not warmed.  Pulling an
+// image which is already present, and downloading modules which are already
+// cached, is cheap, so Warm may be invoked repeatedly, and it can be
+// canceled via the context.  An interrupted pull or download is resumed by
+// the next Warm or Build.
+func (b *Builder) Warm(ctx context.Context, f fn.Function) error {
+	if err := b.validate(); err != nil {
+		return wrap(ErrValidation, err)
+	}
+	builderImage, err := b.builderImage(f, "")
+	if err != nil {
+		return err
+	}
+
+	client, done, err := b.dockerClient()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	r, err := client.ImagePull(ctx, builderImage, image.PullOptions{})
+	if e := builderImageError(builderImage, err); e != nil {
+		return e
+	} else if err != nil {
+		return fmt.Errorf("cannot pull builder image %q: %w", builderImage, err)
+	}
+	defer r.Close()
+
+	if err = b.displayJSONMessages(r); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("pull of builder image %q interrupted: %w", builderImage, ctx.Err())
+		}
+		return fmt.Errorf("cannot pull builder image %q: %w", builderImage, err)
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if b.goModuleCache != nil && f.Runtime == "go" {
+		return b.warmGoModules(ctx, client, builderImage, f)
+	}
+	return nil
+}
+
+// BatchBuild builds each of the functions, all for the given platforms, with
+// at most concurrency builds in flight at once (one if less than one).  A
+// single docker client is shared by all builds.  A failed build does not abort
+// the batch: the returned results are in the order of the functions, with a
+// zero result for each which failed, and the error joins those of every failed
+// build.  The files of WithSkipIfUnchanged, WithProvenance and
+// WithConfigDump are per function (see batchPath), such that concurrent
+// builds neither share nor overwrite them.
+func (b *Builder) BatchBuild(ctx context.Context, ff []fn.Function, platforms []fn.Platform, concurrency int) ([]BuildResult, error) {
+	client, done, err := b.dockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 
+	shared := *b
+	shared.cli = client
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		results = make([]BuildResult, len(ff))
+		errs    = make([]error, len(ff))
+		sem     = make(chan struct{}, concurrency)
+		wg      sync.WaitGroup
+	)
+	for i, f := range ff {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			fb := shared
+			fb.skipStatePath = batchPath(shared.skipStatePath, f)
+			fb.provenancePath = batchPath(shared.provenancePath, f)
+			fb.configDumpPath = batchPath(shared.configDumpPath, f)
+			result, err := fb.BuildWithResult(ctx, f, platforms)
+			if err != nil {
+				errs[i] = fmt.Errorf("cannot build function %q: %w", batchName(f), err)
+				return
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	return results, errors.Join(errs...)
+}
+
+// batchPath returns the path, of an option naming a single file, for the
+// function of a batch build: relative paths are within the function's root,
+// and absolute paths are suffixed with its name and a digest of its root
+// (before any extension), for example "state-hello-1a2b3c4d.json".
+func batchPath(path string, f fn.Function) string {
+	if path == "" {
+		return ""
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(f.Root, path)
+	}
+	sum := sha256.Sum256([]byte(f.Root))
+	suffix := hex.EncodeToString(sum[:4])
+	if f.Name != "" {
+		suffix = f.Name + "-" + suffix
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + suffix + ext
+}
+
+// batchName identifies the function in errors of a batch build.
+func batchName(f fn.Function) string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.Root
+}
+
+// dockerClient returns the client of the remote BuildKit daemon (see
+// WithBuildKitAddr), that with which the builder was configured or, if none
+// was provided, a new client for the default docker host.  The
+// returned function must be called when the client is no longer needed.
+func (b *Builder) dockerClient() (DockerClient, func(), error) {
+	if b.buildKitAddr != "" {
+		return remoteBuildKit{addr: b.buildKitAddr, tls: b.buildKitTLS}, func() {}, nil
+	}
+	if b.cli != nil {
+		return b.cli, func() {}, nil
+	}
+	// The host is that of the client: DOCKER_HOST, or the default host, of
+	// which the socket is checked.
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = defaultDockerHost
+	}
+	if err := checkDockerSocket(host); err != nil {
+		return nil, nil, err
+	}
+	c, _, err := docker.NewClient(host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create docker client: %w", err)
+	}
+	return c, func() { c.Close() }, nil
+}
+
+// displayJSONMessages from the daemon, such as those of a build or pull, at
+// LogLevelDebug.  Errors reported within the stream are returned.
+func (b *Builder) displayJSONMessages(r io.Reader) error {
+	if b.logFile != nil {
+		var wait func()
+		r, wait = b.teeLogFile(r)
+		defer wait()
+	}
+	if b.jsonLogs != nil && b.logs(LogLevelDebug) {
+		return b.jsonLogs.writeStream(b.phase, r)
+	}
 	var out io.Writer = io.Discard
-	if b.verbose {
+	if b.logs(LogLevelDebug) {
 		out = os.Stderr
 	}
 
@@ -367,24 +1707,201 @@ func (b *Builder) Build(ctx context.Context, f fn.Function, platforms []fn.Platf
 		isTerminal = term.IsTerminal(int(outF.Fd()))
 	}
 
-	return jsonmessage.DisplayJSONMessagesStream(resp.Body, out, fd, isTerminal, nil)
+	return jsonmessage.DisplayJSONMessagesStream(r, out, fd, isTerminal, buildKitOutput(out))
+}
+
+// buildKitOutput returns the aux callback of jsonmessage streams which writes
+// the output of builds with BuildKit, that of its trace messages, to w.
+func buildKitOutput(w io.Writer) func(jsonmessage.JSONMessage) {
+	return func(m jsonmessage.JSONMessage) {
+		_, _ = w.Write(buildKitLogs(m))
+	}
 }
 
-func patchDockerfile(path string, f fn.Function) error {
-	data, err := os.ReadFile(path)
+// containerdSnapshotter is the driver type reported by daemons which store
+// images in containerd.
+const containerdSnapshotter = "io.containerd.snapshotter.v1"
+
+// supportsAttestations returns an error unless the daemon is known to store
+// images in containerd, which is required for images to hold attestations.
+func supportsAttestations(ctx context.Context, client DockerClient) error {
+	containerd, err := usesContainerdStore(ctx, client)
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot determine whether the docker daemon supports attestations: %w", err)
+	}
+	if !containerd {
+		return errors.New("the docker daemon does not support attestations: enable its containerd image store to build images with attestations")
+	}
+	return nil
+}
+
+// usesContainerdStore returns whether the docker daemon stores images in the
+// containerd image store.
+func usesContainerdStore(ctx context.Context, client DockerClient) (bool, error) {
+	if _, ok := client.(remoteBuildKit); ok {
+		return true, nil // whose exporters are those of the containerd image store
+	}
+	c, ok := client.(interface {
+		Info(ctx context.Context) (system.Info, error)
+	})
+	if !ok {
+		return false, errors.New("the docker client does not provide the daemon's info")
+	}
+	info, err := c.Info(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range info.DriverStatus {
+		if s[0] == "driver-type" && s[1] == containerdSnapshotter {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// assembleOutputMax is the number of bytes of the end of the build stream
+// retained for reporting the output of a failed assemble script.
+const assembleOutputMax = 64 * 1024
+
+// tailBuffer retains the last max bytes written to it.
+type tailBuffer struct {
+	buf []byte
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
+
+// walkContext walks the directory root, invoking visit for each entry which
+// is neither excluded nor rejected by a filter.  Paths passed to visit as p
+// are relative to root, joined to prefix and use forward slashes; these are
+// also the paths matched by the exclusions and filters.  The target of
+// symbolic links is passed as lnk (see contextLink).
+func (b *Builder) walkContext(root, prefix string, exclude *regexp.Regexp, visit func(p, path string, fi fs.FileInfo, lnk string) error) error {
+	return filepath.Walk(root, func(path string, fi fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		p, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("cannot get relative path: %w", err)
+		}
+		if p == "." {
+			return nil
+		}
+
+		p = filepath.ToSlash(p)
+		if prefix != "" {
+			p = prefix + "/" + p
+		}
+
+		if exclude.MatchString(p) {
+			return nil
+		}
+
+		for _, filter := range b.filters {
+			if !filter(p, fi) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		lnk := ""
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			lnk, err = os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("cannot read link: %w", err)
+			}
+			// Links are archived as-is and never followed, so a link to an
+			// ancestor (such as the scaffolding's link to the function root)
+			// is fine.  A link which can not be resolved for reasons other
+			// than a missing target, however, is part of a loop.
+			if _, err = filepath.EvalSymlinks(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("link %q is part of a symlink loop: %w", p, err)
+			}
+			var ok bool
+			if lnk, ok = contextLink(root, path, lnk, os.PathSeparator); !ok {
+				return fmt.Errorf("link %q points outside source root", p)
+			}
+		}
+
+		return visit(p, path, fi, lnk)
+	})
+}
+
+// contextLink returns the target of the symbolic link at linkPath, of the
+// directory root, as archived in the build context: using forward slashes
+// whatever sep, the path separator of the host, as the daemon may be of
+// another OS, and, if absolute, relative to the directory of the link.
+// False is returned for absolute targets outside root.
+func contextLink(root, linkPath, target string, sep byte) (string, bool) {
+	slash := func(p string) string {
+		return path.Clean(strings.ReplaceAll(p, string(sep), "/"))
+	}
+	if !isAbsSlash(slash(target)) {
+		return strings.ReplaceAll(target, string(sep), "/"), true
+	}
+	root, target = slash(root), slash(target)
+	prefix := strings.TrimSuffix(root, "/") + "/"
+
+	// Relative to the directory of the link: up to the common ancestor of
+	// the two and down to the target, both being of root.
+	var dirs, rels []string
+	if target != root {
+		rel, ok := strings.CutPrefix(target, prefix)
+		if !ok {
+			return "", false
+		}
+		rels = strings.Split(rel, "/")
+	}
+	if dir := path.Dir(slash(linkPath)); dir != root {
+		dirs = strings.Split(strings.TrimPrefix(dir, prefix), "/")
+	}
+	i := 0
+	for i < len(dirs) && i < len(rels) && dirs[i] == rels[i] {
+		i++
 	}
-	re := regexp.MustCompile(`RUN (.*assemble)`)
-	s := sha1.Sum([]byte(f.Root))
-	mountCmd := "--mount=type=cache,target=/tmp/artifacts/,uid=1001,id=" + hex.EncodeToString(s[:8])
-	replacement := fmt.Sprintf("RUN %s \\\n    $1", mountCmd)
-	newDockerFileStr := re.ReplaceAllString(string(data), replacement)
+	parts := append(slices.Repeat([]string{".."}, len(dirs)-i), rels[i:]...)
+	if len(parts) == 0 {
+		return ".", true
+	}
+	return strings.Join(parts, "/"), true
+}
+
+// isAbsSlash returns true if the slash-separated path p is absolute, on any
+// OS: rooted, or of a Windows volume.
+func isAbsSlash(p string) bool {
+	return strings.HasPrefix(p, "/") || len(p) >= 3 && p[1] == ':' && p[2] == '/' &&
+		('a' <= p[0] && p[0] <= 'z' || 'A' <= p[0] && p[0] <= 'Z')
+}
 
-	return os.WriteFile(path, []byte(newDockerFileStr), 0644)
+// s2iScriptsURLLabel is the label of builder images locating their scripts.
+const s2iScriptsURLLabel = "io.openshift.s2i.scripts-url"
+
+// imageLabels returns the labels of the image from the daemon or, if it is
+// not present there, from its registry.
+func (b *Builder) imageLabels(ctx context.Context, cli DockerClient, image string) (map[string]string, error) {
+	c, err := b.imageConfigOf(ctx, cli, image)
+	return c.labels, err
+}
+
+// imageConfig is the part of the config of an image used by the builder.
+type imageConfig struct {
+	labels map[string]string
+	env    []string // KEY=VALUE
 }
 
-func s2iScriptURL(ctx context.Context, cli DockerClient, image string) (string, error) {
+// imageConfigOf returns the config of the image from the daemon or, if it is
+// not present there, from its registry.
+func (b *Builder) imageConfigOf(ctx context.Context, cli DockerClient, image string) (imageConfig, error) {
 	img, _, err := cli.ImageInspectWithRaw(ctx, image)
 	if err != nil {
 		if dockerClient.IsErrNotFound(err) { // image is not in the daemon, get info directly from registry
@@ -396,100 +1913,240 @@ func s2iScriptURL(ctx context.Context, cli DockerClient, image string) (string,
 
 			ref, err = name.ParseReference(image)
 			if err != nil {
-				return "", fmt.Errorf("cannot parse image name: %w", err)
+				return imageConfig{}, fmt.Errorf("cannot parse image name: %w", err)
 			}
 			if _, ok := ref.(name.Tag); ok && !slices.Contains(maps.Values(DefaultBuilderImages), image) {
-				fmt.Fprintln(os.Stderr, "image referenced by tag which is discouraged: Tags are mutable and can point to a different artifact than the expected one")
+				b.logf(LogLevelWarn, "image referenced by tag which is discouraged: Tags are mutable and can point to a different artifact than the expected one")
 			}
-			img, err = remote.Image(ref)
+			img, err = remote.Image(ref, b.remoteOptions(ctx)...)
 			if err != nil {
-				return "", fmt.Errorf("cannot get image from registry: %w", err)
+				return imageConfig{}, fmt.Errorf("cannot get image from registry: %w", err)
 			}
 			cfg, err = img.ConfigFile()
 			if err != nil {
-				return "", fmt.Errorf("cannot get config for image: %w", err)
-			}
-
-			if cfg.Config.Labels != nil {
-				if u, ok := cfg.Config.Labels["io.openshift.s2i.scripts-url"]; ok {
-					return u, nil
-				}
+				return imageConfig{}, fmt.Errorf("cannot get config for image: %w", err)
 			}
+			return imageConfig{labels: cfg.Config.Labels, env: cfg.Config.Env}, nil
 		}
-		return "", err
+		return imageConfig{}, err
 	}
 
-	if img.Config != nil && img.Config.Labels != nil {
-		if u, ok := img.Config.Labels["io.openshift.s2i.scripts-url"]; ok {
-			return u, nil
-		}
+	// Labels of the config take precedence over those of the container
+	// config.
+	c := imageConfig{labels: map[string]string{}}
+	//nolint:staticcheck
+	if img.ContainerConfig != nil {
+		maps.Copy(c.labels, img.ContainerConfig.Labels)
 	}
+	if img.Config != nil {
+		maps.Copy(c.labels, img.Config.Labels)
+		c.env = img.Config.Env
+	}
+	return c, nil
+}
 
-	//nolint:staticcheck
-	if img.ContainerConfig != nil && img.ContainerConfig.Labels != nil {
-		if u, ok := img.ContainerConfig.Labels["io.openshift.s2i.scripts-url"]; ok {
-			return u, nil
+// Builder Image chooses the correct builder image or defaults, as resolved by
+// DefaultBuilderImageResolver.  The builder image of builds by a Builder, as
+// resolved by its resolver (see WithImageResolver), is that of its
+// BuilderImage method.
+func BuilderImage(f fn.Function, builderName string) (string, error) {
+	return resolveBuilderImage(f, builderName, "", DefaultBuilderImageResolver{})
+}
+
+// BuilderImage returns the builder image of builds of the function by the
+// builder targeting no specific platform: that of its func.yaml, that of
+// EnvBuilderImage, or that of the resolver of the builder.
+func (b *Builder) BuilderImage(f fn.Function) (string, error) {
+	return b.builderImage(f, "")
+}
+
+// unresolvedReference matches a ${VAR} style variable reference.
+var unresolvedReference = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+
+// unresolvedReferences returns the sorted names of the envs whose values
+// contain a ${VAR} style reference.
+func unresolvedReferences(envs map[string]string) (names []string) {
+	for k, v := range envs {
+		if unresolvedReference.MatchString(v) {
+			names = append(names, k)
 		}
 	}
+	slices.Sort(names)
+	return
+}
 
-	return "", nil
+// runtimeBuildEnvs returns the default build envs for the given runtime.
+func (b *Builder) runtimeBuildEnvs(runtime string) map[string]string {
+	if b.defaultBuildEnvs != nil {
+		return b.defaultBuildEnvs[runtime]
+	}
+	return DefaultBuildEnvs[runtime]
 }
 
-// Builder Image chooses the correct builder image or defaults.
-func BuilderImage(f fn.Function, builderName string) (string, error) {
-	// delegate as the logic is shared amongst builders
-	return builders.Image(f, builderName, DefaultBuilderImages)
+// pinnedBuilderImage returns the builder image pinned for the given platform
+// in the function's build configuration, if any.
+func pinnedBuilderImage(f fn.Function, builderName, platform string) (string, bool) {
+	for k, v := range f.Build.PlatformBuilderImages[builderName] {
+		if strings.EqualFold(k, platform) && v != "" {
+			return v, true
+		}
+	}
+	return "", false
 }
 
 // scaffold the project
 // Returns a config with settings suitable for building runtimes which
 // support scaffolding.
-func scaffold(cfg *api.Config, f fn.Function) (*api.Config, error) {
-	// Scafffolding is currently only supported by the Go runtime
-	if f.Runtime != "go" {
+func (b *Builder) scaffold(cfg *api.Config, f fn.Function) (*api.Config, error) {
+	scaffolder := scaffolderOf(f.Runtime)
+	if scaffolder == nil {
 		return cfg, nil
 	}
 
-	contextDir := filepath.Join(".s2i", "builds", "last")
-	appRoot := filepath.Join(f.Root, contextDir)
-	_ = os.RemoveAll(appRoot)
+	cfg.KeepSymlinks = true // Don't infinite loop on the symlink to root.
 
-	// The enbedded repository contains the scaffolding code itself which glues
-	// together the middleware and a function via main
-	embeddedRepo, err := fn.NewRepository("", "") // default is the embedded fs
+	if !b.scaffolding {
+		return cfg, nil
+	}
+	repo, err := scaffoldingRepository(b.scaffoldRepository)
 	if err != nil {
-		return cfg, fmt.Errorf("unable to load the embedded scaffolding. %w", err)
+		return cfg, err
+	}
+	if b.invoke != "" {
+		f.Invoke = b.invoke // of the scaffolding only
+	}
+	opts := ScaffoldOptions{Repository: repo, Shell: b.assembleShell, Transforms: b.scaffoldTransforms, Overwrite: b.scaffoldOverwrite}
+	if err = scaffolder(cfg, f, filepath.Join(f.Root, ".s2i"), opts); err != nil {
+		return cfg, err
 	}
+	return cfg, nil
+}
 
-	// Write scaffolding to .s2i/builds/last
-	err = scaffolding.Write(appRoot, f.Root, f.Runtime, f.Invoke, embeddedRepo.FS())
+// Scaffold writes the scaffolding of the function, which glues together the
+// middleware and the function via main, and any assemble script which the
+// runtime requires, to outDir.  The layout of outDir is that of the .s2i
+// directory of a function: the scaffolding is written to builds/last and the
+// assemble script to bin/assemble.  A docker daemon is not required.
+// Runtimes which are not scaffolded (see RegisterScaffolder) yield
+// ErrScaffoldingNotSupported.
+func Scaffold(f fn.Function, outDir string) error {
+	scaffolder := scaffolderOf(f.Runtime)
+	if scaffolder == nil {
+		return wrap(ErrValidation, fmt.Errorf("%w: %q", ErrScaffoldingNotSupported, f.Runtime))
+	}
+	repo, err := scaffoldingRepository("")
 	if err != nil {
-		return cfg, fmt.Errorf("unable to build due to a scaffold error. %w", err)
+		return err
 	}
+	return scaffolder(&api.Config{}, f, outDir, ScaffoldOptions{Repository: repo, Shell: DefaultAssembleShell})
+}
 
-	// Write out an S2I assembler script if the runtime needs to override the
-	// one provided in the S2I image.
-	assemble, err := assembler(f)
-	if err != nil {
-		return cfg, err
+// syncDir makes dst identical to src, moving files of src into dst only where
+// they differ from those of dst in content, mode or (for links) target, such
+// that unchanged files keep their modification times.  Entries of dst which
+// are not in src are removed.
+func syncDir(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
 	}
-	if assemble != "" {
-		if err := os.MkdirAll(filepath.Join(f.Root, ".s2i", "bin"), 0755); err != nil {
-			return nil, fmt.Errorf("unable to create .s2i bin dir. %w", err)
+	synced := map[string]bool{}
+	err := filepath.Walk(src, func(path string, fi fs.FileInfo, err error) error {
+		if err != nil || path == src {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		synced[rel] = true
+		target := filepath.Join(dst, rel)
+		existing, err := os.Lstat(target)
+		if err == nil && fi.IsDir() && existing.IsDir() {
+			return os.Chmod(target, fi.Mode().Perm())
+		}
+		if err == nil && existing.Mode() == fi.Mode() && !fi.IsDir() {
+			if same, err := sameFile(path, target, fi); err != nil || same {
+				return err
+			}
+		}
+		if err = os.RemoveAll(target); err != nil {
+			return err
 		}
-		if err := os.WriteFile(filepath.Join(f.Root, ".s2i", "bin", "assemble"), []byte(assemble), 0700); err != nil {
-			return nil, fmt.Errorf("unable to write go assembler. %w", err)
+		if fi.IsDir() {
+			return os.Mkdir(target, fi.Mode().Perm())
 		}
+		return os.Rename(path, target)
+	})
+	if err != nil {
+		return err
 	}
 
-	cfg.KeepSymlinks = true // Don't infinite loop on the symlink to root.
+	// Remove what is no longer scaffolded.
+	return filepath.Walk(dst, func(path string, fi fs.FileInfo, err error) error {
+		if err != nil || path == dst {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		if synced[rel] {
+			return nil
+		}
+		if err = os.RemoveAll(path); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// sameFile returns true if the files a and b, of equal mode fi, have equal
+// content or, if links, equal targets.
+func sameFile(a, b string, fi fs.FileInfo) (bool, error) {
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		ta, err := os.Readlink(a)
+		if err != nil {
+			return false, err
+		}
+		tb, err := os.Readlink(b)
+		return ta == tb, err
+	}
+	if !fi.Mode().IsRegular() {
+		return false, nil
+	}
+	ca, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	cb, err := os.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ca, cb), nil
+}
 
-	// We want to force that the system use the (copy via filesystem)
-	// method rather than a "git clone" method because (other than being
-	// faster) appears to have a bug where the assemble script is ignored.
-	// Maybe this issue is related:
-	// https://github.com/openshift/source-to-image/issues/1141
-	cfg.ForceCopy = true
+// downloadingBuilder obtains the source of builds with the downloader, into
+// the build context, before building with the Builder.  The Dockerfile
+// strategy of S2I does not consult the Downloader override, so the source is
+// placed where it would have copied it, and the config's own source cleared.
+type downloadingBuilder struct {
+	build.Builder
+	downloader build.Downloader
+}
 
-	return cfg, nil
+func (d downloadingBuilder) Build(cfg *api.Config) (*api.Result, error) {
+	cfg.WorkingDir = filepath.Dir(cfg.AsDockerfile)
+	if err := os.MkdirAll(filepath.Join(cfg.WorkingDir, constants.Source), 0755); err != nil {
+		return nil, err
+	}
+	info, err := d.downloader.Download(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot download the source: %w", err)
+	}
+	cfg.Source = nil
+	cfg.SourceInfo = info
+	return d.Builder.Build(cfg)
 }
@@ -0,0 +1,106 @@
+package s2i
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/build"
+	"github.com/openshift/source-to-image/pkg/scm/git"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// Test_StrategyOverride ensures that the downloader override obtains the
+// source of the build, into its context, before the implementation builds.
+func Test_StrategyOverride(t *testing.T) {
+	var built bool
+	impl := implFunc(func(cfg *api.Config) (*api.Result, error) {
+		built = true
+		if cfg.Source != nil {
+			t.Errorf("expected the source of the config to be cleared, got %v", cfg.Source)
+		}
+		bb, err := os.ReadFile(filepath.Join(filepath.Dir(cfg.AsDockerfile), "upload", "src", "downloaded"))
+		if err != nil || string(bb) != "source" {
+			t.Errorf("expected the downloaded source in the build context, got %q (%v)", bb, err)
+		}
+		return &api.Result{}, nil
+	})
+
+	b := NewBuilder(WithImpl(impl), WithDockerClient(stubDocker{}), WithStrategyOverride(build.Overrides{Downloader: stubDownloader{}}))
+	if err := b.Build(context.Background(), fn.Function{Root: t.TempDir(), Runtime: "node"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !built {
+		t.Fatal("expected the implementation to build")
+	}
+}
+
+// stubDownloader writes a file to the source directory of the working
+// directory of builds.
+type stubDownloader struct{}
+
+func (stubDownloader) Download(cfg *api.Config) (*git.SourceInfo, error) {
+	return &git.SourceInfo{}, os.WriteFile(filepath.Join(cfg.WorkingDir, "upload", "src", "downloaded"), []byte("source"), 0644)
+}
+
+type implFunc func(*api.Config) (*api.Result, error)
+
+func (f implFunc) Build(cfg *api.Config) (*api.Result, error) { return f(cfg) }
+
+type stubImpl struct{}
+
+func (stubImpl) Build(*api.Config) (*api.Result, error) { return &api.Result{}, nil }
+
+type stubDocker struct{}
+
+func (stubDocker) ImageBuild(_ context.Context, r io.Reader, _ types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	_, _ = io.Copy(io.Discard, r)
+	return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func (stubDocker) ImageInspectWithRaw(context.Context, string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{}, nil, nil
+}
+
+func (stubDocker) ImagePull(context.Context, string, image.PullOptions) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+// TestContextLink ensures that the targets of links are archived with forward
+// slashes, and absolute targets relative to the link, whatever the separator
+// of the host, such as when a Windows host builds with a Linux daemon.
+func TestContextLink(t *testing.T) {
+	tests := []struct {
+		name               string
+		sep                byte
+		root, link, target string
+		want               string
+		ok                 bool
+	}{
+		{"windows relative", '\\', `C:\fn`, `C:\fn\src\util.js`, `..\lib\util.js`, "../lib/util.js", true},
+		{"windows absolute", '\\', `C:\fn`, `C:\fn\src\util.js`, `C:\fn\lib\util.js`, "../lib/util.js", true},
+		{"windows root", '\\', `C:\fn`, `C:\fn\.s2i\builds\last\f`, `C:\fn`, "../../..", true},
+		{"windows sibling", '\\', `C:\fn`, `C:\fn\a`, `C:\fn\b\c`, "b/c", true},
+		{"windows outside", '\\', `C:\fn`, `C:\fn\a`, `C:\other\a`, "", false},
+		{"windows prefix", '\\', `C:\fn`, `C:\fn\a`, `C:\fnord\a`, "", false},
+		{"posix relative", '/', "/fn", "/fn/src/util.js", "../lib/util.js", "../lib/util.js", true},
+		{"posix absolute", '/', "/fn", "/fn/a/b/link", "/fn/a/c", "../c", true},
+		{"posix self", '/', "/fn", "/fn/self", "/fn", ".", true},
+		{"posix outside", '/', "/fn", "/fn/a", "/etc/passwd", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := contextLink(tt.root, tt.link, tt.target, tt.sep)
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("expected %q (%v), got %q (%v)", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
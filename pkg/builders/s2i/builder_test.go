@@ -4,16 +4,24 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/registry"
@@ -22,12 +30,17 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/system"
 	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	controlapi "github.com/moby/buildkit/api/services/control"
 
 	"github.com/openshift/source-to-image/pkg/api"
 
 	"knative.dev/func/pkg/builders"
 	"knative.dev/func/pkg/builders/s2i"
+	"knative.dev/func/pkg/docker"
 	fn "knative.dev/func/pkg/functions"
 	. "knative.dev/func/pkg/testing"
 )
@@ -165,6 +178,261 @@ func Test_BuilderImageConfigurable(t *testing.T) {
 	}
 }
 
+// Test_BuilderImagePlatformPinned ensures that a builder image pinned for the
+// requested platform in func.yaml is used, and that platforms which are not
+// pinned fall back to the function's builder image.
+func Test_BuilderImagePlatformPinned(t *testing.T) {
+	testRegistry := startRegistry(t)
+
+	builderImage := testRegistry + "/default/builder:latest"
+	tag, err := name.NewTag(builderImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := tarball.ImageFromPath(filepath.Join("testdata", "builder.tar"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(&tag, img); err != nil {
+		t.Fatal(err)
+	}
+
+	pinned := "example.com/user/builder@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	tests := []struct {
+		name     string
+		platform fn.Platform
+		expected string
+	}{
+		{name: "pinned platform", platform: fn.Platform{OS: "linux", Architecture: "arm64"}, expected: pinned},
+		{name: "unpinned platform", platform: fn.Platform{OS: "linux", Architecture: "amd64"}, expected: builderImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := fn.Function{
+				Runtime: "node",
+				Build: fn.BuildSpec{
+					BuilderImages: map[string]string{builders.S2I: builderImage},
+					PlatformBuilderImages: map[string]map[string]string{
+						builders.S2I: {"linux/arm64": pinned},
+					},
+				},
+			}
+			i := &mockImpl{
+				BuildFn: func(cfg *api.Config) (*api.Result, error) {
+					if cfg.BuilderImage != tt.expected {
+						t.Fatalf("expected builder image %q, got %q", tt.expected, cfg.BuilderImage)
+					}
+					return nil, nil
+				},
+			}
+			b := s2i.NewBuilder(s2i.WithName(builders.S2I), s2i.WithImpl(i), s2i.WithDockerClient(mockDocker{}))
+			if err := b.Build(context.Background(), f, []fn.Platform{tt.platform}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Test_BuilderImagePlatformMismatch ensures that requesting a platform other
+// than that of a single-architecture builder image fails as an unsupported
+// platform, wrapping the mismatch.
+func Test_BuilderImagePlatformMismatch(t *testing.T) {
+	testRegistry := startRegistry(t)
+	builderImage := testRegistry + "/default/builder:latest"
+	tag, err := name.NewTag(builderImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := tarball.ImageFromPath(filepath.Join("testdata", "builder.tar"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(&tag, img); err != nil {
+		t.Fatal(err)
+	}
+
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: builderImage}}}
+	i := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	b := s2i.NewBuilder(s2i.WithName(builders.S2I), s2i.WithImpl(i), s2i.WithDockerClient(mockDocker{}))
+	err = b.Build(context.Background(), f, []fn.Platform{{OS: "linux", Architecture: "s390x"}})
+	var errMismatch docker.ErrPlatformMismatch
+	if !errors.Is(err, s2i.ErrUnsupportedPlatform) || !errors.As(err, &errMismatch) || errMismatch.Platform != "linux/s390x" {
+		t.Fatalf("expected an unsupported platform error wrapping the mismatch, got %v", err)
+	}
+}
+
+// Test_BuilderImagePolicy ensures that the builder image policy is consulted
+// with the resolved builder image, and that a rejection aborts the build with
+// the policy's message.
+func Test_BuilderImagePolicy(t *testing.T) {
+	const pinned = "registry.corp.example.com/builder@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	var (
+		built bool
+		i     = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			built = true
+			return nil, nil
+		}}
+		f = fn.Function{Runtime: "node", Build: fn.BuildSpec{
+			BuilderImages:         map[string]string{builders.S2I: "docker.io/library/builder:latest"},
+			PlatformBuilderImages: map[string]map[string]string{builders.S2I: {"linux/amd64": pinned}},
+		}}
+		platforms = []fn.Platform{{OS: "linux", Architecture: "amd64"}}
+		policy    = func(ref string) error {
+			if !strings.HasPrefix(ref, "registry.corp.example.com/") || !strings.Contains(ref, "@sha256:") {
+				return fmt.Errorf("builder image %q is not permitted", ref)
+			}
+			return nil
+		}
+	)
+
+	b := s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(mockDocker{}), s2i.WithBuilderImagePolicy(policy))
+	if err := b.Build(context.Background(), f, platforms); err != nil {
+		t.Fatal(err)
+	}
+	if !built {
+		t.Fatal("expected the permitted builder image to be built with")
+	}
+
+	built = false
+	err := b.Build(context.Background(), f, nil)
+	if !errors.Is(err, s2i.ErrInvalidBuilderImage) || err.Error() != `builder image "docker.io/library/builder:latest" is not permitted` {
+		t.Fatalf("expected the policy's error, got %v", err)
+	}
+	if built {
+		t.Fatal("expected the build to be aborted")
+	}
+}
+
+// Test_BuildPullPolicy ensures that the pull policy of the builder image
+// determines whether the daemon pulls the images built with, which it does
+// if no policy is chosen, and that builder images which are never pulled
+// must be present.
+func Test_BuildPullPolicy(t *testing.T) {
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	var (
+		pulled  bool
+		present = true
+		built   bool
+	)
+	cli := mockDocker{
+		inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+			if !present {
+				return types.ImageInspect{}, nil, notFoundErr{}
+			}
+			return types.ImageInspect{}, nil, nil
+		},
+		build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			built, pulled = true, options.PullParent
+			_, _ = io.Copy(io.Discard, context)
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+		},
+	}
+	f := fn.Function{Runtime: "node"}
+	for _, tt := range []struct {
+		policy api.PullPolicy
+		pull   bool
+	}{
+		{api.PullAlways, true},
+		{api.PullIfNotPresent, false},
+		{api.PullNever, false},
+	} {
+		b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithPullPolicy(tt.policy))
+		if err := b.Build(context.Background(), f, nil); err != nil {
+			t.Fatal(err)
+		}
+		if pulled != tt.pull {
+			t.Errorf("expected the pull of the images of the build to be %v for policy %s", tt.pull, tt.policy)
+		}
+	}
+
+	// Unless a policy is chosen, the images of the build are pulled.
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !pulled {
+		t.Error("expected the images of the build to be pulled by default")
+	}
+	t.Setenv(s2i.EnvBuildPullPolicy, string(api.PullIfNotPresent))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if pulled {
+		t.Errorf("expected no pull of the images of the build for policy %s of %s", api.PullIfNotPresent, s2i.EnvBuildPullPolicy)
+	}
+
+	present, built = false, false
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithPullPolicy(api.PullNever))
+	if err := b.Build(context.Background(), f, nil); !errors.Is(err, s2i.ErrInvalidBuilderImage) {
+		t.Fatalf("expected an invalid builder image error for a missing image, got %v", err)
+	}
+	if built {
+		t.Fatal("expected no build of a missing builder image which is never pulled")
+	}
+}
+
+// Test_BuildType ensures that functions configured to be built on-cluster
+// are rejected by the local builder with a clear error.
+func Test_BuildType(t *testing.T) {
+	i := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	b := s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(mockDocker{}))
+
+	f := fn.Function{Name: "myfunc", Runtime: "node", Build: fn.BuildSpec{Type: fn.BuildTypeLocal}}
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Build.Type = fn.BuildTypeOnCluster
+	err := b.Build(context.Background(), f, nil)
+	if !errors.Is(err, s2i.ErrValidation) || !errors.Is(err, fn.ErrBuildOnCluster) {
+		t.Fatalf("expected an error for an on-cluster function, got %v", err)
+	}
+
+	f.Build.Type = "elsewhere"
+	if err = b.Build(context.Background(), f, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected an error for an unknown build type, got %v", err)
+	}
+}
+
+// Test_BuildPlatformOS ensures that platforms of operating systems other than
+// linux are rejected, whatever their architecture.
+func Test_BuildPlatformOS(t *testing.T) {
+	for _, goos := range []string{"windows", "darwin"} {
+		t.Run(goos, func(t *testing.T) {
+			i := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+				t.Fatal("unexpected build")
+				return nil, nil
+			}}
+			b := s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(mockDocker{}))
+			err := b.Build(context.Background(), fn.Function{Runtime: "node"}, []fn.Platform{{OS: goos, Architecture: "amd64"}})
+			if !errors.Is(err, s2i.ErrUnsupportedPlatform) {
+				t.Fatalf("expected an unsupported platform error, got %v", err)
+			}
+			if want := "the S2I builder only supports linux images; requested OS: " + goos; err.Error() != want {
+				t.Fatalf("expected %q, got %q", want, err)
+			}
+		})
+	}
+}
+
+// Test_BuildPlatformNoOS ensures that platforms of no OS are built as linux.
+func Test_BuildPlatformNoOS(t *testing.T) {
+	const pinned = "example.com/builders/node-arm64:latest"
+	var built string
+	i := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { built = cfg.BuilderImage; return nil, nil }}
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{
+		PlatformBuilderImages: map[string]map[string]string{"s2i": {"linux/arm64": pinned}},
+	}}
+	b := s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(mockDocker{}))
+	if err := b.Build(context.Background(), f, []fn.Platform{{Architecture: "arm64"}}); err != nil {
+		t.Fatal(err)
+	}
+	if built != pinned {
+		t.Fatalf("expected the builder image of linux/arm64 %q, got %q", pinned, built)
+	}
+}
+
 // Test_BuildImageWithFuncIgnore ensures that ignored files are not added to
 // the func image
 func Test_BuildImageWithFuncIgnore(t *testing.T) {
@@ -284,6 +552,200 @@ func Test_BuildEnvs(t *testing.T) {
 	}
 }
 
+// Test_BuildEnvsUnresolved ensures that build envs with ${...} references
+// remaining after interpolation are errors in strict mode, and that those
+// which are resolved are not.
+func Test_BuildEnvsUnresolved(t *testing.T) {
+	t.Setenv("FUNC_TEST_PROXY", "http://proxy.example.com")
+	var (
+		proxy, proxyValue = "HTTP_PROXY", "{{ env:FUNC_TEST_PROXY }}"
+		path, pathValue   = "EXTRA_PATH", "${HOME}/bin"
+		flags, flagsValue = "FLAGS", "${GOFLAGS} -v"
+		impl              = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		b                 = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithStrictBuildEnvs(true))
+	)
+
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuildEnvs: []fn.Env{{Name: &proxy, Value: &proxyValue}}}}
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Build.BuildEnvs = append(f.Build.BuildEnvs, fn.Env{Name: &path, Value: &pathValue}, fn.Env{Name: &flags, Value: &flagsValue})
+	err := b.Build(context.Background(), f, nil)
+	if !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "EXTRA_PATH, FLAGS") || strings.Contains(err.Error(), proxy) {
+		t.Fatalf("expected the error to list only the unresolved envs, got %q", err)
+	}
+
+	// Not strict: a warning only.
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}))
+	if err = b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test_S2IConfig ensures that S2I config mutations provided as an option are
+// applied, in order, after func populates the config, and are therefore seen
+// by the S2I build implementation.
+func Test_S2IConfig(t *testing.T) {
+	var (
+		i = &mockImpl{}
+		c = mockDocker{}
+		b = s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(c),
+			s2i.WithS2IConfig(func(cfg *api.Config) {
+				cfg.Injections = append(cfg.Injections, api.VolumeSpec{Source: "/src", Destination: "/dst"})
+				cfg.Incremental = true
+			}),
+			s2i.WithS2IConfig(func(cfg *api.Config) {
+				cfg.Incremental = false
+			}))
+	)
+	i.BuildFn = func(cfg *api.Config) (*api.Result, error) {
+		if len(cfg.Injections) != 1 || cfg.Injections[0].Destination != "/dst" {
+			t.Fatalf("expected config mutation to be applied, got injections %v", cfg.Injections)
+		}
+		if cfg.Incremental {
+			t.Fatal("expected config mutations to be applied in order")
+		}
+		if cfg.BuilderImage != s2i.DefaultNodeBuilder {
+			t.Fatalf("expected func defaults to be retained, got builder image %q", cfg.BuilderImage)
+		}
+		return nil, nil
+	}
+	if err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test_AllowedUIDs ensures that allowed uid ranges are validated and passed
+// to the S2I build implementation.
+func Test_AllowedUIDs(t *testing.T) {
+	var (
+		i = &mockImpl{}
+		c = mockDocker{}
+		f = fn.Function{Runtime: "node"}
+	)
+	i.BuildFn = func(cfg *api.Config) (*api.Result, error) {
+		if !cfg.AllowedUIDs.Contains(1001) || cfg.AllowedUIDs.Contains(0) {
+			t.Fatalf("expected allowed uids to permit 1001 but not root, got %v", cfg.AllowedUIDs.String())
+		}
+		return nil, nil
+	}
+	b := s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(c), s2i.WithAllowedUIDs("1-"))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ranges := range []string{"", "a-b", "1-2-3"} {
+		b = s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(c), s2i.WithAllowedUIDs(ranges))
+		if err := b.Build(context.Background(), f, nil); err == nil {
+			t.Fatalf("expected an error for allowed uids %q", ranges)
+		}
+	}
+}
+
+// Test_DefaultBuildEnvs ensures that the runtime's default build envs are
+// included in the build config, that the function's build envs take
+// precedence, and that the defaults can be overridden.
+func Test_DefaultBuildEnvs(t *testing.T) {
+	var (
+		name  = "NODE_ENV"
+		value = "development"
+		c     = mockDocker{}
+	)
+	envs := func(cfg *api.Config) map[string]string {
+		m := map[string]string{}
+		for _, e := range cfg.Environment {
+			m[e.Name] = e.Value
+		}
+		return m
+	}
+	build := func(f fn.Function, options ...s2i.Option) (result map[string]string) {
+		i := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			result = envs(cfg)
+			return nil, nil
+		}}
+		options = append(options, s2i.WithImpl(i), s2i.WithDockerClient(c))
+		if err := s2i.NewBuilder(options...).Build(context.Background(), f, nil); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// Defaults applied
+	f := fn.Function{Runtime: "node"}
+	if v := build(f)["NODE_ENV"]; v != "production" {
+		t.Fatalf("expected default NODE_ENV=production, got %q", v)
+	}
+
+	// User envs win
+	f = fn.Function{Runtime: "node", Build: fn.BuildSpec{BuildEnvs: []fn.Env{{Name: &name, Value: &value}}}}
+	if v := build(f)["NODE_ENV"]; v != value {
+		t.Fatalf("expected build env NODE_ENV=%v to take precedence, got %q", value, v)
+	}
+
+	// Defaults overridden
+	f = fn.Function{Runtime: "node"}
+	result := build(f, s2i.WithDefaultBuildEnvs(map[string]map[string]string{"node": {"NODE_ENV": "test"}}))
+	if v := result["NODE_ENV"]; v != "test" {
+		t.Fatalf("expected overridden default NODE_ENV=test, got %q", v)
+	}
+	result = build(f, s2i.WithDefaultBuildEnvs(map[string]map[string]string{}))
+	if _, ok := result["NODE_ENV"]; ok {
+		t.Fatal("expected defaults to be disabled")
+	}
+}
+
+// Test_Profile ensures that the overrides of a selected build profile are
+// layered over the function's builder image and build envs, and that
+// selecting an undefined profile is an error.
+func Test_Profile(t *testing.T) {
+	var (
+		a, b1, b2 = "A", "base", "profile"
+		shared    = "SHARED"
+		f         = fn.Function{
+			Runtime: "node",
+			Build: fn.BuildSpec{
+				BuilderImages: map[string]string{builders.S2I: "example.com/user/base-builder"},
+				BuildEnvs:     []fn.Env{{Name: &a, Value: &a}, {Name: &shared, Value: &b1}},
+				Profiles: map[string]fn.BuildProfile{
+					"dev": {
+						BuilderImages: map[string]string{builders.S2I: "example.com/user/dev-builder"},
+						BuildEnvs:     []fn.Env{{Name: &shared, Value: &b2}},
+					},
+				},
+			},
+		}
+		i = &mockImpl{}
+		c = mockDocker{}
+	)
+	i.BuildFn = func(cfg *api.Config) (*api.Result, error) {
+		if cfg.BuilderImage != "example.com/user/dev-builder" {
+			t.Fatalf("expected the profile's builder image, got %q", cfg.BuilderImage)
+		}
+		envs := map[string]string{}
+		for _, e := range cfg.Environment {
+			envs[e.Name] = e.Value
+		}
+		if envs[a] != a || envs[shared] != b2 {
+			t.Fatalf("expected profile build envs layered over the function's, got %v", envs)
+		}
+		return nil, nil
+	}
+
+	b := s2i.NewBuilder(s2i.WithName(builders.S2I), s2i.WithImpl(i), s2i.WithDockerClient(c), s2i.WithProfile("dev"))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b = s2i.NewBuilder(s2i.WithName(builders.S2I), s2i.WithImpl(i), s2i.WithDockerClient(c), s2i.WithProfile("prod"))
+	if err := b.Build(context.Background(), f, nil); !errors.Is(err, fn.ErrBuildProfileNotFound) {
+		t.Fatalf("expected ErrBuildProfileNotFound, got %v", err)
+	}
+}
+
 func TestS2IScriptURL(t *testing.T) {
 	testRegistry := startRegistry(t)
 
@@ -453,61 +915,1206 @@ func TestBuildContextUpload(t *testing.T) {
 	}
 }
 
-func TestBuildFail(t *testing.T) {
+// TestBuildContextFileFilter ensures that file filters compose with the
+// default exclusions and with each other when creating the build context.
+func TestBuildContextFileFilter(t *testing.T) {
+	const maxSize = 16
+
+	var found []string
 	cli := mockDocker{
 		build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			tr := tar.NewReader(context)
+			for {
+				hdr, err := tr.Next()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					return types.ImageBuildResponse{}, err
+				}
+				found = append(found, hdr.Name)
+			}
 			return types.ImageBuildResponse{
-				Body:   io.NopCloser(strings.NewReader(`{"errorDetail": {"message": "Error: this is expected"}}`)),
+				Body:   io.NopCloser(strings.NewReader(`{"stream": "OK!"}`)),
 				OSType: "linux",
 			}, nil
 		},
 	}
+
 	impl := &mockImpl{
 		BuildFn: func(config *api.Config) (*api.Result, error) {
-			return &api.Result{Success: true}, nil
+			dir := filepath.Dir(config.AsDockerfile)
+			files := map[string]string{
+				"Dockerfile":           "FROM scratch",
+				"small.txt":            "small",
+				"large.txt":            strings.Repeat("x", maxSize+1),
+				"skip.log":             "log",
+				"node_modules/dep.txt": "dep",
+			}
+			for name, content := range files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					return nil, err
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
 		},
 	}
-	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli))
-	err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil)
-	if err == nil || !strings.Contains(err.Error(), "Error: this is expected") {
-		t.Error("didn't get expected error")
-	}
-}
-
-// mockImpl is a mock implementation of an S2I builder.
-type mockImpl struct {
-	BuildFn func(*api.Config) (*api.Result, error)
-}
-
-func (i *mockImpl) Build(cfg *api.Config) (*api.Result, error) {
-	return i.BuildFn(cfg)
-}
-
-type mockDocker struct {
-	inspect func(ctx context.Context, image string) (types.ImageInspect, []byte, error)
-	build   func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
-}
 
-func (m mockDocker) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
-	if m.inspect != nil {
-		return m.inspect(ctx, image)
+	sizeFilter := func(path string, fi fs.FileInfo) bool {
+		return fi.IsDir() || fi.Size() <= maxSize
+	}
+	extFilter := func(path string, fi fs.FileInfo) bool {
+		return filepath.Ext(path) != ".log"
 	}
 
-	return types.ImageInspect{}, nil, nil
-}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli),
+		s2i.WithFileFilter(sizeFilter), s2i.WithFileFilter(extFilter))
+	if err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil); err != nil {
+		t.Fatal(err)
+	}
 
-func (m mockDocker) ImageBuild(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
-	if m.build != nil {
-		return m.build(ctx, context, options)
+	sort.Strings(found)
+	expected := []string{"Dockerfile", "small.txt"}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("expected build context %v, got %v", expected, found)
 	}
+}
 
-	_, _ = io.Copy(io.Discard, context)
+// TestBuildContextSymlinkLoop ensures that links to an ancestor directory are
+// preserved in the build context, while links forming a loop which can not be
+// resolved fail the build with a descriptive error.
+func TestBuildContextSymlinkLoop(t *testing.T) {
+	tests := []struct {
+		name    string
+		links   map[string]string // link name -> target
+		wantErr string
+	}{
+		{
+			name:  "link to ancestor",
+			links: map[string]string{"sub/root": ".."},
+		},
+		{
+			name:    "link loop",
+			links:   map[string]string{"loop-a": "loop-b", "loop-b": "loop-a"},
+			wantErr: "symlink loop",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := mockDocker{
+				build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+					tr := tar.NewReader(context)
+					for {
+						_, err := tr.Next()
+						if errors.Is(err, io.EOF) {
+							break
+						}
+						if err != nil {
+							return types.ImageBuildResponse{}, err
+						}
+					}
+					return types.ImageBuildResponse{
+						Body:   io.NopCloser(strings.NewReader(`{"stream": "OK!"}`)),
+						OSType: "linux",
+					}, nil
+				},
+			}
+			impl := &mockImpl{
+				BuildFn: func(config *api.Config) (*api.Result, error) {
+					dir := filepath.Dir(config.AsDockerfile)
+					if err := os.WriteFile(config.AsDockerfile, []byte("FROM scratch"), 0644); err != nil {
+						return nil, err
+					}
+					for name, target := range tt.links {
+						path := filepath.Join(dir, name)
+						if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+							return nil, err
+						}
+						if err := os.Symlink(target, path); err != nil {
+							return nil, err
+						}
+					}
+					return nil, nil
+				},
+			}
+
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli))
+			err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestBuildBuilderImageNotFound ensures that a builder image which does not
+// exist, or which the registry denies access to, results in a friendly error.
+func TestBuildBuilderImageNotFound(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		code    string
+		wantErr error
+		wantMsg string
+	}{
+		{"not found", http.StatusNotFound, "MANIFEST_UNKNOWN", s2i.ErrImageNotFound, "not found — check the reference"},
+		{"unauthorized", http.StatusUnauthorized, "UNAUTHORIZED", s2i.ErrImageUnauthorized, "not authorized to pull builder image"},
+		{"forbidden", http.StatusForbidden, "DENIED", s2i.ErrImageUnauthorized, "not authorized to pull builder image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v2/" {
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				fmt.Fprintf(w, `{"errors": [{"code": %q, "message": "registry says no"}]}`, tt.code)
+			}))
+			t.Cleanup(srv.Close)
+
+			var (
+				builderImage = strings.TrimPrefix(srv.URL, "http://") + "/default/builder:latest"
+				impl         = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+				cli          = mockDocker{
+					inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+						return types.ImageInspect{}, nil, notFoundErr{}
+					},
+				}
+				f = fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: builderImage}}}
+			)
+			err := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli)).Build(context.Background(), f, nil)
+			if !errors.Is(err, tt.wantErr) || !errors.Is(err, s2i.ErrInvalidBuilderImage) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) || !strings.Contains(err.Error(), builderImage) {
+				t.Fatalf("expected a friendly error naming the image, got %q", err)
+			}
+		})
+	}
+}
+
+func TestBuildFail(t *testing.T) {
+	cli := mockDocker{
+		build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			return types.ImageBuildResponse{
+				Body:   io.NopCloser(strings.NewReader(`{"errorDetail": {"message": "Error: this is expected"}}`)),
+				OSType: "linux",
+			}, nil
+		},
+	}
+	impl := &mockImpl{
+		BuildFn: func(config *api.Config) (*api.Result, error) {
+			return &api.Result{Success: true}, nil
+		},
+	}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli))
+	err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "Error: this is expected") {
+		t.Error("didn't get expected error")
+	}
+}
+
+// TestBuildAssembleError ensures that a failure of the assemble script is
+// reported with its exit code and output.
+func TestBuildAssembleError(t *testing.T) {
+	var (
+		classic  = `{"stream": "Installing dependencies\n"}` + "\n" + `{"stream": "npm ERR! 404 Not Found\n"}` + "\n"
+		buildKit = buildKitTrace(t, "Installing dependencies\n") + "\n" + buildKitTrace(t, "npm ERR! 404 Not Found\n") + "\n"
+	)
+	tests := []struct {
+		name   string
+		output string
+		stream string
+	}{
+		{"code", classic, `{"errorDetail": {"code": 2, "message": "The command '/bin/sh -c /usr/libexec/s2i/assemble' returned a non-zero code: 2"}}`},
+		{"message", classic, `{"errorDetail": {"message": "process \"/bin/sh -c /usr/libexec/s2i/assemble\" did not complete successfully: exit code: 2"}}`},
+		{"buildkit", buildKit, `{"errorDetail": {"message": "process \"/bin/sh -c /usr/libexec/s2i/assemble\" did not complete successfully: exit code: 2"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := mockDocker{
+				build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+					_, _ = io.Copy(io.Discard, context)
+					stream := tt.output + tt.stream
+					return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(stream)), OSType: "linux"}, nil
+				},
+			}
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+			err := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli)).Build(context.Background(), fn.Function{Runtime: "node"}, nil)
+			var errAssemble s2i.AssembleError
+			if !errors.As(err, &errAssemble) {
+				t.Fatalf("expected an AssembleError, got %v", err)
+			}
+			if errAssemble.ExitCode != 2 {
+				t.Errorf("expected exit code 2, got %d", errAssemble.ExitCode)
+			}
+			if errAssemble.Output != "Installing dependencies\nnpm ERR! 404 Not Found\n" {
+				t.Errorf("unexpected output %q", errAssemble.Output)
+			}
+		})
+	}
+}
+
+// buildKitTrace returns a message of a build stream of BuildKit carrying the
+// output of a step, as the daemon serializes the progress of BuildKit.
+func buildKitTrace(t *testing.T, output string) string {
+	t.Helper()
+	status := controlapi.StatusResponse{Logs: []*controlapi.VertexLog{{Vertex: "sha256:1", Stream: 1, Msg: []byte(output)}}}
+	dt, err := status.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aux, err := json.Marshal(dt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb, err := json.Marshal(jsonmessage.JSONMessage{ID: "moby.buildkit.trace", Aux: (*json.RawMessage)(&aux)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bb)
+}
+
+// TestBuildImageSize ensures that the size of the built image is reported,
+// and that exceeding the size budget fails the build.
+func TestBuildImageSize(t *testing.T) {
+	const size = 200
+	var (
+		impl = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		cli  = mockDocker{
+			inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+				return types.ImageInspect{Size: size}, nil, nil
+			},
+		}
+		f = fn.Function{Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/fn:latest"}}
+	)
+
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithMaxImageSize(size))
+	result, err := b.BuildWithResult(context.Background(), f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Size != size {
+		t.Fatalf("expected image size %d, got %d", size, result.Size)
+	}
+
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithMaxImageSize(size-1))
+	result, err = b.BuildWithResult(context.Background(), f, nil)
+	var errTooLarge s2i.ErrImageTooLarge
+	if !errors.As(err, &errTooLarge) {
+		t.Fatalf("expected ErrImageTooLarge, got %v", err)
+	}
+	if errTooLarge.Size != size || errTooLarge.Max != size-1 || result.Size != size {
+		t.Fatalf("unexpected size reported: %v", err)
+	}
+}
+
+// TestBuildSkipIfUnchanged ensures that a build whose inputs are unchanged
+// since the last build is skipped while its image exists, and that changes
+// to the source or a missing image cause a rebuild.
+func TestBuildSkipIfUnchanged(t *testing.T) {
+	var (
+		root   = t.TempDir()
+		builds int
+		exists = true
+		impl   = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		cli    = mockDocker{
+			inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+				if image == "example.com/alice/fn:latest" && !exists {
+					return types.ImageInspect{}, nil, notFoundErr{}
+				}
+				return types.ImageInspect{ID: "sha256:1234"}, nil, nil
+			},
+			build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+				builds++
+				exists = true
+				_, _ = io.Copy(io.Discard, context)
+				return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+			},
+		}
+		f = fn.Function{Root: root, Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/fn:latest"}}
+		b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli),
+			s2i.WithSkipIfUnchanged(filepath.Join(t.TempDir(), "state.json")))
+	)
+	if err := os.WriteFile(filepath.Join(root, "index.js"), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(wantBuilds int, wantUpToDate bool) {
+		t.Helper()
+		result, err := b.BuildWithResult(context.Background(), f, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if builds != wantBuilds || result.UpToDate != wantUpToDate {
+			t.Fatalf("expected %d builds (up to date: %v), got %d (up to date: %v)",
+				wantBuilds, wantUpToDate, builds, result.UpToDate)
+		}
+	}
+
+	build(1, false) // miss: no prior build
+	build(1, true)  // hit
+
+	// Changes to excluded files do not invalidate the last build.
+	if err := os.MkdirAll(filepath.Join(root, "node_modules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "node_modules", "dep.js"), []byte("dep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	build(1, true)
+
+	// Changes to the source do.
+	if err := os.WriteFile(filepath.Join(root, "index.js"), []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	build(2, false)
+	build(2, true)
+
+	// As does the image having been removed.
+	exists = false
+	build(3, false)
+
+	// As do the options of the builder and the config of the build which
+	// affect the image.
+	state := filepath.Join(t.TempDir(), "state.json")
+	for i, options := range [][]s2i.Option{
+		{},
+		{s2i.WithExposedPort(8080)},
+		{s2i.WithS2IConfig(func(cfg *api.Config) { cfg.Labels = map[string]string{"team": "a"} })},
+		{s2i.WithS2IConfig(func(cfg *api.Config) { cfg.Labels = map[string]string{"team": "b"} })},
+	} {
+		b = s2i.NewBuilder(append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithSkipIfUnchanged(state)}, options...)...)
+		build(4+i, false)
+		build(4+i, true)
+	}
+}
+
+// TestBuildSkipIfUnchangedNotLoaded ensures that builds of images which are
+// not loaded into the daemon are skipped while the image exists in its
+// registry.
+func TestBuildSkipIfUnchangedNotLoaded(t *testing.T) {
+	image := startRegistry(t) + "/alice/fn:latest"
+	var (
+		root   = t.TempDir()
+		builds int
+		impl   = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		cli    = mockDocker{
+			info: func(ctx context.Context) (system.Info, error) {
+				return system.Info{DriverStatus: [][2]string{{"driver-type", "io.containerd.snapshotter.v1"}}}, nil
+			},
+			build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+				builds++
+				_, _ = io.Copy(io.Discard, context)
+				return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+			},
+		}
+		f = fn.Function{Root: root, Runtime: "node", Build: fn.BuildSpec{Image: image}}
+		b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithLoad(false), s2i.WithPush(true),
+			s2i.WithSkipIfUnchanged(filepath.Join(t.TempDir(), "state.json")))
+	)
+	build := func(wantBuilds int, wantUpToDate bool) {
+		t.Helper()
+		result, err := b.BuildWithResult(context.Background(), f, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if builds != wantBuilds || result.UpToDate != wantUpToDate {
+			t.Fatalf("expected %d builds (up to date: %v), got %d (up to date: %v)",
+				wantBuilds, wantUpToDate, builds, result.UpToDate)
+		}
+	}
+
+	build(1, false) // the image was not pushed
+	build(2, false)
+	pushImage(t, image)
+	build(2, true)
+}
+
+// TestContextHash ensures that the context hash changes when a file of the
+// build context changes, and is stable otherwise.
+func TestContextHash(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func(b *s2i.Builder) string {
+		t.Helper()
+		h, err := b.ContextHash(fn.Function{Root: root, Runtime: "node"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	write("index.js", "v1")
+	b := s2i.NewBuilder()
+
+	h1 := hash(b)
+	if h2 := hash(b); h2 != h1 {
+		t.Fatalf("expected a stable hash, got %q and %q", h1, h2)
+	}
+
+	// Excluded and filtered files do not affect the hash.
+	write("node_modules/dep/index.js", "dep")
+	write("notes.txt", "notes")
+	filtered := s2i.NewBuilder(s2i.WithFileFilter(func(path string, info fs.FileInfo) bool {
+		return !strings.HasSuffix(path, ".txt")
+	}))
+	if h2 := hash(filtered); h2 != h1 {
+		t.Fatalf("expected excluded files not to affect the hash")
+	}
+
+	write("index.js", "v2")
+	if h2 := hash(b); h2 == h1 {
+		t.Fatalf("expected the hash to change with the source")
+	}
+}
+
+// TestContextFiles ensures that the files of the build context are listed as
+// they would be included, without the excluded and filtered files.
+func TestContextFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{"index.js", "lib/util.js", "notes.txt", ".git/HEAD", "node_modules/dep/index.js"} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := s2i.NewBuilder(s2i.WithFileFilter(func(path string, info fs.FileInfo) bool {
+		return !strings.HasSuffix(path, ".txt")
+	}))
+	files, err := b.ContextFiles(fn.Function{Root: root, Runtime: "node"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"index.js", "lib", "lib/util.js"}
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected context files %v, got %v", want, files)
+	}
+}
+
+// TestBuildExtraHosts ensures that the extra hosts reach the build options,
+// and that hosts not of the form host:ip are rejected.
+func TestBuildExtraHosts(t *testing.T) {
+	hosts := []string{"nexus.internal:10.0.0.5", "registry.internal:fd00::5", "host.docker.internal:host-gateway"}
+	var got []string
+	cli := mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+		got = options.ExtraHosts
+		_, _ = io.Copy(io.Discard, context)
+		return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+	}}
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithExtraHosts(hosts))
+	if err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != strings.Join(hosts, ",") {
+		t.Fatalf("expected extra hosts %v, got %v", hosts, got)
+	}
+
+	for _, host := range []string{"nexus.internal", "nexus.internal:", ":10.0.0.5", "nexus internal:10.0.0.5", "nexus.internal:10.0.0.256"} {
+		b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithExtraHosts([]string{host}))
+		if err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil); !errors.Is(err, s2i.ErrValidation) {
+			t.Fatalf("expected a validation error for %q, got %v", host, err)
+		}
+	}
+}
+
+// TestBuildTimeout ensures that a build exceeding its timeout fails with an
+// error stating as much, rather than that of the interrupted step.
+func TestBuildTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	cli := mockDocker{
+		build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			<-ctx.Done()
+			return types.ImageBuildResponse{}, errors.New("error reading build context: io: read/write on closed pipe")
+		},
+	}
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithTimeout(timeout))
+	err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if want := "build timed out after " + timeout.String(); !strings.Contains(err.Error(), want) {
+		t.Fatalf("expected error to contain %q, got %q", want, err)
+	}
+}
+
+// TestBuildErrorCategories ensures that build failures can be categorized
+// with errors.Is while retaining the errors they wrap, and that failures of
+// the daemon are not categorized.
+func TestBuildErrorCategories(t *testing.T) {
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	inspectErr := errors.New("daemon unavailable")
+	tests := []struct {
+		name      string
+		f         fn.Function
+		platforms []fn.Platform
+		options   []s2i.Option
+		want      error
+		wrapped   error
+	}{
+		{
+			name:      "unsupported platform",
+			f:         fn.Function{Runtime: "node"},
+			platforms: []fn.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}},
+			want:      s2i.ErrUnsupportedPlatform,
+		},
+		{
+			name:    "invalid builder image",
+			f:       fn.Function{Runtime: "node"},
+			options: []s2i.Option{s2i.WithRequiredBuilderLabels(map[string]string{"io.example.certified": ""})},
+			want:    s2i.ErrInvalidBuilderImage,
+		},
+		{
+			name: "daemon failure",
+			f:    fn.Function{Runtime: "node"},
+			options: []s2i.Option{s2i.WithDockerClient(mockDocker{inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+				return types.ImageInspect{}, nil, inspectErr
+			}})},
+			wrapped: inspectErr,
+		},
+		{
+			name:    "no builder image",
+			f:       fn.Function{},
+			want:    s2i.ErrNoBuildImage,
+			wrapped: builders.ErrRuntimeRequired{Builder: builders.S2I},
+		},
+		{
+			name:    "validation",
+			f:       fn.Function{Runtime: "node"},
+			options: []s2i.Option{s2i.WithExposedPort(70000)},
+			want:    s2i.ErrValidation,
+		},
+		{
+			name: "validation of scaffolding",
+			f:    fn.Function{Root: t.TempDir(), Runtime: "go"},
+			want: s2i.ErrValidation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := append([]s2i.Option{s2i.WithName(builders.S2I), s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{})}, tt.options...)
+			err := s2i.NewBuilder(options...).Build(context.Background(), tt.f, tt.platforms)
+			if tt.want == nil {
+				for _, category := range []error{s2i.ErrUnsupportedPlatform, s2i.ErrInvalidBuilderImage, s2i.ErrNoBuildImage, s2i.ErrValidation} {
+					if errors.Is(err, category) {
+						t.Fatalf("expected error to be uncategorized, got %q: %v", category, err)
+					}
+				}
+			} else if !errors.Is(err, tt.want) {
+				t.Fatalf("expected error to be %q, got %v", tt.want, err)
+			}
+			if tt.wrapped != nil && !errors.Is(err, tt.wrapped) {
+				t.Fatalf("expected error to wrap %q, got %v", tt.wrapped, err)
+			}
+		})
+	}
+}
+
+// TestBuildImageFormat ensures that a requested image format is passed to
+// the daemon as an output setting, and that by default the daemon chooses.
+func TestBuildImageFormat(t *testing.T) {
+	var outputs []types.ImageBuildOutput
+	cli := mockDocker{
+		build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			outputs = options.Outputs
+			_, _ = io.Copy(io.Discard, context)
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+		},
+	}
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	f := fn.Function{Runtime: "node"}
+
+	if err := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli)).Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 0 {
+		t.Fatalf("expected no outputs by default, got %v", outputs)
+	}
+
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithImageFormat(builders.OCI))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 1 || outputs[0].Attrs["oci-mediatypes"] != "true" {
+		t.Fatalf("expected an output with OCI media types, got %v", outputs)
+	}
+
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithImageFormat("v1"))
+	if err := b.Build(context.Background(), f, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error for an unknown format, got %v", err)
+	}
+}
+
+// TestBuildAttestations ensures that requested attestations are passed to
+// BuildKit, and that daemons which can not store them are rejected.
+func TestBuildAttestations(t *testing.T) {
+	var buildArgs map[string]*string
+	cli := mockDocker{
+		build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			buildArgs = options.BuildArgs
+			_, _ = io.Copy(io.Discard, context)
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+		},
+		info: func(ctx context.Context) (system.Info, error) {
+			return system.Info{DriverStatus: [][2]string{{"driver-type", "io.containerd.snapshotter.v1"}}}, nil
+		},
+	}
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	f := fn.Function{Runtime: "node"}
+
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithAttestations(true, true))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := buildArgs["BUILDKIT_ATTEST_SBOM"]; !ok || *v != "" {
+		t.Errorf("expected an SBOM attestation to be requested, got %v", buildArgs)
+	}
+	if v, ok := buildArgs["BUILDKIT_ATTEST_PROVENANCE"]; !ok || *v != "mode=min" {
+		t.Errorf("expected a provenance attestation to be requested, got %v", buildArgs)
+	}
+
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithAttestations(false, true))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := buildArgs["BUILDKIT_ATTEST_SBOM"]; ok {
+		t.Errorf("expected no SBOM attestation to be requested, got %v", buildArgs)
+	}
+
+	// Daemons using the classic image store are rejected.
+	cli.info = func(ctx context.Context) (system.Info, error) {
+		return system.Info{DriverStatus: [][2]string{{"Backing Filesystem", "extfs"}}}, nil
+	}
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithAttestations(true, false))
+	if err := b.Build(context.Background(), f, nil); err == nil || !strings.Contains(err.Error(), "does not support attestations") {
+		t.Fatalf("expected an error for a daemon without attestation support, got %v", err)
+	}
+}
+
+// TestWarm ensures that warming up pulls the function's builder image, and
+// that an interrupted pull is reported as such.
+func TestWarm(t *testing.T) {
+	var pulled []string
+	cli := mockDocker{
+		pull: func(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+			pulled = append(pulled, ref)
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(strings.NewReader(`{"status": "Pulling"}`)), nil
+		},
+	}
+	b := s2i.NewBuilder(s2i.WithDockerClient(cli))
+	f := fn.Function{Runtime: "go"}
+
+	if err := b.Warm(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled) != 1 || pulled[0] != s2i.DefaultGoBuilder {
+		t.Fatalf("expected builder image %q to be pulled, got %v", s2i.DefaultGoBuilder, pulled)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Warm(ctx, f); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled warm-up to return context.Canceled, got %v", err)
+	}
+}
+
+// TestWarmGoModules ensures that warming a Go function built with the Go
+// module cache downloads the modules of the function and of its scaffolding
+// into the cache mount of builds.
+func TestWarmGoModules(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"f.go":   "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n",
+		"go.mod": "module function\n\ngo 1.21\n\nrequire example.com/dep v1.0.0\n",
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		dockerfile string
+		files      = map[string]string{}
+		args       map[string]*string
+	)
+	cli := mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+		args = options.BuildArgs
+		tr := tar.NewReader(context)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return types.ImageBuildResponse{}, err
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return types.ImageBuildResponse{}, err
+			}
+			if hdr.Name == "Dockerfile" {
+				dockerfile = string(data)
+			} else {
+				files[hdr.Name] = string(data)
+			}
+		}
+		return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(""))}, nil
+	}}
+	b := s2i.NewBuilder(s2i.WithDockerClient(cli), s2i.WithGoModuleCache("https://proxy.example.com", ""))
+	if err := b.Warm(context.Background(), fn.Function{Root: root, Runtime: "go"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(dockerfile, "FROM "+s2i.DefaultGoBuilder+"\n") ||
+		!strings.Contains(dockerfile, "--mount=type=cache,target="+s2i.GoModuleCacheDir+",uid=1001,id=func-go-mod") ||
+		!strings.Contains(dockerfile, "go mod download") {
+		t.Fatalf("expected a download of the modules into the module cache, got Dockerfile:\n%s", dockerfile)
+	}
+	if !strings.Contains(files["deps/go.mod"], "replace function => ./f") {
+		t.Errorf("expected the go.mod of the scaffolding, got %q", files["deps/go.mod"])
+	}
+	if !strings.Contains(files["deps/f/go.mod"], "example.com/dep") {
+		t.Errorf("expected the go.mod of the function, got %q", files["deps/f/go.mod"])
+	}
+	if _, ok := files["deps/f/go.sum"]; ok {
+		t.Errorf("expected no go.sum of the function, which has none")
+	}
+	if v := args["GOPROXY"]; v == nil || *v != "https://proxy.example.com" {
+		t.Errorf("expected the module proxy of the cache, got %v", v)
+	}
+}
+
+// TestBuildHooks ensures that the pre-build and post-build hooks are invoked
+// around the build, that an error of the pre-build hook aborts the build and
+// that an error of the post-build hook is returned.
+func TestBuildHooks(t *testing.T) {
+	var (
+		calls []string
+		impl  = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			calls = append(calls, "build")
+			return nil, nil
+		}}
+		f       = fn.Function{Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/fn:latest"}}
+		errHook = errors.New("hook failed")
+		pre     = func(ctx context.Context, f fn.Function) error {
+			calls = append(calls, "pre")
+			return nil
+		}
+		post = func(ctx context.Context, f fn.Function, result s2i.BuildResult) error {
+			calls = append(calls, "post:"+result.Image)
+			return nil
+		}
+	)
+
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithPreBuild(pre), s2i.WithPostBuild(post))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"pre", "build", "post:" + f.Build.Image}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+
+	// An error of the pre-build hook aborts the build.
+	calls = nil
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}),
+		s2i.WithPreBuild(func(ctx context.Context, f fn.Function) error { return errHook }), s2i.WithPostBuild(post))
+	if err := b.Build(context.Background(), f, nil); !errors.Is(err, errHook) {
+		t.Fatalf("expected the pre-build error, got %v", err)
+	}
+	if len(calls) != 0 {
+		t.Fatalf("expected the build to be aborted, got calls %v", calls)
+	}
+
+	// An error of the post-build hook is returned after the build.
+	calls = nil
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}),
+		s2i.WithPostBuild(func(ctx context.Context, f fn.Function, result s2i.BuildResult) error { return errHook }))
+	result, err := b.BuildWithResult(context.Background(), f, nil)
+	if !errors.Is(err, errHook) {
+		t.Fatalf("expected the post-build error, got %v", err)
+	}
+	if result.Image != f.Build.Image || !reflect.DeepEqual(calls, []string{"build"}) {
+		t.Fatalf("expected a completed build, got %v and calls %v", result, calls)
+	}
+}
+
+// TestBatchBuild ensures that a batch of functions is built with bounded
+// concurrency, and that a failed build neither aborts the batch nor affects
+// the results of the others.
+func TestBatchBuild(t *testing.T) {
+	const concurrency = 2
+	var (
+		mu              sync.Mutex
+		inFlight, maxIn int
+		built           []string
+		impl            = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		cli             = mockDocker{
+			build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+				mu.Lock()
+				inFlight++
+				maxIn = max(maxIn, inFlight)
+				built = append(built, options.Tags...)
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				_, _ = io.Copy(io.Discard, context)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+			},
+		}
+		ff = []fn.Function{
+			{Name: "a", Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/a:latest"}},
+			{Name: "b", Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/b:latest"}},
+			{Name: "c", Runtime: "unsupported", Build: fn.BuildSpec{Image: "example.com/alice/c:latest"}},
+			{Name: "d", Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/d:latest"}},
+		}
+	)
+
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli))
+	results, err := b.BatchBuild(context.Background(), ff, nil, concurrency)
+	if !errors.Is(err, s2i.ErrNoBuildImage) || !strings.Contains(err.Error(), `"c"`) {
+		t.Fatalf("expected the build of function c to fail, got %v", err)
+	}
+	if len(results) != len(ff) {
+		t.Fatalf("expected %d results, got %d", len(ff), len(results))
+	}
+	for i, f := range ff {
+		want := f.Build.Image
+		if f.Name == "c" {
+			want = ""
+		}
+		if results[i].Image != want {
+			t.Errorf("expected result %d to be %q, got %q", i, want, results[i].Image)
+		}
+	}
+	if len(built) != 3 {
+		t.Fatalf("expected 3 images to be built, got %v", built)
+	}
+	if maxIn > concurrency {
+		t.Fatalf("expected at most %d concurrent builds, got %d", concurrency, maxIn)
+	}
+}
+
+// TestBatchBuildPaths ensures that the files of the functions of a batch
+// build are their own: within their roots for relative paths, and suffixed
+// by their names for absolute paths.
+func TestBatchBuildPaths(t *testing.T) {
+	var (
+		impl = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		dir  = t.TempDir()
+		ff   = []fn.Function{
+			{Name: "a", Root: t.TempDir(), Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/a:latest"}},
+			{Name: "b", Root: t.TempDir(), Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/b:latest"}},
+		}
+	)
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}),
+		s2i.WithConfigDump(filepath.Join(dir, "config.json")),
+		s2i.WithSkipIfUnchanged(filepath.Join(".func", "state.json")))
+	if _, err := b.BatchBuild(context.Background(), ff, nil, len(ff)); err != nil {
+		t.Fatal(err)
+	}
+
+	dumps, err := filepath.Glob(filepath.Join(dir, "config-*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumps) != len(ff) {
+		t.Fatalf("expected a config dump per function, got %v", dumps)
+	}
+	for i, f := range ff {
+		if !strings.HasPrefix(filepath.Base(dumps[i]), "config-"+f.Name+"-") {
+			t.Errorf("expected the config dump of %q to be named for it, got %v", f.Name, dumps[i])
+		}
+		bb, err := os.ReadFile(filepath.Join(f.Root, ".func", "state.json"))
+		if err != nil {
+			t.Fatalf("expected the build state within the root of %q: %v", f.Name, err)
+		}
+		if !strings.Contains(string(bb), f.Build.Image) {
+			t.Errorf("expected the build state of %q to be its own, got %s", f.Name, bb)
+		}
+	}
+}
+
+// TestScaffold ensures that scaffolding writes the glue code and assemble
+// script of a Go function without a docker client, and that runtimes which
+// are not scaffolded are rejected.
+func TestScaffold(t *testing.T) {
+	root := t.TempDir()
+	impl := `
+package f
+
+type F struct{}
+
+func New() *F { return nil }
+`
+	if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+
+	if err := s2i.Scaffold(fn.Function{Root: root, Runtime: "go"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "builds", "last", "main.go")); err != nil {
+		t.Fatalf("expected scaffolding main: %v", err)
+	}
+	link, err := os.Readlink(filepath.Join(out, "builds", "last", "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := filepath.Join(out, "builds", "last", link); target != root {
+		t.Fatalf("expected scaffolding to link to the function at %q, got %q", root, target)
+	}
+	assemble, err := os.ReadFile(filepath.Join(out, "bin", "assemble"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(assemble) != s2i.GoAssembler {
+		t.Fatal("expected the Go assembler to be written")
+	}
+	if fi, err := os.Stat(filepath.Join(out, "bin", "assemble")); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm() != 0755 {
+		t.Fatalf("expected the assembler to be executable by all users, got %v", fi.Mode().Perm())
+	}
+
+	err = s2i.Scaffold(fn.Function{Root: root, Runtime: "node"}, t.TempDir())
+	if !errors.Is(err, s2i.ErrScaffoldingNotSupported) {
+		t.Fatalf("expected ErrScaffoldingNotSupported, got %v", err)
+	}
+}
+
+// TestScaffoldIncremental ensures that scaffolding again leaves unchanged
+// files untouched, removes stale files, and results in the same scaffolding as
+// a full write.
+func TestScaffoldIncremental(t *testing.T) {
+	root := t.TempDir()
+	impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+	if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := fn.Function{Root: root, Runtime: "go"}
+	out := filepath.Join(root, ".s2i")
+	last := filepath.Join(out, "builds", "last")
+
+	if err := s2i.Scaffold(f, out); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(last, "main.go")
+	before, err := os.ReadFile(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err = os.Chtimes(main, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(last, "stale.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s2i.Scaffold(f, out); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) || !fi.ModTime().Equal(past) {
+		t.Fatalf("expected the unchanged file to be untouched, modified at %v", fi.ModTime())
+	}
+	if _, err = os.Stat(filepath.Join(last, "stale.go")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the stale file to be removed, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(out, "builds", ".staging")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the staging directory to be removed, got %v", err)
+	}
+
+	// The result is that of a full write.
+	full := filepath.Join(t.TempDir(), "builds", "last")
+	if err = s2i.Scaffold(f, filepath.Dir(filepath.Dir(full))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := treeOf(t, last), treeOf(t, full); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the scaffolding of a full write %v, got %v", want, got)
+	}
+}
+
+// treeOf returns the paths of the files of the directory with their content,
+// or the targets of links, excluding the link to the function.
+func treeOf(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	tree := map[string]string{}
+	err := filepath.Walk(dir, func(path string, fi fs.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || filepath.Base(path) == "f" {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		bb, err := os.ReadFile(path)
+		tree[rel] = fmt.Sprintf("%s %s", fi.Mode(), bb)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// TestBuildForceCopy ensures that the source is copied via the filesystem
+// whenever an assemble script is written, and not otherwise.
+func TestBuildForceCopy(t *testing.T) {
+	root := t.TempDir()
+	impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+	if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	forceCopy := func(f fn.Function) bool {
+		t.Helper()
+		var forced bool
+		i := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			forced = cfg.ForceCopy
+			return nil, nil
+		}}
+		if err := s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(mockDocker{})).Build(context.Background(), f, nil); err != nil {
+			t.Fatal(err)
+		}
+		return forced
+	}
+
+	if !forceCopy(fn.Function{Root: root, Runtime: "go"}) {
+		t.Error("expected the copy to be forced when an assemble script is written")
+	}
+	if _, err := os.Stat(filepath.Join(root, ".s2i", "bin", "assemble")); err != nil {
+		t.Fatalf("expected an assemble script to be written: %v", err)
+	}
+	if forceCopy(fn.Function{Root: t.TempDir(), Runtime: "node"}) {
+		t.Error("expected the copy not to be forced without an assemble script")
+	}
+}
+
+// TestBuildWithoutScaffolding ensures that when scaffolding is disabled no
+// scaffolding is written to the source of a Go function, which is built
+// as-is.
+func TestBuildWithoutScaffolding(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+		if cfg.ForceCopy || !cfg.KeepSymlinks {
+			t.Error("expected symlinks to be kept and no assemble script to force a copy")
+		}
+		return nil, nil
+	}}
+
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithScaffolding(false))
+	if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".s2i")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected no scaffolding to be written, got %v", err)
+	}
+}
+
+// mockImpl is a mock implementation of an S2I builder.
+type mockImpl struct {
+	BuildFn func(*api.Config) (*api.Result, error)
+}
+
+func (i *mockImpl) Build(cfg *api.Config) (*api.Result, error) {
+	return i.BuildFn(cfg)
+}
+
+type mockDocker struct {
+	inspect func(ctx context.Context, image string) (types.ImageInspect, []byte, error)
+	build   func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
+	pull    func(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error)
+	info    func(ctx context.Context) (system.Info, error)
+	ping    func(ctx context.Context) (types.Ping, error)
+	tag     func(ctx context.Context, source, target string) error
+}
+
+func (m mockDocker) ImageTag(ctx context.Context, source, target string) error {
+	if m.tag != nil {
+		return m.tag(ctx, source, target)
+	}
+
+	return nil
+}
+
+func (m mockDocker) Ping(ctx context.Context) (types.Ping, error) {
+	if m.ping != nil {
+		return m.ping(ctx)
+	}
+
+	return types.Ping{}, nil
+}
+
+func (m mockDocker) Info(ctx context.Context) (system.Info, error) {
+	if m.info != nil {
+		return m.info(ctx)
+	}
+
+	return system.Info{}, nil
+}
+
+func (m mockDocker) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	if m.inspect != nil {
+		return m.inspect(ctx, image)
+	}
+
+	return types.ImageInspect{}, nil, nil
+}
+
+func (m mockDocker) ImageBuild(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	if m.build != nil {
+		return m.build(ctx, context, options)
+	}
+
+	_, _ = io.Copy(io.Discard, context)
 	return types.ImageBuildResponse{
 		Body:   io.NopCloser(strings.NewReader("")),
 		OSType: "linux",
 	}, nil
 }
 
+func (m mockDocker) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	if m.pull != nil {
+		return m.pull(ctx, ref, options)
+	}
+
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
 type notFoundErr struct {
 }
 
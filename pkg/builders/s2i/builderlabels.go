@@ -0,0 +1,46 @@
+package s2i
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// WithRequiredBuilderLabels requires the builder image, as resolved for the
+// build, to have the labels, such as to only build with builder images
+// certified by an organization.  A label of an empty value must be present
+// with any non-empty value; others must have the value.  Builds with builder
+// images lacking any of the labels fail with ErrInvalidBuilderImage.
+func WithRequiredBuilderLabels(labels map[string]string) Option {
+	return func(b *Builder) {
+		b.requiredBuilderLabels = labels
+	}
+}
+
+// checkBuilderLabels returns an error listing the required labels the builder
+// image, of the given labels, lacks or has other values of.
+func (b *Builder) checkBuilderLabels(image string, labels map[string]string) error {
+	var missing, mismatched []string
+	for name, want := range b.requiredBuilderLabels {
+		got, ok := labels[name]
+		switch {
+		case !ok || got == "":
+			missing = append(missing, name)
+		case want != "" && got != want:
+			mismatched = append(mismatched, fmt.Sprintf("%s (expected %q, got %q)", name, want, got))
+		}
+	}
+	if len(missing) == 0 && len(mismatched) == 0 {
+		return nil
+	}
+	slices.Sort(missing)
+	slices.Sort(mismatched)
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(missing, ", "))
+	}
+	if len(mismatched) > 0 {
+		problems = append(problems, "mismatched "+strings.Join(mismatched, ", "))
+	}
+	return fmt.Errorf("builder image %q lacks required labels: %s", image, strings.Join(problems, "; "))
+}
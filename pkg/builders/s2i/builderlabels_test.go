@@ -0,0 +1,82 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildRequiredBuilderLabels ensures that builds fail, listing the labels,
+// if the builder image lacks labels required, or has other values of them.
+func TestBuildRequiredBuilderLabels(t *testing.T) {
+	const builderImage = "example.com/acme/builder:node"
+	required := map[string]string{
+		"com.example.certified": "true",
+		"com.example.runtime":   "", // any value
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []string // in the error; nil if the build succeeds
+	}{
+		{
+			name:   "present",
+			labels: map[string]string{"com.example.certified": "true", "com.example.runtime": "node20", "other": "x"},
+		},
+		{
+			name:   "missing",
+			labels: map[string]string{"com.example.runtime": ""},
+			want:   []string{"missing com.example.certified, com.example.runtime"},
+		},
+		{
+			name:   "mismatched",
+			labels: map[string]string{"com.example.certified": "false", "com.example.runtime": "node20"},
+			want:   []string{`mismatched com.example.certified (expected "true", got "false")`},
+		},
+		{
+			name:   "missing and mismatched",
+			labels: map[string]string{"com.example.certified": "no"},
+			want:   []string{"missing com.example.runtime", `mismatched com.example.certified (expected "true", got "no")`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := mockDocker{inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+				if image != builderImage {
+					t.Errorf("unexpected image inspected: %s", image)
+				}
+				return types.ImageInspect{Config: &container.Config{Labels: tt.labels}}, nil, nil
+			}}
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithRequiredBuilderLabels(required))
+			f := fn.Function{Root: t.TempDir(), Runtime: "node", Build: fn.BuildSpec{
+				BuilderImages: map[string]string{"s2i": builderImage},
+			}}
+
+			err := b.Build(context.Background(), f, nil)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, s2i.ErrInvalidBuilderImage) {
+				t.Fatalf("expected ErrInvalidBuilderImage, got %v", err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("expected the error to contain %q, got %q", w, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package s2i
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/pkg/jsonmessage"
+	controlapi "github.com/moby/buildkit/api/services/control"
+)
+
+// BuildKitMode determines whether the image is built with BuildKit or with
+// the classic builder of the daemon.
+type BuildKitMode string
+
+const (
+	// BuildKitAuto uses BuildKit if the daemon supports it, falling back to
+	// the classic builder otherwise (the default).  DOCKER_BUILDKIT, if set,
+	// takes precedence over detection.
+	BuildKitAuto BuildKitMode = "auto"
+	// BuildKitOn requires BuildKit; builds fail if the daemon lacks it.
+	BuildKitOn BuildKitMode = "on"
+	// BuildKitOff uses the classic builder.
+	BuildKitOff BuildKitMode = "off"
+)
+
+// buildKitMinAPIVersion is the API version of the first daemons to support
+// BuildKit (18.09).
+const buildKitMinAPIVersion = "1.39"
+
+// WithBuildKit sets whether the image is built with BuildKit (default
+// BuildKitAuto).  The classic builder does not support cache mounts, so
+// builds with it do not reuse the artifacts of previous builds, nor do they
+// support attestations, image formats or BuildKit sessions.
+func WithBuildKit(mode BuildKitMode) Option {
+	return func(b *Builder) {
+		b.buildKit = mode
+	}
+}
+
+// buildKitMode returns the BuildKit mode of the builder, which in auto mode
+// is that of DOCKER_BUILDKIT if set.
+func (b *Builder) buildKitMode() (BuildKitMode, error) {
+	if b.buildKit != "" && b.buildKit != BuildKitAuto {
+		return b.buildKit, nil
+	}
+	v := os.Getenv("DOCKER_BUILDKIT")
+	if v == "" {
+		return BuildKitAuto, nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid DOCKER_BUILDKIT %q: %w", v, err)
+	}
+	if !on {
+		return BuildKitOff, nil
+	}
+	return BuildKitOn, nil
+}
+
+// useBuildKit returns whether the image is to be built with BuildKit.
+func (b *Builder) useBuildKit(ctx context.Context, client DockerClient) (bool, error) {
+	mode, err := b.buildKitMode()
+	if err != nil || mode == BuildKitOff {
+		return false, err
+	}
+
+	if err := supportsBuildKit(ctx, client); err != nil {
+		if mode == BuildKitOn {
+			return false, fmt.Errorf("BuildKit is required but %w", err)
+		}
+		b.logf(LogLevelWarn, "Warning: %v; building with the classic builder, without cache mounts", err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// supportsBuildKit returns an error if the daemon is known not to support
+// BuildKit.  Daemons which cannot be queried are presumed to support it.
+func supportsBuildKit(ctx context.Context, client DockerClient) error {
+	c, ok := client.(interface {
+		Ping(ctx context.Context) (types.Ping, error)
+	})
+	if !ok {
+		return nil
+	}
+	ping, err := c.Ping(ctx)
+	if err != nil {
+		return nil
+	}
+	if ping.OSType == "windows" {
+		return errors.New("the docker daemon does not support BuildKit: Windows daemons support only the classic builder")
+	}
+	if ping.APIVersion != "" && versions.LessThan(ping.APIVersion, buildKitMinAPIVersion) {
+		return fmt.Errorf("the docker daemon does not support BuildKit: its API version %s is older than %s", ping.APIVersion, buildKitMinAPIVersion)
+	}
+	return nil
+}
+
+// classicBuildSupported returns an error if options of the builder require
+// BuildKit.
+func (b *Builder) classicBuildSupported() error {
+	switch {
+	case b.sbom || b.provenance:
+		return errors.New("attestations require BuildKit")
+	case b.imageFormat != "":
+		return errors.New("image formats require BuildKit")
+	case b.session != "":
+		return errors.New("BuildKit sessions require BuildKit")
+	case b.sourceMount:
+		return errors.New("source mounts require BuildKit")
+	}
+	return nil
+}
+
+// buildKitTraceID is the ID of the aux messages of the build stream which
+// carry the progress of BuildKit builds, as serialized status responses.
+const buildKitTraceID = "moby.buildkit.trace"
+
+// buildKitLogs returns the output of the steps of the build, such as of the
+// assemble script, carried by the message if it is a BuildKit trace.  The
+// output of builds with BuildKit is only in such messages, not in the
+// streams of those of the classic builder.
+func buildKitLogs(m jsonmessage.JSONMessage) []byte {
+	if m.ID != buildKitTraceID || m.Aux == nil {
+		return nil
+	}
+	var dt []byte
+	if err := json.Unmarshal(*m.Aux, &dt); err != nil {
+		return nil
+	}
+	var status controlapi.StatusResponse
+	if err := status.Unmarshal(dt); err != nil {
+		return nil
+	}
+	var logs []byte
+	for _, l := range status.Logs {
+		logs = append(logs, l.Msg...)
+	}
+	return logs
+}
@@ -0,0 +1,148 @@
+package s2i_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildKit ensures that images are built with BuildKit only if the
+// daemon supports it and the mode permits, falling back to the classic
+// builder, without cache mounts, or failing otherwise.
+func TestBuildKit(t *testing.T) {
+	const (
+		modern = "1.45"
+		legacy = "1.38"
+	)
+	tests := []struct {
+		name         string
+		apiVersion   string
+		mode         s2i.BuildKitMode
+		dockerEnv    string
+		wantBuildKit bool
+		wantErr      bool
+	}{
+		{name: "auto with BuildKit", apiVersion: modern, wantBuildKit: true},
+		{name: "auto without BuildKit", apiVersion: legacy},
+		{name: "auto disabled by DOCKER_BUILDKIT", apiVersion: modern, dockerEnv: "0"},
+		{name: "auto required by DOCKER_BUILDKIT", apiVersion: legacy, dockerEnv: "1", wantErr: true},
+		{name: "on with BuildKit", apiVersion: modern, mode: s2i.BuildKitOn, wantBuildKit: true},
+		{name: "on without BuildKit", apiVersion: legacy, mode: s2i.BuildKitOn, wantErr: true},
+		{name: "off", apiVersion: modern, mode: s2i.BuildKitOff, dockerEnv: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DOCKER_BUILDKIT", tt.dockerEnv)
+			var (
+				version    types.BuilderVersion
+				dockerfile string
+				impl       = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+					return nil, os.WriteFile(cfg.AsDockerfile, []byte(s2iDockerfile), 0644)
+				}}
+				cli = mockDocker{
+					ping: func(ctx context.Context) (types.Ping, error) {
+						return types.Ping{APIVersion: tt.apiVersion, OSType: "linux"}, nil
+					},
+					build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+						version = options.Version
+						tr := tar.NewReader(context)
+						for {
+							hdr, err := tr.Next()
+							if err != nil {
+								break
+							}
+							if hdr.Name == "Dockerfile" {
+								bb, _ := io.ReadAll(tr)
+								dockerfile = string(bb)
+							}
+						}
+						return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+					},
+				}
+			)
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithBuildKit(tt.mode))
+			err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "BuildKit is required") {
+					t.Fatalf("expected an error requiring BuildKit, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := version == types.BuilderBuildKit; got != tt.wantBuildKit {
+				t.Errorf("expected BuildKit: %v, got builder version %q", tt.wantBuildKit, version)
+			}
+			if got := strings.Contains(dockerfile, "--mount=type=cache"); got != tt.wantBuildKit {
+				t.Errorf("expected cache mounts: %v, got Dockerfile:\n%s", tt.wantBuildKit, dockerfile)
+			}
+		})
+	}
+}
+
+// TestBuildKitClassicUnsupported ensures that options which require BuildKit
+// are rejected when building with the classic builder.
+func TestBuildKitClassicUnsupported(t *testing.T) {
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}),
+		s2i.WithBuildKit(s2i.BuildKitOff), s2i.WithBuildKitSession("session"))
+	if err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
+
+// TestBuildCacheMountUnsupported ensures that a build failing due to the
+// cache mounts of the assemble step reports ErrCacheMountUnsupported, and that
+// disabling them removes them from the Dockerfile.
+func TestBuildCacheMountUnsupported(t *testing.T) {
+	t.Setenv("DOCKER_BUILDKIT", "")
+	tests := []struct {
+		name   string
+		stream string
+	}{
+		{"unknown flag", `{"errorDetail": {"message": "failed to solve with frontend dockerfile.v0: failed to create LLB definition: Dockerfile parse error line 7: Unknown flag: mount"}}`},
+		{"requires BuildKit", `{"errorDetail": {"message": "the --mount option requires BuildKit. Refer to https://docs.docker.com/go/buildkit/ to learn how to build images with BuildKit enabled"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+				return nil, os.WriteFile(cfg.AsDockerfile, []byte(s2iDockerfile), 0644)
+			}}
+			cli := mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+				_, _ = io.Copy(io.Discard, context)
+				return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(tt.stream)), OSType: "linux"}, nil
+			}}
+			err := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli)).Build(context.Background(), fn.Function{Runtime: "node"}, nil)
+			if !errors.Is(err, s2i.ErrCacheMountUnsupported) {
+				t.Fatalf("expected ErrCacheMountUnsupported, got %v", err)
+			}
+			if !strings.Contains(err.Error(), "WithCacheMount(false)") {
+				t.Errorf("expected the error to suggest disabling cache mounts, got %v", err)
+			}
+		})
+	}
+
+	var dockerfile bytes.Buffer
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+		return nil, os.WriteFile(cfg.AsDockerfile, []byte(s2iDockerfile), 0644)
+	}}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithCacheMount(false), s2i.WithDockerfileWriter(&dockerfile))
+	if err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(dockerfile.String(), "--mount=type=cache") {
+		t.Fatalf("expected no cache mounts, got Dockerfile:\n%s", dockerfile.String())
+	}
+}
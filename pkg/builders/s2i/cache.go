@@ -0,0 +1,86 @@
+package s2i
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/go-units"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// cacheMountType is the type of the BuildKit cache records of cache mounts.
+const cacheMountType = "exec.cachemount"
+
+// cacheID returns the id of the cache mount of the assemble step of builds of
+// functions at root, which is unique to it.
+func cacheID(root string) string {
+	s := sha1.Sum([]byte(root))
+	return hex.EncodeToString(s[:8])
+}
+
+// buildCacheClient is implemented by docker clients which can prune the
+// BuildKit cache.
+type buildCacheClient interface {
+	DiskUsage(ctx context.Context, options types.DiskUsageOptions) (types.DiskUsage, error)
+	BuildCachePrune(ctx context.Context, opts types.BuildCachePruneOptions) (*types.BuildCachePruneReport, error)
+}
+
+// PruneCache removes the cache mount of the assemble step of builds of the
+// function from the BuildKit cache of the daemon, such that a stale or
+// corrupt cache can be reset without pruning the cache of other functions.
+// The Go module cache (see WithGoModuleCache) is shared by all functions and
+// is not removed.  Caches in use by a build are not removed.  The cache of
+// functions built from an fs.FS without a root is that of their name (see
+// BuildFSCopy).
+func (b *Builder) PruneCache(ctx context.Context, f fn.Function) error {
+	client, done, err := b.dockerClient()
+	if err != nil {
+		return err
+	}
+	defer done()
+	c, ok := client.(buildCacheClient)
+	if !ok {
+		return errors.New("the docker client does not support pruning the build cache")
+	}
+
+	// The build cache can only be filtered by the exact description of
+	// records, which includes the command of the step, so the records of the
+	// cache mount are found by its id and pruned by theirs.
+	du, err := c.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.BuildCacheObject}})
+	if err != nil {
+		return fmt.Errorf("cannot list the build cache: %w", err)
+	}
+	// The id of the description is that of the mount prefixed with its
+	// namespace, which is empty for the mounts of dockerfile builds: such as
+	// with id "/<id>" or with id "<namespace>/<id>".
+	root, err := fsCacheRoot(f)
+	if err != nil {
+		return wrap(ErrValidation, err)
+	}
+	mount := regexp.MustCompile(` with id "(?:[^"]*/)?` + regexp.QuoteMeta(cacheID(root)) + `"$`)
+	var reclaimed uint64
+	for _, r := range du.BuildCache {
+		if r == nil || r.Type != cacheMountType || !mount.MatchString(r.Description) {
+			continue
+		}
+		report, err := c.BuildCachePrune(ctx, types.BuildCachePruneOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("id", "^"+regexp.QuoteMeta(r.ID)+"$")),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot prune the build cache: %w", err)
+		}
+		if report != nil {
+			reclaimed += report.SpaceReclaimed
+		}
+	}
+	b.logf(LogLevelInfo, "Pruned the build cache of %s, reclaiming %s", f.Root, units.HumanSize(float64(reclaimed)))
+	return nil
+}
@@ -0,0 +1,60 @@
+package s2i_test
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// mockCacheDocker is a mockDocker with a build cache.
+type mockCacheDocker struct {
+	mockDocker
+	records []*types.BuildCache
+	pruned  []string // id filters of the prunes
+}
+
+func (m *mockCacheDocker) DiskUsage(ctx context.Context, options types.DiskUsageOptions) (types.DiskUsage, error) {
+	return types.DiskUsage{BuildCache: m.records}, nil
+}
+
+func (m *mockCacheDocker) BuildCachePrune(ctx context.Context, opts types.BuildCachePruneOptions) (*types.BuildCachePruneReport, error) {
+	m.pruned = append(m.pruned, opts.Filters.Get("id")...)
+	return &types.BuildCachePruneReport{SpaceReclaimed: 1024}, nil
+}
+
+// TestPruneCache ensures that only the records of the cache mount of the
+// function, whose id is that of its builds, are pruned.
+func TestPruneCache(t *testing.T) {
+	f := fn.Function{Root: t.TempDir(), Runtime: "node"}
+	dockerfile, err := buildDockerfile(t, f, s2iDockerfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`target=/tmp/artifacts/,uid=1001,id=([0-9a-f]+)`).FindStringSubmatch(dockerfile)
+	if m == nil {
+		t.Fatalf("expected a cache mount, got:\n%s", dockerfile)
+	}
+	id := m[1]
+
+	cli := &mockCacheDocker{records: []*types.BuildCache{
+		// Descriptions as BuildKit records them, with the namespace of the id.
+		{ID: "rec1", Type: "exec.cachemount", Description: `cached mount /tmp/artifacts/ from exec /bin/sh -c /usr/libexec/s2i/assemble with id "/` + id + `"`},
+		{ID: "rec2", Type: "exec.cachemount", Description: `cached mount /tmp/artifacts/ from exec /bin/sh -c /usr/libexec/s2i/assemble with id "/0123456789abcdef"`},
+		{ID: "rec3", Type: "exec.cachemount", Description: `cached mount /go/pkg/mod from exec /bin/sh -c /usr/libexec/s2i/assemble with id "/func-go-mod"`},
+		{ID: "rec4", Type: "regular", Description: `mount / from exec /bin/sh -c /usr/libexec/s2i/assemble with id "/` + id + `"`},
+		{ID: "rec5", Type: "exec.cachemount", Description: `cached mount /tmp/artifacts/ from exec /bin/sh -c /usr/libexec/s2i/assemble with id "ns/` + id + `"`},
+		{ID: "rec6", Type: "exec.cachemount", Description: `cached mount /tmp/artifacts/ from exec /bin/sh -c /usr/libexec/s2i/assemble with id "/x` + id + `"`},
+	}}
+	if err = s2i.NewBuilder(s2i.WithDockerClient(cli)).PruneCache(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cli.pruned, []string{"^rec1$", "^rec5$"}) {
+		t.Fatalf("expected the cache mount of the function to be pruned, got filters %v", cli.pruned)
+	}
+}
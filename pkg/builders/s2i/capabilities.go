@@ -0,0 +1,62 @@
+package s2i
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Capabilities of a builder: those of the builder itself and those detected
+// of the docker daemon, such that tools can present the features available
+// without attempting a build.
+type Capabilities struct {
+	// Daemon indicates that the docker daemon is reachable.  The
+	// capabilities detected of the daemon are false otherwise.
+	Daemon bool
+	// BuildKit indicates that images are built with BuildKit, per
+	// WithBuildKit and the daemon, and thus with cache mounts.
+	BuildKit bool
+	// Secrets indicates that BuildKit secrets, such as GoNetrcSecret, can be
+	// provided to builds: by the BuildKit session of WithBuildKitSession.
+	Secrets bool
+	// Attestations indicates that SBOM and provenance attestations can be
+	// attached to images, which requires BuildKit and the containerd image
+	// store of the daemon.
+	Attestations bool
+	// MultiArch indicates that a single build can produce an image of
+	// multiple platforms.  Always false: S2I builds a single platform.
+	MultiArch bool
+	// ScaffoldedRuntimes are the runtimes whose functions are scaffolded (see
+	// RegisterScaffolder), sorted.
+	ScaffoldedRuntimes []string
+}
+
+// Capabilities returns the capabilities of the builder with its docker
+// daemon.  Capabilities which cannot be detected are reported as absent.
+func (b *Builder) Capabilities(ctx context.Context) (c Capabilities) {
+	scaffoldersMu.RLock()
+	c.ScaffoldedRuntimes = slices.Sorted(maps.Keys(scaffolders))
+	scaffoldersMu.RUnlock()
+
+	client, done, err := b.dockerClient()
+	if err != nil {
+		return
+	}
+	defer done()
+	if p, ok := client.(interface {
+		Ping(ctx context.Context) (types.Ping, error)
+	}); ok {
+		if _, err = p.Ping(ctx); err != nil {
+			return
+		}
+	}
+	c.Daemon = true
+
+	mode, err := b.buildKitMode()
+	c.BuildKit = err == nil && mode != BuildKitOff && supportsBuildKit(ctx, client) == nil
+	c.Secrets = c.BuildKit && b.session != ""
+	c.Attestations = c.BuildKit && supportsAttestations(ctx, client) == nil
+	return
+}
@@ -0,0 +1,85 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/system"
+
+	"knative.dev/func/pkg/builders/s2i"
+)
+
+// TestCapabilities ensures that the capabilities of the builder reflect those
+// of the daemon and the builder's options.
+func TestCapabilities(t *testing.T) {
+	containerd := func(ctx context.Context) (system.Info, error) {
+		return system.Info{DriverStatus: [][2]string{{"driver-type", "io.containerd.snapshotter.v1"}}}, nil
+	}
+	linux := func(ctx context.Context) (types.Ping, error) {
+		return types.Ping{OSType: "linux", APIVersion: "1.45"}, nil
+	}
+
+	tests := []struct {
+		name    string
+		cli     mockDocker
+		options []s2i.Option
+		want    s2i.Capabilities
+	}{
+		{
+			name: "containerd",
+			cli:  mockDocker{ping: linux, info: containerd},
+			want: s2i.Capabilities{Daemon: true, BuildKit: true, Attestations: true},
+		},
+		{
+			name:    "session",
+			cli:     mockDocker{ping: linux, info: containerd},
+			options: []s2i.Option{s2i.WithBuildKitSession("session-id")},
+			want:    s2i.Capabilities{Daemon: true, BuildKit: true, Secrets: true, Attestations: true},
+		},
+		{
+			name: "classic image store",
+			cli:  mockDocker{ping: linux},
+			want: s2i.Capabilities{Daemon: true, BuildKit: true},
+		},
+		{
+			name: "windows",
+			cli: mockDocker{info: containerd, ping: func(ctx context.Context) (types.Ping, error) {
+				return types.Ping{OSType: "windows", APIVersion: "1.45"}, nil
+			}},
+			want: s2i.Capabilities{Daemon: true},
+		},
+		{
+			name:    "BuildKit off",
+			cli:     mockDocker{ping: linux, info: containerd},
+			options: []s2i.Option{s2i.WithBuildKit(s2i.BuildKitOff)},
+			want:    s2i.Capabilities{Daemon: true},
+		},
+		{
+			name: "unreachable",
+			cli: mockDocker{info: containerd, ping: func(ctx context.Context) (types.Ping, error) {
+				return types.Ping{}, errors.New("connection refused")
+			}},
+			want: s2i.Capabilities{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DOCKER_BUILDKIT", "")
+			b := s2i.NewBuilder(append([]s2i.Option{s2i.WithDockerClient(tt.cli)}, tt.options...)...)
+			got := b.Capabilities(context.Background())
+			for _, runtime := range []string{"go", "typescript"} {
+				if !slices.Contains(got.ScaffoldedRuntimes, runtime) {
+					t.Errorf("expected %s to be scaffolded, got %v", runtime, got.ScaffoldedRuntimes)
+				}
+			}
+			got.ScaffoldedRuntimes = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected capabilities %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
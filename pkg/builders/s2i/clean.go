@@ -0,0 +1,68 @@
+package s2i
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// CleanArtifacts removes the files which builds write to the root of the
+// function, and may leave behind, leaving those of its author intact: the
+// scaffolding (.s2i/builds), assemble scripts written by func and the link of
+// .s2iignore to .funcignore.  Assemble scripts are recognized by their
+// content, such that scripts of the function's own, or modified ones, are
+// kept.  The .s2i directory is removed if left empty.  A docker daemon is not
+// required.
+func CleanArtifacts(f fn.Function) error {
+	s2iDir := filepath.Join(f.Root, ".s2i")
+	if err := os.RemoveAll(filepath.Join(s2iDir, "builds")); err != nil {
+		return fmt.Errorf("cannot remove the scaffolding: %w", err)
+	}
+
+	assemble := filepath.Join(s2iDir, "bin", "assemble")
+	content, err := os.ReadFile(assemble)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot read assembler: %w", err)
+	}
+	if err == nil && generatedAssembler(string(content)) {
+		if err = os.Remove(assemble); err != nil {
+			return fmt.Errorf("cannot remove assembler: %w", err)
+		}
+	}
+	// Directories are removed only if empty.
+	for _, dir := range []string{filepath.Join(s2iDir, "bin"), s2iDir} {
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+			if err = os.Remove(dir); err != nil {
+				return fmt.Errorf("cannot remove %s: %w", dir, err)
+			}
+		}
+	}
+
+	ignore := filepath.Join(f.Root, ".s2iignore")
+	if target, err := os.Readlink(ignore); err == nil && target == "./.funcignore" {
+		if err = os.Remove(ignore); err != nil {
+			return fmt.Errorf("cannot remove the .s2iignore link: %w", err)
+		}
+	}
+	return nil
+}
+
+// generatedAssembler returns whether the assemble script is one written by
+// func, of any shell, including by its earlier versions.
+func generatedAssembler(script string) bool {
+	if script == legacyGoAssembler {
+		return true
+	}
+	_, body, _ := strings.Cut(script, "\n") // the shebang is that of the shell
+	for _, assembler := range []string{GoAssembler, TypeScriptAssembler} {
+		if _, generated, _ := strings.Cut(assembler, "\n"); body == generated {
+			return true
+		}
+	}
+	return false
+}
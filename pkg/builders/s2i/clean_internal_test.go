@@ -0,0 +1,44 @@
+package s2i
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGeneratedAssembler ensures that assemble scripts written by func, of
+// any shell and by its earlier versions, are recognized as such by cleaning
+// and by the check of a clean tree, and that others are not.
+func TestGeneratedAssembler(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   bool
+	}{
+		{name: "go", script: GoAssembler, want: true},
+		{name: "go of bash", script: strings.Replace(GoAssembler, "#!/bin/sh", "#!/bin/bash", 1), want: true},
+		{name: "typescript", script: TypeScriptAssembler, want: true},
+		{name: "legacy go", script: legacyGoAssembler, want: true},
+		{name: "authored", script: "#!/bin/sh\necho custom\n"},
+		{name: "modified legacy go", script: legacyGoAssembler + "echo custom\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := generatedAssembler(tt.script); got != tt.want {
+				t.Fatalf("expected generated: %v, got %v", tt.want, got)
+			}
+			root := t.TempDir()
+			path := filepath.Join(root, ".s2i", "bin", "assemble")
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(tt.script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if got := writtenByBuild(root, ".s2i/bin/assemble"); got != tt.want {
+				t.Fatalf("expected written by builds: %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
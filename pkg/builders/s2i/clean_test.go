@@ -0,0 +1,92 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestCleanArtifacts ensures that the files written by builds are removed,
+// and that those of the function's author survive.
+func TestCleanArtifacts(t *testing.T) {
+	exists := func(t *testing.T, root, p string) bool {
+		t.Helper()
+		_, err := os.Lstat(filepath.Join(root, filepath.FromSlash(p)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+	write := func(t *testing.T, root, p, content string) {
+		t.Helper()
+		path := filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+
+	t.Run("generated", func(t *testing.T) {
+		root := t.TempDir()
+		write(t, root, "f.go", impl)
+		write(t, root, ".funcignore", "node_modules\n")
+		// The link of .s2iignore is left as by an interrupted build.
+		if err := os.Symlink("./.funcignore", filepath.Join(root, ".s2iignore")); err != nil {
+			t.Fatal(err)
+		}
+		f := fn.Function{Root: root, Runtime: "go"}
+		b := s2i.NewBuilder(s2i.WithDockerClient(mockDocker{}), s2i.WithAssembleShell("/bin/bash"),
+			s2i.WithImpl(&mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}))
+		if err := b.Build(context.Background(), f, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !exists(t, root, ".s2i/builds/last/main.go") || !exists(t, root, ".s2i/bin/assemble") {
+			t.Fatal("expected the build to write scaffolding and an assembler")
+		}
+
+		if err := s2i.CleanArtifacts(f); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{".s2i", ".s2iignore"} {
+			if exists(t, root, p) {
+				t.Errorf("expected %s to be removed", p)
+			}
+		}
+		for _, p := range []string{"f.go", ".funcignore"} {
+			if !exists(t, root, p) {
+				t.Errorf("expected %s to survive", p)
+			}
+		}
+	})
+
+	t.Run("authored", func(t *testing.T) {
+		root := t.TempDir()
+		write(t, root, ".s2i/bin/assemble", "#!/bin/sh\necho custom\n")
+		write(t, root, ".s2i/environment", "FOO=bar\n")
+		write(t, root, ".s2i/builds/last/main.go", "package main\n")
+		write(t, root, ".s2iignore", "node_modules\n")
+
+		if err := s2i.CleanArtifacts(fn.Function{Root: root, Runtime: "go"}); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{".s2i/bin/assemble", ".s2i/environment", ".s2iignore"} {
+			if !exists(t, root, p) {
+				t.Errorf("expected %s to survive", p)
+			}
+		}
+		if exists(t, root, ".s2i/builds") {
+			t.Error("expected the scaffolding to be removed")
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package s2i
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"sigs.k8s.io/yaml"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// redacted replaces secret values of dumped configs.
+const redacted = "REDACTED"
+
+// WithConfigDump writes the final S2I config of each build, after
+// scaffolding and all options are applied, to path for audits.  The dump is
+// YAML if path has a .yaml or .yml extension, JSON otherwise.  Secrets are
+// redacted: the values of build envs interpolated from the environment, the
+// passwords of registry credentials, and the docker connection.
+func WithConfigDump(path string) Option {
+	return func(b *Builder) {
+		b.configDumpPath = path
+	}
+}
+
+// interpolatedEnvs returns the names of the build envs of the function whose
+// values are interpolated from the environment, such as {{ env:TOKEN }}, and
+// are thus treated as secrets.
+func interpolatedEnvs(f fn.Function, buildEnvs map[string]string) (names []string) {
+	for _, e := range f.Build.BuildEnvs {
+		if e.Name != nil && e.Value != nil && *e.Value != buildEnvs[*e.Name] {
+			names = append(names, *e.Name)
+		}
+	}
+	return
+}
+
+// dumpConfig writes the config, with the values of the secret envs and other
+// secrets redacted, to the config dump path.
+func (b *Builder) dumpConfig(cfg *api.Config, secretEnvs []string) error {
+	c := *cfg
+	c.Environment = slices.Clone(cfg.Environment)
+	for i, e := range c.Environment {
+		if slices.Contains(secretEnvs, e.Name) {
+			c.Environment[i].Value = redacted
+		}
+	}
+	slices.SortFunc(c.Environment, func(a, b api.EnvironmentSpec) int { return strings.Compare(a.Name, b.Name) })
+	for _, ac := range []*api.AuthConfig{&c.PullAuthentication, &c.RuntimeAuthentication, &c.IncrementalAuthentication} {
+		if ac.Password != "" {
+			ac.Password = redacted
+		}
+	}
+
+	// The config is dumped via a map such that the docker connection, of
+	// structured type, is redacted as a whole.
+	bb, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("cannot dump config: %w", err)
+	}
+	var m map[string]any
+	if err = json.Unmarshal(bb, &m); err != nil {
+		return fmt.Errorf("cannot dump config: %w", err)
+	}
+	if c.DockerConfig != nil {
+		m["DockerConfig"] = redacted
+	}
+	switch strings.ToLower(filepath.Ext(b.configDumpPath)) {
+	case ".yaml", ".yml":
+		bb, err = yaml.Marshal(m)
+	default:
+		bb, err = json.MarshalIndent(m, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot dump config: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(b.configDumpPath), os.ModePerm); err != nil {
+		return err
+	}
+	if err = os.WriteFile(b.configDumpPath, bb, 0644); err != nil {
+		return fmt.Errorf("cannot dump config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package s2i_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildConfigDump ensures that the config of the build is dumped as JSON
+// or YAML with its secrets redacted.
+func TestBuildConfigDump(t *testing.T) {
+	t.Setenv("DUMP_TEST_TOKEN", "env-secret")
+	var (
+		token   = "TOKEN"
+		literal = "LITERAL"
+		fromEnv = "{{ env:DUMP_TEST_TOKEN }}"
+		value   = "literal-value"
+	)
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuildEnvs: []fn.Env{
+		{Name: &token, Value: &fromEnv},
+		{Name: &literal, Value: &value},
+	}}}
+
+	for _, file := range []string{"config.json", "config.yaml"} {
+		t.Run(file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), file)
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithConfigDump(path),
+				s2i.WithS2IConfig(func(cfg *api.Config) {
+					cfg.PullAuthentication = api.AuthConfig{Username: "alice", Password: "pull-secret"}
+					cfg.DockerConfig = &api.DockerConfig{Endpoint: "tcp://docker:2376", KeyFile: "/certs/key.pem"}
+				}))
+			if err := b.Build(context.Background(), f, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			bb, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dump := string(bb)
+			for _, secret := range []string{"env-secret", "pull-secret", "/certs/key.pem"} {
+				if strings.Contains(dump, secret) {
+					t.Errorf("expected %q to be redacted, got:\n%s", secret, dump)
+				}
+			}
+			for _, want := range []string{"literal-value", "alice", "REDACTED"} {
+				if !strings.Contains(dump, want) {
+					t.Errorf("expected the dump to contain %q, got:\n%s", want, dump)
+				}
+			}
+			if yaml := strings.HasSuffix(file, ".yaml"); yaml == strings.HasPrefix(dump, "{") {
+				t.Errorf("expected yaml: %v, got:\n%s", yaml, dump)
+			}
+		})
+	}
+}
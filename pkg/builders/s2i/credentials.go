@@ -0,0 +1,160 @@
+package s2i
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/openshift/source-to-image/pkg/api"
+	s2idocker "github.com/openshift/source-to-image/pkg/docker"
+)
+
+// dockerHubAuthKey is the key of the credentials of Docker Hub in the docker
+// config and in the credentials passed to the daemon.
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// registryAuth resolves credentials for the registries of the images as the
+// docker CLI does: via the credential helper configured for the registry
+// (credHelpers), the default credential store (credsStore) or the auths of
+// the docker config.  Returned are the credentials keyed by registry, as
+// expected by the daemon, omitting registries without credentials.
+func (b *Builder) registryAuth(images ...string) (map[string]registry.AuthConfig, error) {
+	cf, err := config.Load(b.dockerConfigDir())
+	if err != nil {
+		return nil, fmt.Errorf("cannot load docker config: %w", err)
+	}
+	auths := map[string]registry.AuthConfig{}
+	for _, image := range images {
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse image name: %w", err)
+		}
+		key := authKey(ref)
+		if _, ok := auths[key]; ok {
+			continue
+		}
+		ac, err := cf.GetAuthConfig(key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get credentials for registry %q: %w", key, err)
+		}
+		if ac.Username == "" && ac.Password == "" && ac.Auth == "" && ac.IdentityToken == "" && ac.RegistryToken == "" {
+			continue
+		}
+		auths[key] = registry.AuthConfig{
+			Username:      ac.Username,
+			Password:      ac.Password,
+			Auth:          ac.Auth,
+			ServerAddress: key,
+			IdentityToken: ac.IdentityToken,
+			RegistryToken: ac.RegistryToken,
+		}
+	}
+	return auths, nil
+}
+
+// hostAuth resolves the credentials of the host as registryAuth does, such
+// that resources of hosts other than registries can be fetched with the
+// credentials of the docker config.
+func (b *Builder) hostAuth(host string) (registry.AuthConfig, error) {
+	cf, err := config.Load(b.dockerConfigDir())
+	if err != nil {
+		return registry.AuthConfig{}, fmt.Errorf("cannot load docker config: %w", err)
+	}
+	ac, err := cf.GetAuthConfig(host)
+	if err != nil {
+		return registry.AuthConfig{}, fmt.Errorf("cannot get credentials for %q: %w", host, err)
+	}
+	return registry.AuthConfig{
+		Username:      ac.Username,
+		Password:      ac.Password,
+		Auth:          ac.Auth,
+		ServerAddress: host,
+		IdentityToken: ac.IdentityToken,
+		RegistryToken: ac.RegistryToken,
+	}, nil
+}
+
+// keychain of the requests of func to registries, resolving credentials as
+// registryAuth does, such that they are those passed to the daemon.
+func (b *Builder) keychain() authn.Keychain {
+	return dockerConfigKeychain{b: b}
+}
+
+// dockerConfigKeychain resolves the credentials of registries from the docker
+// config of the builder (see WithDockerConfigDir).
+type dockerConfigKeychain struct {
+	b *Builder
+}
+
+func (k dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	cf, err := config.Load(k.b.dockerConfigDir())
+	if err != nil {
+		return nil, fmt.Errorf("cannot load docker config: %w", err)
+	}
+	key := target.RegistryStr()
+	if key == name.DefaultRegistry {
+		key = dockerHubAuthKey
+	}
+	ac, err := cf.GetAuthConfig(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get credentials for registry %q: %w", key, err)
+	}
+	if ac.Username == "" && ac.Password == "" && ac.Auth == "" && ac.IdentityToken == "" && ac.RegistryToken == "" {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      ac.Username,
+		Password:      ac.Password,
+		Auth:          ac.Auth,
+		IdentityToken: ac.IdentityToken,
+		RegistryToken: ac.RegistryToken,
+	}), nil
+}
+
+// authKey returns the key of the credentials of the registry of the image.
+func authKey(ref name.Reference) string {
+	if r := ref.Context().RegistryStr(); r != name.DefaultRegistry {
+		return r
+	}
+	return dockerHubAuthKey
+}
+
+// WithDockerConfigDir reads the docker config, and thus the credentials with
+// which images are pulled, from dir in place of that of DOCKER_CONFIG or the
+// default (~/.docker), such as a directory of job-scoped credentials in CI.
+// The TLS certificates of the daemon are likewise read from dir unless
+// DOCKER_CERT_PATH is set.
+func WithDockerConfigDir(dir string) Option {
+	return func(b *Builder) {
+		b.dockerConfig = dir
+	}
+}
+
+// dockerConfigDir returns the directory of the docker config.  DOCKER_CONFIG
+// is consulted on each call, as config.Dir caches its value.
+func (b *Builder) dockerConfigDir() string {
+	if b.dockerConfig != "" {
+		return b.dockerConfig
+	}
+	if dir := os.Getenv(config.EnvOverrideConfigDir); dir != "" {
+		return dir
+	}
+	return config.Dir()
+}
+
+// s2iDockerConfig returns the configuration of the docker daemon of S2I,
+// that of the environment with the certificates of the docker config dir
+// (see WithDockerConfigDir).
+func (b *Builder) s2iDockerConfig() *api.DockerConfig {
+	cfg := s2idocker.GetDefaultDockerConfig()
+	if b.dockerConfig != "" && os.Getenv("DOCKER_CERT_PATH") == "" {
+		cfg.CertFile = filepath.Join(b.dockerConfig, "cert.pem")
+		cfg.KeyFile = filepath.Join(b.dockerConfig, "key.pem")
+		cfg.CAFile = filepath.Join(b.dockerConfig, "ca.pem")
+	}
+	return cfg
+}
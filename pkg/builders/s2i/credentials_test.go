@@ -0,0 +1,201 @@
+package s2i_test
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	dockerregistry "github.com/docker/docker/api/types/registry"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders"
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+	. "knative.dev/func/pkg/testing"
+)
+
+// mockHelperSrc is the source of a docker credential helper which provides
+// credentials for any registry.
+const mockHelperSrc = `package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "get" {
+		os.Exit(1)
+	}
+	server, _ := io.ReadAll(os.Stdin)
+	fmt.Printf("{\"ServerURL\": %q, \"Username\": \"AWS\", \"Secret\": \"token\"}", strings.TrimSpace(string(server)))
+}
+`
+
+// TestBuildCredentialHelpers ensures that credentials for the registry of
+// the builder image are resolved via the credential helper configured for
+// it, and passed to the daemon.
+func TestBuildCredentialHelpers(t *testing.T) {
+	const host = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+	WithExecutable(t, "docker-credential-ecr-mock", mockHelperSrc)
+
+	configDir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", configDir)
+	config := `{"credHelpers": {"` + host + `": "ecr-mock"}}`
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		auths map[string]dockerregistry.AuthConfig
+		pull  api.AuthConfig
+		cli   = mockDocker{
+			build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+				auths = options.AuthConfigs
+				_, _ = io.Copy(io.Discard, context)
+				return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+			},
+		}
+		impl = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			pull = cfg.PullAuthentication
+			return nil, nil
+		}}
+		build = func(builderImage string) {
+			t.Helper()
+			f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: builderImage}}}
+			if err := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli)).Build(context.Background(), f, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	)
+
+	build(host + "/builder:latest")
+	if ac, ok := auths[host]; !ok || ac.Username != "AWS" || ac.Password != "token" {
+		t.Fatalf("expected credentials of the helper for %s, got %v", host, auths)
+	}
+	if pull.Username != "AWS" || pull.Password != "token" || pull.ServerAddress != host {
+		t.Fatalf("expected the pull authentication to be set, got %v", pull)
+	}
+
+	// Registries without a helper or credentials get none.
+	build("example.com/builder:latest")
+	if len(auths) != 0 {
+		t.Fatalf("expected no credentials, got %v", auths)
+	}
+}
+
+// TestBuildDockerConfigDir ensures that credentials are read from the docker
+// config of DOCKER_CONFIG, or of the directory given in its place, as are the
+// TLS certificates of the daemon.
+func TestBuildDockerConfigDir(t *testing.T) {
+	const host = "registry.example.com"
+	writeConfig := func(username string) string {
+		t.Helper()
+		dir := t.TempDir()
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":secret"))
+		config := `{"auths": {"` + host + `": {"auth": "` + auth + `"}}}`
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	t.Setenv("DOCKER_CONFIG", writeConfig("env"))
+	t.Setenv("DOCKER_CERT_PATH", "")
+	jobDir := writeConfig("job")
+
+	build := func(options ...s2i.Option) (cfg *api.Config) {
+		t.Helper()
+		impl := &mockImpl{BuildFn: func(c *api.Config) (*api.Result, error) {
+			cfg = c
+			return nil, nil
+		}}
+		options = append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{})}, options...)
+		f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: host + "/builder:latest"}}}
+		if err := s2i.NewBuilder(options...).Build(context.Background(), f, nil); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if cfg := build(); cfg.PullAuthentication.Username != "env" {
+		t.Fatalf("expected the credentials of DOCKER_CONFIG, got %v", cfg.PullAuthentication)
+	}
+	cfg := build(s2i.WithDockerConfigDir(jobDir))
+	if cfg.PullAuthentication.Username != "job" || cfg.PullAuthentication.Password != "secret" {
+		t.Fatalf("expected the credentials of the docker config dir, got %v", cfg.PullAuthentication)
+	}
+	if cfg.DockerConfig.CAFile != filepath.Join(jobDir, "ca.pem") {
+		t.Fatalf("expected the certificates of the docker config dir, got %q", cfg.DockerConfig.CAFile)
+	}
+}
+
+// TestBuildRegistryCredentials ensures that the requests of func to
+// registries, such as of the labels and digest of the builder image, are
+// made with the credentials of the docker config.
+func TestBuildRegistryCredentials(t *testing.T) {
+	handler := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	builderImage := host + "/default/builder:latest"
+	ref, err := name.ParseReference(builderImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(ref, img, remote.WithAuth(&authn.Basic{Username: "alice", Password: "secret"})); err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig := func(auths string) string {
+		t.Helper()
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths": {`+auths+`}}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	build := func(configDir string) error {
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		cli := mockDocker{inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+			return types.ImageInspect{}, nil, notFoundErr{}
+		}}
+		f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: builderImage}}}
+		return s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithDockerConfigDir(configDir),
+			s2i.WithVerifyBuilderSignature(&mockVerifier{})).Build(context.Background(), f, nil)
+	}
+
+	if err = build(writeConfig(`"` + host + `": {"auth": "` + auth + `"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err = build(writeConfig("")); err == nil {
+		t.Fatal("expected the build to fail without credentials")
+	}
+}
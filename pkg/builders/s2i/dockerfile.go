@@ -0,0 +1,264 @@
+package s2i
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// GoModuleCacheDir is the directory of the Go module cache when persisted
+// (see WithGoModuleCache).
+const GoModuleCacheDir = "/go/pkg/mod"
+
+// goModuleCache settings of Go builds
+type goModuleCache struct {
+	proxy string
+	flags string
+}
+
+// envs which configure the go command to use the cache.
+func (c goModuleCache) envs() map[string]string {
+	envs := map[string]string{"GOMODCACHE": GoModuleCacheDir}
+	if c.proxy != "" {
+		envs["GOPROXY"] = c.proxy
+	}
+	if c.flags != "" {
+		envs["GOFLAGS"] = c.flags
+	}
+	return envs
+}
+
+// GoNetrcSecret is the id of the BuildKit secret mounted as the .netrc of the
+// user of the assemble step of Go builds with private modules (see
+// WithGoPrivate).
+const GoNetrcSecret = "netrc"
+
+// goNetrcPath is the path of the .netrc in the home of the S2I user.
+const goNetrcPath = "/opt/app-root/src/.netrc"
+
+// goNetrcMount mounts GoNetrcSecret at goNetrcPath.
+const goNetrcMount = "--mount=type=secret,id=" + GoNetrcSecret + ",target=" + goNetrcPath + ",uid=1001,mode=0400,required=true"
+
+// CacheSharing is the sharing mode of the cache mount of the assemble step,
+// which determines how concurrent builds of a function use the cache.
+type CacheSharing string
+
+const (
+	// CacheSharingShared permits concurrent use of the cache (the default).
+	CacheSharingShared CacheSharing = "shared"
+	// CacheSharingPrivate gives concurrent builds a new cache of their own.
+	CacheSharingPrivate CacheSharing = "private"
+	// CacheSharingLocked serializes concurrent builds' use of the cache.
+	CacheSharingLocked CacheSharing = "locked"
+)
+
+// patchDockerfile of the config, as generated by S2I, adding a cache mount to
+// the assemble step if built with BuildKit, a final stage based on the
+// runtime image if configured, and any instructions and directives requested
+// by the builder's options.
+func (b *Builder) patchDockerfile(cfg *api.Config, f fn.Function, buildKit bool) error {
+	path := cfg.AsDockerfile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	newDockerFileStr := string(data)
+	if buildKit {
+		newDockerFileStr = b.mountCaches(newDockerFileStr, f)
+	}
+
+	// Runtime image
+	// S2I does not honor a runtime image when generating a Dockerfile, so
+	// the artifacts are copied from the builder stage to a final stage.
+	if cfg.RuntimeImage != "" {
+		if loc := unnamedFrom.FindStringIndex(newDockerFileStr); loc != nil {
+			newDockerFileStr = newDockerFileStr[:loc[1]] + " AS builder" + newDockerFileStr[loc[1]:]
+		}
+		newDockerFileStr = appendInstruction(newDockerFileStr, "FROM "+cfg.RuntimeImage)
+		for _, a := range cfg.RuntimeArtifacts {
+			newDockerFileStr = appendInstruction(newDockerFileStr, fmt.Sprintf("COPY --from=builder %s %s", a.Source, a.Destination))
+		}
+	}
+
+	if b.pullMirror != "" {
+		newDockerFileStr = b.mirrorFroms(newDockerFileStr)
+	}
+
+	// The working directory is set after the assemble step and any copied
+	// artifacts such that it affects only the running function.
+	if b.workdir != "" {
+		newDockerFileStr = appendInstruction(newDockerFileStr, "WORKDIR "+b.workdir)
+	}
+	// The user is likewise set after the assemble step, which runs as the
+	// user of the builder image.
+	if b.runtimeUser != "" {
+		newDockerFileStr = appendInstruction(newDockerFileStr, "USER "+b.runtimeUser)
+	}
+	if b.exposedPort != 0 && !hasInstruction(newDockerFileStr, "EXPOSE") {
+		newDockerFileStr = appendInstruction(newDockerFileStr, "EXPOSE "+strconv.Itoa(b.exposedPort))
+	}
+	if len(b.entrypoint) > 0 && !hasInstruction(newDockerFileStr, "ENTRYPOINT") {
+		entrypoint, err := json.Marshal(b.entrypoint)
+		if err != nil {
+			return fmt.Errorf("cannot encode entrypoint: %w", err)
+		}
+		// The builder's CMD (usually its run script) is reset such that it
+		// is not passed as arguments to the entrypoint.
+		newDockerFileStr = appendInstruction(newDockerFileStr, "ENTRYPOINT "+string(entrypoint))
+		newDockerFileStr = appendInstruction(newDockerFileStr, "CMD []")
+	}
+
+	// The healthcheck is of the final stage, that of the running function.
+	if b.healthcheck != nil {
+		healthcheck, err := b.healthcheck.instruction()
+		if err != nil {
+			return err
+		}
+		newDockerFileStr = appendInstruction(newDockerFileStr, healthcheck)
+	}
+
+	if b.target != "" && !slices.Contains(stageNames(newDockerFileStr), strings.ToLower(b.target)) {
+		b.logf(LogLevelWarn, "Warning: the Dockerfile has no stage %q; a target is only meaningful with a multi-stage Dockerfile", b.target)
+	}
+
+	// The syntax directive must precede any other line, comments included.
+	if b.dockerfileSyntax != "" {
+		newDockerFileStr = "# syntax=" + b.dockerfileSyntax + "\n" + newDockerFileStr
+	}
+
+	return os.WriteFile(path, []byte(newDockerFileStr), 0644)
+}
+
+// DefaultAssembleRunPattern matches the RUN instruction of the assemble step
+// of Dockerfiles generated by S2I.  Its first group is the command.
+const DefaultAssembleRunPattern = `RUN (.*assemble)`
+
+// mountCaches patches the assemble step of the Dockerfile to use a cache
+// mount, unless disabled, and the source and secrets requested by the
+// builder's options.
+func (b *Builder) mountCaches(dockerfile string, f fn.Function) string {
+	pattern := DefaultAssembleRunPattern
+	if b.assembleRunPattern != "" {
+		pattern = b.assembleRunPattern
+	}
+	re := regexp.MustCompile(pattern) // validated
+	if !re.MatchString(dockerfile) {
+		b.logf(LogLevelDebug, "No assemble step matching %q: the cache mount is not added", pattern)
+		return dockerfile
+	}
+	var mounts []string
+	if b.sourceMount {
+		var ok bool
+		if dockerfile, ok = unstageSource(dockerfile); ok {
+			mounts = append(mounts, sourceMount)
+		} else {
+			b.logf(LogLevelDebug, "No copy of the source in the Dockerfile: the source is not mounted")
+		}
+	}
+	if b.tmpfsSize != "" {
+		size, _ := units.RAMInBytes(b.tmpfsSize) // validated
+		mounts = append(mounts, "--mount=type=tmpfs,target=/tmp/artifacts/,size="+strconv.FormatInt(size, 10))
+	}
+	if b.cacheMount {
+		if b.tmpfsSize == "" {
+			root := f.Root
+			if b.cacheRoot != "" {
+				root = b.cacheRoot // see BuildFSCopy
+			}
+			mount := "--mount=type=cache,target=/tmp/artifacts/,uid=1001,id=" + cacheID(root)
+			if b.cacheSharing != "" {
+				mount += ",sharing=" + string(b.cacheSharing)
+			}
+			mounts = append(mounts, mount)
+		}
+	}
+	if b.goModuleCache != nil && f.Runtime == "go" {
+		mounts = append(mounts, b.goModuleCacheMount())
+	}
+	if b.goPrivate != "" && f.Runtime == "go" {
+		mounts = append(mounts, goNetrcMount)
+	}
+	if len(mounts) == 0 {
+		return dockerfile
+	}
+	replacement := fmt.Sprintf("RUN %s \\\n    $1", strings.Join(mounts, " \\\n    "))
+	return re.ReplaceAllString(dockerfile, replacement)
+}
+
+// goModuleCacheMount is the mount of the Go module cache shared by the builds
+// of all functions (see WithGoModuleCache).
+func (b *Builder) goModuleCacheMount() string {
+	mount := "--mount=type=cache,target=" + GoModuleCacheDir + ",uid=1001,id=func-go-mod"
+	if b.cacheSharing != "" {
+		mount += ",sharing=" + string(b.cacheSharing)
+	}
+	return mount
+}
+
+// writeDockerfile at path to w.
+func writeDockerfile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = io.Copy(w, f); err != nil {
+		return fmt.Errorf("cannot write Dockerfile: %w", err)
+	}
+	return nil
+}
+
+// unnamedFrom matches FROM instructions without a stage name.
+var unnamedFrom = regexp.MustCompile(`(?m)^FROM\s+\S+$`)
+
+// namedFrom matches FROM instructions with a stage name, the first group of
+// which is the name.
+var namedFrom = regexp.MustCompile(`(?im)^FROM\s+(?:--\S+\s+)*\S+\s+AS\s+(\S+)\s*$`)
+
+// stageNamePattern matches valid names of Dockerfile stages.
+var stageNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// stageNames of the Dockerfile, in lower case as they are case insensitive.
+func stageNames(dockerfile string) (names []string) {
+	for _, m := range namedFrom.FindAllStringSubmatch(dockerfile, -1) {
+		names = append(names, strings.ToLower(m[1]))
+	}
+	return
+}
+
+// hasInstruction returns true if the final stage of the Dockerfile contains
+// at least one instruction of the given kind (case insensitive).
+func hasInstruction(dockerfile, instruction string) bool {
+	lines := strings.Split(dockerfile, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if fields := strings.Fields(lines[i]); len(fields) > 0 && strings.EqualFold(fields[0], "FROM") {
+			lines = lines[i:]
+			break
+		}
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.EqualFold(fields[0], instruction) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendInstruction to the end of the Dockerfile (its final stage).
+func appendInstruction(dockerfile, instruction string) string {
+	if dockerfile != "" && !strings.HasSuffix(dockerfile, "\n") {
+		dockerfile += "\n"
+	}
+	return dockerfile + instruction + "\n"
+}
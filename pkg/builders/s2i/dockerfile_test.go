@@ -0,0 +1,625 @@
+package s2i_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// s2iDockerfile is representative of a Dockerfile generated by S2I
+const s2iDockerfile = `FROM example.com/builder
+LABEL "io.openshift.s2i.build.image"="example.com/builder"
+USER root
+COPY upload/src /tmp/src
+RUN chown -R 1001:0 /tmp/src
+USER 1001
+RUN /usr/libexec/s2i/assemble
+CMD /usr/libexec/s2i/run
+`
+
+// buildDockerfile runs a build of the function with the given builder options
+// where the S2I implementation generates the given Dockerfile.  Returned is
+// the final Dockerfile as it was sent to the daemon.
+func buildDockerfile(t *testing.T, f fn.Function, dockerfile string, options ...s2i.Option) (string, error) {
+	t.Helper()
+	return buildDockerfileWithClient(t, mockDocker{}, f, dockerfile, options...)
+}
+
+// buildDockerfileWithClient is buildDockerfile using the given mock client,
+// whose build is replaced.
+func buildDockerfileWithClient(t *testing.T, cli mockDocker, f fn.Function, dockerfile string, options ...s2i.Option) (string, error) {
+	t.Helper()
+	var result string
+	cli.build = func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+		tr := tar.NewReader(context)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return types.ImageBuildResponse{}, err
+			}
+			if hdr.Name == "Dockerfile" {
+				bb, err := io.ReadAll(tr)
+				if err != nil {
+					return types.ImageBuildResponse{}, err
+				}
+				result = string(bb)
+			}
+		}
+		return types.ImageBuildResponse{
+			Body:   io.NopCloser(strings.NewReader(`{"stream": "OK!"}`)),
+			OSType: "linux",
+		}, nil
+	}
+	impl := &mockImpl{
+		BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			return nil, os.WriteFile(cfg.AsDockerfile, []byte(dockerfile), 0644)
+		},
+	}
+	options = append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli)}, options...)
+	err := s2i.NewBuilder(options...).Build(context.Background(), f, nil)
+	return result, err
+}
+
+// TestDockerfile_CacheMount ensures that the assemble step of the generated
+// Dockerfile is patched to use a cache mount.
+func TestDockerfile_CacheMount(t *testing.T) {
+	dockerfile, err := buildDockerfile(t, fn.Function{Runtime: "node"}, s2iDockerfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, "RUN --mount=type=cache,target=/tmp/artifacts/") {
+		t.Fatalf("expected the assemble step to use a cache mount, got:\n%s", dockerfile)
+	}
+}
+
+// TestDockerfile_Syntax ensures that the syntax directive is the first line of
+// the Dockerfile, preceding the cache mount which may require it, and that
+// invalid frontends are rejected.
+func TestDockerfile_Syntax(t *testing.T) {
+	f := fn.Function{Runtime: "node"}
+	dockerfile, err := buildDockerfile(t, f, s2iDockerfile, s2i.WithDockerfileSyntax("docker/dockerfile:1.7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, _, _ := strings.Cut(dockerfile, "\n"); first != "# syntax=docker/dockerfile:1.7" {
+		t.Fatalf("expected the syntax directive as the first line, got:\n%s", dockerfile)
+	}
+	if !strings.Contains(dockerfile, "RUN --mount=type=cache") {
+		t.Fatalf("expected the assemble step to use a cache mount, got:\n%s", dockerfile)
+	}
+
+	if _, err = buildDockerfile(t, f, s2iDockerfile, s2i.WithDockerfileSyntax("docker/dockerfile:1.7\nRUN id")); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
+
+// TestDockerfile_AssembleRunPattern ensures that the cache mount is added to
+// the step matched by a custom assemble run pattern, that a pattern which
+// does not match leaves the Dockerfile as-is, and that invalid patterns are
+// rejected.
+func TestDockerfile_AssembleRunPattern(t *testing.T) {
+	const custom = `FROM example.com/builder
+COPY upload/src /tmp/src
+RUN /opt/custom/build.sh
+CMD /opt/custom/start.sh
+`
+	f := fn.Function{Runtime: "node"}
+
+	dockerfile, err := buildDockerfile(t, f, custom, s2i.WithAssembleRunPattern(`RUN (/opt/custom/build\.sh)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, "RUN --mount=type=cache,target=/tmp/artifacts/") ||
+		!strings.Contains(dockerfile, "\n    /opt/custom/build.sh\n") {
+		t.Fatalf("expected the custom assemble step to use a cache mount, got:\n%s", dockerfile)
+	}
+
+	// The default pattern does not match; the miss is noted when verbose.
+	out := captureStderr(t, func() {
+		if dockerfile, err = buildDockerfile(t, f, custom, s2i.WithVerbose(true)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if dockerfile != custom {
+		t.Fatalf("expected the Dockerfile to be unchanged, got:\n%s", dockerfile)
+	}
+	if !strings.Contains(out, "the cache mount is not added") {
+		t.Fatalf("expected a note of the missing assemble step, got:\n%s", out)
+	}
+
+	for _, pattern := range []string{`RUN (`, `RUN .*assemble`} {
+		if _, err = buildDockerfile(t, f, custom, s2i.WithAssembleRunPattern(pattern)); !errors.Is(err, s2i.ErrValidation) {
+			t.Fatalf("expected a validation error for pattern %q, got %v", pattern, err)
+		}
+	}
+}
+
+// TestDockerfile_CacheSharing ensures that the sharing mode of the cache
+// mount is reflected in the mount, and that invalid modes are rejected.
+func TestDockerfile_CacheSharing(t *testing.T) {
+	f := fn.Function{Runtime: "node"}
+
+	dockerfile, err := buildDockerfile(t, f, s2iDockerfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(dockerfile, "sharing=") {
+		t.Fatalf("expected the default sharing mode, got:\n%s", dockerfile)
+	}
+
+	dockerfile, err = buildDockerfile(t, f, s2iDockerfile, s2i.WithCacheSharing(s2i.CacheSharingLocked))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, ",sharing=locked ") {
+		t.Fatalf("expected the cache mount to be locked, got:\n%s", dockerfile)
+	}
+
+	if _, err = buildDockerfile(t, f, s2iDockerfile, s2i.WithCacheSharing("exclusive")); err == nil {
+		t.Fatal("expected an error for an invalid sharing mode")
+	}
+}
+
+// TestDockerfile_ArtifactsTmpfs ensures that the artifacts directory of the
+// assemble step is mounted as a tmpfs of the given size in place of the cache
+// mount, and that invalid sizes are rejected.
+func TestDockerfile_ArtifactsTmpfs(t *testing.T) {
+	f := fn.Function{Runtime: "node"}
+
+	dockerfile, err := buildDockerfile(t, f, s2iDockerfile, s2i.WithArtifactsTmpfs("2g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, "RUN --mount=type=tmpfs,target=/tmp/artifacts/,size=2147483648 \\\n") {
+		t.Fatalf("expected a tmpfs of 2g on the assemble step, got:\n%s", dockerfile)
+	}
+	if strings.Contains(dockerfile, "type=cache,target=/tmp/artifacts/") {
+		t.Fatalf("expected no cache mount of the artifacts, got:\n%s", dockerfile)
+	}
+
+	for _, size := range []string{"lots", "0", "-1g"} {
+		if _, err = buildDockerfile(t, f, s2iDockerfile, s2i.WithArtifactsTmpfs(size)); !errors.Is(err, s2i.ErrValidation) {
+			t.Fatalf("expected a validation error for tmpfs size %q, got %v", size, err)
+		}
+	}
+}
+
+// TestDockerfile_GoModuleCache ensures that the Go module cache is mounted
+// and configured for builds of Go functions only.
+func TestDockerfile_GoModuleCache(t *testing.T) {
+	root := t.TempDir()
+	impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+	if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var envs map[string]string
+	captureEnvs := s2i.WithS2IConfig(func(cfg *api.Config) {
+		envs = map[string]string{}
+		for _, e := range cfg.Environment {
+			envs[e.Name] = e.Value
+		}
+	})
+	mount := "--mount=type=cache,target=" + s2i.GoModuleCacheDir
+
+	dockerfile, err := buildDockerfile(t, fn.Function{Root: root, Runtime: "go"}, s2iDockerfile,
+		s2i.WithGoModuleCache("https://proxy.example.com", "-mod=mod"), captureEnvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, mount) {
+		t.Fatalf("expected the Go module cache to be mounted, got:\n%s", dockerfile)
+	}
+	if envs["GOMODCACHE"] != s2i.GoModuleCacheDir || envs["GOPROXY"] != "https://proxy.example.com" || envs["GOFLAGS"] != "-mod=mod" {
+		t.Fatalf("expected the go command to be configured to use the cache, got %v", envs)
+	}
+
+	// The Go module cache is independent of the cache of artifacts.
+	dockerfile, err = buildDockerfile(t, fn.Function{Root: root, Runtime: "go"}, s2iDockerfile,
+		s2i.WithGoModuleCache("", ""), s2i.WithCacheMount(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, mount) || strings.Contains(dockerfile, "target=/tmp/artifacts/") {
+		t.Fatalf("expected only the Go module cache to be mounted, got:\n%s", dockerfile)
+	}
+
+	dockerfile, err = buildDockerfile(t, fn.Function{Runtime: "node"}, s2iDockerfile,
+		s2i.WithGoModuleCache("https://proxy.example.com", ""), captureEnvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(dockerfile, mount) || envs["GOMODCACHE"] != "" {
+		t.Fatalf("expected no Go module cache for other runtimes, got:\n%s", dockerfile)
+	}
+}
+
+// TestDockerfile_RuntimeImage ensures that the runtime image is set in the
+// S2I config and that the final image is based on it, with the artifacts
+// copied from the builder.
+func TestDockerfile_RuntimeImage(t *testing.T) {
+	const runtimeImage = "example.com/runtime:latest"
+	f := fn.Function{Runtime: "node"}
+
+	var cfg api.Config
+	captureConfig := s2i.WithS2IConfig(func(c *api.Config) { cfg = *c })
+
+	dockerfile, err := buildDockerfile(t, f, s2iDockerfile,
+		s2i.WithRuntimeImage(runtimeImage, "/opt/app-root/src:app"), s2i.WithExposedPort(8080), captureConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RuntimeImage != runtimeImage || len(cfg.RuntimeArtifacts) != 1 ||
+		cfg.RuntimeArtifacts[0].Source != "/opt/app-root/src" || cfg.RuntimeArtifacts[0].Destination != "app" {
+		t.Fatalf("unexpected runtime image config %q %v", cfg.RuntimeImage, cfg.RuntimeArtifacts)
+	}
+	if !strings.HasPrefix(dockerfile, "FROM example.com/builder AS builder\n") {
+		t.Fatalf("expected the builder stage to be named, got:\n%s", dockerfile)
+	}
+	if !strings.Contains(dockerfile, "\nFROM "+runtimeImage+"\nCOPY --from=builder /opt/app-root/src app\nEXPOSE 8080\n") {
+		t.Fatalf("expected a final stage based on the runtime image, got:\n%s", dockerfile)
+	}
+
+	// Artifacts declared by the runtime image are used if none are given.
+	cli := mockDocker{
+		inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+			if image == runtimeImage {
+				return types.ImageInspect{Config: &container.Config{
+					Labels: map[string]string{"io.openshift.s2i.assemble-input-files": "/opt/app-root/gobinary:bin"},
+				}}, nil, nil
+			}
+			return types.ImageInspect{}, nil, nil
+		},
+	}
+	dockerfile, err = buildDockerfileWithClient(t, cli, f, s2iDockerfile, s2i.WithRuntimeImage(runtimeImage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, "COPY --from=builder /opt/app-root/gobinary bin\n") {
+		t.Fatalf("expected the artifacts declared by the runtime image to be copied, got:\n%s", dockerfile)
+	}
+
+	// Runtime images which declare no artifacts require them.
+	if _, err = buildDockerfile(t, f, s2iDockerfile, s2i.WithRuntimeImage(runtimeImage)); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
+
+// TestDockerfile_ExposedPortAndEntrypoint ensures that the exposed port and
+// entrypoint options are reflected in the final stage of the Dockerfile, are
+// not duplicated, and that the port is validated.
+func TestDockerfile_ExposedPortAndEntrypoint(t *testing.T) {
+	f := fn.Function{Runtime: "node"}
+
+	dockerfile, err := buildDockerfile(t, f, s2iDockerfile,
+		s2i.WithExposedPort(8080), s2i.WithEntrypoint([]string{"/opt/app-root/gobinary", "--verbose"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, "\nEXPOSE 8080\n") {
+		t.Fatalf("expected EXPOSE instruction, got:\n%s", dockerfile)
+	}
+	if !strings.Contains(dockerfile, "\nENTRYPOINT [\"/opt/app-root/gobinary\",\"--verbose\"]\n") {
+		t.Fatalf("expected ENTRYPOINT instruction, got:\n%s", dockerfile)
+	}
+
+	// A port already exposed by the generated Dockerfile is not duplicated.
+	dockerfile, err = buildDockerfile(t, f, s2iDockerfile+"EXPOSE 9000\n", s2i.WithExposedPort(8080))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(dockerfile, "EXPOSE 8080") {
+		t.Fatalf("expected the existing EXPOSE instruction to be preserved, got:\n%s", dockerfile)
+	}
+
+	// Ports out of range are rejected.
+	if _, err = buildDockerfile(t, f, s2iDockerfile, s2i.WithExposedPort(70000)); err == nil {
+		t.Fatal("expected an error for an invalid exposed port")
+	}
+}
+
+// TestDockerfile_Workdir ensures that the working directory is set in the
+// final stage after the assemble step and any copied artifacts, and that it
+// must be absolute.
+func TestDockerfile_Workdir(t *testing.T) {
+	f := fn.Function{Runtime: "node"}
+
+	dockerfile, err := buildDockerfile(t, f, s2iDockerfile, s2i.WithWorkdir("/opt/app-root/src/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	workdir := strings.Index(dockerfile, "\nWORKDIR /opt/app-root/src/app\n")
+	if workdir < 0 || workdir < strings.Index(dockerfile, "/usr/libexec/s2i/assemble") {
+		t.Fatalf("expected WORKDIR after the assemble step, got:\n%s", dockerfile)
+	}
+
+	dockerfile, err = buildDockerfile(t, f, s2iDockerfile,
+		s2i.WithWorkdir("/app"), s2i.WithRuntimeImage("example.com/runtime", "/opt/app-root/src:/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, "\nFROM example.com/runtime\nCOPY --from=builder /opt/app-root/src /app\nWORKDIR /app\n") {
+		t.Fatalf("expected WORKDIR in the final stage after the artifacts, got:\n%s", dockerfile)
+	}
+
+	if _, err = buildDockerfile(t, f, s2iDockerfile, s2i.WithWorkdir("app")); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error for a relative workdir, got %v", err)
+	}
+}
+
+// TestDockerfile_RuntimeUser ensures that the final USER instruction is that
+// of the runtime user, after the assemble step, and that invalid users are
+// rejected.
+func TestDockerfile_RuntimeUser(t *testing.T) {
+	f := fn.Function{Runtime: "node"}
+
+	dockerfile, err := buildDockerfile(t, f, s2iDockerfile, s2i.WithRuntimeUser("1001:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := strings.LastIndex(dockerfile, "\nUSER ")
+	if user < 0 || !strings.HasPrefix(dockerfile[user:], "\nUSER 1001:0\n") {
+		t.Fatalf("expected the final USER to be the runtime user, got:\n%s", dockerfile)
+	}
+	if user < strings.Index(dockerfile, "/usr/libexec/s2i/assemble") {
+		t.Fatalf("expected USER after the assemble step, got:\n%s", dockerfile)
+	}
+
+	for _, uid := range []string{"0", "0:0", "app", "1001:root", "-1"} {
+		if _, err = buildDockerfile(t, f, s2iDockerfile, s2i.WithRuntimeUser(uid)); !errors.Is(err, s2i.ErrValidation) {
+			t.Fatalf("expected a validation error for runtime user %q, got %v", uid, err)
+		}
+	}
+}
+
+// TestDockerfile_Target ensures that the target stage is passed to the build,
+// that a target which is not a stage of the Dockerfile is warned of, and that
+// invalid targets are rejected.
+func TestDockerfile_Target(t *testing.T) {
+	build := func(options ...s2i.Option) (target, stderr string, err error) {
+		t.Helper()
+		cli := mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			target = options.Target
+			_, _ = io.Copy(io.Discard, context)
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+		}}
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			return nil, os.WriteFile(cfg.AsDockerfile, []byte(s2iDockerfile), 0644)
+		}}
+		options = append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli)}, options...)
+		stderr = captureStderr(t, func() {
+			err = s2i.NewBuilder(options...).Build(context.Background(), fn.Function{Runtime: "node"}, nil)
+		})
+		return
+	}
+
+	target, stderr, err := build(s2i.WithTarget("builder"), s2i.WithRuntimeImage("example.com/runtime", "/opt/app-root/src:/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "builder" {
+		t.Fatalf("expected the target %q, got %q", "builder", target)
+	}
+	if strings.Contains(stderr, "Warning") {
+		t.Fatalf("expected no warning, got:\n%s", stderr)
+	}
+
+	if target, stderr, err = build(s2i.WithTarget("test")); err != nil {
+		t.Fatal(err)
+	}
+	if target != "test" || !strings.Contains(stderr, "has no stage \"test\"") {
+		t.Fatalf("expected the target with a warning of the single-stage Dockerfile, got %q and:\n%s", target, stderr)
+	}
+
+	if _, _, err = build(s2i.WithTarget("1st stage")); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
+
+// TestDockerfile_PullRegistryMirror ensures that the default builder images of
+// FROM instructions are rewritten to the registry mirror, retaining their
+// repository and tag or digest, and that other images are rewritten only if
+// opted in.
+func TestDockerfile_PullRegistryMirror(t *testing.T) {
+	const (
+		mirror = "mirror.example.com:5000"
+		digest = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+	)
+	f := fn.Function{Runtime: "node"}
+
+	tests := []struct {
+		name string
+		from string
+		all  bool
+		want string
+	}{
+		{name: "default", from: s2i.DefaultNodeBuilder, want: mirror + "/ubi8/nodejs-20-minimal"},
+		{name: "default tagged", from: s2i.DefaultNodeBuilder + ":1-60", want: mirror + "/ubi8/nodejs-20-minimal:1-60"},
+		{name: "default digest", from: s2i.DefaultNodeBuilder + "@" + digest, want: mirror + "/ubi8/nodejs-20-minimal@" + digest},
+		{name: "user", from: "example.com/builder:1@" + digest, want: "example.com/builder:1@" + digest},
+		{name: "user opted in", from: "example.com/builder:1@" + digest, all: true, want: mirror + "/builder@" + digest},
+		{name: "user docker hub opted in", from: "node:20", all: true, want: mirror + "/library/node:20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generated := strings.Replace(s2iDockerfile, "FROM example.com/builder", "FROM "+tt.from, 1)
+			dockerfile, err := buildDockerfile(t, f, generated,
+				s2i.WithPullRegistryMirror(mirror), s2i.WithPullRegistryMirrorAll(tt.all))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(dockerfile, "FROM "+tt.want+"\n") {
+				t.Fatalf("expected FROM %s, got:\n%s", tt.want, dockerfile)
+			}
+		})
+	}
+
+	// Stages are not images.
+	dockerfile, err := buildDockerfile(t, f, s2iDockerfile+"FROM builder AS test\n",
+		s2i.WithPullRegistryMirror(mirror), s2i.WithPullRegistryMirrorAll(true),
+		s2i.WithRuntimeImage("example.com/runtime", "/opt/app-root/src:/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, "\nFROM builder AS test\n") || !strings.Contains(dockerfile, "\nFROM "+mirror+"/runtime\n") {
+		t.Fatalf("expected only images to be rewritten, got:\n%s", dockerfile)
+	}
+
+	if _, err = buildDockerfile(t, f, s2iDockerfile, s2i.WithPullRegistryMirror("https://mirror.example.com")); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
+
+// TestDockerfile_Writer ensures that the final Dockerfile, as sent to the
+// daemon, is streamed to the Dockerfile writer.
+func TestDockerfile_Writer(t *testing.T) {
+	var buf bytes.Buffer
+	dockerfile, err := buildDockerfile(t, fn.Function{Runtime: "node"}, s2iDockerfile,
+		s2i.WithExposedPort(8080), s2i.WithDockerfileWriter(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "--mount=type=cache") || !strings.Contains(buf.String(), "EXPOSE 8080") {
+		t.Fatalf("expected the patched Dockerfile, got:\n%s", buf.String())
+	}
+	if buf.String() != dockerfile {
+		t.Fatalf("expected the Dockerfile sent to the daemon:\n%s\ngot:\n%s", dockerfile, buf.String())
+	}
+}
+
+// TestDockerfile_GoPrivate ensures that Go builds with private modules set
+// GOPRIVATE and mount the netrc secret for the assemble step only, such that
+// it is not part of the image.
+func TestDockerfile_GoPrivate(t *testing.T) {
+	root := t.TempDir()
+	impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+	if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var envs map[string]string
+	captureEnvs := s2i.WithS2IConfig(func(cfg *api.Config) {
+		envs = map[string]string{}
+		for _, e := range cfg.Environment {
+			envs[e.Name] = e.Value
+		}
+	})
+
+	dockerfile, err := buildDockerfile(t, fn.Function{Root: root, Runtime: "go"}, s2iDockerfile,
+		s2i.WithGoPrivate("git.corp.example.com/*"), s2i.WithBuildKitSession("session-id"), captureEnvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envs["GOPRIVATE"] != "git.corp.example.com/*" {
+		t.Fatalf("expected GOPRIVATE to be set, got %v", envs)
+	}
+	mount := "--mount=type=secret,id=" + s2i.GoNetrcSecret + ","
+	if !strings.Contains(dockerfile, mount) || !strings.Contains(dockerfile, "required=true") {
+		t.Fatalf("expected the netrc secret to be mounted, got:\n%s", dockerfile)
+	}
+	// The secret is referenced by the mount of the assemble step only.
+	for _, line := range strings.Split(dockerfile, "\n") {
+		if strings.Contains(line, ".netrc") && !strings.Contains(line, mount) {
+			t.Fatalf("expected the netrc to be referenced only by the secret mount, got %q", line)
+		}
+	}
+	for k, v := range envs {
+		if strings.Contains(v, "netrc") {
+			t.Fatalf("expected no build env to reference the netrc, got %s=%s", k, v)
+		}
+	}
+
+	dockerfile, err = buildDockerfile(t, fn.Function{Runtime: "node"}, s2iDockerfile,
+		s2i.WithGoPrivate("git.corp.example.com/*"), s2i.WithBuildKitSession("session-id"), captureEnvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(dockerfile, mount) || envs["GOPRIVATE"] != "" {
+		t.Fatalf("expected no private module settings for other runtimes, got:\n%s", dockerfile)
+	}
+
+	// The session providing the secret is attached to the build.
+	var session string
+	cli := mockDocker{
+		build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			session = options.SessionID
+			_, _ = io.Copy(io.Discard, context)
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+		},
+	}
+	b := s2i.NewBuilder(s2i.WithImpl(&mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}),
+		s2i.WithDockerClient(cli), s2i.WithGoPrivate("git.corp.example.com/*"), s2i.WithBuildKitSession("session-id"))
+	if err = b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if session != "session-id" {
+		t.Fatalf("expected the build to be attached to the session, got %q", session)
+	}
+
+	// Without a session, the secret could not be provided.
+	b = s2i.NewBuilder(s2i.WithImpl(&mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}),
+		s2i.WithDockerClient(cli), s2i.WithGoPrivate("git.corp.example.com/*"))
+	if err = b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error without a session, got %v", err)
+	}
+}
+
+// TestDockerfile_Healthcheck ensures that the healthcheck is the last
+// instruction, of the final stage, in exec form with the durations and
+// retries given, and that invalid healthchecks are rejected.
+func TestDockerfile_Healthcheck(t *testing.T) {
+	f := fn.Function{Runtime: "node"}
+	cmd := []string{"curl", "-f", "http://localhost:8080/health/readiness"}
+
+	dockerfile, err := buildDockerfile(t, f, s2iDockerfile,
+		s2i.WithRuntimeImage("example.com/runtime", "/opt/app-root/src:/app"),
+		s2i.WithHealthcheck(cmd, 10*time.Second, 1500*time.Millisecond, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `HEALTHCHECK --interval=10s --timeout=1.5s --retries=3 CMD ["curl","-f","http://localhost:8080/health/readiness"]`
+	if !strings.HasSuffix(dockerfile, "\n"+want+"\n") {
+		t.Fatalf("expected the final instruction %q, got:\n%s", want, dockerfile)
+	}
+	if strings.LastIndex(dockerfile, "\nFROM example.com/runtime") > strings.Index(dockerfile, "\nHEALTHCHECK") {
+		t.Fatalf("expected the healthcheck in the final stage, got:\n%s", dockerfile)
+	}
+
+	// Zero values leave the defaults of the daemon.
+	if dockerfile, err = buildDockerfile(t, f, s2iDockerfile, s2i.WithHealthcheck(cmd[:1], 0, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dockerfile, "\nHEALTHCHECK CMD [\"curl\"]\n") {
+		t.Fatalf("expected a healthcheck without flags, got:\n%s", dockerfile)
+	}
+
+	invalid := []s2i.Option{
+		s2i.WithHealthcheck(nil, 0, 0, 0),
+		s2i.WithHealthcheck([]string{""}, 0, 0, 0),
+		s2i.WithHealthcheck(cmd, time.Microsecond, 0, 0),
+		s2i.WithHealthcheck(cmd, 0, -time.Second, 0),
+		s2i.WithHealthcheck(cmd, 0, 0, -1),
+	}
+	for i, o := range invalid {
+		if _, err = buildDockerfile(t, f, s2iDockerfile, o); !errors.Is(err, s2i.ErrValidation) {
+			t.Fatalf("expected a validation error for healthcheck %d, got %v", i, err)
+		}
+	}
+}
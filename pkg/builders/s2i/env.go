@@ -0,0 +1,89 @@
+package s2i
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// Environment variables consulted for settings configured neither via
+// options nor via func.yaml.  Precedence is options, then func.yaml, then
+// the environment, then defaults.
+const (
+	// EnvBuilderImage is the builder image used for functions whose
+	// func.yaml defines none for this builder.
+	EnvBuilderImage = "FUNC_BUILDER_IMAGE"
+
+	// EnvBuildPlatforms is a comma-separated list of platforms
+	// (os/arch[/variant]) targeted by builds which request none.
+	EnvBuildPlatforms = "FUNC_BUILD_PLATFORMS"
+
+	// EnvBuildPullPolicy is the pull policy of the builder image ("always",
+	// "never" or "if-not-present") unless set via WithPullPolicy.
+	EnvBuildPullPolicy = "FUNC_BUILD_PULL_POLICY"
+)
+
+// builderImage returns the builder image of the function: that of its
+// func.yaml, that of EnvBuilderImage, or that resolved for its runtime and
+// the platform targeted ("" if none; see WithImageResolver).
+func (b *Builder) builderImage(f fn.Function, platform string) (string, error) {
+	if _, ok := f.Build.BuilderImages[b.name]; !ok {
+		if image := os.Getenv(EnvBuilderImage); image != "" {
+			return image, nil
+		}
+	}
+	r := b.imageResolver
+	if r == nil {
+		r = DefaultBuilderImageResolver{}
+	}
+	return resolveBuilderImage(f, b.name, platform, r)
+}
+
+// envPlatforms returns the platforms of EnvBuildPlatforms, if set.
+func envPlatforms() ([]fn.Platform, error) {
+	v := os.Getenv(EnvBuildPlatforms)
+	if v == "" {
+		return nil, nil
+	}
+	var pp []fn.Platform
+	for _, s := range strings.Split(v, ",") {
+		parts := strings.Split(strings.TrimSpace(s), "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid platform %q in %s: expected os/arch[/variant]", s, EnvBuildPlatforms)
+		}
+		p := fn.Platform{OS: parts[0], Architecture: parts[1]}
+		if len(parts) == 3 {
+			p.Variant = parts[2]
+		}
+		pp = append(pp, p)
+	}
+	return pp, nil
+}
+
+// pullPolicy returns the pull policy of the builder image: that of
+// WithPullPolicy, that of EnvBuildPullPolicy, or the default.
+func (b *Builder) pullPolicy() (api.PullPolicy, error) {
+	if b.builderPullPolicy != "" {
+		return b.builderPullPolicy, nil
+	}
+	v := os.Getenv(EnvBuildPullPolicy)
+	if v == "" {
+		return api.DefaultBuilderPullPolicy, nil
+	}
+	var p api.PullPolicy
+	if err := p.Set(v); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", EnvBuildPullPolicy, err)
+	}
+	return p, nil
+}
+
+// pullPolicySet returns whether the pull policy was chosen by WithPullPolicy
+// or EnvBuildPullPolicy.  Unless it was, the daemon pulls the images the
+// image is built with, such that they are kept up to date.
+func (b *Builder) pullPolicySet() bool {
+	return b.builderPullPolicy != "" || os.Getenv(EnvBuildPullPolicy) != ""
+}
@@ -0,0 +1,109 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders"
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildEnvOverrides ensures that the FUNC_* environment variables take
+// effect only when neither options nor func.yaml configure the setting.
+func TestBuildEnvOverrides(t *testing.T) {
+	const (
+		envImage  = "example.com/env/builder"
+		yamlImage = "example.com/yaml/builder"
+		pinned    = "example.com/pinned/builder"
+	)
+
+	build := func(t *testing.T, f fn.Function, platforms []fn.Platform, options ...s2i.Option) (cfg api.Config, err error) {
+		t.Helper()
+		i := &mockImpl{BuildFn: func(c *api.Config) (*api.Result, error) {
+			cfg = *c
+			return nil, nil
+		}}
+		options = append([]s2i.Option{s2i.WithName(builders.S2I), s2i.WithImpl(i), s2i.WithDockerClient(mockDocker{})}, options...)
+		err = s2i.NewBuilder(options...).Build(context.Background(), f, platforms)
+		return
+	}
+
+	t.Run("builder image", func(t *testing.T) {
+		t.Setenv(s2i.EnvBuilderImage, envImage)
+
+		cfg, err := build(t, fn.Function{Runtime: "node"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.BuilderImage != envImage {
+			t.Errorf("expected the builder image of the environment, got %q", cfg.BuilderImage)
+		}
+
+		f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: yamlImage}}}
+		if cfg, err = build(t, f, nil); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.BuilderImage != yamlImage {
+			t.Errorf("expected the builder image of func.yaml, got %q", cfg.BuilderImage)
+		}
+	})
+
+	t.Run("platforms", func(t *testing.T) {
+		t.Setenv(s2i.EnvBuildPlatforms, "windows/amd64")
+
+		_, err := build(t, fn.Function{Runtime: "node"}, nil)
+		if !errors.Is(err, s2i.ErrUnsupportedPlatform) {
+			t.Fatalf("expected the platform of the environment to be rejected, got %v", err)
+		}
+
+		f := fn.Function{Runtime: "node", Build: fn.BuildSpec{
+			PlatformBuilderImages: map[string]map[string]string{builders.S2I: {"linux/arm64": pinned}},
+		}}
+		cfg, err := build(t, f, []fn.Platform{{OS: "linux", Architecture: "arm64"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.BuilderImage != pinned {
+			t.Errorf("expected the requested platform to be built, got builder image %q", cfg.BuilderImage)
+		}
+
+		t.Setenv(s2i.EnvBuildPlatforms, "linux")
+		if _, err = build(t, fn.Function{Runtime: "node"}, nil); !errors.Is(err, s2i.ErrValidation) {
+			t.Fatalf("expected a validation error, got %v", err)
+		}
+	})
+
+	t.Run("pull policy", func(t *testing.T) {
+		cfg, err := build(t, fn.Function{Runtime: "node"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.BuilderPullPolicy != api.DefaultBuilderPullPolicy {
+			t.Errorf("expected the default pull policy, got %q", cfg.BuilderPullPolicy)
+		}
+
+		t.Setenv(s2i.EnvBuildPullPolicy, "always")
+		if cfg, err = build(t, fn.Function{Runtime: "node"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.BuilderPullPolicy != api.PullAlways {
+			t.Errorf("expected the pull policy of the environment, got %q", cfg.BuilderPullPolicy)
+		}
+
+		if cfg, err = build(t, fn.Function{Runtime: "node"}, nil, s2i.WithPullPolicy(api.PullNever)); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.BuilderPullPolicy != api.PullNever {
+			t.Errorf("expected the pull policy of the option, got %q", cfg.BuilderPullPolicy)
+		}
+
+		t.Setenv(s2i.EnvBuildPullPolicy, "sometimes")
+		if _, err = build(t, fn.Function{Runtime: "node"}, nil); !errors.Is(err, s2i.ErrValidation) {
+			t.Fatalf("expected a validation error, got %v", err)
+		}
+	})
+}
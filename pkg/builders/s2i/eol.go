@@ -0,0 +1,90 @@
+package s2i
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// eolRuntime is a runtime whose builder images declare its version in an env,
+// with the end-of-life dates of its versions.
+type eolRuntime struct {
+	name string
+	env  string            // of the builder image, declaring the version
+	eol  map[string]string // version to end-of-life date (YYYY-MM-DD)
+}
+
+// eolRuntimes lists the end-of-life dates of runtime versions, as declared by
+// the envs of the UBI builder images.  Versions which are not listed are
+// presumed current.
+var eolRuntimes = []eolRuntime{
+	{name: "Node.js", env: "NODEJS_VERSION", eol: map[string]string{
+		"10": "2021-04-30",
+		"12": "2022-04-30",
+		"14": "2023-04-30",
+		"16": "2023-09-11",
+		"18": "2025-04-30",
+		"20": "2026-04-30",
+	}},
+	{name: "Python", env: "PYTHON_VERSION", eol: map[string]string{
+		"3.6": "2021-12-23",
+		"3.7": "2023-06-27",
+		"3.8": "2024-10-07",
+		"3.9": "2025-10-31",
+	}},
+}
+
+// WithEOLWarning sets whether a warning is logged when the builder image
+// provides a runtime version past its end-of-life (default false), which
+// requires its config, and thus, for images not in the daemon, a request to
+// its registry.  The default builder images (DefaultBuilderImages) are not
+// checked, their versions being those supported by func.  The warning is
+// advisory: the function is built regardless.
+func WithEOLWarning(enabled bool) Option {
+	return func(b *Builder) {
+		b.eolWarning = enabled
+	}
+}
+
+// warnEOLRuntime logs a warning if the builder image provides a runtime
+// version past its end-of-life at the time of the build.  requested is the
+// builder image before its resolution for the platform of the build, image
+// that resolved.  Failing to inspect the builder image is noted at
+// LogLevelDebug only.
+func (b *Builder) warnEOLRuntime(ctx context.Context, client DockerClient, requested, image string, at time.Time) {
+	if !b.eolWarning || !b.logs(LogLevelWarn) || slices.Contains(slices.Collect(maps.Values(DefaultBuilderImages)), requested) {
+		return
+	}
+	c, err := b.imageConfigOf(ctx, client, image)
+	if err != nil {
+		b.logf(LogLevelDebug, "Cannot check the runtime version of builder image %q: %v", image, err)
+		return
+	}
+	for _, w := range eolWarnings(c.env, at) {
+		b.logf(LogLevelWarn, "Warning: builder image %q provides %s; consider a builder image of a supported version", image, w)
+	}
+}
+
+// eolWarnings returns a description of each runtime version declared by the
+// envs which is past its end-of-life at the given time.
+func eolWarnings(env []string, at time.Time) (warnings []string) {
+	for _, kv := range env {
+		k, version, _ := strings.Cut(kv, "=")
+		for _, r := range eolRuntimes {
+			if k != r.env {
+				continue
+			}
+			date, ok := r.eol[version]
+			if !ok {
+				continue
+			}
+			if eol, err := time.Parse(time.DateOnly, date); err == nil && at.After(eol) {
+				warnings = append(warnings, fmt.Sprintf("%s %s, which reached its end-of-life on %s", r.name, version, date))
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,20 @@
+package s2i
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestEOLWarnings ensures that runtime versions are past their end-of-life
+// after its date only, as of the time given.
+func TestEOLWarnings(t *testing.T) {
+	env := []string{"PATH=/usr/bin:/bin", "NODEJS_VERSION=20"}
+	if got := eolWarnings(env, time.Date(2026, 4, 30, 0, 0, 0, 0, time.UTC)); len(got) != 0 {
+		t.Fatalf("expected no warning on the end-of-life date, got %v", got)
+	}
+	want := []string{"Node.js 20, which reached its end-of-life on 2026-04-30"}
+	if got := eolWarnings(env, time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
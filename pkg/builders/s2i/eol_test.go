@@ -0,0 +1,51 @@
+package s2i_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders"
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildEOLWarning ensures that builder images of runtime versions past
+// their end-of-life are warned of, if enabled, without failing the build,
+// and that current versions and default builder images are not.
+func TestBuildEOLWarning(t *testing.T) {
+	custom := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: "example.com/node-builder"}}}
+	build := func(f fn.Function, env string, options ...s2i.Option) string {
+		t.Helper()
+		cli := mockDocker{inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+			return types.ImageInspect{Config: &container.Config{Env: []string{"PATH=/usr/bin:/bin", env}}}, nil, nil
+		}}
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		options = append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli)}, options...)
+		return captureStderr(t, func() {
+			if err := s2i.NewBuilder(options...).Build(context.Background(), f, nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	if stderr := build(custom, "NODEJS_VERSION=16", s2i.WithEOLWarning(true)); !strings.Contains(stderr, "Node.js 16, which reached its end-of-life on 2023-09-11") {
+		t.Fatalf("expected a warning of the EOL runtime, got:\n%s", stderr)
+	}
+	if stderr := build(custom, "PYTHON_VERSION=3.8", s2i.WithEOLWarning(true)); !strings.Contains(stderr, "Python 3.8") {
+		t.Fatalf("expected a warning of the EOL runtime, got:\n%s", stderr)
+	}
+	if stderr := build(custom, "NODEJS_VERSION=16"); strings.Contains(stderr, "end-of-life") {
+		t.Fatalf("expected no warning unless enabled, got:\n%s", stderr)
+	}
+	if stderr := build(custom, "NODEJS_VERSION=24", s2i.WithEOLWarning(true)); strings.Contains(stderr, "end-of-life") {
+		t.Fatalf("expected no warning of a current runtime, got:\n%s", stderr)
+	}
+	if stderr := build(fn.Function{Runtime: "node"}, "NODEJS_VERSION=16", s2i.WithEOLWarning(true)); strings.Contains(stderr, "end-of-life") {
+		t.Fatalf("expected no warning of a default builder image, got:\n%s", stderr)
+	}
+}
@@ -0,0 +1,205 @@
+package s2i
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/go-units"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Categories of build errors.  Errors returned by the builder wrap (at most)
+// one of these, such that callers can use errors.Is to handle them, while
+// their messages remain those of the underlying failure.  Failures of the
+// daemon, registries or the filesystem are not categorized.
+var (
+	// ErrUnsupportedPlatform indicates that the requested platforms can not
+	// be built.
+	ErrUnsupportedPlatform = errors.New("unsupported platform")
+	// ErrInvalidBuilderImage indicates that the builder image can not be
+	// used, for example because it does not exist, is not permitted or can
+	// not be verified.
+	ErrInvalidBuilderImage = errors.New("invalid builder image")
+	// ErrNoBuildImage indicates that no builder image could be determined
+	// for the function.
+	ErrNoBuildImage = errors.New("no builder image")
+	// ErrValidation indicates that the options of the builder, the function
+	// or the resulting S2I configuration are invalid.
+	ErrValidation = errors.New("validation failed")
+)
+
+// Failures to fetch an image which are due to the image not existing or to a
+// lack of authorization.  These are wrapped by errors of the builder image
+// category.
+var (
+	// ErrImageNotFound indicates that the image does not exist.
+	ErrImageNotFound = errors.New("image not found")
+	// ErrImageUnauthorized indicates that the registry denied access to the
+	// image.
+	ErrImageUnauthorized = errors.New("not authorized to access image")
+)
+
+// ErrScaffoldingNotSupported indicates that functions of a runtime are built
+// without scaffolding.
+var ErrScaffoldingNotSupported = errors.New("scaffolding is not supported for this runtime")
+
+// ErrDockerSocketPermission indicates that the user lacks permission to
+// access the socket of the docker daemon.
+var ErrDockerSocketPermission = errors.New("cannot access the docker daemon socket")
+
+// ErrCacheMountUnsupported indicates that BuildKit of the docker daemon
+// rejected the cache mounts of the assemble step (see WithCacheMount).
+var ErrCacheMountUnsupported = errors.New("cache mounts are not supported by the docker daemon")
+
+// ErrSmokeTest indicates that the built image failed its smoke test (see
+// WithSmokeTest).
+var ErrSmokeTest = errors.New("smoke test failed")
+
+// ErrImageTooLarge indicates that the built image exceeds the size budget.
+type ErrImageTooLarge struct {
+	Image string
+	Size  int64
+	Max   int64
+}
+
+func (e ErrImageTooLarge) Error() string {
+	return fmt.Sprintf("the image %q is %s (%d bytes), which exceeds the size budget of %s (%d bytes)",
+		e.Image, units.HumanSize(float64(e.Size)), e.Size, units.HumanSize(float64(e.Max)), e.Max)
+}
+
+// AssembleError indicates that the assemble script failed.  ExitCode is that
+// with which it exited, if known, and Output the last of the output of the
+// build, such that callers may decide whether the failure is worth retrying.
+type AssembleError struct {
+	ExitCode int
+	Output   string
+	Err      error
+}
+
+func (e AssembleError) Error() string {
+	return e.Err.Error()
+}
+
+func (e AssembleError) Unwrap() error {
+	return e.Err
+}
+
+// exitCodePattern matches the exit code in errors of failed RUN instructions
+// as reported by the classic builder and by BuildKit.
+var exitCodePattern = regexp.MustCompile(`(?:non-zero code|exit code): (\d+)`)
+
+// assembleError returns an AssembleError if err, reported by the build stream
+// of which stream is the last, is a failure of the assemble script.  Other
+// errors are returned as-is.
+func assembleError(err error, stream []byte) error {
+	var jerr *jsonmessage.JSONError
+	if !errors.As(err, &jerr) || !strings.Contains(jerr.Message, "assemble") {
+		return err
+	}
+	e := AssembleError{ExitCode: jerr.Code, Err: err}
+	if m := exitCodePattern.FindStringSubmatch(jerr.Message); e.ExitCode == 0 && m != nil {
+		e.ExitCode, _ = strconv.Atoi(m[1])
+	}
+
+	// The output is that of the stream messages, or of the BuildKit traces,
+	// the first of which may be incomplete due to the stream being truncated.
+	var output strings.Builder
+	for _, line := range bytes.Split(stream, []byte("\n")) {
+		var m jsonmessage.JSONMessage
+		if json.Unmarshal(line, &m) == nil {
+			output.WriteString(m.Stream)
+			output.Write(buildKitLogs(m))
+		}
+	}
+	e.Output = output.String()
+	return e
+}
+
+// cacheMountPattern matches the errors of BuildKit frontends and daemons
+// which do not support the cache mounts of the assemble step.
+var cacheMountPattern = regexp.MustCompile(`(?i)unknown flag: mount|--mount option requires BuildKit|cache mounts? (?:is |are )?(?:not supported|unsupported|disabled)`)
+
+// cacheMountError returns an ErrCacheMountUnsupported if err, reported by the
+// build stream, is due to the cache mounts of the assemble step.  Otherwise
+// nil is returned.
+func cacheMountError(err error) error {
+	var jerr *jsonmessage.JSONError
+	if !errors.As(err, &jerr) || !cacheMountPattern.MatchString(jerr.Message) {
+		return nil
+	}
+	return fmt.Errorf("%w; disable them with WithCacheMount(false): %w", ErrCacheMountUnsupported, err)
+}
+
+// kindError is an error of a category (kind) which retains the message of
+// the underlying error.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// wrap the error as being of the given kind.
+func wrap(kind, err error) error {
+	return kindError{kind: kind, err: err}
+}
+
+// scaffoldingError categorizes an error of writing scaffolding: failures of
+// the filesystem are not categorized, whereas others, such as of functions
+// lacking a supported signature, are validation errors.
+func scaffoldingError(err error) error {
+	var (
+		pathErr *fs.PathError
+		linkErr *os.LinkError
+	)
+	if errors.As(err, &pathErr) || errors.As(err, &linkErr) {
+		return err
+	}
+	return wrap(ErrValidation, err)
+}
+
+// builderImageError returns a friendly error if err, of fetching the builder
+// image from a registry or pulling it via the daemon, is due to the image not
+// existing or to a lack of authorization.  Otherwise nil is returned.
+func builderImageError(image string, err error) error {
+	var notFound, unauthorized bool
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		notFound = terr.StatusCode == http.StatusNotFound
+		unauthorized = terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden
+		for _, d := range terr.Errors {
+			switch d.Code {
+			case transport.ManifestUnknownErrorCode, transport.NameUnknownErrorCode:
+				notFound = true
+			case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+				unauthorized = true
+			}
+		}
+	} else {
+		notFound = errdefs.IsNotFound(err)
+		unauthorized = errdefs.IsUnauthorized(err) || errdefs.IsForbidden(err)
+	}
+	switch {
+	case notFound:
+		return wrap(ErrImageNotFound, fmt.Errorf("builder image %q not found — check the reference or your registry credentials", image))
+	case unauthorized:
+		return wrap(ErrImageUnauthorized, fmt.Errorf("not authorized to pull builder image %q — check your registry credentials", image))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package s2i
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// BuildFSCopy builds the function whose source is fsys rather than its root
+// on disk, such as a function synthesized in memory, returning a description
+// of the built image (see BuildWithResult).  As S2I reads the source from
+// disk, fsys is copied in full to a temporary directory, which is removed
+// after the build, so building from it takes the disk space, and time, of
+// the copy.  Scaffolding, exclusions and filters then apply as to any build.
+// The root of f, which need not exist, or else its name keys the cache mount
+// of the build (see PruneCache), such that builds of the function share it;
+// one of them is thus required.  fsys may contain regular files and
+// directories only.
+func (b *Builder) BuildFSCopy(ctx context.Context, fsys fs.FS, f fn.Function, platforms []fn.Platform) (BuildResult, error) {
+	cacheRoot, err := fsCacheRoot(f)
+	if err != nil {
+		return BuildResult{}, wrap(ErrValidation, err)
+	}
+	root, err := os.MkdirTemp("", "func-s2i-fs")
+	if err != nil {
+		return BuildResult{}, fmt.Errorf("cannot create temporary dir for the source: %w", err)
+	}
+	defer os.RemoveAll(root)
+	if err = os.CopyFS(root, fsys); err != nil {
+		return BuildResult{}, fmt.Errorf("cannot copy the source of the function: %w", err)
+	}
+
+	c := *b
+	c.cacheRoot = cacheRoot
+	f.Root = root
+	return c.BuildWithResult(ctx, f, platforms)
+}
+
+// fsCacheRoot returns the root keying the cache mount of builds of f from an
+// fs.FS: its root, or one of its name.
+func fsCacheRoot(f fn.Function) (string, error) {
+	switch {
+	case f.Root != "":
+		return f.Root, nil
+	case f.Name != "":
+		return "name:" + f.Name, nil
+	}
+	return "", errors.New("the cache of a function built from an fs.FS is keyed by its root or name, neither of which is set")
+}
@@ -0,0 +1,87 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildFSCopy ensures that functions are built, and scaffolded, from the
+// source of an fs.FS, that the temporary source is removed after the build,
+// and that the cache mount is keyed by the root, or else the name, of the
+// function.
+func TestBuildFSCopy(t *testing.T) {
+	fsys := fstest.MapFS{
+		"f.go":        {Data: []byte("package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n")},
+		"go.mod":      {Data: []byte("module function\n\ngo 1.23\n")},
+		"lib/util.go": {Data: []byte("package lib\n")},
+	}
+
+	var roots []string
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+		root := cfg.Source.URL.Path
+		roots = append(roots, root)
+		for _, p := range []string{"f.go", "lib/util.go", ".s2i/builds/last/main.go"} {
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(p))); err != nil {
+				t.Errorf("expected %s in the source: %v", p, err)
+			}
+		}
+		return nil, os.WriteFile(cfg.AsDockerfile, []byte(s2iDockerfile), 0644)
+	}}
+
+	var dockerfiles []string
+	build := func(f fn.Function) error {
+		var dockerfile stringWriter
+		b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithDockerfileWriter(&dockerfile))
+		if _, err := b.BuildFSCopy(context.Background(), fsys, f, nil); err != nil {
+			return err
+		}
+		dockerfiles = append(dockerfiles, string(dockerfile))
+		return nil
+	}
+	for _, f := range []fn.Function{
+		{Root: "in-memory/fn", Runtime: "go"},
+		{Root: "in-memory/fn", Runtime: "go"},
+		{Name: "fn", Runtime: "go"},
+		{Name: "fn", Runtime: "go"},
+	} {
+		if err := build(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, root := range roots {
+		if _, err := os.Stat(root); !os.IsNotExist(err) {
+			t.Errorf("expected the temporary source %s to be removed, got %v", root, err)
+		}
+	}
+	id := regexp.MustCompile(`id=([0-9a-f]+)`)
+	for i := 0; i < len(dockerfiles); i += 2 {
+		first, second := id.FindStringSubmatch(dockerfiles[i]), id.FindStringSubmatch(dockerfiles[i+1])
+		if first == nil || second == nil || first[1] != second[1] {
+			t.Fatalf("expected builds of the function to share the cache mount, got:\n%s\n%s", dockerfiles[i], dockerfiles[i+1])
+		}
+	}
+
+	// Without a root or a name, the cache would not be shared.
+	if err := build(fn.Function{Runtime: "go"}); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error without a root or a name, got %v", err)
+	}
+}
+
+// stringWriter accumulates what is written to it.
+type stringWriter string
+
+func (w *stringWriter) Write(p []byte) (int, error) {
+	*w += stringWriter(p)
+	return len(p), nil
+}
@@ -0,0 +1,137 @@
+package s2i
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// gitTree is the state of the git working tree containing a function.
+type gitTree struct {
+	head      string   // commit of HEAD
+	modified  []string // tracked files with uncommitted changes
+	untracked []string // untracked files which are not ignored
+}
+
+// openGitTree returns the state of the git working tree containing dir, or
+// nil if dir is not in a git repository.  Only files beneath dir, relative to
+// which paths are, are reported: changes elsewhere in the repository, such
+// as to other functions of a monorepo, are not those of the function.
+func openGitTree(dir string) (*gitTree, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot open the git repository of the function: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the git commit of the function: %w", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the git worktree of the function: %w", err)
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the git status of the function: %w", err)
+	}
+
+	t := &gitTree{head: head.Hash().String()}
+	for p, s := range status {
+		rel, err := filepath.Rel(dir, filepath.Join(wt.Filesystem.Root(), filepath.FromSlash(p)))
+		if err != nil {
+			return nil, err
+		}
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if s.Worktree == git.Untracked && s.Staging == git.Untracked {
+			t.untracked = append(t.untracked, rel)
+		} else {
+			t.modified = append(t.modified, rel)
+		}
+	}
+	slices.Sort(t.modified)
+	slices.Sort(t.untracked)
+	return t, nil
+}
+
+// WithRequireCleanTree fails builds of functions whose git working tree has
+// uncommitted changes to tracked files, such that images are built from
+// committed source only.  Untracked files count as changes per
+// WithCleanTreeUntracked.  Functions not in a git repository are built
+// unless WithCleanTreeRequireRepository is set.
+func WithRequireCleanTree(require bool) Option {
+	return func(b *Builder) {
+		b.requireCleanTree = require
+	}
+}
+
+// WithCleanTreeUntracked counts untracked files which are not ignored as
+// changes to the working tree (see WithRequireCleanTree).  Files written to
+// the function by builds, such as the scaffolding, are not counted.
+func WithCleanTreeUntracked(untracked bool) Option {
+	return func(b *Builder) {
+		b.cleanTreeUntracked = untracked
+	}
+}
+
+// WithCleanTreeRequireRepository fails builds of functions which are not in a
+// git repository when a clean tree is required (see WithRequireCleanTree).
+func WithCleanTreeRequireRepository(required bool) Option {
+	return func(b *Builder) {
+		b.cleanTreeRepository = required
+	}
+}
+
+// checkCleanTree returns an error if the working tree containing root has
+// uncommitted changes.
+func (b *Builder) checkCleanTree(root string) error {
+	tree, err := openGitTree(root)
+	if err != nil {
+		return err
+	}
+	if tree == nil {
+		if b.cleanTreeRepository {
+			return fmt.Errorf("a clean working tree is required but %s is not in a git repository", root)
+		}
+		b.logf(LogLevelDebug, "Not checking the working tree: %s is not in a git repository", root)
+		return nil
+	}
+	changes := tree.modified
+	if b.cleanTreeUntracked {
+		for _, p := range tree.untracked {
+			if !writtenByBuild(root, p) {
+				changes = append(changes, p)
+			}
+		}
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("working tree is dirty; commit or stash before building (changed: %s)", strings.Join(changes, ", "))
+	}
+	return nil
+}
+
+// writtenByBuild returns true if the file at p, relative to the function's
+// root, is written to the function by builds.
+func writtenByBuild(root, p string) bool {
+	p = filepath.ToSlash(p)
+	switch {
+	case strings.HasPrefix(p, ".s2i/builds/"), p == ".s2iignore":
+		return true
+	case p == ".s2i/bin/assemble":
+		bb, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(p)))
+		return err == nil && generatedAssembler(string(bb))
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// gitCommit initializes a git repository at root, commits its handle.js and
+// returns the commit.
+func gitCommit(t *testing.T, root string) string {
+	t.Helper()
+	repo, err := git.PlainInit(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wt.Add("handle.js"); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := wt.Commit("initial", &git.CommitOptions{Author: &object.Signature{Name: "alice", Email: "alice@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash.String()
+}
+
+// TestBuildRequireCleanTree ensures that builds of functions with uncommitted
+// changes fail when a clean tree is required, counting untracked files and
+// requiring a repository as configured.
+func TestBuildRequireCleanTree(t *testing.T) {
+	tests := []struct {
+		name    string
+		repo    bool              // the function is in a git repository
+		files   map[string]string // written after the commit
+		options []s2i.Option
+		wantErr bool
+	}{
+		{name: "clean", repo: true},
+		{name: "modified", repo: true, files: map[string]string{"handle.js": "// changed\n"}, wantErr: true},
+		{name: "untracked", repo: true, files: map[string]string{"new.js": ""}},
+		{
+			name:    "untracked counted",
+			repo:    true,
+			files:   map[string]string{"new.js": ""},
+			options: []s2i.Option{s2i.WithCleanTreeUntracked(true)},
+			wantErr: true,
+		},
+		{
+			name:    "written by builds",
+			repo:    true,
+			files:   map[string]string{".s2i/builds/last/main.go": "", ".s2i/bin/assemble": s2i.GoAssembler},
+			options: []s2i.Option{s2i.WithCleanTreeUntracked(true)},
+		},
+		{name: "not a repository"},
+		{
+			name:    "repository required",
+			options: []s2i.Option{s2i.WithCleanTreeRequireRepository(true)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.WriteFile(filepath.Join(root, "handle.js"), []byte("// handle\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.repo {
+				gitCommit(t, root)
+			}
+			for p, content := range tt.files {
+				p = filepath.Join(root, filepath.FromSlash(p))
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+			options := append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithRequireCleanTree(true)}, tt.options...)
+			err := s2i.NewBuilder(options...).Build(context.Background(), fn.Function{Root: root, Runtime: "node"}, nil)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, s2i.ErrValidation) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			if tt.repo && !strings.Contains(err.Error(), "working tree is dirty; commit or stash before building") {
+				t.Errorf("expected a dirty working tree error, got %v", err)
+			}
+		})
+	}
+}
+
+// TestBuildRequireCleanTreeSubdir ensures that only changes beneath the root
+// of a function count as changes to its working tree, and not those to other
+// files of its repository, such as of other functions of a monorepo.
+func TestBuildRequireCleanTreeSubdir(t *testing.T) {
+	top := t.TempDir()
+	root := filepath.Join(top, "fn")
+	for p, content := range map[string]string{"fn/handle.js": "// handle\n", "other/handle.js": "// other\n"} {
+		p = filepath.Join(top, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo, err := git.PlainInit(top, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wt.AddGlob("*/handle.js"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wt.Commit("initial", &git.CommitOptions{Author: &object.Signature{Name: "alice", Email: "alice@example.com", When: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithRequireCleanTree(true), s2i.WithCleanTreeUntracked(true))
+	for p, content := range map[string]string{"other/handle.js": "// changed\n", "untracked.js": ""} {
+		if err = os.WriteFile(filepath.Join(top, filepath.FromSlash(p)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = b.Build(context.Background(), fn.Function{Root: root, Runtime: "node"}, nil); err != nil {
+		t.Fatalf("expected changes outside of the function not to count, got %v", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(root, "handle.js"), []byte("// changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = b.Build(context.Background(), fn.Function{Root: root, Runtime: "node"}, nil)
+	if !errors.Is(err, s2i.ErrValidation) || !strings.Contains(err.Error(), "changed: handle.js") {
+		t.Fatalf("expected the change to the function to count, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package s2i
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// WithUseGitignore additionally excludes from the build context the source
+// files ignored by the .gitignore files of the function (of any directory,
+// nested ones taking precedence, negations included), those of its parent
+// directories within the git worktree containing it and the worktree's
+// .git/info/exclude, per the semantics of git.  The global excludes file of
+// the git config is not consulted.  As with git, files of an ignored
+// directory cannot be included by negation, and the .gitignore files of
+// ignored directories are not read.  The .s2i directory is never excluded.
+// Files which are tracked by git despite being ignored (such as those added
+// before being ignored, or with git add -f) are nonetheless excluded: the
+// patterns alone are consulted, not the index.
+func WithUseGitignore(enabled bool) Option {
+	return func(b *Builder) {
+		b.useGitignore = enabled
+	}
+}
+
+// withGitignore returns the builder with a filter of the source files ignored
+// by the .gitignore files of the function at root, if so configured.
+func (b *Builder) withGitignore(root string) (*Builder, error) {
+	if !b.useGitignore {
+		return b, nil
+	}
+	worktree, prefix, err := gitWorktree(root)
+	if err != nil {
+		return b, fmt.Errorf("cannot read .gitignore files: %w", err)
+	}
+	patterns, err := gitignorePatterns(osfs.New(worktree), prefix)
+	if err != nil {
+		return b, fmt.Errorf("cannot read .gitignore files: %w", err)
+	}
+	m := gitignore.NewMatcher(patterns)
+	c := *b
+	c.filters = append(slices.Clip(b.filters), func(p string, fi fs.FileInfo) bool {
+		rel, ok := strings.CutPrefix(p, uploadSrc+"/")
+		if !ok {
+			return true // not of the source
+		}
+		parts := strings.Split(rel, "/")
+		return parts[0] == ".s2i" || !m.Match(slices.Concat(prefix, parts), fi.IsDir())
+	})
+	return &c, nil
+}
+
+// gitWorktree returns the root of the git worktree containing the directory
+// root, and the path of root within it.  A root not within a worktree is
+// returned as its own.
+func gitWorktree(root string) (worktree string, prefix []string, err error) {
+	if root, err = filepath.Abs(root); err != nil {
+		return
+	}
+	for dir := root; ; dir = filepath.Dir(dir) {
+		if _, err = os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			rel, err := filepath.Rel(dir, root)
+			if err != nil || rel == "." {
+				return dir, nil, err
+			}
+			return dir, strings.Split(filepath.ToSlash(rel), "/"), nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", nil, err
+		}
+		if filepath.Dir(dir) == dir {
+			return root, nil, nil
+		}
+	}
+}
+
+// gitignorePatterns returns the patterns, in ascending order of precedence,
+// of the .git/info/exclude of the worktree, of the .gitignore files of the
+// directories of the worktree down to that at prefix, and of those of the
+// directories beneath it which are not ignored.
+func gitignorePatterns(worktree billy.Filesystem, prefix []string) ([]gitignore.Pattern, error) {
+	patterns, err := readGitignore(worktree, nil, ".git/info/exclude")
+	if err != nil {
+		return nil, err
+	}
+	for i := range prefix {
+		ps, err := readGitignore(worktree, prefix[:i], ".gitignore")
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, ps...)
+	}
+	return walkGitignore(worktree, prefix, patterns)
+}
+
+// walkGitignore appends to the patterns those of the .gitignore files of the
+// directory at path and of the directories beneath it, not descending into
+// those which are ignored.
+func walkGitignore(worktree billy.Filesystem, path []string, patterns []gitignore.Pattern) ([]gitignore.Pattern, error) {
+	ps, err := readGitignore(worktree, path, ".gitignore")
+	if err != nil {
+		return nil, err
+	}
+	patterns = append(patterns, ps...)
+	fis, err := worktree.ReadDir(worktree.Join(path...))
+	if err != nil {
+		return nil, err
+	}
+	m := gitignore.NewMatcher(patterns)
+	for _, fi := range fis {
+		dir := append(slices.Clip(path), fi.Name())
+		if !fi.IsDir() || fi.Name() == ".git" || m.Match(dir, true) {
+			continue
+		}
+		if patterns, err = walkGitignore(worktree, dir, patterns); err != nil {
+			return nil, err
+		}
+	}
+	return patterns, nil
+}
+
+// readGitignore returns the patterns of the ignore file of the directory at
+// path, if any, whose domain is that directory.
+func readGitignore(worktree billy.Filesystem, path []string, file string) ([]gitignore.Pattern, error) {
+	f, err := worktree.Open(worktree.Join(append(slices.Clip(path), file)...))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []gitignore.Pattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "#") && strings.TrimSpace(line) != "" {
+			patterns = append(patterns, gitignore.ParsePattern(line, path))
+		}
+	}
+	return patterns, scanner.Err()
+}
@@ -0,0 +1,110 @@
+package s2i_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestUseGitignore ensures that the source ignored by .gitignore files is
+// excluded from the build context when so configured, nested .gitignore
+// files and negations applying as with git, and the .s2i directory never
+// being excluded.
+func TestUseGitignore(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":         "*.log\n!keep.log\nbuild/\n.s2i/\n",
+		"handle.js":          "",
+		"app.log":            "",
+		"keep.log":           "",
+		"secret.txt":         "",
+		"build/out.js":       "",
+		"src/.gitignore":     "secret.txt\n!debug.log\n",
+		"src/index.js":       "",
+		"src/secret.txt":     "",
+		"src/debug.log":      "",
+		"src/trace.log":      "",
+		".s2i/bin/assemble":  "",
+		".s2i/environment":   "",
+		"vendor/.gitignore":  "*\n!.gitignore\n",
+		"vendor/lib/lib.js":  "",
+		"vendor/lib/keep.js": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := fn.Function{Root: root, Runtime: "node"}
+
+	all, err := s2i.NewBuilder().ContextFiles(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(all, "app.log") || !slices.Contains(all, "build/out.js") {
+		t.Fatalf("expected .gitignore not to be applied by default, got %v", all)
+	}
+
+	got, err := s2i.NewBuilder(s2i.WithUseGitignore(true)).ContextFiles(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{ // .gitignore files being excluded by default
+		".s2i", ".s2i/bin", ".s2i/bin/assemble", ".s2i/environment",
+		"handle.js",
+		"keep.log",
+		"secret.txt",
+		"src", "src/debug.log", "src/index.js",
+		"vendor",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected context files\n%v\ngot\n%v", want, got)
+	}
+}
+
+// TestUseGitignoreWorktree ensures that the .gitignore files of the parent
+// directories of a function within its git worktree, and the worktree's
+// .git/info/exclude, apply to it, and that ignored directories are not read.
+func TestUseGitignoreWorktree(t *testing.T) {
+	worktree := t.TempDir()
+	files := map[string]string{
+		".git/info/exclude":        "local.txt\n",
+		".gitignore":               "*.tmp\n",
+		"fns/.gitignore":           "dist/\nnode_modules/\n",
+		"fns/fn/handle.js":         "",
+		"fns/fn/local.txt":         "",
+		"fns/fn/scratch.tmp":       "",
+		"fns/fn/dist/out.js":       "",
+		"fns/fn/node_modules/a.js": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(worktree, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A directory ignored by a parent is not read: were it, its unreadable
+	// .gitignore would fail the build.
+	if err := os.Mkdir(filepath.Join(worktree, "fns", "fn", "node_modules", ".gitignore"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s2i.NewBuilder(s2i.WithUseGitignore(true)).ContextFiles(fn.Function{Root: filepath.Join(worktree, "fns", "fn"), Runtime: "node"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"handle.js"}; !slices.Equal(got, want) {
+		t.Fatalf("expected context files\n%v\ngot\n%v", want, got)
+	}
+}
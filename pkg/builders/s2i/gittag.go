@@ -0,0 +1,90 @@
+package s2i
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// gitShaLen is the length of the abbreviated commit of git sha tags.
+const gitShaLen = 7
+
+// WithGitShaTag additionally tags the image with the abbreviated commit of
+// the git repository of the function, such as example.com/alice/fn:1a2b3c4,
+// suffixed with -dirty if tracked files have uncommitted changes.  Functions
+// not in a git repository are tagged as configured only.  Builds skipped as
+// up to date (see WithSkipIfUnchanged) tag the image of the last build.
+func WithGitShaTag(enabled bool) Option {
+	return func(b *Builder) {
+		b.tagGitSha = enabled
+	}
+}
+
+// gitShaTag returns the git sha tag of the image for the repository
+// containing root, or "" if root is not in a git repository.
+func (b *Builder) gitShaTag(image, root string) (string, error) {
+	tree, err := openGitTree(root)
+	if err != nil {
+		return "", err
+	}
+	if tree == nil {
+		b.logf(LogLevelDebug, "Not tagging the image with the git commit: %s is not in a git repository", root)
+		return "", nil
+	}
+
+	// Only changes to tracked files make the tree dirty, as with
+	// `git describe --dirty`, such that untracked files written by builds
+	// (such as the scaffolding) do not.
+	sha := tree.head[:gitShaLen]
+	if len(tree.modified) > 0 {
+		sha += "-dirty"
+	}
+
+	tag, err := name.NewTag(image)
+	if err != nil {
+		return "", fmt.Errorf("cannot tag image %q with the git commit: %w", image, err)
+	}
+	return tag.Context().Tag(sha).String(), nil
+}
+
+// tagUpToDate tags the image of the last build, which is up to date, with the
+// git sha tag for the repository containing root: in the daemon if it is
+// loaded, and in its registry if it is pushed or not loaded.
+func (b *Builder) tagUpToDate(ctx context.Context, client DockerClient, image, root string) error {
+	tag, err := b.gitShaTag(image, root)
+	if err != nil || tag == "" {
+		return err
+	}
+	if b.load {
+		c, ok := client.(interface {
+			ImageTag(ctx context.Context, source, target string) error
+		})
+		if !ok {
+			return errors.New("the docker client cannot tag images")
+		}
+		if err = c.ImageTag(ctx, image, tag); err != nil {
+			return fmt.Errorf("cannot tag image %q with the git commit: %w", image, err)
+		}
+	}
+	if b.push || !b.load {
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			return fmt.Errorf("cannot parse image name: %w", err)
+		}
+		desc, err := remote.Get(ref, b.remoteOptions(ctx)...)
+		if err != nil {
+			return fmt.Errorf("cannot get the image of the last build from its registry: %w", err)
+		}
+		t, err := name.NewTag(tag)
+		if err != nil {
+			return err
+		}
+		if err = remote.Tag(t, desc, b.remoteOptions(ctx)...); err != nil {
+			return fmt.Errorf("cannot tag image %q with the git commit: %w", image, err)
+		}
+	}
+	return nil
+}
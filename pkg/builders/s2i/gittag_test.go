@@ -0,0 +1,145 @@
+package s2i_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildGitShaTag ensures that the image is additionally tagged with the
+// commit of the function's git repository, marked dirty if tracked files are
+// modified, and only as configured outside of a git repository.
+func TestBuildGitShaTag(t *testing.T) {
+	const image = "example.com/alice/fn:latest"
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, root string) (sha string) // "" if untagged
+	}{
+		{
+			name: "clean",
+			setup: func(t *testing.T, root string) string {
+				sha := gitCommit(t, root)[:7]
+				// Untracked files, such as those written by builds, are not
+				// changes.
+				if err := os.WriteFile(filepath.Join(root, "untracked.txt"), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return sha
+			},
+		},
+		{
+			name: "dirty",
+			setup: func(t *testing.T, root string) string {
+				sha := gitCommit(t, root)[:7]
+				if err := os.WriteFile(filepath.Join(root, "handle.js"), []byte("// changed\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return sha + "-dirty"
+			},
+		},
+		{
+			name:  "not a repository",
+			setup: func(t *testing.T, root string) string { return "" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.WriteFile(filepath.Join(root, "handle.js"), []byte("// handle\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sha := tt.setup(t, root)
+
+			var tags []string
+			cli := mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+				tags = options.Tags
+				_, _ = io.Copy(io.Discard, context)
+				return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+			}}
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithGitShaTag(true))
+			f := fn.Function{Root: root, Runtime: "node", Build: fn.BuildSpec{Image: image}}
+			if err := b.Build(context.Background(), f, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []string{image}
+			if sha != "" {
+				want = append(want, "example.com/alice/fn:"+sha)
+			}
+			if strings.Join(tags, ",") != strings.Join(want, ",") {
+				t.Fatalf("expected tags %v, got %v", want, tags)
+			}
+		})
+	}
+}
+
+// TestBuildGitShaTagUpToDate ensures that a build skipped as up to date tags
+// the image of the last build with a commit which changed no built file.
+func TestBuildGitShaTagUpToDate(t *testing.T) {
+	const image = "example.com/alice/fn:latest"
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "handle.js"), []byte("// handle\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitCommit(t, root)
+
+	var builds int
+	tags := map[string]string{}
+	cli := mockDocker{
+		build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			builds++
+			_, _ = io.Copy(io.Discard, context)
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+		},
+		tag: func(ctx context.Context, source, target string) error {
+			tags[target] = source
+			return nil
+		},
+	}
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithGitShaTag(true),
+		s2i.WithSkipIfUnchanged(filepath.Join(t.TempDir(), "state.json")))
+	f := fn.Function{Root: root, Runtime: "node", Build: fn.BuildSpec{Image: image}}
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := git.PlainOpen(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := wt.Commit("empty", &git.CommitOptions{AllowEmptyCommits: true,
+		Author: &object.Signature{Name: "alice", Email: "alice@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := b.BuildWithResult(context.Background(), f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.UpToDate || builds != 1 {
+		t.Fatalf("expected the build to be skipped, got %d builds (%+v)", builds, result)
+	}
+	if tag := "example.com/alice/fn:" + hash.String()[:7]; tags[tag] != image {
+		t.Fatalf("expected the image to be tagged %s, got %v", tag, tags)
+	}
+}
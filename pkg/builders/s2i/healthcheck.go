@@ -0,0 +1,68 @@
+package s2i
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// healthcheck of the resulting image.
+type healthcheck struct {
+	cmd      []string
+	interval time.Duration
+	timeout  time.Duration
+	retries  int
+}
+
+// WithHealthcheck sets the healthcheck of the resulting image, which the
+// container runtime, or an orchestrator honoring it, runs to determine the
+// health of the function.  cmd is run in exec form, such as
+// []string{"curl", "-f", "http://localhost:8080/health/readiness"}, and must
+// be present in the resulting image.  A zero interval, timeout or retries
+// leaves that of the daemon (30s, 30s and 3).  Images have no healthcheck by
+// default.
+func WithHealthcheck(cmd []string, interval, timeout time.Duration, retries int) Option {
+	return func(b *Builder) {
+		b.healthcheck = &healthcheck{cmd: cmd, interval: interval, timeout: timeout, retries: retries}
+	}
+}
+
+// validate the healthcheck.  Durations which are not zero must be at least a
+// millisecond, as with docker.
+func (h *healthcheck) validate() error {
+	if len(h.cmd) == 0 || strings.TrimSpace(h.cmd[0]) == "" {
+		return errors.New("invalid healthcheck: a command is required")
+	}
+	if h.interval != 0 && h.interval < time.Millisecond {
+		return fmt.Errorf("invalid healthcheck interval %s: must be at least 1ms", h.interval)
+	}
+	if h.timeout != 0 && h.timeout < time.Millisecond {
+		return fmt.Errorf("invalid healthcheck timeout %s: must be at least 1ms", h.timeout)
+	}
+	if h.retries < 0 {
+		return fmt.Errorf("invalid healthcheck retries %d: must not be negative", h.retries)
+	}
+	return nil
+}
+
+// instruction returns the HEALTHCHECK instruction of the healthcheck.
+func (h *healthcheck) instruction() (string, error) {
+	cmd, err := json.Marshal(h.cmd)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode healthcheck command: %w", err)
+	}
+	instruction := "HEALTHCHECK"
+	if h.interval != 0 {
+		instruction += " --interval=" + h.interval.String()
+	}
+	if h.timeout != 0 {
+		instruction += " --timeout=" + h.timeout.String()
+	}
+	if h.retries != 0 {
+		instruction += " --retries=" + strconv.Itoa(h.retries)
+	}
+	return instruction + " CMD " + string(cmd), nil
+}
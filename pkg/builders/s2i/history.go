@@ -0,0 +1,206 @@
+package s2i
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	dockerClient "github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// maxDurations is the number of durations of builds retained per function,
+// platform and builder image.
+const maxDurations = 20
+
+// WithDurationHistory records the duration of each successful build of a
+// function at path, keyed by the function, the platform targeted and the
+// digest of the builder image, such that subsequent builds can be estimated
+// (see EstimateDuration).  The most recent durations are retained.  Failing
+// to record a duration is warned of; the build succeeds regardless.
+func WithDurationHistory(path string) Option {
+	return func(b *Builder) {
+		b.historyPath = path
+	}
+}
+
+// durationHistory is the record of the durations of builds, most recent
+// last, by durationKey.
+type durationHistory map[string][]time.Duration
+
+// readDurationHistory from path.  A missing file yields an empty history.
+func readDurationHistory(path string) (durationHistory, error) {
+	h := durationHistory{}
+	bb, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	} else if err != nil {
+		return h, err
+	}
+	err = json.Unmarshal(bb, &h)
+	return h, err
+}
+
+// writeDurationHistory to path, creating its directory if necessary.
+func writeDurationHistory(path string, h durationHistory) error {
+	bb, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, bb, 0644)
+}
+
+// lockDurationHistory acquires an exclusive lock of the history at path,
+// such that concurrent builds, in this or other processes, record their
+// durations without losing those of one another.  Returned is a function
+// releasing it.
+func lockDurationHistory(ctx context.Context, path string) (release func(), err error) {
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if release, err = lockFile(ctx, path+".lock"); err != nil {
+		return nil, fmt.Errorf("cannot lock the duration history: %w", err)
+	}
+	return release, nil
+}
+
+// durationKey returns the key of the durations of builds of the function for
+// the platform with the builder image, as named before it is resolved for
+// the platform.
+func (b *Builder) durationKey(ctx context.Context, client DockerClient, f fn.Function, platform, builderImage string) (string, error) {
+	id, err := b.builderImageID(ctx, client, builderImage, platform)
+	if err != nil {
+		return "", fmt.Errorf("cannot get the id of the builder image: %w", err)
+	}
+	root := f.Root
+	if b.cacheRoot != "" {
+		root = b.cacheRoot // see BuildFSCopy
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return "", err
+	}
+	return root + "|" + platform + "|" + id, nil
+}
+
+// builderImageID returns the id of the image, the digest of its config, from
+// the daemon or, if it is not present there, from its registry for the
+// platform, such that the key of its durations is the same once it is
+// pulled.
+func (b *Builder) builderImageID(ctx context.Context, client DockerClient, image, platform string) (string, error) {
+	img, _, err := client.ImageInspectWithRaw(ctx, image)
+	if err == nil {
+		return img.ID, nil
+	} else if !dockerClient.IsErrNotFound(err) {
+		return "", err
+	}
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse image name: %w", err)
+	}
+	opts := b.remoteOptions(ctx)
+	if platform != "" {
+		p, err := v1.ParsePlatform(platform)
+		if err != nil {
+			return "", err
+		}
+		opts = append(opts, remote.WithPlatform(*p))
+	}
+	remoteImg, err := remote.Image(ref, opts...)
+	if err != nil {
+		return "", err
+	}
+	id, err := remoteImg.ConfigName()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// recordDuration of a build of the function in the history.
+func (b *Builder) recordDuration(ctx context.Context, client DockerClient, f fn.Function, platform, builderImage string, d time.Duration) error {
+	key, err := b.durationKey(ctx, client, f, platform, builderImage)
+	if err != nil {
+		return err
+	}
+	release, err := lockDurationHistory(ctx, b.historyPath)
+	if err != nil {
+		return err
+	}
+	defer release()
+	h, err := readDurationHistory(b.historyPath)
+	if err != nil {
+		return fmt.Errorf("cannot read the duration history: %w", err)
+	}
+	h[key] = append(h[key], d)
+	if n := len(h[key]); n > maxDurations {
+		h[key] = h[key][n-maxDurations:]
+	}
+	return writeDurationHistory(b.historyPath, h)
+}
+
+// EstimateDuration returns the estimated duration of a build of the
+// function: the median of the durations recorded of builds of the function
+// for the same platform and builder image (see WithDurationHistory).  False
+// is returned if there are none, or they cannot be determined, such as when
+// the context is canceled looking up the builder image.
+func (b *Builder) EstimateDuration(ctx context.Context, f fn.Function) (time.Duration, bool) {
+	if b.historyPath == "" {
+		return 0, false
+	}
+	var err error
+	if b.profile != "" {
+		if f.Build, err = f.Build.WithProfile(b.profile); err != nil {
+			return 0, false
+		}
+	}
+	platforms, err := b.targetPlatforms(ctx, nil)
+	if err != nil {
+		return 0, false
+	}
+	platform := targetPlatform(platforms)
+	builderImage, err := b.builderImage(f, platform)
+	if err != nil {
+		return 0, false
+	}
+	client, done, err := b.dockerClient()
+	if err != nil {
+		return 0, false
+	}
+	defer done()
+	key, err := b.durationKey(ctx, client, f, platform, builderImage)
+	if err != nil {
+		return 0, false
+	}
+	release, err := lockDurationHistory(ctx, b.historyPath)
+	if err != nil {
+		return 0, false
+	}
+	defer release()
+	h, err := readDurationHistory(b.historyPath)
+	if err != nil || len(h[key]) == 0 {
+		return 0, false
+	}
+	return median(h[key]), true
+}
+
+// median of the durations, of which there is at least one.
+func median(durations []time.Duration) time.Duration {
+	d := slices.Sorted(slices.Values(durations))
+	if n := len(d); n%2 == 0 {
+		return (d[n/2-1] + d[n/2]) / 2
+	}
+	return d[len(d)/2]
+}
@@ -0,0 +1,181 @@
+package s2i
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestEstimateDuration ensures that the estimate of a build is the median of
+// the durations recorded for the function and builder image, and that builds
+// record their durations.
+func TestEstimateDuration(t *testing.T) {
+	t.Setenv(EnvBuildPlatforms, "")
+	var (
+		ctx    = context.Background()
+		path   = filepath.Join(t.TempDir(), "durations.json")
+		f      = fn.Function{Root: t.TempDir(), Runtime: "node"}
+		client = digestDocker{id: "sha256:1"}
+		b      = NewBuilder(WithImpl(stubImpl{}), WithDockerClient(client), WithDurationHistory(path))
+	)
+	builderImage, err := b.builderImage(f, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := b.EstimateDuration(ctx, f); ok {
+		t.Fatal("expected no estimate without history")
+	}
+	for _, s := range []int{3, 1, 10, 2, 5} {
+		if err = b.recordDuration(ctx, client, f, "", builderImage, time.Duration(s)*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d, ok := b.EstimateDuration(ctx, f); !ok || d != 3*time.Second {
+		t.Fatalf("expected an estimate of 3s, got %v (%v)", d, ok)
+	}
+	if err = b.recordDuration(ctx, client, f, "", builderImage, 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := b.EstimateDuration(ctx, f); !ok || d != 3500*time.Millisecond {
+		t.Fatalf("expected an estimate of 3.5s, got %v (%v)", d, ok)
+	}
+
+	// The durations of other builder images are not of this one.
+	other := NewBuilder(WithImpl(stubImpl{}), WithDockerClient(digestDocker{id: "sha256:2"}), WithDurationHistory(path))
+	if _, ok := other.EstimateDuration(ctx, f); ok {
+		t.Fatal("expected no estimate for another builder image")
+	}
+
+	// Builds record their durations, of which the most recent are retained.
+	for i := 0; i <= maxDurations; i++ {
+		if err = other.Build(ctx, f, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d, ok := other.EstimateDuration(ctx, f); !ok || d <= 0 || d >= time.Second {
+		t.Fatalf("expected an estimate of the builds, got %v (%v)", d, ok)
+	}
+	h, err := readDurationHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, durations := range h {
+		if len(durations) > maxDurations {
+			t.Fatalf("expected at most %d durations of %s, got %d", maxDurations, key, len(durations))
+		}
+	}
+}
+
+// TestRecordDurationConcurrent ensures that the durations of concurrent
+// builds are all recorded.
+func TestRecordDurationConcurrent(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		path   = filepath.Join(t.TempDir(), "durations.json")
+		f      = fn.Function{Root: t.TempDir(), Runtime: "node"}
+		client = digestDocker{id: "sha256:1"}
+		b      = NewBuilder(WithImpl(stubImpl{}), WithDockerClient(client), WithDurationHistory(path))
+		wg     sync.WaitGroup
+	)
+	errs := make(chan error, maxDurations)
+	for i := 0; i < maxDurations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- b.recordDuration(ctx, client, f, "", "builder", time.Second)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := readDurationHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, durations := range h {
+		if len(durations) != maxDurations {
+			t.Fatalf("expected %d durations of %s, got %d", maxDurations, key, len(durations))
+		}
+	}
+}
+
+// TestDurationKeyPulled ensures that the durations of a builder image are
+// keyed alike whether it is in the daemon or only in its registry, such that
+// they are estimated once it is pulled.
+func TestDurationKeyPulled(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	image := strings.TrimPrefix(srv.URL, "http://") + "/default/builder:latest"
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	id, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ctx = context.Background()
+		f   = fn.Function{Root: t.TempDir(), Runtime: "node"}
+		b   = NewBuilder(WithImpl(stubImpl{}))
+	)
+	remoteKey, err := b.durationKey(ctx, absentDocker{}, f, "", image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localKey, err := b.durationKey(ctx, digestDocker{id: id.String()}, f, "", image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remoteKey != localKey {
+		t.Fatalf("expected the keys of the image in its registry and in the daemon to be equal, got %q and %q", remoteKey, localKey)
+	}
+}
+
+// absentDocker is a stubDocker without images.
+type absentDocker struct {
+	stubDocker
+}
+
+func (absentDocker) ImageInspectWithRaw(_ context.Context, image string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("no such image: %s", image))
+}
+
+// digestDocker is a stubDocker whose images are of the given id.
+type digestDocker struct {
+	stubDocker
+	id string
+}
+
+func (c digestDocker) ImageInspectWithRaw(context.Context, string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{ID: c.id}, nil, nil
+}
@@ -0,0 +1,50 @@
+package s2i
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// WithHostEnvAllowlist permits build envs to reference, as {{ env:NAME }},
+// only the host environment variables named, such that arbitrary variables of
+// the host (such as credentials) cannot be exposed to builds by func.yaml.
+// References to other variables fail the build with ErrValidation.  By
+// default any variable may be referenced; an empty allowlist permits none.
+func WithHostEnvAllowlist(names []string) Option {
+	return func(b *Builder) {
+		b.hostEnvAllowlist = make(map[string]bool, len(names))
+		for _, name := range names {
+			b.hostEnvAllowlist[name] = true
+		}
+	}
+}
+
+// hostEnvReference matches the values of envs interpolated from the host,
+// as does fn.Interpolate.
+var hostEnvReference = regexp.MustCompile(`^{{\s*env\s*:(\w+)\s*}}$`)
+
+// checkHostEnvs returns an error naming the build envs which reference host
+// environment variables not allowed by WithHostEnvAllowlist, if any.
+func (b *Builder) checkHostEnvs(envs []fn.Env) error {
+	if b.hostEnvAllowlist == nil {
+		return nil
+	}
+	var disallowed []string
+	for _, e := range envs {
+		if e.Name == nil || e.Value == nil {
+			continue
+		}
+		if m := hostEnvReference.FindStringSubmatch(*e.Value); m != nil && !b.hostEnvAllowlist[m[1]] {
+			disallowed = append(disallowed, fmt.Sprintf("%s (references %s)", *e.Name, m[1]))
+		}
+	}
+	if len(disallowed) == 0 {
+		return nil
+	}
+	slices.Sort(disallowed)
+	return fmt.Errorf("build envs %s reference host environment variables which are not allowed", strings.Join(disallowed, ", "))
+}
@@ -0,0 +1,74 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildHostEnvAllowlist ensures that build envs may only reference the
+// host environment variables allowed, and any when there is no allowlist.
+func TestBuildHostEnvAllowlist(t *testing.T) {
+	t.Setenv("FUNC_TEST_PROXY", "http://proxy.example.com")
+	t.Setenv("FUNC_TEST_TOKEN", "secret")
+	var (
+		proxy, proxyValue = "HTTP_PROXY", "{{ env:FUNC_TEST_PROXY }}"
+		token, tokenValue = "TOKEN", "{{env:FUNC_TEST_TOKEN}}"
+		plain, plainValue = "PLAIN", "value"
+	)
+
+	tests := []struct {
+		name       string
+		options    []s2i.Option
+		disallowed string // "" if the build succeeds
+	}{
+		{name: "no allowlist"},
+		{name: "allowed", options: []s2i.Option{s2i.WithHostEnvAllowlist([]string{"FUNC_TEST_PROXY", "FUNC_TEST_TOKEN"})}},
+		{name: "disallowed", options: []s2i.Option{s2i.WithHostEnvAllowlist([]string{"FUNC_TEST_PROXY"})}, disallowed: "TOKEN (references FUNC_TEST_TOKEN)"},
+		{name: "empty", options: []s2i.Option{s2i.WithHostEnvAllowlist(nil)}, disallowed: "HTTP_PROXY (references FUNC_TEST_PROXY), TOKEN (references FUNC_TEST_TOKEN)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var envs map[string]string
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+				envs = map[string]string{}
+				for _, e := range cfg.Environment {
+					envs[e.Name] = e.Value
+				}
+				return nil, nil
+			}}
+			f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuildEnvs: []fn.Env{
+				{Name: &proxy, Value: &proxyValue},
+				{Name: &token, Value: &tokenValue},
+				{Name: &plain, Value: &plainValue},
+			}}}
+			b := s2i.NewBuilder(append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{})}, tt.options...)...)
+			err := b.Build(context.Background(), f, nil)
+
+			if tt.disallowed != "" {
+				if !errors.Is(err, s2i.ErrValidation) {
+					t.Fatalf("expected a validation error, got %v", err)
+				}
+				if !strings.Contains(err.Error(), "build envs "+tt.disallowed+" reference") {
+					t.Fatalf("expected the error to list the disallowed references, got %q", err)
+				}
+				if envs != nil {
+					t.Fatal("expected the build not to run")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if envs[proxy] != "http://proxy.example.com" || envs[token] != "secret" || envs[plain] != plainValue {
+				t.Fatalf("expected the build envs to be interpolated, got %v", envs)
+			}
+		})
+	}
+}
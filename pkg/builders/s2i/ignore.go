@@ -0,0 +1,64 @@
+package s2i
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// IgnoreLinkMode determines how the .funcignore of a function is provided to
+// S2I, which reads .s2iignore.
+type IgnoreLinkMode string
+
+const (
+	// IgnoreLinkSymlink links .s2iignore to .funcignore (the default).
+	IgnoreLinkSymlink IgnoreLinkMode = "symlink"
+	// IgnoreLinkCopy writes a copy of .funcignore to .s2iignore, for
+	// filesystems which do not permit symlinks.
+	IgnoreLinkCopy IgnoreLinkMode = "copy"
+	// IgnoreLinkNone provides no .s2iignore, such that the function's root
+	// is not written to.  Only the files always excluded from the build
+	// context are excluded; .funcignore is not honored.
+	IgnoreLinkNone IgnoreLinkMode = "none"
+)
+
+// WithIgnoreLinkMode sets how .funcignore is provided to S2I as .s2iignore
+// (default IgnoreLinkSymlink).  The .s2iignore written is removed after the
+// build.  An existing .s2iignore is used as-is in any mode.
+func WithIgnoreLinkMode(mode IgnoreLinkMode) Option {
+	return func(b *Builder) {
+		b.ignoreLinkMode = mode
+	}
+}
+
+// linkIgnoreFile provides the .funcignore of the function as its .s2iignore
+// per the builder's ignore link mode.  Returned is a function removing the
+// .s2iignore written, if any.
+func (b *Builder) linkIgnoreFile(root string) (undo func(), err error) {
+	undo = func() {}
+	if b.ignoreLinkMode == IgnoreLinkNone {
+		return
+	}
+	funcignorePath := filepath.Join(root, ".funcignore")
+	s2iignorePath := filepath.Join(root, ".s2iignore")
+	if _, err = os.Stat(funcignorePath); err != nil {
+		return undo, nil
+	}
+	if _, err = os.Stat(s2iignorePath); err == nil {
+		b.logf(LogLevelWarn, "Warning: an existing .s2iignore was detected.  Using this with preference over .funcignore")
+		return
+	}
+
+	if b.ignoreLinkMode == IgnoreLinkCopy {
+		var bb []byte
+		if bb, err = os.ReadFile(funcignorePath); err != nil {
+			return undo, fmt.Errorf("cannot read .funcignore: %w", err)
+		}
+		if err = os.WriteFile(s2iignorePath, bb, 0644); err != nil {
+			return undo, fmt.Errorf("cannot copy .funcignore to .s2iignore: %w", err)
+		}
+	} else if err = os.Symlink("./.funcignore", s2iignorePath); err != nil {
+		return undo, fmt.Errorf("cannot link .s2iignore to .funcignore (see WithIgnoreLinkMode): %w", err)
+	}
+	return func() { os.Remove(s2iignorePath) }, nil
+}
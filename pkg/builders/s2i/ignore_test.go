@@ -0,0 +1,95 @@
+package s2i_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildIgnoreLinkMode ensures that .funcignore is provided as .s2iignore
+// per the ignore link mode for the duration of the build only, and that the
+// root is not written to in mode none.
+func TestBuildIgnoreLinkMode(t *testing.T) {
+	tests := []struct {
+		mode s2i.IgnoreLinkMode
+		want string // kind of .s2iignore during the build
+	}{
+		{mode: "", want: "symlink"},
+		{mode: s2i.IgnoreLinkSymlink, want: "symlink"},
+		{mode: s2i.IgnoreLinkCopy, want: "file"},
+		{mode: s2i.IgnoreLinkNone, want: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.WriteFile(filepath.Join(root, ".funcignore"), []byte("hello.txt\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			old := time.Now().Add(-time.Hour)
+			if err := os.Chtimes(root, old, old); err != nil {
+				t.Fatal(err)
+			}
+
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+				got := "none"
+				if fi, err := os.Lstat(filepath.Join(root, ".s2iignore")); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+					got = "symlink"
+				} else if err == nil && fi.Mode().IsRegular() {
+					got = "file"
+				}
+				if got != tt.want {
+					t.Errorf("expected .s2iignore to be %s, got %s", tt.want, got)
+				}
+				return nil, nil
+			}}
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithIgnoreLinkMode(tt.mode))
+			if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "node"}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := os.Lstat(filepath.Join(root, ".s2iignore")); !os.IsNotExist(err) {
+				t.Errorf("expected .s2iignore to be removed after the build, got %v", err)
+			}
+			fi, err := os.Stat(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written := !fi.ModTime().Equal(old); written != (tt.want != "none") {
+				t.Errorf("expected the root to be written to: %v, got %v", tt.want != "none", written)
+			}
+		})
+	}
+}
+
+// TestBuildIgnoreLinkModeReadOnly ensures that functions with a read-only
+// root are built in mode none, and fail with a descriptive error otherwise.
+func TestBuildIgnoreLinkModeReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, ".funcignore"), []byte("hello.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(root, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(root, 0755) })
+
+	for mode, wantErr := range map[s2i.IgnoreLinkMode]bool{s2i.IgnoreLinkCopy: true, s2i.IgnoreLinkNone: false} {
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithIgnoreLinkMode(mode))
+		err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "node"}, nil)
+		if (err != nil) != wantErr {
+			t.Errorf("mode %s: expected error: %v, got %v", mode, wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package s2i
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openshift/source-to-image/pkg/api"
+)
+
+// WithBuilderImageEnvs adds the envs declared by the builder image (such as
+// GOPATH or NPM_CONFIG_PREFIX) to the build envs of the S2I config which func
+// sets none of, such that the config (see WithConfigDump) records every env
+// of the assemble step.  Regardless, build envs shadowing those of the
+// builder image are noted when logging at LogLevelDebug.
+func WithBuilderImageEnvs(merge bool) Option {
+	return func(b *Builder) {
+		b.builderImageEnvs = merge
+	}
+}
+
+// checkBuilderImageEnvs notes the build envs of the config which shadow envs
+// of the builder image with a different value, and merges the envs of the
+// builder image per WithBuilderImageEnvs.  Failing to inspect the builder
+// image is an error only if merging.
+func (b *Builder) checkBuilderImageEnvs(ctx context.Context, client DockerClient, cfg *api.Config) error {
+	if !b.builderImageEnvs && !b.logs(LogLevelDebug) {
+		return nil
+	}
+	c, err := b.imageConfigOf(ctx, client, cfg.BuilderImage)
+	if err != nil {
+		err = fmt.Errorf("cannot get the envs of builder image %q: %w", cfg.BuilderImage, err)
+		if b.builderImageEnvs {
+			return err
+		}
+		b.logf(LogLevelDebug, "%v", err)
+		return nil
+	}
+
+	defaults := make(map[string]string, len(c.env))
+	var names []string
+	for _, kv := range c.env {
+		k, v, _ := strings.Cut(kv, "=")
+		if _, ok := defaults[k]; !ok {
+			names = append(names, k)
+		}
+		defaults[k] = v
+	}
+
+	// Values are not logged, as build envs may be secrets.
+	set := make(map[string]bool, len(cfg.Environment))
+	var shadowed []string
+	for _, e := range cfg.Environment {
+		set[e.Name] = true
+		if v, ok := defaults[e.Name]; ok && v != e.Value {
+			shadowed = append(shadowed, e.Name)
+		}
+	}
+	if len(shadowed) > 0 {
+		slices.Sort(shadowed)
+		b.logf(LogLevelDebug, "Build envs shadowing those of the builder image: %s", strings.Join(shadowed, ", "))
+	}
+
+	if b.builderImageEnvs {
+		for _, k := range names {
+			if !set[k] {
+				cfg.Environment = append(cfg.Environment, api.EnvironmentSpec{Name: k, Value: defaults[k]})
+			}
+		}
+	}
+	return nil
+}
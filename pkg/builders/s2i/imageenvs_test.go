@@ -0,0 +1,85 @@
+package s2i_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildBuilderImageEnvs ensures that build envs shadowing envs of the
+// builder image are noted when debugging, and that the envs of the builder
+// image are merged into those which func does not set only if requested.
+func TestBuildBuilderImageEnvs(t *testing.T) {
+	cli := mockDocker{inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+		return types.ImageInspect{Config: &container.Config{Env: []string{
+			"PATH=/usr/bin:/bin",
+			"NODE_ENV=development",
+			"NPM_CONFIG_PREFIX=/opt/app-root/src/.npm-global",
+		}}}, nil, nil
+	}}
+	name, value := "SECRET", "s3cr3t"
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuildEnvs: []fn.Env{{Name: &name, Value: &value}}}}
+
+	tests := []struct {
+		name    string
+		options []s2i.Option
+		want    map[string]string // envs of the config
+		logged  bool
+	}{
+		{
+			name:    "debug",
+			logged:  true,
+			options: []s2i.Option{s2i.WithLogLevel(s2i.LogLevelDebug)},
+			want:    map[string]string{"NODE_ENV": "production", "SECRET": "s3cr3t"},
+		},
+		{
+			name:    "merge",
+			options: []s2i.Option{s2i.WithBuilderImageEnvs(true)},
+			want: map[string]string{
+				"NODE_ENV":          "production",
+				"SECRET":            "s3cr3t",
+				"PATH":              "/usr/bin:/bin",
+				"NPM_CONFIG_PREFIX": "/opt/app-root/src/.npm-global",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+				got = map[string]string{}
+				for _, e := range cfg.Environment {
+					got[e.Name] = e.Value
+				}
+				return nil, nil
+			}}
+			options := append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli)}, tt.options...)
+			out := captureStderr(t, func() {
+				if err := s2i.NewBuilder(options...).Build(context.Background(), f, nil); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected envs %v, got %v", tt.want, got)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Fatalf("expected envs %v, got %v", tt.want, got)
+				}
+			}
+			if logged := strings.Contains(out, "shadowing those of the builder image: NODE_ENV\n"); logged != tt.logged {
+				t.Fatalf("expected the shadowed envs logged: %v, got:\n%s", tt.logged, out)
+			}
+			if strings.Contains(out, "s3cr3t") || strings.Contains(out, "development") {
+				t.Fatalf("expected no values logged, got:\n%s", out)
+			}
+		})
+	}
+}
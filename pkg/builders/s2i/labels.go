@@ -0,0 +1,104 @@
+package s2i
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/openshift/source-to-image/pkg/scm/git"
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+	"gopkg.in/yaml.v2"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// labelPlaceholder matches placeholders of label values such as {{ git.sha }}.
+var labelPlaceholder = regexp.MustCompile(`{{\s*([^{}\s]+)\s*}}`)
+
+// labelContext provides the values of the placeholders of labels of the image
+// of a function.
+type labelContext struct {
+	root string
+	now  time.Time
+	info *git.SourceInfo // loaded on first use
+}
+
+// interpolate the placeholders of the label value.  Supported are
+// {{ git.sha }}, {{ git.branch }}, {{ date }} (RFC 3339, UTC) and
+// {{ env:NAME }}.
+func (c *labelContext) interpolate(value string) (string, error) {
+	var err error
+	value = labelPlaceholder.ReplaceAllStringFunc(value, func(m string) string {
+		name := labelPlaceholder.FindStringSubmatch(m)[1]
+		switch {
+		case name == "date":
+			return c.now.UTC().Format(time.RFC3339)
+		case name == "git.sha" || name == "git.branch":
+			if c.info == nil {
+				c.info = git.New(fs.NewFileSystem(), cmd.NewCommandRunner()).GetInfo(c.root)
+			}
+			if c.info.CommitID == "" {
+				err = fmt.Errorf("cannot interpolate %s: %q is not within a git repository", m, c.root)
+				return m
+			}
+			if name == "git.sha" {
+				return c.info.CommitID
+			}
+			return c.info.Ref
+		case strings.HasPrefix(name, "env:"):
+			return os.Getenv(strings.TrimPrefix(name, "env:"))
+		}
+		if err == nil {
+			err = fmt.Errorf("unknown placeholder %s", m)
+		}
+		return m
+	})
+	return value, err
+}
+
+// readLabelsTemplate reads the labels of the template at path, a YAML map of
+// label keys to values.  A relative path is relative to the function's root.
+func readLabelsTemplate(path string, f fn.Function) (map[string]string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(f.Root, path)
+	}
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read labels template: %w", err)
+	}
+	labels := map[string]string{}
+	if err = yaml.UnmarshalStrict(bb, &labels); err != nil {
+		return nil, fmt.Errorf("cannot parse labels template %q: %w", path, err)
+	}
+	return labels, nil
+}
+
+// imageLabelsOf returns the labels of the image of the function: those of
+// the template at path, interpolated, overridden by the labels of the
+// function itself.
+func imageLabelsOf(path string, f fn.Function, now time.Time) (map[string]string, error) {
+	labels, err := readLabelsTemplate(path, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range f.Deploy.Labels {
+		if l.Key == nil {
+			continue
+		}
+		labels[*l.Key] = ""
+		if l.Value != nil {
+			labels[*l.Key] = *l.Value
+		}
+	}
+	c := &labelContext{root: f.Root, now: now}
+	for k, v := range labels {
+		if labels[k], err = c.interpolate(v); err != nil {
+			return nil, fmt.Errorf("cannot interpolate label %q: %w", k, err)
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,100 @@
+package s2i_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildLabelsTemplate ensures that the labels of the template are
+// loaded, interpolated and overridden by those of the function.
+func TestBuildLabelsTemplate(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required")
+	}
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "index.js"), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "."},
+		{"-c", "user.name=alice", "-c", "user.email=alice@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := strings.TrimSpace(string(out))
+
+	template := filepath.Join(t.TempDir(), "labels.yaml")
+	if err := os.WriteFile(template, []byte(`org.opencontainers.image.revision: "{{ git.sha }}"
+org.opencontainers.image.created: "{{date}}"
+com.example.branch: "{{ git.branch }}"
+com.example.team: "{{ env:TEAM }}"
+com.example.tier: "backend"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEAM", "payments")
+
+	var (
+		labels  map[string]string
+		impl    = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		capture = s2i.WithS2IConfig(func(cfg *api.Config) { labels = cfg.Labels })
+		key     = "com.example.tier"
+		value   = "frontend"
+		f       = fn.Function{Root: root, Runtime: "node", Deploy: fn.DeploySpec{Labels: []fn.Label{{Key: &key, Value: &value}}}}
+	)
+
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithLabelsTemplate(template), capture)
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if labels["org.opencontainers.image.revision"] != commit {
+		t.Errorf("expected the revision %q, got %q", commit, labels["org.opencontainers.image.revision"])
+	}
+	if labels["com.example.branch"] != "main" {
+		t.Errorf("expected the branch main, got %q", labels["com.example.branch"])
+	}
+	if _, err := time.Parse(time.RFC3339, labels["org.opencontainers.image.created"]); err != nil {
+		t.Errorf("expected an RFC 3339 date, got %q", labels["org.opencontainers.image.created"])
+	}
+	if labels["com.example.team"] != "payments" {
+		t.Errorf("expected the team from the environment, got %q", labels["com.example.team"])
+	}
+	if labels[key] != value {
+		t.Errorf("expected the label of the function to take precedence, got %q", labels[key])
+	}
+
+	// Unknown placeholders, and git placeholders outside of a repository,
+	// are rejected.
+	for _, content := range []string{`a: "{{ unknown }}"`, `a: "{{ git.sha }}"`} {
+		if err := os.WriteFile(template, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f := fn.Function{Root: t.TempDir(), Runtime: "node"}
+		b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithLabelsTemplate(template))
+		if err := b.Build(context.Background(), f, nil); err == nil {
+			t.Errorf("expected an error interpolating %s", content)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package s2i
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+
+	"knative.dev/func/pkg/builders"
+)
+
+// WithLoad sets whether the built image is loaded into the image store of the
+// docker daemon (default true).  An image which is not loaded must be pushed
+// (see WithPush), saving the disk of the daemon where only the registry's
+// copy is used, and can thus not be inspected after the build: its size is
+// not reported, and options requiring the local image (WithArtifactExtract,
+// WithMaxImageSize and WithProvenance) are rejected.
+func WithLoad(load bool) Option {
+	return func(b *Builder) {
+		b.load = load
+	}
+}
+
+// WithPush pushes the built image, with any additional tags, to its registry
+// as part of the build, by the image exporter of BuildKit, which requires
+// the containerd image store of the docker daemon.  The daemon pushes with
+// its own credentials.
+func WithPush(push bool) Option {
+	return func(b *Builder) {
+		b.push = push
+	}
+}
+
+// validateLoad checks that images which are not loaded are pushed, and that
+// no options requiring the local image are set.
+func (b *Builder) validateLoad() error {
+	if b.load {
+		return nil
+	}
+	if !b.push {
+		return errors.New("an image which is not loaded must be pushed")
+	}
+	if len(b.artifacts) > 0 || b.maxImageSize > 0 || b.provenancePath != "" {
+		return errors.New("artifacts, the image size budget and provenance require the image to be loaded")
+	}
+	return nil
+}
+
+// checkPush checks that the daemon can push the image as part of the build.
+func checkPush(ctx context.Context, client DockerClient, buildKit bool, image string) error {
+	if image == "" {
+		return errors.New("cannot push an untagged image")
+	}
+	if !buildKit {
+		return errors.New("pushing the image as part of the build requires BuildKit")
+	}
+	containerd, err := usesContainerdStore(ctx, client)
+	if err != nil {
+		return fmt.Errorf("cannot determine whether the docker daemon can push the image: %w", err)
+	}
+	if !containerd {
+		return errors.New("the docker daemon cannot push the image as part of the build: enable its containerd image store")
+	}
+	return nil
+}
+
+// pushOutput returns the output of the build exporting the image to its
+// registry and, if loaded, to the image store of the daemon.
+func (b *Builder) pushOutput(tags []string) types.ImageBuildOutput {
+	attrs := map[string]string{
+		"name":  strings.Join(tags, ","),
+		"push":  "true",
+		"store": strconv.FormatBool(b.load),
+	}
+	if b.imageFormat != "" {
+		attrs["oci-mediatypes"] = strconv.FormatBool(b.imageFormat == builders.OCI)
+	}
+	return types.ImageBuildOutput{Type: "image", Attrs: attrs}
+}
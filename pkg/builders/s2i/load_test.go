@@ -0,0 +1,112 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/system"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildLoad ensures that images are pushed as part of the build when
+// requested, loaded into the daemon unless disabled, and not inspected if
+// not loaded, and that images which would be neither loaded nor pushed, or
+// pushed by a daemon which cannot, are rejected.
+func TestBuildLoad(t *testing.T) {
+	const image = "example.com/alice/fn:latest"
+	containerd := func(ctx context.Context) (system.Info, error) {
+		return system.Info{DriverStatus: [][2]string{{"driver-type", "io.containerd.snapshotter.v1"}}}, nil
+	}
+
+	tests := []struct {
+		name      string
+		options   []s2i.Option
+		info      func(ctx context.Context) (system.Info, error)
+		outputs   []types.ImageBuildOutput
+		inspected bool
+		invalid   bool
+	}{
+		{
+			name:      "load",
+			inspected: true,
+		},
+		{
+			name:      "load and push",
+			options:   []s2i.Option{s2i.WithPush(true)},
+			info:      containerd,
+			outputs:   []types.ImageBuildOutput{{Type: "image", Attrs: map[string]string{"name": image, "push": "true", "store": "true"}}},
+			inspected: true,
+		},
+		{
+			name:    "push only",
+			options: []s2i.Option{s2i.WithLoad(false), s2i.WithPush(true)},
+			info:    containerd,
+			outputs: []types.ImageBuildOutput{{Type: "image", Attrs: map[string]string{"name": image, "push": "true", "store": "false"}}},
+		},
+		{
+			name:    "neither loaded nor pushed",
+			options: []s2i.Option{s2i.WithLoad(false)},
+			invalid: true,
+		},
+		{
+			name:    "not loaded with a size budget",
+			options: []s2i.Option{s2i.WithLoad(false), s2i.WithPush(true), s2i.WithMaxImageSize(1 << 30)},
+			info:    containerd,
+			invalid: true,
+		},
+		{
+			name:    "push with the classic image store",
+			options: []s2i.Option{s2i.WithPush(true)},
+			info: func(ctx context.Context) (system.Info, error) {
+				return system.Info{DriverStatus: [][2]string{{"Backing Filesystem", "extfs"}}}, nil
+			},
+			invalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				outputs   []types.ImageBuildOutput
+				inspected bool
+			)
+			cli := mockDocker{
+				build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+					outputs = options.Outputs
+					_, _ = io.Copy(io.Discard, context)
+					return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+				},
+				inspect: func(ctx context.Context, name string) (types.ImageInspect, []byte, error) {
+					inspected = inspected || name == image
+					return types.ImageInspect{}, nil, nil
+				},
+				info: tt.info,
+			}
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+			b := s2i.NewBuilder(append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli)}, tt.options...)...)
+			err := b.Build(context.Background(), fn.Function{Runtime: "node", Build: fn.BuildSpec{Image: image}}, nil)
+			if tt.invalid {
+				if !errors.Is(err, s2i.ErrValidation) {
+					t.Fatalf("expected a validation error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(outputs, tt.outputs) {
+				t.Errorf("expected outputs %v, got %v", tt.outputs, outputs)
+			}
+			if inspected != tt.inspected {
+				t.Errorf("expected the image to be inspected: %v, got %v", tt.inspected, inspected)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package s2i
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockPollInterval is the interval at which a build waiting for a concurrent
+// build of the same function retries acquiring the lock of its root.
+const lockPollInterval = 100 * time.Millisecond
+
+// lockRoot acquires an exclusive lock of the function's root for the steps of
+// a build which write to it (scaffolding, .s2iignore and overlaid scripts)
+// and read it back (S2I copying the source), such that concurrent builds of a
+// function, in this or other processes, do not clobber one another.  The lock
+// file is kept outside of the root, which may be read-only.  Waiting for the
+// lock is canceled with the context.  Returned is a function releasing it.
+func lockRoot(ctx context.Context, root string) (release func(), err error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("cannot lock function root: %w", err)
+	}
+	sum := sha256.Sum256([]byte(abs))
+	release, err = lockFile(ctx, filepath.Join(os.TempDir(), "func-s2i-"+hex.EncodeToString(sum[:8])+".lock"))
+	if ctx.Err() != nil && errors.Is(err, context.Cause(ctx)) {
+		return nil, fmt.Errorf("interrupted waiting for a concurrent build of the function: %w", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot lock function root: %w", err)
+	}
+	return release, nil
+}
+
+// lockFile acquires an exclusive lock of the file at path, creating it if
+// necessary, polling until it is acquired or the context is canceled, whose
+// cause is then returned.  Returned is a function releasing it.
+func lockFile(ctx context.Context, path string) (release func(), err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			return func() {
+				_ = unlock(f)
+				f.Close()
+			}, nil
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, context.Cause(ctx)
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// chain returns a function invoking each of the functions in order.
+func chain(fns ...func()) func() {
+	return func() {
+		for _, fn := range fns {
+			fn()
+		}
+	}
+}
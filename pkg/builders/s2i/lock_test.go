@@ -0,0 +1,68 @@
+package s2i_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildConcurrent ensures that concurrent builds of the same function
+// root do not clobber each other's scaffolding: each build reads the
+// scaffolding it wrote, and no two builds read the root at the same time.
+func TestBuildConcurrent(t *testing.T) {
+	root := t.TempDir()
+	impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+	if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		inFlight, maxInFlight atomic.Int32
+		wg                    sync.WaitGroup
+	)
+	build := func(invoke, want string) {
+		defer wg.Done()
+		i := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+			}
+			time.Sleep(50 * time.Millisecond) // widen the window of a race
+
+			main, err := os.ReadFile(filepath.Join(root, ".s2i", "builds", "last", "main.go"))
+			if err != nil {
+				t.Errorf("%s: %v", invoke, err)
+			} else if !strings.Contains(string(main), want) {
+				t.Errorf("%s: expected the scaffolding of the build, got:\n%s", invoke, main)
+			}
+			if _, err := os.Stat(filepath.Join(root, ".s2i", "bin", "assemble")); err != nil {
+				t.Errorf("%s: %v", invoke, err)
+			}
+			return nil, nil
+		}}
+		b := s2i.NewBuilder(s2i.WithImpl(i), s2i.WithDockerClient(mockDocker{}))
+		if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "go", Invoke: invoke}, nil); err != nil {
+			t.Errorf("%s: %v", invoke, err)
+		}
+	}
+	for n := 0; n < 3; n++ {
+		wg.Add(2)
+		go build("http", "knative.dev/func-go/http")
+		go build("cloudevent", "knative.dev/func-go/cloudevents")
+	}
+	wg.Wait()
+
+	if maxInFlight.Load() != 1 {
+		t.Fatalf("expected builds to read the root in turn, got %d at once", maxInFlight.Load())
+	}
+}
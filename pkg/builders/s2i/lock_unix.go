@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package s2i
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLock acquires an exclusive lock of the file without blocking, returning
+// false if it is held elsewhere.
+func tryLock(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// unlock the file.
+func unlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,182 @@
+package s2i
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// LogLevel is the verbosity of the messages of the builder written to
+// stderr.  Each level includes those before it.
+type LogLevel int
+
+const (
+	// LogLevelError shows errors only.
+	LogLevelError LogLevel = iota
+	// LogLevelWarn shows warnings, such as of a discouraged builder image
+	// tag or an existing .s2iignore.  The default.
+	LogLevelWarn
+	// LogLevelInfo shows progress, such as an image being up to date.
+	LogLevelInfo
+	// LogLevelDebug shows the full output of the build.  Implied by
+	// WithVerbose(true).
+	LogLevelDebug
+)
+
+// String returns the name of the level.
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelError:
+		return "error"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelDebug:
+		return "debug"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// WithLogLevel sets the verbosity of the builder (default LogLevelWarn).
+// Verbose builders log at LogLevelDebug regardless.
+func WithLogLevel(l LogLevel) Option {
+	return func(b *Builder) {
+		b.logLevel = l
+	}
+}
+
+// logs reports whether messages of the level are shown.
+func (b *Builder) logs(l LogLevel) bool {
+	if b.verbose {
+		return true
+	}
+	return l <= b.logLevel
+}
+
+// logf writes the message to stderr, or the JSON logs, if its level is
+// shown, and to the log file if any.
+func (b *Builder) logf(l LogLevel, format string, args ...any) {
+	if b.logFile != nil {
+		fmt.Fprintf(b.logFile, format+"\n", args...)
+	}
+	if !b.logs(l) {
+		return
+	}
+	if b.jsonLogs != nil {
+		b.jsonLogs.write(b.phase, l, fmt.Sprintf(format, args...))
+		return
+	}
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+// Phase of a build, as annotated in JSON logs.
+type Phase string
+
+const (
+	// PhasePrepare resolves and checks the builder image and configuration.
+	PhasePrepare Phase = "prepare"
+	// PhaseScaffold writes the scaffolding of the function.
+	PhaseScaffold Phase = "scaffold"
+	// PhaseGenerate generates the Dockerfile with S2I.
+	PhaseGenerate Phase = "generate"
+	// PhaseContext patches the Dockerfile and uploads the build context.
+	PhaseContext Phase = "tar"
+	// PhaseBuild builds the image, running the assemble script.
+	PhaseBuild Phase = "build"
+	// PhaseFinish inspects the built image and writes the outputs of the
+	// build, such as artifacts and provenance.
+	PhaseFinish Phase = "finish"
+)
+
+// WithJSONLogs writes the messages of the builder to w in place of stderr,
+// as JSON lines of the form
+//
+//	{"phase":"build","level":"debug","message":"...","timestamp":"..."}
+//
+// where phase is the Phase of the build ("" outside of builds) and the
+// output of the build is a message per line.  The log level applies as to
+// stderr.  Concurrent builds may share w.
+func WithJSONLogs(w io.Writer) Option {
+	return func(b *Builder) {
+		b.jsonLogs = &jsonLogger{w: w}
+	}
+}
+
+// jsonLogger writes JSON logs, serializing the writes of concurrent builds.
+type jsonLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// jsonLogLine is a line of JSON logs.
+type jsonLogLine struct {
+	Phase     Phase     `json:"phase"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (l *jsonLogger) write(phase Phase, level LogLevel, message string) {
+	bb, _ := json.Marshal(jsonLogLine{Phase: phase, Level: level.String(), Message: message, Timestamp: time.Now().UTC()})
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(append(bb, '\n'))
+}
+
+// writeStream of JSON messages from the daemon as debug logs, a line per
+// message line, including those of the logs of BuildKit trace messages.  Errors reported within the stream are returned.
+func (l *jsonLogger) writeStream(phase Phase, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var m jsonmessage.JSONMessage
+		if err := dec.Decode(&m); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if m.Error != nil {
+			return m.Error
+		}
+		text := m.Stream
+		if logs := buildKitLogs(m); len(logs) > 0 {
+			text = string(logs)
+		} else if text == "" && m.Status != "" {
+			text = m.Status
+			if m.ID != "" {
+				text = m.ID + ": " + text
+			}
+		}
+		for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+			if strings.TrimSpace(line) != "" {
+				l.write(phase, LogLevelDebug, line)
+			}
+		}
+	}
+}
+
+// withPhase returns the builder of a build, tracking its phase for JSON logs.
+// Builds are given a copy of the builder, which may be shared by concurrent
+// builds, only if JSON logs are written.
+func (b *Builder) withPhase() *Builder {
+	if b.jsonLogs == nil {
+		return b
+	}
+	c := *b
+	c.phase = PhasePrepare
+	return &c
+}
+
+// setPhase of the build, if tracked (see withPhase).
+func (b *Builder) setPhase(p Phase) {
+	if b.jsonLogs != nil {
+		b.phase = p
+	}
+}
@@ -0,0 +1,201 @@
+package s2i_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildLogLevel ensures that the messages of the builder are shown at
+// their levels and above, and that verbose builders show all of them.
+func TestBuildLogLevel(t *testing.T) {
+	const (
+		warning = "an existing .s2iignore was detected"
+		info    = "is up to date"
+		debug   = "message of the s2i build"
+	)
+
+	tests := []struct {
+		name    string
+		options []s2i.Option
+		want    []string
+	}{
+		{name: "default", want: []string{warning}},
+		{name: "error", options: []s2i.Option{s2i.WithLogLevel(s2i.LogLevelError)}},
+		{name: "warn", options: []s2i.Option{s2i.WithLogLevel(s2i.LogLevelWarn)}, want: []string{warning}},
+		{name: "info", options: []s2i.Option{s2i.WithLogLevel(s2i.LogLevelInfo)}, want: []string{warning, info}},
+		{name: "debug", options: []s2i.Option{s2i.WithLogLevel(s2i.LogLevelDebug)}, want: []string{warning, info, debug}},
+		{name: "verbose", options: []s2i.Option{s2i.WithLogLevel(s2i.LogLevelError), s2i.WithVerbose(true)}, want: []string{warning, info, debug}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, name := range []string{".funcignore", ".s2iignore"} {
+				if err := os.WriteFile(filepath.Join(root, name), []byte("hello.txt\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var (
+				impl = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+					return &api.Result{Messages: []string{debug}}, nil
+				}}
+				cli = mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+					_, _ = io.Copy(io.Discard, context)
+					return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+				}}
+				f       = fn.Function{Root: root, Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/fn:latest"}}
+				options = append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli),
+					s2i.WithSkipIfUnchanged(filepath.Join(t.TempDir(), "state.json"))}, tt.options...)
+				b = s2i.NewBuilder(options...)
+			)
+
+			// The second build is skipped as up to date.
+			out := captureStderr(t, func() {
+				for i := 0; i < 2; i++ {
+					if err := b.Build(context.Background(), f, nil); err != nil {
+						t.Fatal(err)
+					}
+				}
+			})
+			for _, msg := range []string{warning, info, debug} {
+				want := false
+				for _, w := range tt.want {
+					want = want || w == msg
+				}
+				if strings.Contains(out, msg) != want {
+					t.Errorf("expected %q to be shown: %v, got output:\n%s", msg, want, out)
+				}
+			}
+		})
+	}
+}
+
+// TestBuildLogNilResult ensures that builds whose S2I implementation returns
+// no result, as implementations other than that of S2I may, succeed at
+// LogLevelDebug, at which the messages of the result are shown.
+func TestBuildLogNilResult(t *testing.T) {
+	var (
+		impl = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		cli  = mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			_, _ = io.Copy(io.Discard, context)
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+		}}
+	)
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithLogLevel(s2i.LogLevelDebug))
+	captureStderr(t, func() {
+		if err := b.Build(context.Background(), fn.Function{Root: t.TempDir(), Runtime: "node"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestBuildJSONLogs ensures that JSON logs are written in place of stderr, a
+// JSON object per line annotated with the phase of the build, including a
+// line per line of the output of the build.
+func TestBuildJSONLogs(t *testing.T) {
+	var (
+		impl = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			return &api.Result{Messages: []string{"message of the s2i build"}}, nil
+		}}
+		cli = mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			_, _ = io.Copy(io.Discard, context)
+			stream := `{"stream": "Step 1/2 : FROM example.com/builder\n"}` + "\n" + `{"stream": "Installing\nInstalled\n"}` + "\n" +
+				buildKitTrace(t, "npm install\nadded 1 package\n")
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(stream)), OSType: "linux"}, nil
+		}}
+		logs bytes.Buffer
+	)
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithLogLevel(s2i.LogLevelDebug), s2i.WithJSONLogs(&logs))
+	out := captureStderr(t, func() {
+		if err := b.Build(context.Background(), fn.Function{Root: t.TempDir(), Runtime: "node"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if out != "" {
+		t.Fatalf("expected nothing written to stderr, got:\n%s", out)
+	}
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(logs.String(), "\n"), "\n") {
+		var l struct {
+			Phase     string    `json:"phase"`
+			Level     string    `json:"level"`
+			Message   string    `json:"message"`
+			Timestamp time.Time `json:"timestamp"`
+		}
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			t.Fatalf("expected a JSON object per line, got %q: %v", line, err)
+		}
+		if l.Phase == "" || l.Level == "" || l.Message == "" || l.Timestamp.IsZero() {
+			t.Fatalf("expected phase, level, message and timestamp, got %q", line)
+		}
+		got = append(got, l.Phase+" "+l.Level+" "+l.Message)
+	}
+	for _, want := range []string{
+		"generate debug message of the s2i build",
+		"build debug Step 1/2 : FROM example.com/builder",
+		"build debug Installing",
+		"build debug Installed",
+		"build debug npm install",
+		"build debug added 1 package",
+	} {
+		if !slices.Contains(got, want) {
+			t.Errorf("expected the log %q, got:\n%s", want, strings.Join(got, "\n"))
+		}
+	}
+}
+
+// TestBuildBuildKitLogs ensures that the output of builds with BuildKit,
+// that of its trace messages, is shown at LogLevelDebug.
+func TestBuildBuildKitLogs(t *testing.T) {
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	cli := mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+		_, _ = io.Copy(io.Discard, context)
+		stream := buildKitTrace(t, "npm install\nadded 1 package\n")
+		return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(stream)), OSType: "linux"}, nil
+	}}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithLogLevel(s2i.LogLevelDebug))
+	out := captureStderr(t, func() {
+		if err := b.Build(context.Background(), fn.Function{Root: t.TempDir(), Runtime: "node"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "npm install\nadded 1 package\n") {
+		t.Fatalf("expected the output of the build to be shown, got:\n%s", out)
+	}
+}
+
+// captureStderr returns what is written to stderr by fn.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	done := make(chan string)
+	go func() {
+		bb, _ := io.ReadAll(r)
+		done <- string(bb)
+	}()
+	defer func() {
+		os.Stderr = stderr
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
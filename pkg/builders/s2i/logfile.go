@@ -0,0 +1,105 @@
+package s2i
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// WithLogFile additionally writes the messages of the builder, and the output
+// of builds, to the file at path, regardless of the log level, such that the
+// full log of a build is retained.  Once the file would exceed maxBytes it is
+// rotated: renamed to path.1 (replacing any such file) and begun anew.  A
+// maxBytes of zero disables rotation.  Concurrent builds may share the file.
+func WithLogFile(path string, maxBytes int64) Option {
+	return func(b *Builder) {
+		b.logFile = &rotatingFile{path: path, max: maxBytes}
+	}
+}
+
+// rotatingFile is a log file rotated by size.
+type rotatingFile struct {
+	path string
+	max  int64
+
+	mu   sync.Mutex
+	f    *os.File // opened on the first write
+	size int64
+}
+
+// validate the log file.
+func (r *rotatingFile) validate() error {
+	if r.max < 0 {
+		return fmt.Errorf("invalid log file size %d: must not be negative", r.max)
+	}
+	if fi, err := os.Stat(filepath.Dir(r.path)); err != nil || !fi.IsDir() {
+		return fmt.Errorf("invalid log file %q: its directory must exist", r.path)
+	}
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.max > 0 && r.size > 0 && r.size+int64(len(p)) > r.max {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// open the file for appending.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cannot open log file: %w", err)
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+// rotate the file to path.1 and open it anew.
+func (r *rotatingFile) rotate() error {
+	err := r.f.Close()
+	r.f = nil
+	if err = errors.Join(err, os.Rename(r.path, r.path+".1")); err != nil {
+		return fmt.Errorf("cannot rotate log file: %w", err)
+	}
+	return r.open()
+}
+
+// teeLogFile returns a reader of the JSON messages of r which writes them, as
+// rendered, to the log file as they are read, including the output of builds
+// with BuildKit, and a function to be invoked
+// once r has been read, which waits for them to be written.
+func (b *Builder) teeLogFile(r io.Reader) (io.Reader, func()) {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = jsonmessage.DisplayJSONMessagesStream(pr, b.logFile, 0, false, buildKitOutput(b.logFile))
+		_, _ = io.Copy(io.Discard, pr) // any remainder, such that writes do not block
+	}()
+	return io.TeeReader(r, pw), func() {
+		pw.Close()
+		<-done
+	}
+}
@@ -0,0 +1,94 @@
+package s2i_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildLogFile ensures that the output of builds is written to the log
+// file regardless of the log level, and that the file is rotated once it
+// would exceed its size, without losing the output since, and that the
+// output of builds with BuildKit, that of its trace messages, is written.
+func TestBuildLogFile(t *testing.T) {
+	const (
+		steps    = 100
+		maxBytes = 256
+	)
+	var stream strings.Builder
+	for i := 0; i < steps; i++ {
+		fmt.Fprintf(&stream, "{\"stream\":\"Step %02d\\n\"}\n", i)
+	}
+
+	var (
+		path = filepath.Join(t.TempDir(), "build.log")
+		impl = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		cli  = mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			_, _ = io.Copy(io.Discard, context)
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(stream.String())), OSType: "linux"}, nil
+		}}
+		f = fn.Function{Root: t.TempDir(), Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/fn:latest"}}
+		b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithLogFile(path, maxBytes))
+	)
+	out := captureStderr(t, func() {
+		if err := b.Build(context.Background(), f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if strings.Contains(out, "Step") {
+		t.Errorf("expected the output not to be shown at the default log level, got:\n%s", out)
+	}
+
+	var content string
+	for _, p := range []string{path + ".1", path} {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("expected log file %s: %v", p, err)
+		}
+		if len(data) > maxBytes {
+			t.Errorf("expected log file %s of at most %d bytes, got %d", p, maxBytes, len(data))
+		}
+		content += string(data)
+	}
+
+	// The output since the rotation is complete, and in order.
+	first := steps
+	for i := steps - 1; i >= 0 && strings.Contains(content, fmt.Sprintf("Step %02d\n", i)); i-- {
+		first = i
+	}
+	if first == 0 || first == steps {
+		t.Fatalf("expected the log file to be rotated mid-build, got:\n%s", content)
+	}
+	for i := 0; i < first; i++ {
+		if strings.Contains(content, fmt.Sprintf("Step %02d\n", i)) {
+			t.Errorf("expected the output since the rotation to be contiguous, got:\n%s", content)
+		}
+	}
+	if last := strings.LastIndex(content, "Step"); last < strings.LastIndex(content, fmt.Sprintf("Step %02d", first)) {
+		t.Errorf("expected the output in order, got:\n%s", content)
+	}
+
+	// The output of builds with BuildKit is that of its trace messages.
+	stream.Reset()
+	stream.WriteString(buildKitTrace(t, "npm install\nadded 1 package\n") + "\n")
+	path = filepath.Join(t.TempDir(), "build.log")
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithLogFile(path, 0))
+	captureStderr(t, func() {
+		if err := b.Build(context.Background(), f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), "npm install\nadded 1 package\n") {
+		t.Errorf("expected the output of the BuildKit build in the log file, got %q (%v)", data, err)
+	}
+}
@@ -0,0 +1,70 @@
+package s2i
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"golang.org/x/exp/maps"
+)
+
+// WithPullRegistryMirror pulls the base images of the Dockerfile via the
+// registry mirror at host, for daemons which can not reach the registries of
+// the images themselves.  The FROM instructions of the generated Dockerfile
+// are rewritten to the mirror, retaining the repository and the tag or
+// digest, such that registry.access.redhat.com/ubi8/nodejs-20-minimal is
+// pulled as <host>/ubi8/nodejs-20-minimal.  Only the default builder images
+// are rewritten unless WithPullRegistryMirrorAll is also given.
+func WithPullRegistryMirror(host string) Option {
+	return func(b *Builder) {
+		b.pullMirror = host
+	}
+}
+
+// WithPullRegistryMirrorAll additionally rewrites the images configured by
+// the user, such as builder images of func.yaml and the runtime image, to the
+// registry mirror (see WithPullRegistryMirror).
+func WithPullRegistryMirrorAll(enabled bool) Option {
+	return func(b *Builder) {
+		b.pullMirrorAll = enabled
+	}
+}
+
+// fromImage matches FROM instructions, the second group of which is the
+// image (or stage) built upon.
+var fromImage = regexp.MustCompile(`(?im)^(FROM\s+(?:--\S+\s+)*)(\S+)`)
+
+// mirrorFroms rewrites the images of the FROM instructions of the Dockerfile
+// to the registry mirror.  References to stages are left as-is.
+func (b *Builder) mirrorFroms(dockerfile string) string {
+	stages := stageNames(dockerfile)
+	defaults := map[string]bool{}
+	for _, image := range maps.Values(DefaultBuilderImages) {
+		if ref, err := name.ParseReference(image); err == nil {
+			defaults[ref.Context().Name()] = true
+		}
+	}
+	return fromImage.ReplaceAllStringFunc(dockerfile, func(from string) string {
+		m := fromImage.FindStringSubmatch(from)
+		image := m[2]
+		if slices.Contains(stages, strings.ToLower(image)) {
+			return from
+		}
+		ref, err := name.ParseReference(image)
+		if err != nil || (!b.pullMirrorAll && !defaults[ref.Context().Name()]) {
+			return from
+		}
+		mirrored := b.pullMirror + "/" + ref.Context().RepositoryStr()
+		switch r := ref.(type) {
+		case name.Digest:
+			mirrored += "@" + r.DigestStr()
+		case name.Tag:
+			if strings.HasSuffix(image, ":"+r.TagStr()) { // the default tag is implied
+				mirrored += ":" + r.TagStr()
+			}
+		}
+		b.logf(LogLevelDebug, "Pulling %s via the registry mirror as %s", image, mirrored)
+		return m[1] + mirrored
+	})
+}
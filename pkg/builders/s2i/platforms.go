@@ -0,0 +1,154 @@
+package s2i
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+
+	"knative.dev/func/pkg/docker"
+	fn "knative.dev/func/pkg/functions"
+	"knative.dev/func/pkg/k8s"
+)
+
+// WithDefaultPlatforms sets the platforms targeted by builds which request
+// none.  Precedence is the platforms requested of the build, then those of
+// EnvBuildPlatforms, then those of the cluster (see WithPlatformsFromCluster),
+// then these, then the builder image as-is (typically that of the host).
+// func.yaml records no platforms.  As S2I builds a single platform per build,
+// at most one platform may be given.
+func WithDefaultPlatforms(platforms []fn.Platform) Option {
+	return func(b *Builder) {
+		b.defaultPlatforms = platforms
+	}
+}
+
+// WithPlatformsFromCluster targets, in builds which request no platforms,
+// those of the nodes of the cluster of the client, on which the function is
+// to be deployed, rather than that of the host (see k8s.NodePlatforms).  As
+// S2I builds a single platform per build, builds for clusters of nodes of
+// several platforms fail with ErrUnsupportedPlatform unless a platform is
+// requested.  Failing to list the nodes, such as for lack of permission, is
+// warned of, and the defaults are targeted.
+func WithPlatformsFromCluster(client kubernetes.Interface) Option {
+	return func(b *Builder) {
+		b.cluster = client
+	}
+}
+
+// WithPlatformResolvedHandler sets a handler invoked, during builds, with
+// each platform targeted and the reference of the builder image resolved for
+// it: the image pinned for the platform in func.yaml, or that of the platform
+// in the index of the builder image (by digest), or the builder image itself
+// if of the platform.  As S2I builds a single platform per build, it is
+// invoked at most once per build, and not when no platform is targeted.
+func WithPlatformResolvedHandler(handler func(requested fn.Platform, resolvedRef string)) Option {
+	return func(b *Builder) {
+		b.platformResolved = handler
+	}
+}
+
+// targetPlatforms returns the platforms targeted by a build requesting the
+// given platforms, per the precedence of WithDefaultPlatforms.  Platforms of
+// no OS are of linux, that of all images S2I builds.
+func (b *Builder) targetPlatforms(ctx context.Context, platforms []fn.Platform) ([]fn.Platform, error) {
+	platforms, err := b.requestedPlatforms(ctx, platforms)
+	if err != nil {
+		return nil, err
+	}
+	return defaultOS(platforms), nil
+}
+
+// requestedPlatforms returns the given platforms or, if none, those of the
+// environment, the cluster or the defaults.
+func (b *Builder) requestedPlatforms(ctx context.Context, platforms []fn.Platform) ([]fn.Platform, error) {
+	if len(platforms) > 0 {
+		return platforms, nil
+	}
+	platforms, err := envPlatforms()
+	if err != nil || len(platforms) > 0 {
+		return platforms, err
+	}
+	if b.cluster != nil {
+		platforms, err = k8s.NodePlatforms(ctx, b.cluster)
+		if err != nil {
+			b.logf(LogLevelWarn, "Warning: not targeting the platforms of the cluster: %v", err)
+		} else if len(platforms) > 0 {
+			b.logf(LogLevelDebug, "Targeting the platforms of the nodes of the cluster: %v", platforms)
+			return platforms, nil
+		}
+	}
+	return b.defaultPlatforms, nil
+}
+
+// defaultOS returns the platforms with those of no OS being of linux.  The
+// platforms given are not modified.
+func defaultOS(platforms []fn.Platform) []fn.Platform {
+	if !slices.ContainsFunc(platforms, func(p fn.Platform) bool { return p.OS == "" }) {
+		return platforms
+	}
+	platforms = slices.Clone(platforms)
+	for i := range platforms {
+		if platforms[i].OS == "" {
+			platforms[i].OS = "linux"
+		}
+	}
+	return platforms
+}
+
+// ValidatePlatforms checks, without building, that the builder image of the
+// function can build each of the platforms, such that misconfiguration is
+// found before a lengthy build.  Platforms default as with builds (see
+// WithDefaultPlatforms).  Builder images pinned for a platform in func.yaml
+// are presumed to support it.  The error lists all unsupported platforms, and
+// is an ErrUnsupportedPlatform.
+func (b *Builder) ValidatePlatforms(ctx context.Context, f fn.Function, platforms []fn.Platform) (err error) {
+	if b.profile != "" {
+		if f.Build, err = f.Build.WithProfile(b.profile); err != nil {
+			return wrap(ErrValidation, err)
+		}
+	}
+	if platforms, err = b.targetPlatforms(ctx, platforms); err != nil {
+		return wrap(ErrValidation, err)
+	}
+	builderImage, err := b.builderImage(f, targetPlatform(platforms))
+	if err != nil {
+		return
+	}
+
+	var unsupported []string
+	for _, p := range platforms {
+		platform := strings.ToLower(p.OS + "/" + p.Architecture)
+		if !strings.EqualFold(p.OS, "linux") {
+			unsupported = append(unsupported, platform+" (the S2I builder only supports linux images)")
+			continue
+		}
+		if _, ok := pinnedBuilderImage(f, b.name, platform); ok {
+			continue
+		}
+		_, err = docker.GetPlatformImageContext(ctx, builderImage, platform, b.remoteOptions(ctx)...)
+		var (
+			errMismatch   docker.ErrPlatformMismatch
+			errNotInIndex docker.ErrPlatformNotInIndex
+		)
+		switch {
+		case err == nil:
+		case errors.As(err, &errMismatch):
+			unsupported = append(unsupported, fmt.Sprintf("%s (the image only supports %s)", platform, errMismatch.Supported))
+		case errors.As(err, &errNotInIndex):
+			unsupported = append(unsupported, fmt.Sprintf("%s (available are %s)", platform, strings.Join(errNotInIndex.Available, ", ")))
+		default:
+			if e := builderImageError(builderImage, err); e != nil {
+				return wrap(ErrInvalidBuilderImage, e)
+			}
+			return fmt.Errorf("cannot inspect the platforms of builder image %q: %w", builderImage, err)
+		}
+	}
+	if len(unsupported) > 0 {
+		return wrap(ErrUnsupportedPlatform, fmt.Errorf("builder image %q cannot build the platforms: %s", builderImage, strings.Join(unsupported, "; ")))
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/openshift/source-to-image/pkg/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"knative.dev/func/pkg/builders"
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestValidatePlatforms ensures that the platforms which the index of the
+// builder image lacks are reported together, and that pinned platforms are
+// presumed supported.
+func TestValidatePlatforms(t *testing.T) {
+	builderImage := startRegistry(t) + "/default/builder:latest"
+	pushIndex(t, builderImage, "amd64", "arm64")
+
+	f := fn.Function{
+		Runtime: "node",
+		Build: fn.BuildSpec{
+			BuilderImages: map[string]string{builders.S2I: builderImage},
+			PlatformBuilderImages: map[string]map[string]string{
+				builders.S2I: {"linux/s390x": "example.com/user/builder-s390x:latest"},
+			},
+		},
+	}
+	b := s2i.NewBuilder(s2i.WithName(builders.S2I), s2i.WithDockerClient(mockDocker{}))
+
+	supported := []fn.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}, {OS: "linux", Architecture: "s390x"}}
+	if err := b.ValidatePlatforms(context.Background(), f, supported); err != nil {
+		t.Fatal(err)
+	}
+
+	err := b.ValidatePlatforms(context.Background(), f, append(supported,
+		fn.Platform{OS: "linux", Architecture: "ppc64le"},
+		fn.Platform{OS: "windows", Architecture: "amd64"}))
+	if !errors.Is(err, s2i.ErrUnsupportedPlatform) {
+		t.Fatalf("expected an unsupported platform error, got %v", err)
+	}
+	for _, want := range []string{"linux/ppc64le", "windows/amd64", "linux/amd64, linux/arm64"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to mention %q, got %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "linux/s390x") {
+		t.Errorf("expected the pinned platform to be supported, got %v", err)
+	}
+}
+
+// TestBuildDefaultPlatforms ensures that the platform built is that requested,
+// else that of the environment, else the default, else that of the builder
+// image itself.
+func TestBuildDefaultPlatforms(t *testing.T) {
+	const (
+		builderImage = "example.com/default/builder:latest"
+		amd64        = "example.com/default/builder@sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		arm64        = "example.com/default/builder@sha256:0000000000000000000000000000000000000000000000000000000000000002"
+		s390x        = "example.com/default/builder@sha256:0000000000000000000000000000000000000000000000000000000000000003"
+	)
+
+	tests := []struct {
+		name      string
+		platforms []fn.Platform
+		env       string
+		defaults  []fn.Platform
+		expected  string
+	}{
+		{
+			name:      "requested",
+			platforms: []fn.Platform{{OS: "linux", Architecture: "arm64"}},
+			env:       "linux/s390x",
+			defaults:  []fn.Platform{{OS: "linux", Architecture: "amd64"}},
+			expected:  arm64,
+		},
+		{
+			name:     "environment",
+			env:      "linux/s390x",
+			defaults: []fn.Platform{{OS: "linux", Architecture: "amd64"}},
+			expected: s390x,
+		},
+		{
+			name:     "default",
+			defaults: []fn.Platform{{OS: "linux", Architecture: "amd64"}},
+			expected: amd64,
+		},
+		{
+			name:     "none",
+			expected: builderImage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(s2i.EnvBuildPlatforms, tt.env)
+			f := fn.Function{
+				Runtime: "node",
+				Build: fn.BuildSpec{
+					BuilderImages: map[string]string{builders.S2I: builderImage},
+					PlatformBuilderImages: map[string]map[string]string{
+						builders.S2I: {"linux/amd64": amd64, "linux/arm64": arm64, "linux/s390x": s390x},
+					},
+				},
+			}
+			var got string
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+				got = cfg.BuilderImage
+				return nil, nil
+			}}
+			b := s2i.NewBuilder(s2i.WithName(builders.S2I), s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}),
+				s2i.WithDefaultPlatforms(tt.defaults))
+			if err := b.Build(context.Background(), f, tt.platforms); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Fatalf("expected builder image %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestDefaultPlatformsInvalid ensures that multiple default platforms are
+// rejected, as the S2I builder builds a single platform.
+func TestDefaultPlatformsInvalid(t *testing.T) {
+	b := s2i.NewBuilder(s2i.WithImpl(&mockImpl{}), s2i.WithDockerClient(mockDocker{}),
+		s2i.WithDefaultPlatforms([]fn.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}))
+	err := b.Build(context.Background(), fn.Function{Root: t.TempDir(), Runtime: "node"}, nil)
+	if !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
+
+// TestBuildPlatformsFromCluster ensures that builds requesting no platform
+// target that of the nodes of the cluster, and that clusters of nodes of
+// several platforms are reported as unsupported.
+func TestBuildPlatformsFromCluster(t *testing.T) {
+	t.Setenv(s2i.EnvBuildPlatforms, "")
+	node := func(name, arch string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{OperatingSystem: "linux", Architecture: arch}},
+		}
+	}
+	f := fn.Function{
+		Runtime: "node",
+		Build: fn.BuildSpec{PlatformBuilderImages: map[string]map[string]string{
+			builders.S2I: {"linux/arm64": "example.com/builder-arm64", "linux/amd64": "example.com/builder-amd64"},
+		}},
+	}
+	build := func(client *fake.Clientset) (string, error) {
+		var image string
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			image = cfg.BuilderImage
+			return nil, nil
+		}}
+		b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithPlatformsFromCluster(client))
+		err := b.Build(context.Background(), f, nil)
+		return image, err
+	}
+
+	image, err := build(fake.NewSimpleClientset(node("a", "arm64"), node("b", "arm64")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image != "example.com/builder-arm64" {
+		t.Fatalf("expected the builder image of the cluster's platform, got %q", image)
+	}
+
+	if _, err = build(fake.NewSimpleClientset(node("a", "arm64"), node("b", "amd64"))); !errors.Is(err, s2i.ErrUnsupportedPlatform) {
+		t.Fatalf("expected an unsupported platform error for a cluster of mixed platforms, got %v", err)
+	}
+}
+
+// TestBuildPlatformResolvedHandler ensures that the handler is notified of
+// the requested platform and the builder image resolved for it from the index
+// of the builder image, once per build.
+func TestBuildPlatformResolvedHandler(t *testing.T) {
+	builderImage := startRegistry(t) + "/default/builder:latest"
+	digests := pushIndex(t, builderImage, "amd64", "arm64")
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: builderImage}}}
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		t.Run(arch, func(t *testing.T) {
+			type resolution struct {
+				platform fn.Platform
+				ref      string
+			}
+			var (
+				got     []resolution
+				handler = func(requested fn.Platform, resolvedRef string) {
+					got = append(got, resolution{requested, resolvedRef})
+				}
+				impl     = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+				platform = fn.Platform{OS: "linux", Architecture: arch}
+			)
+			b := s2i.NewBuilder(s2i.WithName(builders.S2I), s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}),
+				s2i.WithPlatformResolvedHandler(handler))
+			if err := b.Build(context.Background(), f, []fn.Platform{platform}); err != nil {
+				t.Fatal(err)
+			}
+			want := []resolution{{platform, strings.TrimSuffix(builderImage, ":latest") + "@" + digests[arch]}}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected resolutions %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+// TestBuildSingleArchMismatch ensures that building a platform other than
+// that of a single-architecture builder image fails, naming both platforms.
+func TestBuildSingleArchMismatch(t *testing.T) {
+	builderImage := startRegistry(t) + "/default/builder:latest"
+	img, err := mutate.ConfigFile(empty.Image, &v1.ConfigFile{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := name.NewTag(builderImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(&tag, img); err != nil {
+		t.Fatal(err)
+	}
+
+	var built bool
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+		built = true
+		return nil, nil
+	}}
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: builderImage}}}
+	b := s2i.NewBuilder(s2i.WithName(builders.S2I), s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}))
+
+	if err = b.Build(context.Background(), f, []fn.Platform{{OS: "linux", Architecture: "amd64"}}); err != nil {
+		t.Fatal(err)
+	}
+	built = false
+	err = b.Build(context.Background(), f, []fn.Platform{{OS: "linux", Architecture: "arm64"}})
+	if !errors.Is(err, s2i.ErrUnsupportedPlatform) {
+		t.Fatalf("expected an unsupported platform error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "is linux/amd64, requested linux/arm64") {
+		t.Errorf("expected the error to name both platforms, got %v", err)
+	}
+	if built {
+		t.Error("expected the wrong platform not to be built")
+	}
+}
+
+// pushIndex pushes an index of random linux images of the architectures to
+// ref, returning the digests of the images by architecture.
+func pushIndex(t *testing.T, ref string, archs ...string) map[string]string {
+	t.Helper()
+	digests := map[string]string{}
+	idx := mutate.IndexMediaType(empty.Index, "application/vnd.oci.image.index.v1+json")
+	for _, arch := range archs {
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[arch] = digest.String()
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+	tag, err := name.NewTag(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.WriteIndex(tag, idx); err != nil {
+		t.Fatal(err)
+	}
+	return digests
+}
@@ -0,0 +1,163 @@
+package s2i
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/openshift/source-to-image/pkg/scm/git"
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+const (
+	// provenanceStatementType is the in-toto statement type of provenance.
+	provenanceStatementType = "https://in-toto.io/Statement/v0.1"
+	// provenancePredicateType is the SLSA predicate type of provenance.
+	provenancePredicateType = "https://slsa.dev/provenance/v0.2"
+	// provenanceBuildType identifies builds of functions by this builder.
+	provenanceBuildType = "https://knative.dev/func/builders/s2i@v1"
+)
+
+// provenance is a SLSA provenance statement describing how an image was
+// built (see WithProvenance).
+type provenance struct {
+	Type          string               `json:"_type"`
+	PredicateType string               `json:"predicateType"`
+	Subject       []provenanceMaterial `json:"subject"`
+	Predicate     provenancePredicate  `json:"predicate"`
+}
+
+// provenanceMaterial is an artifact which was built, or used to build.
+type provenanceMaterial struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+// provenancePredicate is the SLSA v0.2 provenance predicate.
+type provenancePredicate struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType string               `json:"buildType"`
+	Metadata  provenanceMetadata   `json:"metadata"`
+	Materials []provenanceMaterial `json:"materials"`
+}
+
+// provenanceMetadata describes the build.
+type provenanceMetadata struct {
+	BuildStartedOn  time.Time `json:"buildStartedOn"`
+	BuildFinishedOn time.Time `json:"buildFinishedOn"`
+	Completeness    struct {
+		Parameters  bool `json:"parameters"`
+		Environment bool `json:"environment"`
+		Materials   bool `json:"materials"`
+	} `json:"completeness"`
+	Reproducible bool `json:"reproducible"`
+}
+
+// newProvenance of an image built from the function's source with the given
+// builder image.  Digests are of the form "algorithm:hex".
+func (b *Builder) newProvenance(f fn.Function, image, imageDigest, builderImage, builderDigest string, started time.Time) provenance {
+	p := provenance{
+		Type:          provenanceStatementType,
+		PredicateType: provenancePredicateType,
+		Subject:       []provenanceMaterial{{Name: image, Digest: digestSet(imageDigest)}},
+	}
+	p.Predicate.Builder.ID = "https://knative.dev/func/builders/" + b.name + "@" + builderDigest
+	p.Predicate.BuildType = provenanceBuildType
+	p.Predicate.Metadata.BuildStartedOn = started.UTC()
+	p.Predicate.Metadata.BuildFinishedOn = time.Now().UTC()
+
+	if m, ok := sourceMaterial(f.Root); ok {
+		p.Predicate.Materials = append(p.Predicate.Materials, m)
+	}
+	builderURI := "pkg:docker/" + builderImage
+	if ref, err := name.ParseReference(builderImage); err == nil {
+		builderURI = "pkg:docker/" + ref.Context().Name()
+	}
+	p.Predicate.Materials = append(p.Predicate.Materials, provenanceMaterial{
+		URI:    builderURI,
+		Digest: digestSet(builderDigest),
+	})
+	return p
+}
+
+// sourceMaterial describes the commit from which the function at root was
+// built, if it is within a git repository.
+func sourceMaterial(root string) (provenanceMaterial, bool) {
+	info := git.New(fs.NewFileSystem(), cmd.NewCommandRunner()).GetInfo(root)
+	if info.CommitID == "" {
+		return provenanceMaterial{}, false
+	}
+	location := info.Location
+	if location == "" {
+		location = (&url.URL{Scheme: "file", Path: filepath.ToSlash(root)}).String()
+	}
+	uri := "git+" + location
+	if info.Ref != "" && info.Ref != "HEAD" {
+		uri += "@refs/heads/" + info.Ref
+	}
+	return provenanceMaterial{URI: uri, Digest: map[string]string{"sha1": info.CommitID}}, true
+}
+
+// manifestDigest returns the digest of the manifest of the image, of the
+// given inspection, which identifies it in registries.  Its ID is the digest
+// of its config, but for daemons of the containerd image store, whose IDs
+// are of manifests (or indexes).  Images of the classic image store only
+// have manifests once pushed.
+func manifestDigest(ctx context.Context, client DockerClient, image string, img types.ImageInspect) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse image name: %w", err)
+	}
+	for _, repoDigest := range img.RepoDigests {
+		d, err := name.NewDigest(repoDigest)
+		if err == nil && d.Context().Name() == ref.Context().Name() {
+			return d.DigestStr(), nil
+		}
+	}
+	containerd, err := usesContainerdStore(ctx, client)
+	if err != nil {
+		return "", err
+	}
+	if !containerd {
+		return "", fmt.Errorf("image %q has no manifest digest: images of the classic image store only have one once pushed", image)
+	}
+	return img.ID, nil
+}
+
+// digestSet converts a digest of the form "algorithm:hex" to a SLSA digest
+// set.
+func digestSet(digest string) map[string]string {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok {
+		return map[string]string{}
+	}
+	return map[string]string{algorithm: hex}
+}
+
+// writeProvenance to path, creating its directory if necessary.
+func writeProvenance(path string, p provenance) error {
+	bb, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if err = os.WriteFile(path, bb, 0644); err != nil {
+		return fmt.Errorf("cannot write provenance: %w", err)
+	}
+	return nil
+}
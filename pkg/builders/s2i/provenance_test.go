@@ -0,0 +1,173 @@
+package s2i_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/system"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildProvenance ensures that the provenance written for a build is a
+// SLSA provenance statement naming the built image, by the digest of its
+// manifest, as its subject and the
+// source commit and builder image as its materials.
+func TestBuildProvenance(t *testing.T) {
+	const (
+		imageID   = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		digest    = "sha256:3333333333333333333333333333333333333333333333333333333333333333"
+		builderID = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required")
+	}
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "index.js"), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "."},
+		{"-c", "user.name=alice", "-c", "user.email=alice@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := strings.TrimSpace(string(out))
+
+	var (
+		path = filepath.Join(t.TempDir(), "provenance.json")
+		impl = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		cli  = mockDocker{
+			inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+				if image == s2i.DefaultNodeBuilder {
+					return types.ImageInspect{ID: builderID}, nil, nil
+				}
+				return types.ImageInspect{ID: imageID, RepoDigests: []string{"example.com/alice/fn@" + digest}}, nil, nil
+			},
+		}
+		f = fn.Function{Root: root, Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/fn:latest"}}
+	)
+
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithProvenance(path))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type material struct {
+		Name   string            `json:"name"`
+		URI    string            `json:"uri"`
+		Digest map[string]string `json:"digest"`
+	}
+	var statement struct {
+		Type          string     `json:"_type"`
+		PredicateType string     `json:"predicateType"`
+		Subject       []material `json:"subject"`
+		Predicate     struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+			BuildType string `json:"buildType"`
+			Metadata  struct {
+				BuildStartedOn  time.Time `json:"buildStartedOn"`
+				BuildFinishedOn time.Time `json:"buildFinishedOn"`
+			} `json:"metadata"`
+			Materials []material `json:"materials"`
+		} `json:"predicate"`
+	}
+	if err = json.Unmarshal(bb, &statement); err != nil {
+		t.Fatal(err)
+	}
+
+	if statement.Type != "https://in-toto.io/Statement/v0.1" || statement.PredicateType != "https://slsa.dev/provenance/v0.2" {
+		t.Fatalf("unexpected statement type %q, predicate type %q", statement.Type, statement.PredicateType)
+	}
+	if len(statement.Subject) != 1 || statement.Subject[0].Name != f.Build.Image ||
+		statement.Subject[0].Digest["sha256"] != strings.TrimPrefix(digest, "sha256:") {
+		t.Fatalf("unexpected subject %+v", statement.Subject)
+	}
+	p := statement.Predicate
+	if !strings.HasSuffix(p.Builder.ID, "@"+builderID) || p.BuildType == "" {
+		t.Fatalf("unexpected builder %q, build type %q", p.Builder.ID, p.BuildType)
+	}
+	if p.Metadata.BuildStartedOn.IsZero() || p.Metadata.BuildFinishedOn.Before(p.Metadata.BuildStartedOn) {
+		t.Fatalf("unexpected build times %+v", p.Metadata)
+	}
+	if len(p.Materials) != 2 {
+		t.Fatalf("expected source and builder image materials, got %+v", p.Materials)
+	}
+	if !strings.HasPrefix(p.Materials[0].URI, "git+") || p.Materials[0].Digest["sha1"] != commit {
+		t.Fatalf("unexpected source material %+v", p.Materials[0])
+	}
+	if !strings.HasPrefix(p.Materials[1].URI, "pkg:docker/") ||
+		p.Materials[1].Digest["sha256"] != strings.TrimPrefix(builderID, "sha256:") {
+		t.Fatalf("unexpected builder image material %+v", p.Materials[1])
+	}
+}
+
+// TestBuildProvenanceImageID ensures that the subject of provenance is the
+// ID of the built image for daemons of the containerd image store, whose IDs
+// are of manifests, and that images of the classic image store, whose IDs are
+// of configs, lacking a manifest digest have no provenance.
+func TestBuildProvenanceImageID(t *testing.T) {
+	const imageID = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	for _, containerd := range []bool{true, false} {
+		path := filepath.Join(t.TempDir(), "provenance.json")
+		cli := mockDocker{
+			inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+				return types.ImageInspect{ID: imageID}, nil, nil
+			},
+			info: func(ctx context.Context) (system.Info, error) {
+				if containerd {
+					return system.Info{DriverStatus: [][2]string{{"driver-type", "io.containerd.snapshotter.v1"}}}, nil
+				}
+				return system.Info{}, nil
+			},
+		}
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		f := fn.Function{Root: t.TempDir(), Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/fn:latest"}}
+
+		b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithProvenance(path))
+		err := b.Build(context.Background(), f, nil)
+		if !containerd {
+			if err == nil {
+				t.Fatal("expected an error for an image of the classic image store lacking a manifest digest")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		bb, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(bb), strings.TrimPrefix(imageID, "sha256:")) {
+			t.Fatalf("expected the image ID as the subject digest, got %s", bb)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package s2i
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/net/http/httpproxy"
+	"knative.dev/pkg/ptr"
+)
+
+// ProxyConfig of the requests of a build.
+type ProxyConfig struct {
+	// HTTPProxy is the proxy of http requests.
+	HTTPProxy string
+	// HTTPSProxy is the proxy of https requests.
+	HTTPSProxy string
+	// NoProxy is a comma-separated list of hosts which are not proxied.
+	NoProxy string
+}
+
+// WithProxy sets the proxy of builds in place of that of the environment
+// (HTTP_PROXY, HTTPS_PROXY and NO_PROXY).  It proxies the requests of func
+// to registries and to http(s) scripts urls, unless made by a transport of
+// WithTransport, and is passed to the build as the proxy build args
+// predefined by docker, such that downloads of the assemble step are
+// proxied.  It does not proxy the connection to the daemon building the
+// image (see WithBuildKitAddr), nor the pulls of that daemon, which use the
+// proxies of the environment of func and of the daemon respectively.
+func WithProxy(cfg ProxyConfig) Option {
+	return func(b *Builder) {
+		b.proxy = &cfg
+	}
+}
+
+// proxyConfig returns the proxy of the build: that of WithProxy, or that of
+// the environment (see WithProxy).
+func (b *Builder) proxyConfig() ProxyConfig {
+	if b.proxy != nil {
+		return *b.proxy
+	}
+	env := httpproxy.FromEnvironment()
+	return ProxyConfig{HTTPProxy: env.HTTPProxy, HTTPSProxy: env.HTTPSProxy, NoProxy: env.NoProxy}
+}
+
+// httpTransport returns the transport of the requests of func: that of
+// WithTransport, as-is, or def using the proxy of the build.
+func (b *Builder) httpTransport(def http.RoundTripper) http.RoundTripper {
+	if b.transport != nil {
+		return b.transport
+	}
+	return b.proxyTransport(def)
+}
+
+// proxyTransport returns a transport like t, using the proxy of the build.
+func (b *Builder) proxyTransport(t http.RoundTripper) http.RoundTripper {
+	ht, ok := t.(*http.Transport)
+	if !ok {
+		return t
+	}
+	cfg := b.proxyConfig()
+	proxy := (&httpproxy.Config{HTTPProxy: cfg.HTTPProxy, HTTPSProxy: cfg.HTTPSProxy, NoProxy: cfg.NoProxy}).ProxyFunc()
+	ht = ht.Clone()
+	ht.Proxy = func(r *http.Request) (*url.URL, error) { return proxy(r.URL) }
+	return ht
+}
+
+// remoteOptions of requests to registries, with the credentials of the
+// docker config (see keychain).
+func (b *Builder) remoteOptions(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithTransport(b.httpTransport(remote.DefaultTransport)),
+		remote.WithAuthFromKeychain(b.keychain()),
+	}
+}
+
+// proxyBuildArgs returns the proxy build args of the build, in both cases as
+// predefined by docker.
+func (b *Builder) proxyBuildArgs() map[string]*string {
+	cfg := b.proxyConfig()
+	args := map[string]*string{}
+	for k, v := range map[string]string{"HTTP_PROXY": cfg.HTTPProxy, "HTTPS_PROXY": cfg.HTTPSProxy, "NO_PROXY": cfg.NoProxy} {
+		if v != "" {
+			args[k] = ptr.String(v)
+			args[strings.ToLower(k)] = ptr.String(v)
+		}
+	}
+	return args
+}
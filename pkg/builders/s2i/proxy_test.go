@@ -0,0 +1,163 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders"
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildProxyArgs ensures that the proxy of the environment, or that of
+// WithProxy in its place, is passed to the build as build args.
+func TestBuildProxyArgs(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://env-proxy:3128")
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3129")
+	t.Setenv("NO_PROXY", "internal.example.com")
+
+	tests := []struct {
+		name    string
+		options []s2i.Option
+		want    map[string]string
+	}{
+		{
+			name: "environment",
+			want: map[string]string{
+				"HTTP_PROXY": "http://env-proxy:3128", "http_proxy": "http://env-proxy:3128",
+				"HTTPS_PROXY": "http://env-proxy:3129", "https_proxy": "http://env-proxy:3129",
+				"NO_PROXY": "internal.example.com", "no_proxy": "internal.example.com",
+			},
+		},
+		{
+			name:    "override",
+			options: []s2i.Option{s2i.WithProxy(s2i.ProxyConfig{HTTPSProxy: "http://proxy:8080"})},
+			want:    map[string]string{"HTTPS_PROXY": "http://proxy:8080", "https_proxy": "http://proxy:8080"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args map[string]*string
+			cli := mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+				args = options.BuildArgs
+				_, _ = io.Copy(io.Discard, context)
+				return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+			}}
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+			options := append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli)}, tt.options...)
+			if err := s2i.NewBuilder(options...).Build(context.Background(), fn.Function{Runtime: "node"}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if len(args) != len(tt.want) {
+				t.Fatalf("expected the build args %v, got %d", tt.want, len(args))
+			}
+			for k, v := range tt.want {
+				if args[k] == nil || *args[k] != v {
+					t.Errorf("expected build arg %s=%q, got %v", k, v, args[k])
+				}
+			}
+		})
+	}
+}
+
+// TestBuildProxyRegistry ensures that requests to the registry of a builder
+// image which is not in the daemon use the proxy of the environment, or that
+// of WithProxy in its place.
+func TestBuildProxyRegistry(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		tunnels []string
+	)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodConnect {
+			tunnels = append(tunnels, r.Host)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(proxy.Close)
+
+	tests := []struct {
+		name    string
+		env     string
+		options []s2i.Option
+	}{
+		{name: "environment", env: proxy.URL},
+		{name: "override", env: "http://unused.invalid:3128", options: []s2i.Option{s2i.WithProxy(s2i.ProxyConfig{HTTPSProxy: proxy.URL})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HTTPS_PROXY", tt.env)
+			t.Setenv("NO_PROXY", "")
+			mu.Lock()
+			tunnels = nil
+			mu.Unlock()
+
+			cli := mockDocker{inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+				return types.ImageInspect{}, nil, notFoundErr{}
+			}}
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+			f := fn.Function{Runtime: "node", Build: fn.BuildSpec{
+				BuilderImages: map[string]string{builders.S2I: "registry.example.com/default/builder@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+			}}
+			options := append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli)}, tt.options...)
+			if err := s2i.NewBuilder(options...).Build(context.Background(), f, nil); err == nil {
+				t.Fatal("expected the registry to be unreachable via the proxy")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(tunnels) == 0 || tunnels[0] != "registry.example.com:443" {
+				t.Fatalf("expected a tunnel to the registry via the proxy, got %v", tunnels)
+			}
+		})
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper of a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// TestBuildTransportRegistry ensures that requests to registries are made by
+// the transport of WithTransport, in place of the proxy of the build.
+func TestBuildTransportRegistry(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://unused.invalid:3128")
+	var (
+		mu    sync.Mutex
+		hosts []string
+	)
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		hosts = append(hosts, r.URL.Host)
+		return nil, errors.New("unreachable")
+	})
+	cli := mockDocker{inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+		return types.ImageInspect{}, nil, notFoundErr{}
+	}}
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{
+		BuilderImages: map[string]string{builders.S2I: "registry.example.com/default/builder:latest"},
+	}}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithTransport(transport))
+	if err := b.Build(context.Background(), f, nil); err == nil {
+		t.Fatal("expected the registry to be unreachable via the transport")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hosts) == 0 || hosts[0] != "registry.example.com" {
+		t.Fatalf("expected the registry to be requested via the transport, got %v", hosts)
+	}
+}
@@ -0,0 +1,354 @@
+package s2i
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth/authprovider"
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/tonistiigi/fsutil"
+	fstypes "github.com/tonistiigi/fsutil/types"
+)
+
+// BuildKitTLS secures the connection to a remote BuildKit daemon (see
+// WithBuildKitAddr).  Certificates and keys are paths of PEM files.
+type BuildKitTLS struct {
+	CACert     string // certificate of the CA which signed that of the daemon
+	Cert       string // certificate of the client
+	Key        string // key of the certificate of the client
+	ServerName string // name of the daemon in its certificate, if not that of the address
+}
+
+// WithBuildKitAddr builds the image with the BuildKit daemon at addr, such as
+// "tcp://buildkitd:1234", in place of the docker daemon, offloading builds
+// to a shared BuildKit daemon whose cache they share.  tls, if not nil,
+// secures the connection.
+//
+// Builds are solved by the daemon's gRPC API with the Dockerfile frontend,
+// the context being synchronized by the session of the build, and the
+// registry credentials being those of the docker config.  The daemon has no
+// image store, so the image is pushed to its registry and not loaded (see
+// WithPush and WithLoad, which are required), and the builder image is
+// inspected in its registry.  Resource limits and sessions of the build are
+// those of the docker daemon, and thus not supported, nor are source mounts
+// (see WithSourceMount).
+func WithBuildKitAddr(addr string, tls *BuildKitTLS) Option {
+	return func(b *Builder) {
+		b.buildKitAddr = addr
+		b.buildKitTLS = tls
+	}
+}
+
+// validateBuildKitAddr checks that the options of the builder are supported
+// by remote BuildKit daemons.
+func (b *Builder) validateBuildKitAddr() error {
+	if b.buildKitAddr == "" {
+		return nil
+	}
+	switch {
+	case b.load || !b.push:
+		return errors.New("images built with a remote BuildKit daemon must be pushed and not loaded")
+	case b.buildKit == BuildKitOff:
+		return errors.New("a remote BuildKit daemon can not build with the classic builder")
+	case b.buildMemory > 0 || b.buildCPUs != "" || len(b.ulimits) > 0:
+		return errors.New("resource limits of the build are not supported by remote BuildKit daemons")
+	case b.session != "":
+		return errors.New("BuildKit sessions are not supported by remote BuildKit daemons")
+	case b.sourceMount:
+		return errors.New("the source can not be mounted by remote BuildKit daemons, which can not own it by the S2I user")
+	case b.builderPullPolicy == api.PullNever:
+		return errors.New("remote BuildKit daemons have no images to build with without pulling")
+	}
+	return nil
+}
+
+// remoteBuildKit is the DockerClient of builds with a remote BuildKit daemon
+// (see WithBuildKitAddr).  It has no images, which are thus inspected in
+// their registries, and pulls nothing, the daemon pulling the images it
+// builds with itself.
+type remoteBuildKit struct {
+	addr string
+	tls  *BuildKitTLS
+}
+
+// ImageBuild solves the Dockerfile of the context with the daemon, reporting
+// the status of the solve as the BuildKit traces of the build stream, as the
+// docker daemon does, and a failure as the error of the stream.
+func (r remoteBuildKit) ImageBuild(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	fsys, err := newTarFS(context)
+	if err != nil {
+		return types.ImageBuildResponse{}, fmt.Errorf("cannot read the build context: %w", err)
+	}
+	c, err := client.New(ctx, r.addr, r.clientOpts()...)
+	if err != nil {
+		fsys.Close()
+		return types.ImageBuildResponse{}, fmt.Errorf("cannot connect to the remote BuildKit daemon: %w", err)
+	}
+	opt := solveOpt(options, fsys)
+	opt.Session = []session.Attachable{authprovider.NewDockerAuthProvider(config.LoadDefaultConfigFile(io.Discard), nil)}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer fsys.Close()
+		defer c.Close()
+		_ = pw.CloseWithError(solve(ctx, c, opt, pw))
+	}()
+	return types.ImageBuildResponse{Body: pr, OSType: "linux"}, nil
+}
+
+func (remoteBuildKit) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("image %q is not in a remote BuildKit daemon", image))
+}
+
+func (remoteBuildKit) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+// clientOpts of the connection to the daemon.
+func (r remoteBuildKit) clientOpts() []client.ClientOpt {
+	if r.tls == nil {
+		return nil
+	}
+	opts := []client.ClientOpt{client.WithServerConfig(r.tls.ServerName, r.tls.CACert)}
+	if r.tls.Cert != "" {
+		opts = append(opts, client.WithCredentials(r.tls.Cert, r.tls.Key))
+	}
+	return opts
+}
+
+// solveOpt returns the options of the solve of the Dockerfile of the context
+// fsys with the Dockerfile frontend, per the options of the build.
+func solveOpt(options types.ImageBuildOptions, fsys fsutil.FS) client.SolveOpt {
+	attrs := map[string]string{}
+	if options.Dockerfile != "" {
+		attrs["filename"] = options.Dockerfile
+	}
+	if options.Target != "" {
+		attrs["target"] = options.Target
+	}
+	if options.Platform != "" {
+		attrs["platform"] = options.Platform
+	}
+	if options.PullParent {
+		attrs["image-resolve-mode"] = "pull"
+	}
+	if len(options.ExtraHosts) > 0 {
+		attrs["add-hosts"] = strings.Join(options.ExtraHosts, ",")
+	}
+	for k, v := range options.Labels {
+		attrs["label:"+k] = v
+	}
+	for k, v := range options.BuildArgs {
+		value := ""
+		if v != nil {
+			value = *v
+		}
+		// Attestations are attributes of the frontend, not build args.
+		if kind, ok := strings.CutPrefix(k, "BUILDKIT_ATTEST_"); ok {
+			attrs["attest:"+strings.ToLower(kind)] = value
+			continue
+		}
+		attrs["build-arg:"+k] = value
+	}
+	if options.NoCache {
+		attrs["no-cache"] = ""
+	}
+
+	outputs := options.Outputs
+	if len(outputs) == 0 {
+		outputs = []types.ImageBuildOutput{{Type: "image", Attrs: map[string]string{"name": strings.Join(options.Tags, ","), "push": "true"}}}
+	}
+	exports := make([]client.ExportEntry, 0, len(outputs))
+	for _, o := range outputs {
+		exports = append(exports, client.ExportEntry{Type: o.Type, Attrs: maps.Clone(o.Attrs)})
+	}
+	return client.SolveOpt{
+		Frontend:      "dockerfile.v0",
+		FrontendAttrs: attrs,
+		LocalMounts:   map[string]fsutil.FS{"context": fsys, "dockerfile": fsys},
+		Exports:       exports,
+	}
+}
+
+// solve the build with the client, writing the status of the solve to w as
+// the BuildKit traces of a build stream, the last message of which is the
+// error of a failed build.
+func solve(ctx context.Context, c *client.Client, opt client.SolveOpt, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch := make(chan *client.SolveStatus)
+	solved := make(chan error, 1)
+	go func() {
+		_, err := c.Solve(ctx, nil, opt, ch)
+		solved <- err
+	}()
+
+	enc := json.NewEncoder(w)
+	var err error
+	for status := range ch { // closed by the solve
+		if err != nil {
+			continue // the solve is canceled
+		}
+		for _, sr := range status.Marshal() {
+			if err = writeTrace(enc, sr); err != nil {
+				cancel()
+				break
+			}
+		}
+	}
+	if e := <-solved; e != nil && err == nil {
+		return enc.Encode(jsonmessage.JSONMessage{Error: &jsonmessage.JSONError{Message: e.Error()}})
+	}
+	return err
+}
+
+// writeTrace writes the status as the aux message of a BuildKit trace, as
+// the docker daemon does (see buildKitLogs).
+func writeTrace(enc *json.Encoder, status *controlapi.StatusResponse) error {
+	dt, err := status.Marshal()
+	if err != nil {
+		return err
+	}
+	aux, err := json.Marshal(dt)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(jsonmessage.JSONMessage{ID: buildKitTraceID, Aux: (*json.RawMessage)(&aux)})
+}
+
+// tarFS is the fsutil.FS of a build context archive, spooled to a temporary
+// file from which the content of its entries is read in place, such that it
+// is synchronized to the daemon without being extracted.
+type tarFS struct {
+	f       *os.File
+	entries []tarEntry     // in the order of a walk
+	files   map[string]int // entries of regular files by path
+}
+
+// tarEntry of a tarFS: its stat and the offset of its content.
+type tarEntry struct {
+	stat   *fstypes.Stat
+	offset int64
+}
+
+// newTarFS spools the archive r to a temporary file, indexing its entries.
+func newTarFS(r io.Reader) (*tarFS, error) {
+	f, err := os.CreateTemp("", "func-buildkit-context")
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(f.Name()) // removed once closed
+	t := &tarFS{f: f}
+	if _, err = io.Copy(f, r); err != nil {
+		t.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Close()
+		return nil, err
+	}
+	// The tar reader reads no further than the header of an entry, such that
+	// the offset of the file after it is that of the entry's content.
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Close()
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		if !fs.ValidPath(name) || name == "." {
+			t.Close()
+			return nil, fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.entries = append(t.entries, tarEntry{offset: offset, stat: &fstypes.Stat{
+			Path:     filepath.FromSlash(name),
+			Mode:     uint32(hdr.FileInfo().Mode()),
+			Uid:      uint32(hdr.Uid),
+			Gid:      uint32(hdr.Gid),
+			Size_:    hdr.Size,
+			ModTime:  hdr.ModTime.UnixNano(),
+			Linkname: hdr.Linkname,
+		}})
+	}
+	slices.SortFunc(t.entries, func(a, b tarEntry) int {
+		return slices.Compare(strings.Split(filepath.ToSlash(a.stat.Path), "/"), strings.Split(filepath.ToSlash(b.stat.Path), "/"))
+	})
+	t.files = map[string]int{}
+	for i, e := range t.entries {
+		if os.FileMode(e.stat.Mode).IsRegular() {
+			t.files[e.stat.Path] = i
+		}
+	}
+	return t, nil
+}
+
+// Walk the entries at or beneath target, parents before their children.
+func (t *tarFS) Walk(ctx context.Context, target string, fn fs.WalkDirFunc) error {
+	target = filepath.Clean(target)
+	var skipped string // directory whose entries are skipped
+	for _, e := range t.entries {
+		p := e.stat.Path
+		if target != "." && p != target && !strings.HasPrefix(p, target+string(filepath.Separator)) {
+			continue
+		}
+		if skipped != "" && strings.HasPrefix(p, skipped+string(filepath.Separator)) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := fn(p, &fsutil.DirEntryInfo{Stat: e.stat}, nil)
+		if errors.Is(err, filepath.SkipDir) {
+			// The rest of the directory, or of that containing a file, is
+			// skipped.
+			if skipped = p; !os.FileMode(e.stat.Mode).IsDir() {
+				skipped = filepath.Dir(p)
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open the content of the regular file at p.
+func (t *tarFS) Open(p string) (io.ReadCloser, error) {
+	i, ok := t.files[filepath.Clean(p)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	e := t.entries[i]
+	return io.NopCloser(io.NewSectionReader(t.f, e.offset, e.stat.Size_)), nil
+}
+
+// Close removes the spooled archive.
+func (t *tarFS) Close() error {
+	return t.f.Close()
+}
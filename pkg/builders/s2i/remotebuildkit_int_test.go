@@ -0,0 +1,50 @@
+//go:build integration
+// +build integration
+
+package s2i_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+	. "knative.dev/func/pkg/testing"
+)
+
+// TestBuildBuildKitAddrIntegration builds a function with the BuildKit daemon
+// at FUNC_TEST_BUILDKIT_ADDR (such as "tcp://localhost:1234"), pushing it to
+// the registry FUNC_TEST_REGISTRY reachable by both the daemon and the test.
+func TestBuildBuildKitAddrIntegration(t *testing.T) {
+	addr, registry := os.Getenv("FUNC_TEST_BUILDKIT_ADDR"), os.Getenv("FUNC_TEST_REGISTRY")
+	if addr == "" || registry == "" {
+		t.Skip("FUNC_TEST_BUILDKIT_ADDR and FUNC_TEST_REGISTRY are required")
+	}
+	root, cleanup := Mktemp(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	f, err := fn.New().Init(fn.Function{Root: root, Runtime: "node", Registry: registry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Build.Image = registry + "/func-buildkit-test:latest"
+
+	b := s2i.NewBuilder(s2i.WithBuildKitAddr(addr, nil), s2i.WithLoad(false), s2i.WithPush(true))
+	if err = b.Build(ctx, f, nil); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(f.Build.Image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = remote.Head(ref, remote.WithContext(ctx)); err != nil {
+		t.Fatalf("expected the image to be pushed: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package s2i
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestTarFS ensures that the fsutil.FS of a build context walks its entries
+// parents first, with the owners of the archive, and reads their content.
+func TestTarFS(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "upload/src/index.js", Mode: 0644, Size: 5, Uid: 1001, Typeflag: tar.TypeReg},
+		{Name: "Dockerfile", Mode: 0644, Size: 9, Typeflag: tar.TypeReg},
+		{Name: "upload/", Mode: 0755, Uid: 1001, Typeflag: tar.TypeDir},
+		{Name: "upload/src/", Mode: 0755, Uid: 1001, Typeflag: tar.TypeDir},
+		{Name: "upload/src-link", Linkname: "src", Typeflag: tar.TypeSymlink},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		content := map[string]string{"Dockerfile": "FROM img\n", "upload/src/index.js": "hello"}[hdr.Name]
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := newTarFS(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	var walked []string
+	err = fsys.Walk(context.Background(), "upload", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if p == filepath.Join("upload", "src", "index.js") && fi.Sys().(interface{ GetUid() uint32 }).GetUid() != 1001 {
+			t.Errorf("expected %v to be owned by 1001", p)
+		}
+		walked = append(walked, filepath.ToSlash(p))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"upload", "upload/src", "upload/src/index.js", "upload/src-link"}; !slices.Equal(walked, want) {
+		t.Errorf("expected to walk %v, got %v", want, walked)
+	}
+
+	r, err := fsys.Open("Dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if bb, err := io.ReadAll(r); err != nil || string(bb) != "FROM img\n" {
+		t.Errorf("expected the content of the Dockerfile, got %q (%v)", bb, err)
+	}
+}
@@ -0,0 +1,133 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/openshift/source-to-image/pkg/api"
+	"google.golang.org/grpc"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// fakeBuildKit is a BuildKit daemon which records the requests of its solves,
+// and fails them as an assemble script would if fail is set.
+type fakeBuildKit struct {
+	controlapi.UnimplementedControlServer
+
+	fail bool
+
+	mu       sync.Mutex
+	requests []*controlapi.SolveRequest
+	logged   map[string]chan struct{} // by ref, closed once its status is sent
+}
+
+// startBuildKit serves a fakeBuildKit, returning it and its address.
+func startBuildKit(t *testing.T) (*fakeBuildKit, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeBuildKit{logged: map[string]chan struct{}{}}
+	srv := grpc.NewServer()
+	controlapi.RegisterControlServer(srv, d)
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+	return d, "tcp://" + l.Addr().String()
+}
+
+func (d *fakeBuildKit) loggedCh(ref string) chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.logged[ref] == nil {
+		d.logged[ref] = make(chan struct{})
+	}
+	return d.logged[ref]
+}
+
+func (d *fakeBuildKit) Solve(ctx context.Context, req *controlapi.SolveRequest) (*controlapi.SolveResponse, error) {
+	d.mu.Lock()
+	d.requests = append(d.requests, req)
+	d.mu.Unlock()
+	select {
+	case <-d.loggedCh(req.Ref):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if d.fail {
+		return nil, errors.New(`process "/bin/sh -c /usr/libexec/s2i/assemble" did not complete successfully: exit code: 3`)
+	}
+	return &controlapi.SolveResponse{}, nil
+}
+
+func (d *fakeBuildKit) Status(req *controlapi.StatusRequest, stream controlapi.Control_StatusServer) error {
+	defer close(d.loggedCh(req.Ref))
+	return stream.Send(&controlapi.StatusResponse{Logs: []*controlapi.VertexLog{{
+		Vertex: "sha256:assemble",
+		Stream: 1,
+		Msg:    []byte("npm ERR! 404 Not Found\n"),
+	}}})
+}
+
+func (d *fakeBuildKit) Session(stream controlapi.Control_SessionServer) error {
+	<-stream.Context().Done()
+	return nil
+}
+
+// TestBuildBuildKitAddr ensures that builds with a remote BuildKit daemon are
+// solved by the daemon with the Dockerfile frontend, pushing the image, and
+// that failures of the assemble script are reported as such.
+func TestBuildBuildKitAddr(t *testing.T) {
+	daemon, addr := startBuildKit(t)
+	registry := startRegistry(t)
+	builderImage := registry + "/default/builder:latest"
+	pushImage(t, builderImage)
+	var (
+		impl = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			return nil, os.WriteFile(cfg.AsDockerfile, []byte("FROM "+cfg.BuilderImage+"\n"), 0644)
+		}}
+		f = fn.Function{Root: t.TempDir(), Runtime: "node", Build: fn.BuildSpec{
+			Image:         registry + "/alice/fn:latest",
+			BuilderImages: map[string]string{"s2i": builderImage},
+		}}
+	)
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithBuildKitAddr(addr, nil), s2i.WithLoad(false), s2i.WithPush(true))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(daemon.requests) != 1 {
+		t.Fatalf("expected a solve, got %d", len(daemon.requests))
+	}
+	req := daemon.requests[0]
+	if req.Frontend != "dockerfile.v0" {
+		t.Errorf("expected the Dockerfile frontend, got %q", req.Frontend)
+	}
+	if req.Session == "" {
+		t.Error("expected the solve to be of a session, which synchronizes its context")
+	}
+	if len(req.Exporters) != 1 || req.Exporters[0].Type != "image" ||
+		req.Exporters[0].Attrs["name"] != f.Build.Image || req.Exporters[0].Attrs["push"] != "true" {
+		t.Errorf("expected the image to be pushed as %q, got %v", f.Build.Image, req.Exporters)
+	}
+
+	daemon.fail = true
+	err := b.Build(context.Background(), f, nil)
+	var errAssemble s2i.AssembleError
+	if !errors.As(err, &errAssemble) || errAssemble.ExitCode != 3 || !strings.Contains(errAssemble.Output, "npm ERR! 404 Not Found") {
+		t.Fatalf("expected an AssembleError of exit code 3, got %v", err)
+	}
+
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithBuildKitAddr(addr, nil))
+	if err = b.Build(context.Background(), f, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error for a loaded image, got %v", err)
+	}
+}
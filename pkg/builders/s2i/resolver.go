@@ -0,0 +1,68 @@
+package s2i
+
+import (
+	"strings"
+
+	"knative.dev/func/pkg/builders"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// BuilderImageResolver resolves the builder image of functions which define
+// none in their func.yaml, such that organizations can implement their own
+// policies (mirrors, digest pinning, per-team registries) without modifying
+// DefaultBuilderImages.
+type BuilderImageResolver interface {
+	// Resolve returns the reference of the builder image of the function of
+	// the runtime, for the platform targeted (os/arch, lowercase) or "" if
+	// the build targets none.  It returns "" if it has no builder image for
+	// the runtime.
+	Resolve(runtime, platform string, f fn.Function) (string, error)
+}
+
+// DefaultBuilderImageResolver resolves builder images per
+// DefaultBuilderImages, regardless of the platform.
+type DefaultBuilderImageResolver struct{}
+
+// Resolve returns the default builder image of the runtime.
+func (DefaultBuilderImageResolver) Resolve(runtime, platform string, f fn.Function) (string, error) {
+	return DefaultBuilderImages[runtime], nil
+}
+
+// WithImageResolver resolves the builder images of functions which define
+// none in their func.yaml, nor via EnvBuilderImage, with r in place of
+// DefaultBuilderImageResolver.
+func WithImageResolver(r BuilderImageResolver) Option {
+	return func(b *Builder) {
+		b.imageResolver = r
+	}
+}
+
+// resolveBuilderImage returns the builder image of the function: that of its
+// func.yaml, or that resolved by r for its runtime and the platform, per
+// builders.Image.
+func resolveBuilderImage(f fn.Function, builderName, platform string, r BuilderImageResolver) (string, error) {
+	var defaults map[string]string
+	if _, ok := f.Build.BuilderImages[builderName]; !ok && f.Runtime != "" {
+		image, err := r.Resolve(f.Runtime, platform, f)
+		if err != nil {
+			return "", wrap(ErrNoBuildImage, err)
+		}
+		if image != "" {
+			defaults = map[string]string{f.Runtime: image}
+		}
+	}
+	image, err := builders.Image(f, builderName, defaults)
+	if err != nil {
+		return "", wrap(ErrNoBuildImage, err)
+	}
+	return image, nil
+}
+
+// targetPlatform returns the platform (os/arch, lowercase) of the single
+// platform targeted, or "" if none or several are.
+func targetPlatform(platforms []fn.Platform) string {
+	if len(platforms) != 1 {
+		return ""
+	}
+	return strings.ToLower(platforms[0].OS + "/" + platforms[0].Architecture)
+}
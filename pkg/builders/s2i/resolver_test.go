@@ -0,0 +1,108 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders"
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// teamResolver resolves builder images of a team's registry, recording the
+// platforms it is asked for.
+type teamResolver struct {
+	platforms []string
+}
+
+func (r *teamResolver) Resolve(runtime, platform string, f fn.Function) (string, error) {
+	r.platforms = append(r.platforms, platform)
+	if runtime == "rust" {
+		return "", nil
+	}
+	return "registry.example.com/team/" + runtime + "-builder", nil
+}
+
+// TestBuildImageResolver ensures that builder images not defined in func.yaml
+// are those of the resolver, given the targeted platform, that runtimes it
+// has no image for yield ErrNoBuildImage, and that the default resolver is
+// that of DefaultBuilderImages.
+func TestBuildImageResolver(t *testing.T) {
+	build := func(f fn.Function, platforms []fn.Platform, options ...s2i.Option) (string, error) {
+		t.Helper()
+		var image string
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			image = cfg.BuilderImage
+			return nil, nil
+		}}
+		options = append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{})}, options...)
+		err := s2i.NewBuilder(options...).Build(context.Background(), f, platforms)
+		return image, err
+	}
+
+	r := &teamResolver{}
+	image, err := build(fn.Function{Runtime: "node"}, nil, s2i.WithImageResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image != "registry.example.com/team/node-builder" {
+		t.Fatalf("expected the builder image of the resolver, got %q", image)
+	}
+
+	// The platform targeted is that of the build, with any image pinned for
+	// it taking precedence.
+	const pinned = "registry.example.com/team/node-builder-amd64"
+	f := fn.Function{Runtime: "node", Build: fn.BuildSpec{PlatformBuilderImages: map[string]map[string]string{builders.S2I: {"linux/amd64": pinned}}}}
+	if image, err = build(f, []fn.Platform{{OS: "linux", Architecture: "amd64"}}, s2i.WithImageResolver(r)); err != nil {
+		t.Fatal(err)
+	}
+	if image != pinned || r.platforms[len(r.platforms)-1] != "linux/amd64" {
+		t.Fatalf("expected the resolver to be given linux/amd64 and the pinned image, got %v and %q", r.platforms, image)
+	}
+
+	// Images of func.yaml are not resolved.
+	r.platforms = nil
+	f = fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: "example.com/builder"}}}
+	if image, err = build(f, nil, s2i.WithImageResolver(r)); err != nil {
+		t.Fatal(err)
+	}
+	if image != "example.com/builder" || len(r.platforms) != 0 {
+		t.Fatalf("expected the image of func.yaml without resolving, got %q", image)
+	}
+
+	if _, err = build(fn.Function{Runtime: "rust"}, nil, s2i.WithImageResolver(r)); !errors.Is(err, s2i.ErrNoBuildImage) {
+		t.Fatalf("expected ErrNoBuildImage for a runtime without an image, got %v", err)
+	}
+
+	if image, err = build(fn.Function{Runtime: "python"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if image != s2i.DefaultBuilderImages["python"] {
+		t.Fatalf("expected the default builder image, got %q", image)
+	}
+}
+
+// TestBuilderImageResolver ensures that the builder image of a builder is
+// that of its resolver, as its builds resolve it, whereas that of
+// BuilderImage is the default.
+func TestBuilderImageResolver(t *testing.T) {
+	f := fn.Function{Runtime: "node"}
+	image, err := s2i.NewBuilder(s2i.WithImageResolver(&teamResolver{})).BuilderImage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image != "registry.example.com/team/node-builder" {
+		t.Fatalf("expected the builder image of the resolver, got %q", image)
+	}
+	if image, err = s2i.BuilderImage(f, builders.S2I); err != nil || image != s2i.DefaultBuilderImages["node"] {
+		t.Fatalf("expected the default builder image, got %q (%v)", image, err)
+	}
+
+	var errNoDefault builders.ErrNoDefaultImage
+	if _, err = s2i.NewBuilder(s2i.WithImageResolver(&teamResolver{})).BuilderImage(fn.Function{Runtime: "rust"}); !errors.As(err, &errNoDefault) {
+		t.Fatalf("expected ErrNoDefaultImage for a runtime without an image, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package s2i
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// buildMinMemory is the least memory limit accepted by the docker daemon.
+const buildMinMemory = 6 * 1024 * 1024
+
+// WithBuildResources constrains the resources of the containers of the build,
+// and thus of the assemble step: mem is the limit of its memory in bytes
+// (including swap, which is thereby disabled), and cpus the set of CPUs on
+// which it may run in the notation of cpusets ("0-3", "0,2").  Zero and ""
+// leave the respective resource unconstrained.
+func WithBuildResources(mem int64, cpus string) Option {
+	return func(b *Builder) {
+		b.buildMemory = mem
+		b.buildCPUs = cpus
+	}
+}
+
+// WithUlimit sets the soft and hard limits of the named resource limit of the
+// containers of the build (as `ulimit` would), such as "nofile" or "nproc",
+// for assemble steps exceeding those of the daemon.  Limits of a name given
+// before are replaced.  Names are those of docker's --ulimit.
+func WithUlimit(name string, soft, hard int64) Option {
+	return func(b *Builder) {
+		b.ulimits = slices.DeleteFunc(b.ulimits, func(u *container.Ulimit) bool { return u.Name == name })
+		b.ulimits = append(b.ulimits, &container.Ulimit{Name: name, Soft: soft, Hard: hard})
+	}
+}
+
+// validateBuildResources returns an error if the build resources are not
+// acceptable to the docker daemon.
+func (b *Builder) validateBuildResources() error {
+	if b.buildMemory != 0 && b.buildMemory < buildMinMemory {
+		return fmt.Errorf("invalid build memory %d: must be at least %d bytes", b.buildMemory, buildMinMemory)
+	}
+	for _, u := range b.ulimits {
+		// Names and limits are validated as docker's --ulimit.
+		if _, err := units.ParseUlimit(fmt.Sprintf("%s=%d:%d", u.Name, u.Soft, u.Hard)); err != nil {
+			return fmt.Errorf("invalid ulimit %s: %w", u.Name, err)
+		}
+	}
+	if b.buildCPUs == "" {
+		return nil
+	}
+	for _, cpus := range strings.Split(b.buildCPUs, ",") {
+		from, to, isRange := strings.Cut(cpus, "-")
+		first, err := strconv.ParseUint(from, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid build cpus %q: %q is not a cpu or range of cpus", b.buildCPUs, cpus)
+		}
+		if !isRange {
+			continue
+		}
+		last, err := strconv.ParseUint(to, 10, 16)
+		if err != nil || last < first {
+			return fmt.Errorf("invalid build cpus %q: %q is not a cpu or range of cpus", b.buildCPUs, cpus)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildResources ensures that the build resources are validated and
+// passed to the build.
+func TestBuildResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		mem      int64
+		cpus     string
+		wantErr  bool
+		wantSwap int64
+	}{
+		{name: "unconstrained"},
+		{name: "constrained", mem: 512 * 1024 * 1024, cpus: "0-3,6", wantSwap: 512 * 1024 * 1024},
+		{name: "negative memory", mem: -1, wantErr: true},
+		{name: "insufficient memory", mem: 1024, wantErr: true},
+		{name: "invalid cpus", cpus: "0-", wantErr: true},
+		{name: "reversed cpus", cpus: "3-1", wantErr: true},
+		{name: "empty cpu", cpus: "0,,1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts types.ImageBuildOptions
+			cli := mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+				opts = options
+				_, _ = io.Copy(io.Discard, context)
+				return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+			}}
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli), s2i.WithBuildResources(tt.mem, tt.cpus))
+			err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil)
+			if tt.wantErr {
+				if !errors.Is(err, s2i.ErrValidation) {
+					t.Fatalf("expected a validation error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.Memory != tt.mem || opts.MemorySwap != tt.wantSwap || opts.CPUSetCPUs != tt.cpus {
+				t.Errorf("expected memory %d, swap %d and cpus %q, got %d, %d and %q",
+					tt.mem, tt.wantSwap, tt.cpus, opts.Memory, opts.MemorySwap, opts.CPUSetCPUs)
+			}
+		})
+	}
+}
+
+// TestBuildUlimits ensures that ulimits reach the build, later limits of a
+// name replacing earlier ones, and that unknown names and soft limits above
+// hard limits are rejected.
+func TestBuildUlimits(t *testing.T) {
+	build := func(options ...s2i.Option) ([]*container.Ulimit, error) {
+		t.Helper()
+		var ulimits []*container.Ulimit
+		cli := mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			ulimits = options.Ulimits
+			_, _ = io.Copy(io.Discard, context)
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+		}}
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+		options = append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli)}, options...)
+		err := s2i.NewBuilder(options...).Build(context.Background(), fn.Function{Runtime: "node"}, nil)
+		return ulimits, err
+	}
+
+	ulimits, err := build(s2i.WithUlimit("nofile", 1024, 2048), s2i.WithUlimit("nproc", 512, 512), s2i.WithUlimit("nofile", 65536, 65536))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*container.Ulimit{{Name: "nproc", Soft: 512, Hard: 512}, {Name: "nofile", Soft: 65536, Hard: 65536}}
+	if !reflect.DeepEqual(ulimits, want) {
+		t.Fatalf("expected ulimits %v, got %v", want, ulimits)
+	}
+
+	for _, o := range []s2i.Option{s2i.WithUlimit("files", 1, 1), s2i.WithUlimit("nofile", 2048, 1024)} {
+		if _, err = build(o); !errors.Is(err, s2i.ErrValidation) {
+			t.Fatalf("expected a validation error, got %v", err)
+		}
+	}
+}
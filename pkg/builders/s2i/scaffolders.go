@@ -0,0 +1,399 @@
+package s2i
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/filesystem"
+	fn "knative.dev/func/pkg/functions"
+	"knative.dev/func/pkg/scaffolding"
+)
+
+// Scaffolder writes the scaffolding of functions of a runtime to outDir, whose
+// layout is that of the .s2i directory of a function: glue code beneath
+// builds/last and any assemble script at bin/assemble.  The config of the
+// build may be adjusted as the scaffolding requires; when scaffolding outside
+// of a build (see Scaffold) changes to it are discarded.
+type Scaffolder func(cfg *api.Config, f fn.Function, outDir string, opts ScaffoldOptions) error
+
+// ScaffoldOptions of the scaffolding of a function, per the options of the
+// builder.  Scaffolders should ignore options they do not support, such that
+// options may be added without breaking them.
+type ScaffoldOptions struct {
+	// Repository is the filesystem of the scaffolding repository from which
+	// glue code is taken: the embedded repository, or that of
+	// WithScaffoldRepository.
+	Repository filesystem.Filesystem
+	// Shell is the path of the POSIX shell of assemble scripts within the
+	// builder image (see WithAssembleShell).
+	Shell string
+	// Transforms to apply, in order, to the glue code written (see
+	// WithScaffoldTransform).
+	Transforms []ScaffoldTransform
+	// Overwrite is the policy for files of the scaffolding directory which
+	// are not scaffolded (see WithScaffoldOverwrite).  Empty is
+	// ScaffoldOverwriteAlways.
+	Overwrite ScaffoldOverwritePolicy
+}
+
+// ScaffoldOverwritePolicy determines the handling of files of the directory
+// of the scaffolding (.s2i/builds/last) which were not scaffolded, such as
+// those placed there by the user, when it is scaffolded anew.
+type ScaffoldOverwritePolicy string
+
+const (
+	// ScaffoldOverwriteAlways removes such files (the default).
+	ScaffoldOverwriteAlways ScaffoldOverwritePolicy = "overwrite"
+	// ScaffoldOverwriteFail fails the build, listing such files.
+	ScaffoldOverwriteFail ScaffoldOverwritePolicy = "fail"
+	// ScaffoldOverwriteSkip preserves such files beside the scaffolding.
+	ScaffoldOverwriteSkip ScaffoldOverwritePolicy = "skip"
+)
+
+// scaffoldOverwritePolicies are those valid.
+var scaffoldOverwritePolicies = []ScaffoldOverwritePolicy{ScaffoldOverwriteAlways, ScaffoldOverwriteFail, ScaffoldOverwriteSkip}
+
+// ScaffoldTransform returns the content of the scaffolding file at path, as
+// transformed, given its content as written.  The path is relative to the
+// scaffolding, which fsys provides as written, and uses forward slashes.
+type ScaffoldTransform func(fsys fs.FS, path string, content []byte) ([]byte, error)
+
+var (
+	scaffoldersMu sync.RWMutex
+	scaffolders   = map[string]Scaffolder{
+		"go":         scaffoldGo,
+		"typescript": scaffoldTypeScript,
+	}
+)
+
+// RegisterScaffolder registers the scaffolder of functions of the runtime,
+// replacing any registered before.  Functions of runtimes without a
+// scaffolder are built as-is.
+func RegisterScaffolder(runtime string, s Scaffolder) {
+	scaffoldersMu.Lock()
+	defer scaffoldersMu.Unlock()
+	scaffolders[runtime] = s
+}
+
+// WithScaffoldRepository takes the glue code of scaffolding from the template
+// repository at uri (any accepted by fn.NewRepository) in place of the
+// embedded repository, such that organizations can customize the middleware
+// glue.  The repository must provide <runtime>/scaffolding and certs as the
+// embedded repository does.  Remote repositories are cloned once per process.
+func WithScaffoldRepository(uri string) Option {
+	return func(b *Builder) {
+		b.scaffoldRepository = uri
+	}
+}
+
+// invokeModes are the invocation modes of functions (f.Invoke), of which the
+// scaffolding glues the corresponding signature.
+var invokeModes = []string{"http", "cloudevent"}
+
+// WithInvoke scaffolds the function as if invoked per mode ("http" or
+// "cloudevent") in place of that of its func.yaml, such that template authors
+// can verify that both signatures build.  Only the scaffolding is affected.
+func WithInvoke(mode string) Option {
+	return func(b *Builder) {
+		b.invoke = mode
+	}
+}
+
+// WithScaffoldOverwrite sets the policy for files of the directory of the
+// scaffolding which were not scaffolded, which are otherwise removed when the
+// function is scaffolded anew.  Files which are scaffolded are always
+// rewritten.
+func WithScaffoldOverwrite(policy ScaffoldOverwritePolicy) Option {
+	return func(b *Builder) {
+		b.scaffoldOverwrite = policy
+	}
+}
+
+// WithScaffoldTransform adds a transform of the glue code of scaffolding, such
+// as one adding build tags or instrumentation imports to main.go, which is
+// applied before the function is assembled.  Transforms compose, each
+// receiving the content as transformed by those added before it.
+func WithScaffoldTransform(t ScaffoldTransform) Option {
+	return func(b *Builder) {
+		b.scaffoldTransforms = append(b.scaffoldTransforms, t)
+	}
+}
+
+// transformScaffolding applies the transforms to each file of the scaffolding
+// in dir.  Links, such as that to the function, are not followed.
+func transformScaffolding(dir string, transforms []ScaffoldTransform) error {
+	if len(transforms) == 0 {
+		return nil
+	}
+	fsys := os.DirFS(dir)
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		for _, t := range transforms {
+			if content, err = t(fsys, p, content); err != nil {
+				return fmt.Errorf("unable to transform scaffolding %s. %w", p, err)
+			}
+		}
+		return os.WriteFile(filepath.Join(dir, filepath.FromSlash(p)), content, info.Mode().Perm())
+	})
+}
+
+// repositoryClones are the filesystems of the template repositories which
+// were cloned, by URI, such that each is cloned once per process rather than
+// by every build (see scaffoldingRepository and WithScriptsRepository).
+var (
+	repositoryClonesMu sync.Mutex
+	repositoryClones   = map[string]filesystem.Filesystem{}
+)
+
+// repositoryFS returns the filesystem of the template repository at uri, or
+// of the embedded repository if uri is "".  Repositories which are cloned are
+// cached; those read from disk are read anew.
+func repositoryFS(uri string) (filesystem.Filesystem, error) {
+	repositoryClonesMu.Lock()
+	defer repositoryClonesMu.Unlock()
+	if fsys, ok := repositoryClones[uri]; ok {
+		return fsys, nil
+	}
+	repo, err := fn.NewRepository("", uri) // default is the embedded fs
+	if err != nil {
+		return nil, err
+	}
+	fsys := repo.FS()
+	if _, cloned := fsys.(filesystem.BillyFilesystem); cloned && uri != "" {
+		repositoryClones[uri] = fsys
+	}
+	return fsys, nil
+}
+
+// scaffoldingRepository returns the filesystem of the scaffolding repository
+// at uri, or of the embedded repository if uri is "".
+func scaffoldingRepository(uri string) (filesystem.Filesystem, error) {
+	fsys, err := repositoryFS(uri)
+	if err != nil {
+		if uri == "" {
+			return nil, fmt.Errorf("unable to load the embedded scaffolding. %w", err)
+		}
+		return nil, fmt.Errorf("unable to load the scaffolding repository %q. %w", uri, err)
+	}
+	return fsys, nil
+}
+
+// scaffolderOf the runtime, or nil if it is not scaffolded.
+func scaffolderOf(runtime string) Scaffolder {
+	scaffoldersMu.RLock()
+	defer scaffoldersMu.RUnlock()
+	return scaffolders[runtime]
+}
+
+// scaffoldGo writes the main which glues together the middleware and the
+// function, and an assemble script building it.
+func scaffoldGo(cfg *api.Config, f fn.Function, outDir string, opts ScaffoldOptions) error {
+	// Scaffolding is written to a staging directory beside builds/last (such
+	// that relative links are equal) and then synchronized to it, leaving
+	// files which are unchanged untouched to preserve build cache hits.
+	appRoot := filepath.Join(outDir, "builds", "last")
+	staging := filepath.Join(outDir, "builds", ".staging")
+	_ = os.RemoveAll(staging)
+	defer os.RemoveAll(staging)
+
+	// The repository contains the scaffolding code itself which glues
+	// together the middleware and a function via main
+	for _, dir := range []string{path.Join(f.Runtime, "scaffolding"), "certs"} {
+		if _, err := opts.Repository.Stat(dir); err != nil {
+			return wrap(ErrValidation, fmt.Errorf("the scaffolding repository lacks %s: %w", dir, err))
+		}
+	}
+
+	// Write scaffolding to builds/last
+	err := scaffolding.Write(staging, f.Root, f.Runtime, f.Invoke, opts.Repository)
+	if err != nil {
+		return scaffoldingError(fmt.Errorf("unable to build due to a scaffold error. %w", err))
+	}
+	if err = transformScaffolding(staging, opts.Transforms); err != nil {
+		return err
+	}
+	written, err := scaffoldedFiles(staging)
+	if err != nil {
+		return err
+	}
+	previous, err := readScaffolded(outDir)
+	if err != nil {
+		return err
+	}
+	if err = keepUserFiles(staging, appRoot, previous, opts.Overwrite); err != nil {
+		return err
+	}
+	if err = syncDir(staging, appRoot); err != nil {
+		return fmt.Errorf("unable to write scaffolding. %w", err)
+	}
+	if err = writeScaffolded(outDir, written); err != nil {
+		return err
+	}
+
+	// Override the assemble script provided in the S2I image.
+	return writeAssembler(cfg, outDir, GoAssembler, opts.Shell)
+}
+
+// scaffoldedRecord is the record, beside builds/last, of the files scaffolded
+// to it, such that they are told from those of the user when it is
+// scaffolded anew, even if no longer scaffolded.
+const scaffoldedRecord = ".scaffolded"
+
+// legacyScaffolding are the files scaffolded to builds/last by versions of
+// func which kept no record: those of the embedded scaffolding and certs.
+var legacyScaffolding = []string{"README.md", "ca-certificates.crt", "f", "go.mod", "go.sum", "main.go"}
+
+// scaffoldedFiles returns the paths, relative and with forward slashes, of
+// the files and directories of the scaffolding in dir.
+func scaffoldedFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, fi fs.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		paths = append(paths, filepath.ToSlash(rel))
+		return err
+	})
+	return paths, err
+}
+
+// readScaffolded returns the set of files recorded as scaffolded to
+// builds/last of outDir, or those of legacy scaffolding if none are.
+func readScaffolded(outDir string) (map[string]bool, error) {
+	paths := legacyScaffolding
+	bb, err := os.ReadFile(filepath.Join(outDir, "builds", scaffoldedRecord))
+	if err == nil {
+		paths = strings.Fields(string(bb))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unable to read the record of the scaffolding. %w", err)
+	}
+	scaffolded := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		scaffolded[p] = true
+	}
+	return scaffolded, nil
+}
+
+// writeScaffolded records the paths as scaffolded to builds/last of outDir.
+func writeScaffolded(outDir string, paths []string) error {
+	var buf strings.Builder
+	for _, p := range paths {
+		buf.WriteString(p + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "builds", scaffoldedRecord), []byte(buf.String()), 0644); err != nil {
+		return fmt.Errorf("unable to record the scaffolding. %w", err)
+	}
+	return nil
+}
+
+// keepUserFiles applies the overwrite policy to the files of dst, a directory
+// of scaffolding, which are neither of src, the scaffolding to be
+// synchronized to it, nor previously scaffolded: such files are moved into
+// src to be preserved, or fail the build.
+func keepUserFiles(src, dst string, scaffolded map[string]bool, policy ScaffoldOverwritePolicy) error {
+	if policy == "" || policy == ScaffoldOverwriteAlways {
+		return nil
+	}
+	var user []string
+	err := filepath.Walk(dst, func(path string, fi fs.FileInfo, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == dst {
+			return filepath.SkipDir // never scaffolded
+		}
+		if err != nil || path == dst {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		if _, err = os.Lstat(filepath.Join(src, rel)); err == nil || scaffolded[filepath.ToSlash(rel)] {
+			return nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		user = append(user, filepath.ToSlash(rel))
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil || len(user) == 0 {
+		return err
+	}
+	if policy == ScaffoldOverwriteFail {
+		return wrap(ErrValidation, fmt.Errorf("the scaffolding directory %s contains files which are not scaffolded: %s", dst, strings.Join(user, ", ")))
+	}
+	for _, rel := range user {
+		// The directory of the file may be one no longer scaffolded.
+		if err = os.MkdirAll(filepath.Dir(filepath.Join(src, rel)), 0755); err != nil {
+			return err
+		}
+		if err = os.Rename(filepath.Join(dst, rel), filepath.Join(src, rel)); err != nil {
+			return fmt.Errorf("unable to preserve %s of the scaffolding directory. %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// scaffoldTypeScript writes an assemble script compiling the function.  An
+// assemble script of the function's own is left in place.
+func scaffoldTypeScript(cfg *api.Config, f fn.Function, outDir string, opts ScaffoldOptions) error {
+	existing, err := os.ReadFile(filepath.Join(outDir, "bin", "assemble"))
+	if err == nil && !strings.Contains(string(existing), typeScriptAssemblerMarker) {
+		return nil
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to read assembler. %w", err)
+	}
+	return writeAssembler(cfg, outDir, TypeScriptAssembler, opts.Shell)
+}
+
+// writeAssembler writes the assemble script, run by shell, to the bin
+// directory of outDir.  The script is executable by all users, as builds on
+// cluster run it as a user other than that which scaffolded it.
+func writeAssembler(cfg *api.Config, outDir, assemble, shell string) error {
+	if shell == "" {
+		shell = DefaultAssembleShell
+	}
+	_, body, _ := strings.Cut(assemble, "\n") // the shebang is replaced
+	assemble = "#!" + shell + "\n" + body
+
+	if err := os.MkdirAll(filepath.Join(outDir, "bin"), 0755); err != nil {
+		return fmt.Errorf("unable to create .s2i bin dir. %w", err)
+	}
+	path := filepath.Join(outDir, "bin", "assemble")
+	if err := os.WriteFile(path, []byte(assemble), 0755); err != nil {
+		return fmt.Errorf("unable to write assembler. %w", err)
+	}
+	// The mode of an existing script is not changed by writing it.
+	if err := os.Chmod(path, 0755); err != nil {
+		return fmt.Errorf("unable to write assembler. %w", err)
+	}
+
+	// Whenever an assemble script is written, of any runtime, we want to force
+	// that the system use the (copy via filesystem) method rather than a
+	// "git clone" method because (other than being faster) the latter appears
+	// to have a bug where the assemble script is ignored.
+	// Maybe this issue is related:
+	// https://github.com/openshift/source-to-image/issues/1141
+	cfg.ForceCopy = true
+	return nil
+}
@@ -0,0 +1,42 @@
+package s2i
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestKeepUserFiles ensures that leftovers of the scaffolding of earlier
+// versions of func, which kept no record of it, are not taken to be files of
+// the user, and that files of the user are.
+func TestKeepUserFiles(t *testing.T) {
+	outDir := t.TempDir()
+	var (
+		src = filepath.Join(outDir, "builds", ".staging")
+		dst = filepath.Join(outDir, "builds", "last")
+	)
+	for _, p := range []string{
+		filepath.Join(src, "main.go"),
+		filepath.Join(dst, "main.go"),
+		filepath.Join(dst, "ca-certificates.crt"), // no longer scaffolded
+		filepath.Join(dst, "notes", "todo.txt"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scaffolded, err := readScaffolded(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keepUserFiles(src, dst, scaffolded, ScaffoldOverwriteFail)
+	if !errors.Is(err, ErrValidation) || !strings.HasSuffix(err.Error(), "not scaffolded: notes") {
+		t.Fatalf("expected a validation error listing only the user's files, got %v", err)
+	}
+}
@@ -0,0 +1,478 @@
+package s2i_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders"
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestRegisterScaffolder ensures that functions of a runtime are scaffolded by
+// its registered scaffolder, both during builds and via Scaffold, and that
+// functions of runtimes without one are not.
+func TestRegisterScaffolder(t *testing.T) {
+	var invoked []string // outDirs of the invocations
+	s2i.RegisterScaffolder("test-scaffolded", func(cfg *api.Config, f fn.Function, outDir string, _ s2i.ScaffoldOptions) error {
+		invoked = append(invoked, outDir)
+		cfg.Environment = append(cfg.Environment, api.EnvironmentSpec{Name: "SCAFFOLDED", Value: "true"})
+		return os.WriteFile(filepath.Join(f.Root, "scaffolded"), nil, 0644)
+	})
+
+	root := t.TempDir()
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+		var scaffolded bool
+		for _, e := range cfg.Environment {
+			scaffolded = scaffolded || e.Name == "SCAFFOLDED"
+		}
+		if !scaffolded || !cfg.KeepSymlinks {
+			t.Errorf("expected the config of the scaffolder, got %+v", cfg)
+		}
+		return nil, nil
+	}}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}))
+	build := fn.BuildSpec{BuilderImages: map[string]string{builders.S2I: "example.com/alice/builder"}}
+	if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "test-scaffolded", Build: build}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(invoked) != 1 || invoked[0] != filepath.Join(root, ".s2i") {
+		t.Fatalf("expected the scaffolder to be invoked for .s2i, got %v", invoked)
+	}
+
+	out := t.TempDir()
+	if err := s2i.Scaffold(fn.Function{Root: root, Runtime: "test-scaffolded"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if len(invoked) != 2 || invoked[1] != out {
+		t.Fatalf("expected the scaffolder to be invoked for %s, got %v", out, invoked)
+	}
+
+	root = t.TempDir()
+	impl.BuildFn = func(cfg *api.Config) (*api.Result, error) { return nil, nil }
+	if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "test-unscaffolded", Build: build}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(invoked) != 2 {
+		t.Fatalf("expected functions of other runtimes not to be scaffolded, got %v", invoked)
+	}
+	err := s2i.Scaffold(fn.Function{Root: root, Runtime: "test-unscaffolded"}, t.TempDir())
+	if !errors.Is(err, s2i.ErrScaffoldingNotSupported) {
+		t.Fatalf("expected ErrScaffoldingNotSupported, got %v", err)
+	}
+}
+
+// TestScaffoldTypeScript ensures that TypeScript functions are built with an
+// assemble script compiling them, unless they define their own, and that
+// Node.js functions are built with the assemble script of the builder image.
+func TestScaffoldTypeScript(t *testing.T) {
+	tests := []struct {
+		runtime  string
+		existing string // assemble script of the function
+		want     string // assemble script of the build
+	}{
+		{runtime: "typescript", want: s2i.TypeScriptAssembler},
+		{runtime: "typescript", existing: "#!/bin/bash\necho custom\n", want: "#!/bin/bash\necho custom\n"},
+		{runtime: "node"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.runtime, func(t *testing.T) {
+			root := t.TempDir()
+			assemble := filepath.Join(root, ".s2i", "bin", "assemble")
+			if tt.existing != "" {
+				if err := os.MkdirAll(filepath.Dir(assemble), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(assemble, []byte(tt.existing), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+				got, err := os.ReadFile(assemble)
+				if err != nil && !os.IsNotExist(err) {
+					return nil, err
+				}
+				if string(got) != tt.want {
+					t.Errorf("expected the assemble script %q, got %q", tt.want, got)
+				}
+				if forceCopy := tt.want == s2i.TypeScriptAssembler; cfg.ForceCopy != forceCopy {
+					t.Errorf("expected force copy %v, got %v", forceCopy, cfg.ForceCopy)
+				}
+				return nil, nil
+			}}
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}))
+			if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: tt.runtime}, nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestTypeScriptAssembler ensures that the TypeScript assembler compiles the
+// function with tsc only if package.json defines no build script, which the
+// assemble script of the builder image runs.
+func TestTypeScriptAssembler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the assembler is a shell script")
+	}
+	// The fakes log their invocations, node succeeding if a build script is
+	// defined, per FAKE_BUILD_SCRIPT.
+	bin := t.TempDir()
+	for name, script := range map[string]string{
+		"assemble": "#!/bin/sh\necho assemble >> \"$FAKE_LOG\"\n",
+		"node":     "#!/bin/sh\n[ -n \"$FAKE_BUILD_SCRIPT\" ]\n",
+		"npm":      "#!/bin/sh\necho npm \"$@\" >> \"$FAKE_LOG\"\n",
+		"npx":      "#!/bin/sh\necho npx \"$@\" >> \"$FAKE_LOG\"\n",
+	} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		name        string
+		buildScript bool
+		want        string
+	}{
+		{name: "build script", buildScript: true, want: "assemble\n"},
+		{name: "tsc", want: "assemble\nnpx -p typescript tsc\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			log := filepath.Join(t.TempDir(), "log")
+			cmd := exec.Command("sh", "-c", s2i.TypeScriptAssembler)
+			cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"),
+				"STI_SCRIPTS_PATH="+bin, "FAKE_LOG="+log)
+			if tt.buildScript {
+				cmd.Env = append(cmd.Env, "FAKE_BUILD_SCRIPT=1")
+			}
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%v: %s", err, out)
+			}
+			got, err := os.ReadFile(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("expected the invocations %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestBuildScaffoldRepository ensures that the glue code of scaffolding is
+// taken from the scaffolding repository, which must provide that of the
+// function's runtime.
+func TestBuildScaffoldRepository(t *testing.T) {
+	repo := t.TempDir()
+	for p, content := range map[string]string{
+		"go/scaffolding/instanced-http/main.go": "package main // custom instanced\n",
+		"go/scaffolding/static-http/main.go":    "package main // custom static\n",
+		"certs/ca.crt":                          "custom ca\n",
+	} {
+		p = filepath.Join(repo, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newRoot := func(t *testing.T) string {
+		root := t.TempDir()
+		impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+		if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+
+	root := newRoot(t)
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+		main, err := os.ReadFile(filepath.Join(root, ".s2i", "builds", "last", "main.go"))
+		if err != nil {
+			return nil, err
+		}
+		if string(main) != "package main // custom instanced\n" {
+			t.Errorf("expected the scaffolding of the repository, got %q", main)
+		}
+		return nil, nil
+	}}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithScaffoldRepository("file://"+filepath.ToSlash(repo)))
+	if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(repo, "go")); err != nil {
+		t.Fatal(err)
+	}
+	root = newRoot(t)
+	err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil)
+	if !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error of a repository lacking go scaffolding, got %v", err)
+	}
+}
+
+// TestBuildScaffoldRepositoryClone ensures that scaffolding repositories
+// which are cloned are cloned once, rather than by every build.
+func TestBuildScaffoldRepositoryClone(t *testing.T) {
+	src := t.TempDir()
+	for p, content := range map[string]string{
+		"go/scaffolding/instanced-http/main.go": "package main // cloned instanced\n",
+		"go/scaffolding/static-http/main.go":    "package main // cloned static\n",
+		"certs/ca.crt":                          "cloned ca\n",
+	} {
+		p = filepath.Join(src, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo, err := git.PlainInit(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wt.AddGlob("."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wt.Commit("initial", &git.CommitOptions{Author: &object.Signature{Name: "alice", Email: "alice@example.com", When: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	// A bare repository, which is cloned rather than read from disk.
+	bare := filepath.Join(t.TempDir(), "scaffolding.git")
+	if _, err = git.PlainClone(bare, true, &git.CloneOptions{URL: src}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := s2i.NewBuilder(s2i.WithImpl(&mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}),
+		s2i.WithDockerClient(mockDocker{}), s2i.WithScaffoldRepository("file://"+filepath.ToSlash(bare)))
+	build := func() string {
+		t.Helper()
+		root := t.TempDir()
+		impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+		if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		main, err := os.ReadFile(filepath.Join(root, ".s2i", "builds", "last", "main.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(main)
+	}
+	if main := build(); main != "package main // cloned instanced\n" {
+		t.Fatalf("expected the scaffolding of the repository, got %q", main)
+	}
+
+	// Were it cloned again, the build would fail.
+	if err = os.RemoveAll(bare); err != nil {
+		t.Fatal(err)
+	}
+	if main := build(); main != "package main // cloned instanced\n" {
+		t.Fatalf("expected the scaffolding of the clone, got %q", main)
+	}
+}
+
+// TestBuildScaffoldTransform ensures that transforms of the scaffolding
+// compose, modifying the generated main before the function is assembled.
+func TestBuildScaffoldTransform(t *testing.T) {
+	root := t.TempDir()
+	impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+	if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	tag := func(fsys fs.FS, path string, content []byte) ([]byte, error) {
+		paths = append(paths, path)
+		if path != "main.go" {
+			return content, nil
+		}
+		return append([]byte("//go:build tracing\n\n"), content...), nil
+	}
+	instrument := func(fsys fs.FS, path string, content []byte) ([]byte, error) {
+		if path != "main.go" {
+			return content, nil
+		}
+		if _, err := fs.Stat(fsys, "go.mod"); err != nil {
+			return nil, err
+		}
+		return bytes.Replace(content, []byte("import ("), []byte("import (\n\t_ \"example.com/instrumentation\""), 1), nil
+	}
+
+	assembled := false
+	b := s2i.NewBuilder(s2i.WithDockerClient(mockDocker{}),
+		s2i.WithScaffoldTransform(tag), s2i.WithScaffoldTransform(instrument),
+		s2i.WithImpl(&mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			assembled = true
+			main, err := os.ReadFile(filepath.Join(root, ".s2i", "builds", "last", "main.go"))
+			if err != nil {
+				return nil, err
+			}
+			if !strings.HasPrefix(string(main), "//go:build tracing\n") || !strings.Contains(string(main), "_ \"example.com/instrumentation\"") {
+				t.Errorf("expected the transformed main, got:\n%s", main)
+			}
+			return nil, nil
+		}}))
+	if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !assembled {
+		t.Fatal("expected the build to proceed")
+	}
+	for _, p := range paths {
+		if strings.HasPrefix(p, "f/") {
+			t.Fatalf("expected the link to the function not to be followed, got %s", p)
+		}
+	}
+}
+
+// TestBuildInvoke ensures that the invoke mode overrides that of the function
+// for the scaffolding, such that the glue differs per mode, and that unknown
+// modes are rejected.
+func TestBuildInvoke(t *testing.T) {
+	root := t.TempDir()
+	impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+	if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	scaffold := func(options ...s2i.Option) (string, error) {
+		t.Helper()
+		var main []byte
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (_ *api.Result, err error) {
+			main, err = os.ReadFile(filepath.Join(root, ".s2i", "builds", "last", "main.go"))
+			return nil, err
+		}}
+		options = append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{})}, options...)
+		err := s2i.NewBuilder(options...).Build(context.Background(), fn.Function{Root: root, Runtime: "go", Invoke: "cloudevent"}, nil)
+		return string(main), err
+	}
+
+	http, err := scaffold(s2i.WithInvoke("http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloudevent, err := scaffold(s2i.WithInvoke("cloudevent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if http == cloudevent {
+		t.Fatalf("expected the glue of the invoke modes to differ, got:\n%s", http)
+	}
+	function, err := scaffold()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if function != cloudevent {
+		t.Fatalf("expected the glue of the function's invoke mode without an override, got:\n%s", function)
+	}
+
+	if _, err = scaffold(s2i.WithInvoke("grpc")); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error for an unknown invoke mode, got %v", err)
+	}
+}
+
+// TestBuildScaffoldOverwrite ensures that files of the scaffolding directory
+// which are not scaffolded are removed, fail the build or are preserved per
+// the overwrite policy, the scaffolding being written regardless.
+func TestBuildScaffoldOverwrite(t *testing.T) {
+	impl := "package f\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+
+	tests := []struct {
+		policy s2i.ScaffoldOverwritePolicy
+		kept   bool
+		fails  bool
+	}{
+		{policy: ""},
+		{policy: s2i.ScaffoldOverwriteAlways},
+		{policy: s2i.ScaffoldOverwriteFail, kept: true, fails: true},
+		{policy: s2i.ScaffoldOverwriteSkip, kept: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+				t.Fatal(err)
+			}
+			last := filepath.Join(root, ".s2i", "builds", "last")
+			if err := os.MkdirAll(filepath.Join(last, "notes"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(last, "notes", "todo.txt"), []byte("mine\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			b := s2i.NewBuilder(s2i.WithImpl(&mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}),
+				s2i.WithDockerClient(mockDocker{}), s2i.WithScaffoldOverwrite(tt.policy))
+			err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil)
+			if tt.fails {
+				if !errors.Is(err, s2i.ErrValidation) || !strings.Contains(err.Error(), "not scaffolded: notes") {
+					t.Fatalf("expected a validation error listing the user's files, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if _, err = os.Stat(filepath.Join(last, "main.go")); err != nil {
+				t.Fatalf("expected the scaffolding to be written: %v", err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(last, "notes", "todo.txt"))
+			if kept := err == nil && string(content) == "mine\n"; kept != tt.kept {
+				t.Fatalf("expected the user's file to be kept: %v, got %v (%v)", tt.kept, kept, err)
+			}
+		})
+	}
+
+	// Files recorded as scaffolded by an earlier build, such as of another
+	// scaffolding repository, are not the user's either.
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := s2i.NewBuilder(s2i.WithImpl(&mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}),
+		s2i.WithDockerClient(mockDocker{}), s2i.WithScaffoldOverwrite(s2i.ScaffoldOverwriteFail))
+	if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	builds := filepath.Join(root, ".s2i", "builds")
+	record, err := os.ReadFile(filepath.Join(builds, ".scaffolded"))
+	if err != nil || !strings.Contains(string(record), "main.go\n") {
+		t.Fatalf("expected the scaffolding to be recorded, got %q (%v)", record, err)
+	}
+	if err = os.WriteFile(filepath.Join(builds, ".scaffolded"), append(record, "stale.go\n"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(builds, "last", "stale.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Build(context.Background(), fn.Function{Root: root, Runtime: "go"}, nil); err != nil {
+		t.Fatalf("expected files recorded as scaffolded to be overwritten, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(builds, "last", "stale.go")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the stale scaffolding to be removed, got %v", err)
+	}
+
+	b = s2i.NewBuilder(s2i.WithImpl(&mockImpl{}), s2i.WithDockerClient(mockDocker{}), s2i.WithScaffoldOverwrite("merge"))
+	if err := b.Build(context.Background(), fn.Function{Root: t.TempDir(), Runtime: "go"}, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error for an unknown policy, got %v", err)
+	}
+}
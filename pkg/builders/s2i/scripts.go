@@ -0,0 +1,226 @@
+package s2i
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/openshift/source-to-image/pkg/api/constants"
+
+	"knative.dev/func/pkg/filesystem"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// WithScriptsRepository overlays the .s2i directory of the template
+// repository at uri (any accepted by fn.NewRepository) onto that of each
+// function built, such that .s2i/bin scripts can be shared among functions.
+// The repository's <runtime>/.s2i directory is used if present, its root
+// .s2i directory otherwise.  Files of the function's own .s2i directory
+// always take precedence, and overlaid files are removed after the build.
+// Repositories which are cloned are cloned once, rather than by every build.
+func WithScriptsRepository(uri string) Option {
+	return func(b *Builder) {
+		b.scriptsRepository = uri
+	}
+}
+
+// overlayScripts writes the files of the .s2i directory of the scripts
+// repository which the function's .s2i directory lacks.  Returned is a
+// function removing the files and directories written.
+func (b *Builder) overlayScripts(f fn.Function) (undo func(), err error) {
+	undo = func() {}
+	if b.scriptsRepository == "" {
+		return
+	}
+	fsys, err := repositoryFS(b.scriptsRepository)
+	if err != nil {
+		return undo, fmt.Errorf("cannot load scripts repository %q: %w", b.scriptsRepository, err)
+	}
+	root := path.Join(f.Runtime, ".s2i")
+	if _, err = fsys.Stat(root); err != nil {
+		root = ".s2i"
+		if _, err = fsys.Stat(root); errors.Is(err, fs.ErrNotExist) {
+			return undo, nil // the repository provides no scripts
+		} else if err != nil {
+			return undo, fmt.Errorf("cannot read scripts repository %q: %w", b.scriptsRepository, err)
+		}
+	}
+
+	// Written paths are recorded such that they are removed, deepest first,
+	// once the build completes (or should the overlay fail).
+	var written []string
+	undo = func() {
+		for _, p := range slices.Backward(written) {
+			_ = os.Remove(p)
+		}
+	}
+	dst := filepath.Join(f.Root, ".s2i")
+	err = fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.FromSlash(root), filepath.FromSlash(p))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if _, err := os.Lstat(target); err == nil {
+			return nil // local files take precedence; existing dirs are merged
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if d.IsDir() {
+			if err := os.Mkdir(target, 0755); err != nil {
+				return err
+			}
+		} else if err := copyScript(fsys, p, target); err != nil {
+			return err
+		}
+		written = append(written, target)
+		return nil
+	})
+	if err != nil {
+		undo()
+		return func() {}, fmt.Errorf("cannot overlay scripts of repository %q: %w", b.scriptsRepository, err)
+	}
+	return undo, nil
+}
+
+// copyScript at p of the filesystem to target, preserving its mode such that
+// scripts remain executable.
+func copyScript(fsys filesystem.Filesystem, p, target string) error {
+	fi, err := fsys.Stat(p)
+	if err != nil {
+		return err
+	}
+	src, err := fsys.Open(p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// urlScripts are the scripts fetched from http(s) scripts urls.
+var urlScripts = []string{constants.Assemble, constants.Run, constants.SaveArtifacts, constants.Usage, constants.AssembleRuntime}
+
+// resolveScriptsURL returns the scripts url of the build for that of the
+// scripts-url label of the builder image.  Supported are image://, file://,
+// http:// and https:// urls.  The scripts of http(s) urls are fetched to dir
+// by func, as S2I does not authenticate, with the credentials of the host in
+// the docker config (for https only) and any query of the url preserved.
+// The url of dir is returned in their place.
+func (b *Builder) resolveScriptsURL(ctx context.Context, scriptsURL, dir string) (string, error) {
+	u, err := url.Parse(scriptsURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid scripts url %q: %w", scriptsURL, err)
+	}
+	switch u.Scheme {
+	case "image", "file":
+		return scriptsURL, nil
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("unsupported scripts url %q: the scheme must be one of image, file, http or https", scriptsURL)
+	}
+
+	var ac registry.AuthConfig
+	if u.Scheme == "https" {
+		if ac, err = b.hostAuth(u.Host); err != nil {
+			b.logf(LogLevelWarn, "Warning: %v", err)
+		}
+	}
+	client := &http.Client{Transport: b.httpTransport(http.DefaultTransport)}
+
+	var fetched int
+	for _, script := range urlScripts {
+		ok, err := fetchScript(ctx, client, u.JoinPath(script), ac, filepath.Join(dir, script))
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			fetched++
+		}
+	}
+	if fetched == 0 {
+		return "", fmt.Errorf("no scripts found at scripts url %q", scriptsURL)
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir)}).String(), nil
+}
+
+// fetchScript at u to path, returning false if it does not exist.
+func fetchScript(ctx context.Context, client *http.Client, u *url.URL, ac registry.AuthConfig, path string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	if ac.Username != "" || ac.Password != "" {
+		req.SetBasicAuth(ac.Username, ac.Password)
+	} else if ac.RegistryToken != "" {
+		req.Header.Set("Authorization", "Bearer "+ac.RegistryToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("cannot fetch script %q: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("cannot fetch script %q: %s", u.Redacted(), resp.Status)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return false, err
+	}
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return false, fmt.Errorf("cannot fetch script %q: %w", u.Redacted(), err)
+	}
+	return true, f.Close()
+}
+
+// WithNormalizeScripts converts CRLF line endings of the S2I scripts of the
+// build context (those of .s2i/bin and any S2I uploads as scripts) to LF, as
+// scripts checked out on Windows may have, which the builder image's shell
+// fails to run (with errors such as "bad interpreter" or "command not
+// found").  Enabled by default.
+func WithNormalizeScripts(enabled bool) Option {
+	return func(b *Builder) {
+		b.normalizeScripts = enabled
+	}
+}
+
+// isScript returns whether p, a path of the build context, is an S2I script.
+func isScript(p string) bool {
+	return strings.HasPrefix(p, constants.UploadScripts+"/") || strings.HasPrefix(p, uploadSrc+"/.s2i/bin/")
+}
+
+// normalizedScript returns the content of the script at path with CRLF line
+// endings converted to LF.
+func normalizedScript(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read script: %w", err)
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), nil
+}
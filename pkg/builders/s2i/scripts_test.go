@@ -0,0 +1,345 @@
+package s2i_test
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+	. "knative.dev/func/pkg/testing"
+)
+
+// TestBuildScriptsRepository ensures that the .s2i directory of the scripts
+// repository is overlaid onto that of the function for the duration of the
+// build, with the files of the function taking precedence.
+func TestBuildScriptsRepository(t *testing.T) {
+	repo := t.TempDir()
+	for p, content := range map[string]string{
+		".s2i/bin/assemble":     "repo assemble",
+		".s2i/bin/run":          "repo run",
+		".s2i/environment":      "REPO=true",
+		"python/.s2i/bin/run":   "repo python run",
+		"python/.s2i/bin/usage": "repo python usage",
+		"node/README.md":        "not scripts",
+	} {
+		p = filepath.Join(repo, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		runtime string
+		local   map[string]string // files of the function's .s2i dir
+		want    map[string]string // files of the function's .s2i dir during the build
+	}{
+		{
+			name:    "overlay",
+			runtime: "node",
+			want:    map[string]string{"bin/assemble": "repo assemble", "bin/run": "repo run", "environment": "REPO=true"},
+		},
+		{
+			name:    "local override",
+			runtime: "node",
+			local:   map[string]string{"bin/run": "local run"},
+			want:    map[string]string{"bin/assemble": "repo assemble", "bin/run": "local run", "environment": "REPO=true"},
+		},
+		{
+			name:    "runtime scripts",
+			runtime: "python",
+			want:    map[string]string{"bin/run": "repo python run", "bin/usage": "repo python usage"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for p, content := range tt.local {
+				p = filepath.Join(root, ".s2i", filepath.FromSlash(p))
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(content), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := treeOf(t, root)
+
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+				got := treeOf(t, filepath.Join(root, ".s2i"))
+				for p, content := range tt.want {
+					if want := "-rwxr-xr-x " + content; got[filepath.FromSlash(p)] != want {
+						t.Errorf("expected .s2i/%s to be %q, got %q", p, want, got[filepath.FromSlash(p)])
+					}
+				}
+				if len(got) != len(tt.want) {
+					t.Errorf("expected the .s2i files %v, got %v", tt.want, got)
+				}
+				return nil, nil
+			}}
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithScriptsRepository("file://"+filepath.ToSlash(repo)))
+			if err := b.Build(context.Background(), fn.Function{Root: root, Runtime: tt.runtime}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			// Overlaid files are removed after the build.
+			after := treeOf(t, root)
+			if len(after) != len(before) {
+				t.Fatalf("expected the function's files to be restored to %v, got %v", before, after)
+			}
+			for p, content := range before {
+				if after[p] != content {
+					t.Errorf("expected %s to be %q after the build, got %q", p, content, after[p])
+				}
+			}
+			if _, err := os.Stat(filepath.Join(root, ".s2i")); len(tt.local) == 0 && !os.IsNotExist(err) {
+				t.Errorf("expected the overlaid .s2i dir to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+// TestBuildScriptsRepositoryCloned ensures that a scripts repository which is
+// cloned is cloned once, its scripts being overlaid by later builds without
+// it being cloned again.
+func TestBuildScriptsRepositoryCloned(t *testing.T) {
+	served := t.TempDir()
+	src := filepath.Join(served, "scripts")
+	if err := os.MkdirAll(filepath.Join(src, ".s2i", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, ".s2i", "bin", "assemble"), []byte("repo assemble"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git.PlainInit(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wt.Add(".s2i"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wt.Commit("scripts", &git.CommitOptions{Author: &object.Signature{Name: "alice", Email: "alice@example.com", When: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	uri := RunGitServer(served, t) + "/scripts"
+
+	root := t.TempDir()
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+		if got := treeOf(t, filepath.Join(root, ".s2i"))[filepath.Join("bin", "assemble")]; !strings.HasSuffix(got, "repo assemble") {
+			t.Errorf("expected the assemble script of the repository to be overlaid, got %q", got)
+		}
+		return nil, nil
+	}}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithScriptsRepository(uri))
+	f := fn.Function{Root: root, Runtime: "node"}
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The repository is no longer served, so it is not cloned anew.
+	if err := os.RemoveAll(src); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatalf("expected the clone of the scripts repository to be reused, got %v", err)
+	}
+}
+
+// TestBuildScriptsURLSchemes ensures that image:// scripts urls are passed to
+// S2I as-is, that the scripts of http(s) urls are fetched, with the
+// credentials of the docker config over https only, and that other schemes
+// are rejected.
+func TestBuildScriptsURLSchemes(t *testing.T) {
+	const username, password = "alice", "s3cr3t"
+
+	handler := func(t *testing.T, wantAuth bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if ok != wantAuth || (ok && (user != username || pass != password)) {
+				t.Errorf("unexpected credentials %q:%q (sent: %v) for %s", user, pass, ok, r.URL)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("ref") != "main" {
+				t.Errorf("expected the query of the scripts url to be preserved, got %s", r.URL)
+			}
+			switch r.URL.Path {
+			case "/s2i/assemble", "/s2i/run":
+				_, _ = w.Write([]byte("#!/bin/sh\necho " + strings.TrimPrefix(r.URL.Path, "/s2i/")))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})
+	}
+	httpServer := httptest.NewServer(handler(t, false))
+	t.Cleanup(httpServer.Close)
+	httpsServer := httptest.NewTLSServer(handler(t, true))
+	t.Cleanup(httpsServer.Close)
+
+	// Credentials of both hosts are configured, but sent only over https.
+	configDir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", configDir)
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	config := `{"auths": {`
+	for i, s := range []*httptest.Server{httpServer, httpsServer} {
+		u, _ := url.Parse(s.URL)
+		if i > 0 {
+			config += ","
+		}
+		config += `"` + u.Host + `": {"auth": "` + auth + `"}`
+	}
+	config += `}}`
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		fetched bool // scripts are fetched to a local dir
+		wantErr bool
+	}{
+		{name: "image", url: "image:///usr/local/s2i"},
+		{name: "http", url: httpServer.URL + "/s2i?ref=main", fetched: true},
+		{name: "https", url: httpsServer.URL + "/s2i?ref=main", fetched: true},
+		{name: "unsupported", url: "ftp://example.com/s2i", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := mockDocker{
+				inspect: func(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+					return types.ImageInspect{
+						Config: &container.Config{Labels: map[string]string{"io.openshift.s2i.scripts-url": tt.url}},
+					}, nil, nil
+				},
+			}
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+				if !tt.fetched {
+					if cfg.ScriptsURL != tt.url {
+						t.Errorf("expected the scripts url %q, got %q", tt.url, cfg.ScriptsURL)
+					}
+					return nil, nil
+				}
+				u, err := url.Parse(cfg.ScriptsURL)
+				if err != nil || u.Scheme != "file" {
+					t.Fatalf("expected a file:// scripts url, got %q", cfg.ScriptsURL)
+				}
+				for _, script := range []string{"assemble", "run"} {
+					bb, err := os.ReadFile(filepath.Join(filepath.FromSlash(u.Path), script))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !strings.HasSuffix(string(bb), "echo "+script) {
+						t.Errorf("unexpected %s script %q", script, bb)
+					}
+				}
+				return nil, nil
+			}}
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(cli),
+				s2i.WithTransport(httpsServer.Client().Transport))
+			err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil)
+			if tt.wantErr {
+				if !errors.Is(err, s2i.ErrInvalidBuilderImage) {
+					t.Fatalf("expected an invalid builder image error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestBuildNormalizeScripts ensures that CRLF line endings of the scripts of
+// the build context are converted to LF, unless disabled, and that other
+// files are archived as-is.
+func TestBuildNormalizeScripts(t *testing.T) {
+	files := map[string]string{
+		"upload/scripts/run":           "#!/bin/sh\r\nexec run\r\n",
+		"upload/src/.s2i/bin/assemble": "#!/bin/sh\r\necho assemble\r\n",
+		"upload/src/handle.js":         "// handle\r\n",
+	}
+
+	build := func(options ...s2i.Option) map[string]string {
+		t.Helper()
+		archived := map[string]string{}
+		cli := mockDocker{build: func(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			tr := tar.NewReader(context)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return types.ImageBuildResponse{}, err
+				}
+				data, err := io.ReadAll(tr)
+				if err != nil {
+					return types.ImageBuildResponse{}, err
+				}
+				archived[hdr.Name] = string(data)
+			}
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+		}}
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			dir := filepath.Dir(cfg.AsDockerfile)
+			for p, content := range files {
+				path := filepath.Join(dir, filepath.FromSlash(p))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					return nil, err
+				}
+				if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		}}
+		options = append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli)}, options...)
+		if err := s2i.NewBuilder(options...).Build(context.Background(), fn.Function{Root: t.TempDir(), Runtime: "node"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		return archived
+	}
+
+	archived := build()
+	for p, want := range map[string]string{
+		"upload/scripts/run":           "#!/bin/sh\nexec run\n",
+		"upload/src/.s2i/bin/assemble": "#!/bin/sh\necho assemble\n",
+		"upload/src/handle.js":         "// handle\r\n",
+	} {
+		if archived[p] != want {
+			t.Errorf("expected %s to be archived as %q, got %q", p, want, archived[p])
+		}
+	}
+
+	archived = build(s2i.WithNormalizeScripts(false))
+	for p, want := range files {
+		if archived[p] != want {
+			t.Errorf("expected %s to be archived as-is without normalization, got %q", p, archived[p])
+		}
+	}
+}
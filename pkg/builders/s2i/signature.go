@@ -0,0 +1,184 @@
+package s2i
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// cosignSignatureAnnotation is the annotation of a layer of a cosign
+// signature image which holds the base64 encoded signature of the layer.
+const cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// SignatureVerifier verifies that an image is signed by a trusted party.
+type SignatureVerifier interface {
+	// Verify the signature of the image, returning an error if it is not
+	// signed as required.
+	Verify(ctx context.Context, image string) error
+}
+
+// ErrSignatureNotVerified indicates that no signature of an image could be
+// verified.
+var ErrSignatureNotVerified = errors.New("no valid signature found")
+
+// CosignVerifier verifies cosign signatures of images made with a key pair.
+// Signatures are located using cosign's tag convention within the
+// repository of the image.  Keyless (Fulcio/Rekor) policies may be supported
+// by providing another implementation of SignatureVerifier.
+type CosignVerifier struct {
+	key      crypto.PublicKey
+	options  []remote.Option
+	defaults bool // options are the defaults, not those given
+}
+
+// NewCosignVerifier creates a verifier of signatures made with the private
+// key of the given PEM encoded public key.  ECDSA, RSA and Ed25519 keys are
+// supported.  Without options, the registry credentials are those of the
+// default keychain or, when verifying builder images, those of the builder
+// (see WithVerifyBuilderSignature).
+func NewCosignVerifier(publicKey []byte, options ...remote.Option) (*CosignVerifier, error) {
+	block, _ := pem.Decode(publicKey)
+	if block == nil {
+		return nil, errors.New("cannot decode public key: no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	defaults := len(options) == 0
+	if defaults {
+		options = []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	}
+	return &CosignVerifier{key: key, options: options, defaults: defaults}, nil
+}
+
+// verifier of the signature of builder images: that of the builder, with the
+// registry options of the builder if a CosignVerifier of default options.
+func (b *Builder) verifier(ctx context.Context) SignatureVerifier {
+	if v, ok := b.signatureVerifier.(*CosignVerifier); ok && v.defaults {
+		c := *v
+		c.options = b.remoteOptions(ctx)
+		return &c
+	}
+	return b.signatureVerifier
+}
+
+// Verify that the image has at least one signature made with the key of the
+// verifier over a payload naming the digest of the image.
+func (v *CosignVerifier) Verify(ctx context.Context, image string) error {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return fmt.Errorf("cannot parse image name: %w", err)
+	}
+	options := append([]remote.Option{remote.WithContext(ctx)}, v.options...)
+	desc, err := remote.Head(ref, options...)
+	if err != nil {
+		return fmt.Errorf("cannot get image digest: %w", err)
+	}
+	digest := desc.Digest.String()
+
+	sigRef := ref.Context().Tag(strings.Replace(digest, ":", "-", 1) + ".sig")
+	sigImg, err := remote.Image(sigRef, options...)
+	if err != nil {
+		return fmt.Errorf("cannot get signatures %q: %w", sigRef, err)
+	}
+	manifest, err := sigImg.Manifest()
+	if err != nil {
+		return fmt.Errorf("cannot get signatures manifest: %w", err)
+	}
+
+	for _, l := range manifest.Layers {
+		sig, err := base64.StdEncoding.DecodeString(l.Annotations[cosignSignatureAnnotation])
+		if err != nil || len(sig) == 0 {
+			continue
+		}
+		layer, err := sigImg.LayerByDigest(l.Digest)
+		if err != nil {
+			return fmt.Errorf("cannot get signature payload: %w", err)
+		}
+		payload, err := readLayer(layer.Compressed)
+		if err != nil {
+			return fmt.Errorf("cannot read signature payload: %w", err)
+		}
+		if v.verifySignature(payload, sig) && payloadDigest(payload) == digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w for %s", ErrSignatureNotVerified, ref.Context().Digest(digest))
+}
+
+// verifySignature of the payload with the key of the verifier.
+func (v *CosignVerifier) verifySignature(payload, sig []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch key := v.key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, hash[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, sig)
+	}
+	return false
+}
+
+// payloadDigest returns the image digest named by a cosign signature payload
+// (a "simple signing" document).
+func payloadDigest(payload []byte) string {
+	var p struct {
+		Critical struct {
+			Image struct {
+				Digest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return ""
+	}
+	return p.Critical.Image.Digest
+}
+
+func readLayer(open func() (io.ReadCloser, error)) ([]byte, error) {
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// pinImage returns the reference of the image by the digest it has in its
+// registry.  References by digest are returned as-is.
+func (b *Builder) pinImage(ctx context.Context, image string) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return image, fmt.Errorf("cannot parse image name: %w", err)
+	}
+	if _, ok := ref.(name.Digest); ok {
+		return image, nil
+	}
+	desc, err := remote.Head(ref, b.remoteOptions(ctx)...)
+	if err != nil {
+		return image, err
+	}
+	return ref.Context().Digest(desc.Digest.String()).String(), nil
+}
@@ -0,0 +1,170 @@
+package s2i_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// mockVerifier is a SignatureVerifier which records the images verified.
+type mockVerifier struct {
+	verified []string
+	err      error
+}
+
+func (v *mockVerifier) Verify(ctx context.Context, image string) error {
+	v.verified = append(v.verified, image)
+	return v.err
+}
+
+// TestBuildVerifyBuilderSignature ensures that the builder image is verified
+// before the build, by digest, that the build uses the image verified, and
+// that failed verification fails the build.
+func TestBuildVerifyBuilderSignature(t *testing.T) {
+	builderImage := startRegistry(t) + "/default/builder:latest"
+	digest := pushImage(t, builderImage)
+	pinned := strings.TrimSuffix(builderImage, ":latest") + "@" + digest
+
+	var (
+		built string // builder image of the build
+		impl  = &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { built = cfg.BuilderImage; return nil, nil }}
+		f     = fn.Function{Runtime: "node", Build: fn.BuildSpec{BuilderImages: map[string]string{"s2i": builderImage}}}
+	)
+
+	v := &mockVerifier{}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithVerifyBuilderSignature(v))
+	if err := b.Build(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.verified) != 1 || v.verified[0] != pinned || built != pinned {
+		t.Fatalf("expected builder image %q to be verified and built, got %v and %q", pinned, v.verified, built)
+	}
+
+	built = ""
+	v = &mockVerifier{err: s2i.ErrSignatureNotVerified}
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithVerifyBuilderSignature(v))
+	if err := b.Build(context.Background(), f, nil); !errors.Is(err, s2i.ErrSignatureNotVerified) {
+		t.Fatalf("expected a verification error, got %v", err)
+	}
+	if built != "" {
+		t.Fatal("expected the build not to run after failed verification")
+	}
+}
+
+// TestCosignVerifier ensures that an image signed with the key is verified
+// and that an unsigned image, or one signed with another key, is not.
+func TestCosignVerifier(t *testing.T) {
+	var (
+		registry = startRegistry(t)
+		signed   = registry + "/default/builder:signed"
+		unsigned = registry + "/default/builder:unsigned"
+	)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushImage(t, unsigned)
+	digest := pushImage(t, signed)
+	pushSignature(t, signed, digest, key)
+
+	v, err := s2i.NewCosignVerifier(publicKeyPEM(t, key), remote.WithContext(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = v.Verify(context.Background(), signed); err != nil {
+		t.Fatalf("expected signed image to be verified: %v", err)
+	}
+	if err = v.Verify(context.Background(), unsigned); err == nil {
+		t.Fatal("expected unsigned image not to be verified")
+	}
+
+	v, err = s2i.NewCosignVerifier(publicKeyPEM(t, otherKey), remote.WithContext(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = v.Verify(context.Background(), signed); !errors.Is(err, s2i.ErrSignatureNotVerified) {
+		t.Fatalf("expected image signed with another key not to be verified, got %v", err)
+	}
+}
+
+// pushImage pushes a random image, returning its digest.
+func pushImage(t *testing.T, image string) string {
+	t.Helper()
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest.String()
+}
+
+// pushSignature pushes a cosign signature of the image with the given digest.
+func pushSignature(t *testing.T, image, digest string, key *ecdsa.PrivateKey) {
+	t.Helper()
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`,
+		ref.Context().String(), digest))
+	hash := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, types.MediaType("application/vnd.dev.cosign.simplesigning.v1+json")),
+		Annotations: map[string]string{"dev.cosignproject.cosign/signature": base64.StdEncoding.EncodeToString(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(ref.Context().Tag(strings.Replace(digest, ":", "-", 1)+".sig"), img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func publicKeyPEM(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
@@ -0,0 +1,155 @@
+package s2i
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// smokeTestInterval is the interval at which the endpoint of a smoke test is
+// requested until it responds.
+const smokeTestInterval = 250 * time.Millisecond
+
+// smokeTest of the built image.
+type smokeTest struct {
+	port    int
+	path    string
+	timeout time.Duration
+}
+
+// WithSmokeTest runs the built image once built, failing the build with
+// ErrSmokeTest unless, within the timeout, the function responds to a GET of
+// path (such as "/health/readiness") on port with a status other than a
+// server error (5xx).  The port of the container is published to the
+// loopback interface of the host, such that the docker daemon must be local.
+// The container is always removed.  Requires a tagged image which is loaded
+// (see WithLoad) and a docker client capable of running containers.
+func WithSmokeTest(port int, path string, timeout time.Duration) Option {
+	return func(b *Builder) {
+		b.smokeTest = &smokeTest{port: port, path: path, timeout: timeout}
+	}
+}
+
+// smokeTestClient is implemented by docker clients capable of running
+// containers, as is required to smoke test the image.
+type smokeTestClient interface {
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, container string, options container.StartOptions) error
+	ContainerInspect(ctx context.Context, container string) (types.ContainerJSON, error)
+	ContainerRemove(ctx context.Context, container string, options container.RemoveOptions) error
+}
+
+// validate the smoke test.
+func (s *smokeTest) validate(load bool) error {
+	if s.port < 1 || s.port > 65535 {
+		return fmt.Errorf("invalid smoke test port %d: must be between 1 and 65535", s.port)
+	}
+	if !strings.HasPrefix(s.path, "/") {
+		return fmt.Errorf("invalid smoke test path %q: must start with /", s.path)
+	}
+	if s.timeout <= 0 {
+		return fmt.Errorf("invalid smoke test timeout %s: must be positive", s.timeout)
+	}
+	if !load {
+		return errors.New("a smoke test requires the image to be loaded")
+	}
+	return nil
+}
+
+// runSmokeTest runs the image and requests the endpoint of the smoke test
+// until it responds, the container exits or the timeout elapses.
+func (b *Builder) runSmokeTest(ctx context.Context, client DockerClient, image string) (err error) {
+	sc, ok := client.(smokeTestClient)
+	if !ok {
+		return errors.New("cannot smoke test the image: the docker client does not support containers")
+	}
+	port := nat.Port(strconv.Itoa(b.smokeTest.port) + "/tcp")
+	c, err := sc.ContainerCreate(ctx,
+		&container.Config{Image: image, ExposedPorts: nat.PortSet{port: {}}},
+		&container.HostConfig{PortBindings: nat.PortMap{port: {{HostIP: "127.0.0.1"}}}},
+		nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("cannot create a container of the image %q: %w", image, err)
+	}
+	defer func() {
+		if e := sc.ContainerRemove(context.WithoutCancel(ctx), c.ID, container.RemoveOptions{Force: true}); e != nil && err == nil {
+			err = fmt.Errorf("cannot remove the container %q: %w", c.ID, e)
+		}
+	}()
+	if err = sc.ContainerStart(ctx, c.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("%w: cannot start a container of the image %q: %w", ErrSmokeTest, image, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.smokeTest.timeout)
+	defer cancel()
+	url := ""
+	for {
+		var status int
+		if url, status, err = b.smokeTestRequest(ctx, sc, c.ID, port, url); err == nil {
+			b.logf(LogLevelInfo, "Smoke test of %s passed: %s responded %d", image, url, status)
+			return nil
+		}
+		var exited errContainerExited
+		if errors.As(err, &exited) {
+			return fmt.Errorf("%w: %w", ErrSmokeTest, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: the function did not respond within %s: %w", ErrSmokeTest, b.smokeTest.timeout, err)
+		case <-time.After(smokeTestInterval):
+		}
+	}
+}
+
+// errContainerExited is the error of a smoke test whose container exited.
+type errContainerExited struct {
+	code int
+}
+
+func (e errContainerExited) Error() string {
+	return fmt.Sprintf("the container exited with code %d", e.code)
+}
+
+// smokeTestRequest requests the endpoint of the smoke test once, returning its
+// url (that given, if any, as resolved once the port is published) and the
+// status with which the function responded.
+func (b *Builder) smokeTestRequest(ctx context.Context, sc smokeTestClient, id string, port nat.Port, url string) (string, int, error) {
+	c, err := sc.ContainerInspect(ctx, id)
+	if err != nil {
+		return url, 0, fmt.Errorf("cannot inspect the container: %w", err)
+	}
+	if c.State != nil && !c.State.Running && c.State.Status == "exited" {
+		return url, 0, errContainerExited{code: c.State.ExitCode}
+	}
+	if url == "" {
+		if c.NetworkSettings == nil || len(c.NetworkSettings.Ports[port]) == 0 || c.NetworkSettings.Ports[port][0].HostPort == "" {
+			return url, 0, fmt.Errorf("port %s is not yet published", port)
+		}
+		url = "http://" + net.JoinHostPort("127.0.0.1", c.NetworkSettings.Ports[port][0].HostPort) + b.smokeTest.path
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return url, 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return url, 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return url, resp.StatusCode, fmt.Errorf("%s responded %s", url, resp.Status)
+	}
+	return url, resp.StatusCode, nil
+}
@@ -0,0 +1,109 @@
+package s2i_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// mockRunDocker is a mock docker client capable of running containers, whose
+// published port is hostPort, exiting with exitCode if not running.
+type mockRunDocker struct {
+	mockDocker
+	hostPort string
+	running  bool
+	exitCode int
+	started  []string // ids of the containers started
+	removed  []string // ids of the containers removed
+}
+
+func (m *mockRunDocker) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	return container.CreateResponse{ID: "c1"}, nil
+}
+
+func (m *mockRunDocker) ContainerStart(ctx context.Context, id string, options container.StartOptions) error {
+	m.started = append(m.started, id)
+	return nil
+}
+
+func (m *mockRunDocker) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	state := &types.ContainerState{Running: m.running, Status: "running"}
+	if !m.running {
+		state.Status, state.ExitCode = "exited", m.exitCode
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: id, State: state},
+		NetworkSettings: &types.NetworkSettings{NetworkSettingsBase: types.NetworkSettingsBase{
+			Ports: nat.PortMap{"8080/tcp": {{HostIP: "127.0.0.1", HostPort: m.hostPort}}},
+		}},
+	}, nil
+}
+
+func (m *mockRunDocker) ContainerRemove(ctx context.Context, id string, options container.RemoveOptions) error {
+	m.removed = append(m.removed, id)
+	return nil
+}
+
+// TestBuildSmokeTest ensures that builds fail with ErrSmokeTest unless the
+// function of the image responds, and that its container is always removed.
+func TestBuildSmokeTest(t *testing.T) {
+	serve := func(status int) string {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/health/readiness" {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(s.Close)
+		u, _ := url.Parse(s.URL)
+		return u.Port()
+	}
+
+	tests := []struct {
+		name    string
+		cli     *mockRunDocker
+		timeout time.Duration
+		passes  bool
+	}{
+		{name: "responding", cli: &mockRunDocker{hostPort: serve(http.StatusOK), running: true}, timeout: 5 * time.Second, passes: true},
+		{name: "failing", cli: &mockRunDocker{hostPort: serve(http.StatusServiceUnavailable), running: true}, timeout: 500 * time.Millisecond},
+		{name: "exited", cli: &mockRunDocker{hostPort: serve(http.StatusOK), exitCode: 1}, timeout: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+			b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(tt.cli), s2i.WithSmokeTest(8080, "/health/readiness", tt.timeout))
+			err := b.Build(context.Background(), fn.Function{Runtime: "node", Build: fn.BuildSpec{Image: "example.com/alice/fn:latest"}}, nil)
+			if tt.passes && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.passes && !errors.Is(err, s2i.ErrSmokeTest) {
+				t.Fatalf("expected ErrSmokeTest, got %v", err)
+			}
+			if len(tt.cli.started) != 1 || len(tt.cli.removed) != 1 {
+				t.Fatalf("expected the container to be started and removed, got started %v and removed %v", tt.cli.started, tt.cli.removed)
+			}
+		})
+	}
+
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(&mockRunDocker{}), s2i.WithSmokeTest(0, "/", time.Second))
+	if err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error for an invalid port, got %v", err)
+	}
+}
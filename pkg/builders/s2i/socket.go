@@ -0,0 +1,45 @@
+package s2i
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	dockerClient "github.com/docker/docker/client"
+)
+
+// defaultDockerHost is the host of the docker daemon if DOCKER_HOST is not
+// set.  Replaced in tests.
+var defaultDockerHost = dockerClient.DefaultDockerHost
+
+// dialDockerSocket connects to the unix socket of the docker daemon at path.
+// Replaced in tests.
+var dialDockerSocket = func(path string) error {
+	c, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// checkDockerSocket returns an ErrDockerSocketPermission if host is the unix
+// socket of the docker daemon which the user lacks permission to access,
+// which otherwise surfaces as an obscure error of the first request of the
+// client.  Other failures are left to the client to report.
+func checkDockerSocket(host string) error {
+	u, err := url.Parse(host)
+	if err != nil || u.Scheme != "unix" {
+		return nil
+	}
+	if _, err = os.Stat(u.Path); err == nil {
+		err = dialDockerSocket(u.Path)
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		return nil
+	}
+	return fmt.Errorf("%w at %s: permission denied; add your user to the docker group or use rootless mode", ErrDockerSocketPermission, u.Path)
+}
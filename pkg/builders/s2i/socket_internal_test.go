@@ -0,0 +1,47 @@
+package s2i
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestDockerSocketPermission ensures that a docker socket which exists but
+// which the user may not access fails the build with a friendly error.
+func TestDockerSocketPermission(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	if err := os.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dial := dialDockerSocket
+	t.Cleanup(func() { dialDockerSocket = dial })
+	dialDockerSocket = func(path string) error {
+		return &net.OpError{Op: "dial", Net: "unix", Err: os.NewSyscallError("connect", syscall.EACCES)}
+	}
+	host := defaultDockerHost
+	t.Cleanup(func() { defaultDockerHost = host })
+
+	// The socket is that of DOCKER_HOST, or of the default host if unset.
+	for _, env := range []string{"unix://" + socket, ""} {
+		t.Setenv("DOCKER_HOST", env)
+		defaultDockerHost = "unix://" + socket
+		if env != "" {
+			defaultDockerHost = "unix:///nonexistent/docker.sock"
+		}
+		err := NewBuilder(WithImpl(stubImpl{})).Build(context.Background(), fn.Function{Root: t.TempDir(), Runtime: "node"}, nil)
+		if !errors.Is(err, ErrDockerSocketPermission) {
+			t.Fatalf("expected ErrDockerSocketPermission, got %v", err)
+		}
+		if !strings.Contains(err.Error(), socket) || !strings.Contains(err.Error(), "docker group") {
+			t.Fatalf("expected an actionable error naming the socket, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package s2i
+
+import (
+	"archive/tar"
+	"regexp"
+	"strings"
+)
+
+// WithSourceMount bind-mounts the source of the function from the build
+// context into the assemble step of BuildKit builds, rather than copying it
+// into a layer of its own and changing its ownership in another.  For large
+// sources this saves committing, and thus snapshotting, two layers the size
+// of the source.  Writes to the mount are discarded.  The source is sent to
+// the daemon once, in the build context, which is the only means by which
+// the docker API provides files to builds, owned by the S2I user; builds with
+// a remote BuildKit daemon, whose sources are owned by the user of func, can
+// thus not mount it.  Requires BuildKit.
+func WithSourceMount(enabled bool) Option {
+	return func(b *Builder) {
+		b.sourceMount = enabled
+	}
+}
+
+// sourceMount is the mount of the source of the assemble step.
+const sourceMount = "--mount=type=bind,source=" + uploadSrc + ",target=/tmp/src,rw"
+
+// sourceCopy matches the instructions of Dockerfiles generated by S2I which
+// copy the source into the image and change its ownership to the S2I user.
+var (
+	sourceCopy  = regexp.MustCompile(`(?m)^COPY ` + uploadSrc + ` /tmp/src\n`)
+	sourceChown = regexp.MustCompile(`(?m)^RUN chown -R 1001:0 /tmp/src\n`)
+)
+
+// unstageSource returns the Dockerfile without the instructions copying the
+// source into the image, or false if it has none.
+func unstageSource(dockerfile string) (string, bool) {
+	if !sourceCopy.MatchString(dockerfile) {
+		return dockerfile, false
+	}
+	dockerfile = sourceCopy.ReplaceAllString(dockerfile, "")
+	return sourceChown.ReplaceAllString(dockerfile, ""), true
+}
+
+// ownSource sets the owner of entries of the source in the build context to
+// the S2I user, as the instruction changing the ownership of the copied
+// source would, such that the assemble step can move the files mounted.
+func ownSource(hdr *tar.Header) {
+	if hdr.Name == uploadSrc || strings.HasPrefix(hdr.Name, uploadSrc+"/") {
+		hdr.Uid, hdr.Gid = 1001, 0
+		hdr.Uname, hdr.Gname = "", ""
+	}
+}
@@ -0,0 +1,121 @@
+package s2i_test
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildSourceMount ensures that the source is mounted into the assemble
+// step rather than copied, owned by the S2I user as it would be if copied,
+// and that the build is otherwise that of a copied source.
+func TestBuildSourceMount(t *testing.T) {
+	t.Setenv("DOCKER_BUILDKIT", "")
+	type entry struct {
+		content string
+		uid     int
+	}
+	build := func(t *testing.T, options ...s2i.Option) (dockerfile string, files map[string]entry) {
+		t.Helper()
+		files = map[string]entry{}
+		cli := mockDocker{build: func(ctx context.Context, r io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+			tr := tar.NewReader(r)
+			for {
+				hdr, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return types.ImageBuildResponse{}, err
+				}
+				bb, err := io.ReadAll(tr)
+				if err != nil {
+					return types.ImageBuildResponse{}, err
+				}
+				if hdr.Name == "Dockerfile" {
+					dockerfile = string(bb)
+				} else {
+					files[hdr.Name] = entry{string(bb), hdr.Uid}
+				}
+			}
+			return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader("")), OSType: "linux"}, nil
+		}}
+		impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+			src := filepath.Join(filepath.Dir(cfg.AsDockerfile), "upload", "src")
+			if err := os.MkdirAll(src, 0755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(filepath.Join(src, "handle.js"), []byte("// handle\n"), 0644); err != nil {
+				return nil, err
+			}
+			return nil, os.WriteFile(cfg.AsDockerfile, []byte(s2iDockerfile), 0644)
+		}}
+		options = append([]s2i.Option{s2i.WithImpl(impl), s2i.WithDockerClient(cli)}, options...)
+		if err := s2i.NewBuilder(options...).Build(context.Background(), fn.Function{Runtime: "node"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	copied, copiedContext := build(t, s2i.WithCacheMount(false))
+	mounted, mountedContext := build(t, s2i.WithCacheMount(false), s2i.WithSourceMount(true))
+
+	want := strings.NewReplacer(
+		"COPY upload/src /tmp/src\n", "",
+		"RUN chown -R 1001:0 /tmp/src\n", "",
+		"RUN /usr/libexec/s2i/assemble", "RUN --mount=type=bind,source=upload/src,target=/tmp/src,rw \\\n    /usr/libexec/s2i/assemble",
+	).Replace(copied)
+	if mounted != want {
+		t.Fatalf("expected the Dockerfile:\n%s\ngot:\n%s", want, mounted)
+	}
+
+	if mountedContext["upload/src/handle.js"].uid != 1001 {
+		t.Errorf("expected the mounted source to be owned by the S2I user, got %+v", mountedContext["upload/src/handle.js"])
+	}
+	for name, e := range copiedContext {
+		if strings.HasPrefix(name, "upload/src") {
+			e.uid = 1001
+			copiedContext[name] = e
+		}
+	}
+	if !reflect.DeepEqual(mountedContext, copiedContext) {
+		t.Fatalf("expected the same context, got %v and %v", copiedContext, mountedContext)
+	}
+	// The source is in the context once, and not copied into the image.
+	var sources int
+	for name := range mountedContext {
+		if strings.HasSuffix(name, "handle.js") {
+			sources++
+		}
+	}
+	if sources != 1 || strings.Contains(mounted, "COPY upload/src") {
+		t.Fatalf("expected the source in the context once and not copied, got %d in %v and:\n%s", sources, mountedContext, mounted)
+	}
+
+	// The classic builder cannot mount.
+	impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) { return nil, nil }}
+	b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithSourceMount(true), s2i.WithBuildKit(s2i.BuildKitOff))
+	if err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error with the classic builder, got %v", err)
+	}
+
+	// Nor can remote BuildKit daemons, which can not own the source by the
+	// S2I user.
+	b = s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithSourceMount(true),
+		s2i.WithBuildKitAddr("tcp://buildkitd:1234", nil), s2i.WithLoad(false), s2i.WithPush(true))
+	if err := b.Build(context.Background(), fn.Function{Runtime: "node"}, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error with a remote BuildKit daemon, got %v", err)
+	}
+}
@@ -0,0 +1,332 @@
+package s2i
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+const (
+	// SourceSBOMCycloneDX is the CycloneDX (1.5, JSON) format of source SBOMs.
+	SourceSBOMCycloneDX = "cyclonedx"
+	// SourceSBOMSPDX is the SPDX (2.3, JSON) format of source SBOMs.
+	SourceSBOMSPDX = "spdx"
+)
+
+// SourceSBOMLabel is the label of images built with a source SBOM whose value
+// is the digest ("sha256:<hex>") of the SBOM, such that the image can be
+// related to it.  The digest of an SPDX SBOM is that of it created at the
+// zero time, such that the label, and thus the image, does not change with
+// the time of the build.
+const SourceSBOMLabel = "dev.knative.func.source-sbom"
+
+// WithSourceSBOM generates, before each build, a bill of materials of the
+// dependencies of the function in the format (SourceSBOMCycloneDX or
+// SourceSBOMSPDX) from its dependency manifest: go.mod for go functions,
+// package-lock.json for node and typescript functions and requirements.txt
+// for python functions.  It is written to SourceSBOMPath, and the image is
+// labeled with its digest (see SourceSBOMLabel).  Functions of other runtimes,
+// or lacking the manifest, have no SBOM.  Unlike the SBOM attestations of
+// WithAttestations, which BuildKit generates from the image, the source SBOM
+// neither requires BuildKit nor lists the packages of the builder image.
+func WithSourceSBOM(format string) Option {
+	return func(b *Builder) {
+		b.sourceSBOM = format
+	}
+}
+
+// SourceSBOMPath returns the path of the source SBOM of the function in the
+// format (see WithSourceSBOM): within the .func directory of the function,
+// which is never part of the build context.
+func SourceSBOMPath(f fn.Function, format string) string {
+	name := "sbom.cdx.json"
+	if format == SourceSBOMSPDX {
+		name = "sbom.spdx.json"
+	}
+	return filepath.Join(f.Root, fn.RunDataDir, name)
+}
+
+// dependency of the source of a function.
+type dependency struct {
+	Name    string
+	Version string // "" if not pinned
+	PURL    string // package URL
+}
+
+// writeSourceSBOM writes the source SBOM of the function, if any, returning
+// its digest, or "" if the runtime or function has no dependency manifest.
+func (b *Builder) writeSourceSBOM(f fn.Function, now time.Time) (string, error) {
+	deps, manifest, err := sourceDependencies(f)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the dependencies of the function from %s: %w", manifest, err)
+	}
+	if deps == nil {
+		b.logf(LogLevelDebug, "No source SBOM: no dependency manifest of the %s runtime", f.Runtime)
+		return "", nil
+	}
+
+	// The digest is of the document as of its content alone, such that it is
+	// the same of builds of the same dependencies: SPDX documents are created
+	// as at the zero time to determine it.
+	var doc, content any
+	if b.sourceSBOM == SourceSBOMSPDX {
+		doc, content = spdxDocument(f, deps, now), spdxDocument(f, deps, time.Time{})
+	} else {
+		doc = cycloneDXDocument(f, deps)
+		content = doc
+	}
+	bb, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	cc, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := SourceSBOMPath(f, b.sourceSBOM)
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(path, bb, 0644); err != nil {
+		return "", fmt.Errorf("cannot write the source SBOM: %w", err)
+	}
+	sum := sha256.Sum256(cc)
+	b.logf(LogLevelDebug, "Wrote the source SBOM of %d dependencies to %s", len(deps), path)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// sourceDependencies returns the dependencies of the function, sorted by
+// name, per the dependency manifest of its runtime, which is returned.  Nil
+// is returned if there is no manifest.
+func sourceDependencies(f fn.Function) (deps []dependency, manifest string, err error) {
+	var parse func([]byte) ([]dependency, error)
+	switch f.Runtime {
+	case "go":
+		manifest, parse = "go.mod", goDependencies
+	case "node", "typescript":
+		manifest, parse = "package-lock.json", npmDependencies
+	case "python":
+		manifest, parse = "requirements.txt", pythonDependencies
+	default:
+		return
+	}
+	bb, err := os.ReadFile(filepath.Join(f.Root, manifest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, manifest, nil
+	} else if err != nil {
+		return
+	}
+	if deps, err = parse(bb); err != nil {
+		return
+	}
+	if deps == nil {
+		deps = []dependency{} // a manifest without dependencies
+	}
+	slices.SortFunc(deps, func(a, b dependency) int {
+		return strings.Compare(a.Name+"@"+a.Version, b.Name+"@"+b.Version)
+	})
+	return slices.Compact(deps), manifest, nil
+}
+
+// goDependencies of a go.mod: its requirements, direct and indirect, as
+// replaced by any replace directives other than those of local directories.
+func goDependencies(data []byte) (deps []dependency, err error) {
+	mod, err := modfile.Parse("go.mod", data, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range mod.Require {
+		v := r.Mod
+		for _, rep := range mod.Replace {
+			if rep.Old.Path == v.Path && (rep.Old.Version == "" || rep.Old.Version == v.Version) {
+				v = rep.New
+			}
+		}
+		if v.Version == "" {
+			continue // a local directory, which is of the source itself
+		}
+		deps = append(deps, dependency{Name: v.Path, Version: v.Version, PURL: "pkg:golang/" + v.Path + "@" + v.Version})
+	}
+	return
+}
+
+// npmDependencies of a package-lock.json: its packages (lockfile version 2
+// and later) or dependencies (version 1), nested ones included.
+func npmDependencies(data []byte) (deps []dependency, err error) {
+	type lockDependency struct {
+		Version      string                    `json:"version"`
+		Dependencies map[string]lockDependency `json:"dependencies"`
+	}
+	var lock struct {
+		Packages map[string]struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+		Dependencies map[string]lockDependency `json:"dependencies"`
+	}
+	if err = json.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+	npm := func(name, version string) dependency {
+		return dependency{Name: name, Version: version, PURL: "pkg:npm/" + strings.Replace(name, "@", "%40", 1) + "@" + version}
+	}
+	if lock.Packages != nil {
+		for path, p := range lock.Packages {
+			i := strings.LastIndex(path, "node_modules/")
+			if i < 0 || p.Link {
+				continue // the function itself, or a workspace
+			}
+			name := p.Name
+			if name == "" {
+				name = path[i+len("node_modules/"):]
+			}
+			deps = append(deps, npm(name, p.Version))
+		}
+		return
+	}
+	var walk func(map[string]lockDependency)
+	walk = func(dd map[string]lockDependency) {
+		for name, d := range dd {
+			deps = append(deps, npm(name, d.Version))
+			walk(d.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return
+}
+
+// pythonRequirement matches the name and any pinned version of a requirement
+// of a requirements.txt, after any environment marker is removed.
+var pythonRequirement = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)\s*(?:\[[^\]]*\])?\s*(?:===?\s*([^\s,;]+)\s*$)?`)
+
+// pythonNameSeparators are replaced by "-" in normalized names of python
+// packages (PEP 503).
+var pythonNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// pythonDependencies of a requirements.txt.  Options (such as -r), URLs and
+// paths are not dependencies of a name.
+func pythonDependencies(data []byte) (deps []dependency, err error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		line, _, _ = strings.Cut(line, ";")
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "-") || strings.HasPrefix(line, ".") || strings.HasPrefix(line, "/") || strings.Contains(line, "://") {
+			continue
+		}
+		m := pythonRequirement.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		name := strings.ToLower(pythonNameSeparators.ReplaceAllString(m[1], "-"))
+		purl := "pkg:pypi/" + name
+		if m[2] != "" {
+			purl += "@" + url.PathEscape(m[2])
+		}
+		deps = append(deps, dependency{Name: name, Version: m[2], PURL: purl})
+	}
+	return deps, s.Err()
+}
+
+// cycloneDXDocument lists the dependencies of the function as a CycloneDX
+// BOM.  It has no timestamp or serial number, such that it is reproducible.
+func cycloneDXDocument(f fn.Function, deps []dependency) any {
+	type component struct {
+		Type    string `json:"type"`
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+		PURL    string `json:"purl"`
+	}
+	components := make([]component, 0, len(deps))
+	for _, d := range deps {
+		components = append(components, component{Type: "library", Name: d.Name, Version: d.Version, PURL: d.PURL})
+	}
+	return map[string]any{
+		"bomFormat":   "CycloneDX",
+		"specVersion": "1.5",
+		"version":     1,
+		"metadata": map[string]any{
+			"component": map[string]string{"type": "application", "name": functionName(f)},
+		},
+		"components": components,
+	}
+}
+
+// spdxDocument lists the dependencies of the function as an SPDX document
+// describing the function, created at now, which depends on them.  Files are
+// not analyzed.
+func spdxDocument(f fn.Function, deps []dependency, now time.Time) any {
+	type externalRef struct {
+		Category string `json:"referenceCategory"`
+		Type     string `json:"referenceType"`
+		Locator  string `json:"referenceLocator"`
+	}
+	type pkg struct {
+		SPDXID           string        `json:"SPDXID"`
+		Name             string        `json:"name"`
+		VersionInfo      string        `json:"versionInfo,omitempty"`
+		DownloadLocation string        `json:"downloadLocation"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		ExternalRefs     []externalRef `json:"externalRefs,omitempty"`
+	}
+	type relationship struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}
+	const function = "SPDXRef-Function"
+	name := functionName(f)
+	packages := []pkg{{SPDXID: function, Name: name, DownloadLocation: "NOASSERTION"}}
+	relationships := []relationship{{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", Related: function}}
+	h := sha256.New()
+	for i, d := range deps {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		packages = append(packages, pkg{
+			SPDXID:           id,
+			Name:             d.Name,
+			VersionInfo:      d.Version,
+			DownloadLocation: "NOASSERTION",
+			ExternalRefs:     []externalRef{{Category: "PACKAGE-MANAGER", Type: "purl", Locator: d.PURL}},
+		})
+		relationships = append(relationships, relationship{Element: function, Type: "DEPENDS_ON", Related: id})
+		fmt.Fprintln(h, d.PURL)
+	}
+	return map[string]any{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              name,
+		"documentNamespace": "https://knative.dev/func/spdx/" + url.PathEscape(name) + "-" + hex.EncodeToString(h.Sum(nil))[:16],
+		"creationInfo": map[string]any{
+			"created":  now.UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: knative-func"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+// functionName returns the name of the function, or that of its directory.
+func functionName(f fn.Function) string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return filepath.Base(f.Root)
+}
@@ -0,0 +1,181 @@
+package s2i_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/source-to-image/pkg/api"
+
+	"knative.dev/func/pkg/builders/s2i"
+	fn "knative.dev/func/pkg/functions"
+)
+
+// TestBuildSourceSBOM ensures that the SBOM of the dependencies of the
+// function is generated from its dependency manifest, in either format, and
+// that the image is labeled with its digest.
+func TestBuildSourceSBOM(t *testing.T) {
+	const goMod = `module function
+
+go 1.21
+
+require (
+	github.com/cloudevents/sdk-go/v2 v2.15.2
+	github.com/google/uuid v1.6.0 // indirect
+	example.com/local v0.0.0
+	example.com/forked v1.0.0
+)
+
+replace (
+	example.com/local => ./local
+	example.com/forked => example.com/fork v1.0.1
+)
+`
+	tests := []struct {
+		name     string
+		runtime  string
+		manifest string
+		content  string
+		want     []string // purls
+	}{
+		{
+			name:     "go",
+			runtime:  "go",
+			manifest: "go.mod",
+			content:  goMod,
+			want: []string{
+				"pkg:golang/example.com/fork@v1.0.1",
+				"pkg:golang/github.com/cloudevents/sdk-go/v2@v2.15.2",
+				"pkg:golang/github.com/google/uuid@v1.6.0",
+			},
+		},
+		{
+			name:     "node",
+			runtime:  "node",
+			manifest: "package-lock.json",
+			content: `{"lockfileVersion": 3, "packages": {
+				"": {"name": "function"},
+				"node_modules/express": {"version": "4.19.2"},
+				"node_modules/@scope/util": {"version": "1.0.0"},
+				"node_modules/express/node_modules/debug": {"version": "2.6.9"}}}`,
+			want: []string{"pkg:npm/%40scope/util@1.0.0", "pkg:npm/debug@2.6.9", "pkg:npm/express@4.19.2"},
+		},
+		{
+			name:     "python",
+			runtime:  "python",
+			manifest: "requirements.txt",
+			content:  "# dependencies\n-r base.txt\nFlask==3.0.3\nparliament_functions[extra] == 0.1.0 ; python_version >= '3.9'\nrequests>=2.0\nhttps://example.com/pkg.tar.gz\n",
+			want:     []string{"pkg:pypi/flask@3.0.3", "pkg:pypi/parliament-functions@0.1.0", "pkg:pypi/requests"},
+		},
+	}
+	for _, tt := range tests {
+		for _, format := range []string{s2i.SourceSBOMCycloneDX, s2i.SourceSBOMSPDX} {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				root := t.TempDir()
+				if err := os.WriteFile(filepath.Join(root, tt.manifest), []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if tt.runtime == "go" {
+					impl := "package function\n\ntype F struct{}\n\nfunc New() *F { return nil }\n"
+					if err := os.WriteFile(filepath.Join(root, "f.go"), []byte(impl), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				var labels map[string]string
+				impl := &mockImpl{BuildFn: func(cfg *api.Config) (*api.Result, error) {
+					labels = cfg.Labels
+					return nil, nil
+				}}
+				f := fn.Function{Root: root, Runtime: tt.runtime}
+				b := s2i.NewBuilder(s2i.WithImpl(impl), s2i.WithDockerClient(mockDocker{}), s2i.WithSourceSBOM(format))
+				if err := b.Build(context.Background(), f, nil); err != nil {
+					t.Fatal(err)
+				}
+
+				bb, err := os.ReadFile(s2i.SourceSBOMPath(f, format))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var doc struct {
+					BOMFormat  string `json:"bomFormat"`
+					Components []struct {
+						PURL string `json:"purl"`
+					} `json:"components"`
+					SPDXVersion string `json:"spdxVersion"`
+					Packages    []struct {
+						SPDXID        string `json:"SPDXID"`
+						FilesAnalyzed bool   `json:"filesAnalyzed"`
+						ExternalRefs  []struct {
+							Locator string `json:"referenceLocator"`
+						} `json:"externalRefs"`
+					} `json:"packages"`
+					Relationships []struct {
+						Element string `json:"spdxElementId"`
+						Type    string `json:"relationshipType"`
+						Related string `json:"relatedSpdxElement"`
+					} `json:"relationships"`
+				}
+				if err = json.Unmarshal(bb, &doc); err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				if format == s2i.SourceSBOMCycloneDX {
+					if doc.BOMFormat != "CycloneDX" {
+						t.Fatalf("expected a CycloneDX BOM, got %s", bb)
+					}
+					for _, c := range doc.Components {
+						got = append(got, c.PURL)
+					}
+				} else {
+					if doc.SPDXVersion != "SPDX-2.3" {
+						t.Fatalf("expected an SPDX document, got %s", bb)
+					}
+					// The document describes the function, the first package,
+					// which depends on the others.
+					if len(doc.Relationships) != len(doc.Packages) || doc.Relationships[0].Type != "DESCRIBES" ||
+						doc.Relationships[0].Related != doc.Packages[0].SPDXID {
+						t.Fatalf("expected the document to describe the function, got %s", bb)
+					}
+					for _, p := range doc.Packages[1:] {
+						got = append(got, p.ExternalRefs[0].Locator)
+					}
+					for _, p := range doc.Packages {
+						if p.FilesAnalyzed {
+							t.Fatalf("expected the files of %s not to be analyzed", p.SPDXID)
+						}
+					}
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Fatalf("expected dependencies\n%v\ngot\n%v", tt.want, got)
+				}
+				if !strings.HasPrefix(labels[s2i.SourceSBOMLabel], "sha256:") {
+					t.Fatalf("expected the image to be labeled with the digest of the SBOM, got %v", labels)
+				}
+
+				// The label does not change with the time of the build, of
+				// which SPDX documents are created.
+				if tt.name == "go" {
+					label := labels[s2i.SourceSBOMLabel]
+					time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+					if err = b.Build(context.Background(), f, nil); err != nil {
+						t.Fatal(err)
+					}
+					if labels[s2i.SourceSBOMLabel] != label {
+						t.Fatalf("expected the label of a later build to be %s, got %s", label, labels[s2i.SourceSBOMLabel])
+					}
+				}
+			})
+		}
+	}
+
+	b := s2i.NewBuilder(s2i.WithImpl(&mockImpl{}), s2i.WithDockerClient(mockDocker{}), s2i.WithSourceSBOM("syft"))
+	if err := b.Build(context.Background(), fn.Function{Root: t.TempDir(), Runtime: "node"}, nil); !errors.Is(err, s2i.ErrValidation) {
+		t.Fatalf("expected a validation error for an unknown format, got %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package s2i
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	dockerClient "github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// uploadSrc is the directory of the build context into which S2I places the
+// source of the function.
+const uploadSrc = "upload/src"
+
+// buildState is the record of the last successful build which is persisted
+// when skipping unchanged builds (see WithSkipIfUnchanged).
+type buildState struct {
+	Hash  string `json:"hash"`
+	Image string `json:"image"`
+}
+
+// readBuildState from path.  A missing file yields an empty state.
+func readBuildState(path string) (s buildState, err error) {
+	bb, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return
+	}
+	err = json.Unmarshal(bb, &s)
+	return
+}
+
+// writeBuildState to path, creating its directory if necessary.
+func writeBuildState(path string, s buildState) error {
+	bb, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, bb, 0644)
+}
+
+// upToDate returns a result describing the image of the last successful
+// build if it was built from inputs with the given hash and still exists.
+func (b *Builder) upToDate(ctx context.Context, client DockerClient, hash, image string) (result BuildResult, ok bool, err error) {
+	state, err := readBuildState(b.skipStatePath)
+	if err != nil {
+		return result, false, fmt.Errorf("cannot read build state: %w", err)
+	}
+	if state.Hash != hash || state.Image != image {
+		return
+	}
+	if !b.load {
+		// The image is only in its registry (see WithLoad).
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			return result, false, fmt.Errorf("cannot parse image name: %w", err)
+		}
+		if _, err = remote.Head(ref, b.remoteOptions(ctx)...); err != nil {
+			var terr *transport.Error
+			if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+				return result, false, nil
+			}
+			return result, false, fmt.Errorf("cannot get the image of the last build from its registry: %w", err)
+		}
+		return BuildResult{Image: image, UpToDate: true}, true, nil
+	}
+	img, _, err := client.ImageInspectWithRaw(ctx, image)
+	if dockerClient.IsErrNotFound(err) {
+		return result, false, nil
+	} else if err != nil {
+		return result, false, fmt.Errorf("cannot inspect the image of the last build: %w", err)
+	}
+	return BuildResult{Image: image, Size: img.Size, UpToDate: true}, true, nil
+}
+
+// buildHash returns a hash over the inputs of a build: the source of the
+// function, the effective S2I config, the digests of the builder image and
+// of any runtime image, and the options of the builder which affect the
+// image.
+func (b *Builder) buildHash(ctx context.Context, client DockerClient, f fn.Function, cfg *api.Config) (string, error) {
+	exclude, err := regexp.Compile(cfg.ExcludeRegExp)
+	if err != nil {
+		return "", fmt.Errorf("invalid exclude expression: %w", err)
+	}
+	source, err := b.sourceHash(f, exclude)
+	if err != nil {
+		return "", fmt.Errorf("cannot hash the function source: %w", err)
+	}
+	digest, err := b.builderImageDigest(ctx, client, cfg.BuilderImage)
+	if err != nil {
+		return "", fmt.Errorf("cannot get the digest of the builder image: %w", err)
+	}
+	var runtimeDigest string
+	if cfg.RuntimeImage != "" {
+		if runtimeDigest, err = b.builderImageDigest(ctx, client, cfg.RuntimeImage); err != nil {
+			return "", fmt.Errorf("cannot get the digest of the runtime image: %w", err)
+		}
+	}
+	config, scripts, err := hashedConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+	options, err := b.imageOptions()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "source:%s\nbuilder:%s\nruntime:%s\nscripts:%s\n", source, digest, runtimeDigest, scripts)
+	fmt.Fprintf(h, "config:%s\noptions:%s\n", config, options)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashedConfig returns the S2I config as hashed by buildHash, without the
+// fields which vary between builds of equal images (such as the paths of
+// temporary files and credentials), and a hash of the scripts of a scripts
+// URL of a local directory, which are hashed in place of its path.
+func hashedConfig(cfg *api.Config) (config []byte, scripts string, err error) {
+	c := *cfg
+	c.Source = nil // the source is hashed by content
+	c.AsDockerfile = ""
+	c.Quiet = false
+	c.DockerConfig = nil
+	c.BuilderPullPolicy = ""
+	c.PullAuthentication = api.AuthConfig{}
+	c.RuntimeAuthentication = api.AuthConfig{}
+	c.IncrementalAuthentication = api.AuthConfig{}
+	c.Environment = slices.Clone(cfg.Environment)
+	slices.SortFunc(c.Environment, func(a, b api.EnvironmentSpec) int { return strings.Compare(a.Name, b.Name) })
+	if dir, ok := strings.CutPrefix(c.ScriptsURL, "file://"); ok {
+		if scripts, err = dirHash(dir); err != nil {
+			return nil, "", fmt.Errorf("cannot hash the scripts: %w", err)
+		}
+		c.ScriptsURL = ""
+	}
+	if config, err = json.Marshal(c); err != nil {
+		return nil, "", fmt.Errorf("cannot hash the config: %w", err)
+	}
+	return
+}
+
+// imageOptions returns the options of the builder which affect the image
+// beyond the S2I config, encoded for hashing.
+func (b *Builder) imageOptions() ([]byte, error) {
+	var healthcheck string
+	if b.healthcheck != nil {
+		var err error
+		if healthcheck, err = b.healthcheck.instruction(); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(struct {
+		Invoke             string
+		ExposedPort        int
+		Entrypoint         []string
+		Workdir            string
+		RuntimeUser        string
+		Healthcheck        string
+		DockerfileSyntax   string
+		Target             string
+		AssembleRunPattern string
+		PullMirror         string
+		PullMirrorAll      bool
+		ExtraHosts         []string
+		NormalizeScripts   bool
+		SourceMount        bool
+		ImageFormat        string
+		SBOM, Provenance   bool
+		TagGitSha          bool
+	}{
+		Invoke:             b.invoke, // the scaffolding is not of the source
+		ExposedPort:        b.exposedPort,
+		Entrypoint:         b.entrypoint,
+		Workdir:            b.workdir,
+		RuntimeUser:        b.runtimeUser,
+		Healthcheck:        healthcheck,
+		DockerfileSyntax:   b.dockerfileSyntax,
+		Target:             b.target,
+		AssembleRunPattern: b.assembleRunPattern,
+		PullMirror:         b.pullMirror,
+		PullMirrorAll:      b.pullMirrorAll,
+		ExtraHosts:         b.extraHosts,
+		NormalizeScripts:   b.normalizeScripts,
+		SourceMount:        b.sourceMount,
+		ImageFormat:        string(b.imageFormat),
+		SBOM:               b.sbom,
+		Provenance:         b.provenance,
+		TagGitSha:          b.tagGitSha,
+	})
+}
+
+// dirHash returns a content hash of the files beneath dir.
+func dirHash(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		bb, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), len(bb))
+		h.Write(bb)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ContextHash returns a content hash of the source of the function as it
+// would be included in the build context, applying the same exclusions and
+// filters as a build, without building.  It is the hash of the source
+// considered by WithSkipIfUnchanged, such that tools may use it to decide
+// whether a build is necessary.
+func (b *Builder) ContextHash(f fn.Function) (string, error) {
+	exclude, err := b.contextExclude()
+	if err != nil {
+		return "", err
+	}
+	if b, err = b.withGitignore(f.Root); err != nil {
+		return "", err
+	}
+	return b.sourceHash(f, exclude)
+}
+
+// ContextFiles returns the sorted paths, relative to the function's root and
+// using forward slashes, of the source of the function as it would be
+// included in the build context, applying the same exclusions and filters as
+// a build, without building.  Files generated by func during a build are not
+// listed, nor is .funcignore applied, which S2I honors when copying the
+// source.
+func (b *Builder) ContextFiles(f fn.Function) ([]string, error) {
+	exclude, err := b.contextExclude()
+	if err != nil {
+		return nil, err
+	}
+	if b, err = b.withGitignore(f.Root); err != nil {
+		return nil, err
+	}
+	var files []string
+	err = b.walkSource(f, exclude, func(p, path string, fi fs.FileInfo, lnk string) error {
+		files = append(files, strings.TrimPrefix(p, uploadSrc+"/"))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// contextExclude returns the exclusions of the build context, as configured
+// by the builder's S2I config mutators.
+func (b *Builder) contextExclude() (*regexp.Regexp, error) {
+	cfg := &api.Config{ExcludeRegExp: excludeRegExp}
+	for _, configFn := range b.configFns {
+		configFn(cfg)
+	}
+	exclude, err := regexp.Compile(cfg.ExcludeRegExp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude expression: %w", err)
+	}
+	return exclude, nil
+}
+
+// sourceHash returns a content hash of the source of the function as it
+// would be included in the build context.  Files generated by func during a
+// build are not considered.  Modification times do not affect the hash.
+func (b *Builder) sourceHash(f fn.Function, exclude *regexp.Regexp) (string, error) {
+	h := sha256.New()
+	err := b.walkSource(f, exclude, func(p, path string, fi fs.FileInfo, lnk string) error {
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s\x00", p, fi.Mode(), fi.Size(), filepath.ToSlash(lnk))
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		r, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(h, r)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// walkSource walks the source of the function as it would be included in the
+// build context (see walkContext), skipping files generated by func during a
+// build.  Paths are those of the build context.
+func (b *Builder) walkSource(f fn.Function, exclude *regexp.Regexp, visit func(p, path string, fi fs.FileInfo, lnk string) error) error {
+	return b.walkContext(f.Root, uploadSrc, exclude, func(p, path string, fi fs.FileInfo, lnk string) error {
+		switch {
+		case p == uploadSrc+"/.s2i/builds" && fi.IsDir():
+			return filepath.SkipDir // scaffolding
+		case p == uploadSrc+"/.s2iignore" && fi.Mode()&fs.ModeSymlink != 0:
+			return nil // link to .funcignore
+		}
+		return visit(p, path, fi, lnk)
+	})
+}
+
+// builderImageDigest returns the id of the image if it is in the daemon,
+// otherwise the digest of the image in its registry.
+func (b *Builder) builderImageDigest(ctx context.Context, cli DockerClient, image string) (string, error) {
+	img, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if err == nil {
+		return img.ID, nil
+	} else if !dockerClient.IsErrNotFound(err) {
+		return "", err
+	}
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse image name: %w", err)
+	}
+	desc, err := remote.Head(ref, b.remoteOptions(ctx)...)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest.String(), nil
+}
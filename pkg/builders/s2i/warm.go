@@ -0,0 +1,148 @@
+package s2i
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/openshift/source-to-image/pkg/api"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// goModuleFiles are the files of a Go module which determine its
+// dependencies.
+var goModuleFiles = []string{"go.mod", "go.sum"}
+
+// warmGoModules downloads the modules required by the Go function, and by its
+// scaffolding, into the module cache shared by builds (see
+// WithGoModuleCache): the dependency-only part of its assemble step.  This
+// is done by building an untagged image, of the builder image, which only
+// runs "go mod download" with the cache mounted.
+func (b *Builder) warmGoModules(ctx context.Context, client DockerClient, builderImage string, f fn.Function) error {
+	buildKit, err := b.useBuildKit(ctx, client)
+	if err != nil {
+		return err
+	}
+	if !buildKit {
+		b.logf(LogLevelDebug, "Not warming the Go module cache: it is a cache mount, which requires BuildKit")
+		return nil
+	}
+
+	// The modules are those of the scaffolding, whose main module replaces
+	// the function with ./f, or those of the function itself if built as-is.
+	files := map[string]string{} // path in the context: path on disk
+	fnDir := "deps"
+	if scaffolder := scaffolderOf(f.Runtime); scaffolder != nil && b.scaffolding {
+		out, err := os.MkdirTemp("", "func-warm")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(out)
+		repo, err := scaffoldingRepository(b.scaffoldRepository)
+		if err != nil {
+			return err
+		}
+		if b.invoke != "" {
+			f.Invoke = b.invoke
+		}
+		opts := ScaffoldOptions{Repository: repo, Shell: b.assembleShell, Transforms: b.scaffoldTransforms}
+		if err = scaffolder(&api.Config{}, f, out, opts); err != nil {
+			return err
+		}
+		for _, name := range goModuleFiles {
+			files["deps/"+name] = filepath.Join(out, "builds", "last", name)
+		}
+		fnDir = "deps/f"
+	}
+	for _, name := range goModuleFiles {
+		files[path.Join(fnDir, name)] = filepath.Join(f.Root, name)
+	}
+
+	buildEnvs, err := fn.Interpolate(f.Build.BuildEnvs)
+	if err != nil {
+		return err
+	}
+	envs := b.goModuleCache.envs()
+	if b.goPrivate != "" {
+		envs["GOPRIVATE"] = b.goPrivate
+	}
+	maps.Copy(envs, buildEnvs)
+
+	mounts := []string{b.goModuleCacheMount()}
+	if b.goPrivate != "" {
+		mounts = append(mounts, goNetrcMount)
+	}
+	dockerfile := "FROM " + builderImage + "\n"
+	opts := types.ImageBuildOptions{
+		Version:   types.BuilderBuildKit,
+		BuildArgs: map[string]*string{},
+		SessionID: b.session,
+		Remove:    true,
+	}
+	for _, k := range slices.Sorted(maps.Keys(envs)) {
+		v := envs[k]
+		dockerfile += "ARG " + k + "\n"
+		opts.BuildArgs[k] = &v
+	}
+	dockerfile += "COPY --chown=1001:0 deps/ /tmp/deps/\n" +
+		"WORKDIR /tmp/deps\n" +
+		"RUN " + strings.Join(mounts, " \\\n    ") + " \\\n    go mod download\n"
+
+	context, err := warmContext(dockerfile, files)
+	if err != nil {
+		return err
+	}
+	b.logf(LogLevelInfo, "Downloading the Go modules of the function to the module cache")
+	resp, err := client.ImageBuild(ctx, context, opts)
+	if err != nil {
+		return fmt.Errorf("cannot warm the Go module cache: %w", err)
+	}
+	defer resp.Body.Close()
+	if err = b.displayJSONMessages(resp.Body); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("warming of the Go module cache interrupted: %w", ctx.Err())
+		}
+		return fmt.Errorf("cannot warm the Go module cache: %w", err)
+	}
+	return ctx.Err()
+}
+
+// warmContext returns the build context of the Dockerfile and the files,
+// those absent on disk excepted.
+func warmContext(dockerfile string, files map[string]string) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	write := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write("Dockerfile", []byte(dockerfile)); err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		data, err := os.ReadFile(files[name])
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // such as go.sum of a module without requirements
+		} else if err != nil {
+			return nil, err
+		}
+		if err = write(name, data); err != nil {
+			return nil, err
+		}
+	}
+	return buf, tw.Close()
+}
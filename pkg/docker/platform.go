@@ -1,7 +1,9 @@
 package docker
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"github.com/containerd/platforms"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -14,7 +16,13 @@ import (
 // If the image is not multi-arch it returns ref argument directly (provided platform matches).
 // If the image is multi-arch it returns digest based reference (provided the platform is part of the multi-arch image).
 func GetPlatformImage(ref, platform string) (string, error) {
+	return GetPlatformImageContext(context.Background(), ref, platform)
+}
 
+// GetPlatformImageContext is GetPlatformImage with a context which bounds
+// the requests to the registry, and options of the requests, such as their
+// credentials.
+func GetPlatformImageContext(ctx context.Context, ref, platform string, options ...remote.Option) (string, error) {
 	plat, err := platforms.Parse(platform)
 	if err != nil {
 		return "", fmt.Errorf("cannot parse platform: %w", err)
@@ -25,7 +33,7 @@ func GetPlatformImage(ref, platform string) (string, error) {
 		return "", fmt.Errorf("cannot parse reference: %w", err)
 	}
 
-	desc, err := remote.Get(r)
+	desc, err := remote.Get(r, append([]remote.Option{remote.WithContext(ctx)}, options...)...)
 	if err != nil {
 		return "", fmt.Errorf("cannot get remote image: %w", err)
 	}
@@ -43,11 +51,17 @@ func GetPlatformImage(ref, platform string) (string, error) {
 			return "", fmt.Errorf("cannot get config file for the image: %w", err)
 		}
 
-		if plat.OS == cfg.OS &&
-			plat.Architecture == cfg.Architecture {
+		// The platform of the image is that of its config, as the
+		// descriptor of a single image carries none.
+		imgPlat := platforms.Platform{OS: cfg.OS, Architecture: cfg.Architecture, Variant: cfg.Variant}
+		if matchesPlatform(plat, imgPlat) {
 			return ref, nil
 		}
-		return "", fmt.Errorf("the %q platform is not supported by the %q image", platform, ref)
+		return "", ErrPlatformMismatch{
+			Image:     ref,
+			Platform:  platform,
+			Supported: formatPlatform(imgPlat),
+		}
 	}
 
 	idx, err := desc.ImageIndex()
@@ -64,12 +78,70 @@ func GetPlatformImage(ref, platform string) (string, error) {
 		return "", fmt.Errorf("platform image has too many manifests")
 	}
 
+	var available []string
 	for _, manifest := range idxMft.Manifests {
-		if plat.OS == manifest.Platform.OS &&
-			plat.Architecture == manifest.Platform.Architecture {
+		if manifest.Platform == nil {
+			continue
+		}
+		if matchesPlatform(plat, platforms.Platform{OS: manifest.Platform.OS, Architecture: manifest.Platform.Architecture, Variant: manifest.Platform.Variant}) {
 			return r.Context().Name() + "@" + manifest.Digest.String(), nil
 		}
+		available = append(available, manifest.Platform.OS+"/"+manifest.Platform.Architecture)
+	}
+
+	return "", ErrPlatformNotInIndex{
+		Image:     ref,
+		Platform:  platform,
+		Available: available,
 	}
+}
 
-	return "", fmt.Errorf("the %q platform is not supported by the %q image", platform, ref)
+// matchesPlatform returns whether an image of the platform p provides the
+// requested platform: its OS and architecture are those requested, as are
+// its variant if one is requested, once normalized (such that aarch64 is
+// arm64 and arm is arm/v7).  An image of unknown platform matches none.
+func matchesPlatform(requested, p platforms.Platform) bool {
+	if p.OS == "" || p.Architecture == "" {
+		return false
+	}
+	requested, p = platforms.Normalize(requested), platforms.Normalize(p)
+	return requested.OS == p.OS &&
+		requested.Architecture == p.Architecture &&
+		(requested.Variant == "" || requested.Variant == p.Variant)
+}
+
+// formatPlatform returns the platform as os/arch[/variant], or "unknown" if
+// its OS or architecture is not known.
+func formatPlatform(p platforms.Platform) string {
+	if p.OS == "" || p.Architecture == "" {
+		return "unknown"
+	}
+	return platforms.Format(platforms.Normalize(p))
+}
+
+// ErrPlatformMismatch indicates that a platform was requested of a
+// single-architecture image which is built for a different platform.
+type ErrPlatformMismatch struct {
+	Image     string
+	Platform  string // requested platform
+	Supported string // the single platform of the image
+}
+
+func (e ErrPlatformMismatch) Error() string {
+	return fmt.Sprintf("the %q image is %s, requested %s", e.Image, e.Supported, e.Platform)
+}
+
+// ErrPlatformNotInIndex indicates that a platform was requested of a
+// multi-architecture image whose index does not contain that platform.
+type ErrPlatformNotInIndex struct {
+	Image     string
+	Platform  string   // requested platform
+	Available []string // platforms contained in the index
+}
+
+func (e ErrPlatformNotInIndex) Error() string {
+	if len(e.Available) == 0 {
+		return fmt.Sprintf("the %q platform is not supported by the %q image", e.Platform, e.Image)
+	}
+	return fmt.Sprintf("the %q platform is not supported by the %q image; available platforms are %s", e.Platform, e.Image, strings.Join(e.Available, ", "))
 }
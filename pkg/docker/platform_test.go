@@ -67,13 +67,19 @@ func TestPlatform(t *testing.T) {
 	// end push testing builders to registry
 
 	_, err = docker.GetPlatformImage(nonMultiArchBuilder, "windows/amd64")
-	if err == nil {
-		t.Error("expected error but got nil")
+	var errMismatch docker.ErrPlatformMismatch
+	if !errors.As(err, &errMismatch) {
+		t.Errorf("expected ErrPlatformMismatch but got %v", err)
+	} else if errMismatch.Supported != "linux/ppc64le" {
+		t.Errorf("expected supported platform linux/ppc64le, got %q", errMismatch.Supported)
 	}
 
 	_, err = docker.GetPlatformImage(multiArchBuilder, "windows/amd64")
-	if err == nil {
-		t.Error("expected error but got nil")
+	var errNotInIndex docker.ErrPlatformNotInIndex
+	if !errors.As(err, &errNotInIndex) {
+		t.Errorf("expected ErrPlatformNotInIndex but got %v", err)
+	} else if len(errNotInIndex.Available) != 1 || errNotInIndex.Available[0] != "linux/ppc64le" {
+		t.Errorf("expected available platforms [linux/ppc64le], got %v", errNotInIndex.Available)
 	}
 
 	var ref string
@@ -100,6 +106,62 @@ func TestPlatform(t *testing.T) {
 	}
 }
 
+// TestPlatformSingleArch ensures that a single-architecture image provides
+// only the platform of its config, normalized, and that mismatches name both
+// platforms.
+func TestPlatformSingleArch(t *testing.T) {
+	testRegistry := startRegistry(t)
+
+	tests := []struct {
+		name      string
+		config    v1.ConfigFile
+		platform  string
+		supported string // "" if the platform is provided
+	}{
+		{name: "match", config: v1.ConfigFile{OS: "linux", Architecture: "amd64"}, platform: "linux/amd64"},
+		{name: "alias", config: v1.ConfigFile{OS: "linux", Architecture: "aarch64"}, platform: "linux/arm64"},
+		{name: "default variant", config: v1.ConfigFile{OS: "linux", Architecture: "arm"}, platform: "linux/arm/v7"},
+		{name: "architecture", config: v1.ConfigFile{OS: "linux", Architecture: "amd64"}, platform: "linux/arm64", supported: "linux/amd64"},
+		{name: "variant", config: v1.ConfigFile{OS: "linux", Architecture: "arm", Variant: "v6"}, platform: "linux/arm/v7", supported: "linux/arm/v6"},
+		{name: "os", config: v1.ConfigFile{OS: "windows", Architecture: "amd64"}, platform: "linux/amd64", supported: "windows/amd64"},
+		{name: "unknown", config: v1.ConfigFile{}, platform: "linux/amd64", supported: "unknown"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref := fmt.Sprintf("%s/default/builder:%d", testRegistry, i)
+			tag, err := name.NewTag(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := mutate.ConfigFile(empty.Image, &tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = remote.Write(&tag, img); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := docker.GetPlatformImage(ref, tt.platform)
+			if tt.supported == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != ref {
+					t.Fatalf("expected reference %q, got %q", ref, got)
+				}
+				return
+			}
+			var errMismatch docker.ErrPlatformMismatch
+			if !errors.As(err, &errMismatch) {
+				t.Fatalf("expected ErrPlatformMismatch, got %v", err)
+			}
+			if errMismatch.Supported != tt.supported {
+				t.Errorf("expected supported platform %q, got %q", tt.supported, errMismatch.Supported)
+			}
+		})
+	}
+}
+
 func startRegistry(t *testing.T) (addr string) {
 	s := http.Server{
 		Handler: registry.New(registry.Logger(log.New(io.Discard, "", 0))),
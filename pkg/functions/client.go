@@ -628,6 +628,11 @@ func BuildWithPlatforms(pp []Platform) BuildOption {
 // Build the function at path. Errors if the function is either unloadable or does
 // not contain a populated Image.
 func (c *Client) Build(ctx context.Context, f Function, options ...BuildOption) (Function, error) {
+	// Functions configured to be built on-cluster are built by the pipelines
+	// provider (see RunPipeline), whichever the builder.
+	if f.Build.Type == BuildTypeOnCluster {
+		return f, fmt.Errorf("%w (build.type: %s): build it remotely or set build.type to %s", ErrBuildOnCluster, f.Build.Type, BuildTypeLocal)
+	}
 	fmt.Fprintf(os.Stderr, "Building function image\n")
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -2197,3 +2197,25 @@ func TestClient_BuildPopulatesRuntimeImage(t *testing.T) {
 		t.Fatalf("written image in ./.func/built-image '%s' does not match expected '%s'", got, expect)
 	}
 }
+
+// TestClient_BuildOnCluster ensures that functions configured to be built
+// on-cluster are not built by the (local) builder of the client.
+func TestClient_BuildOnCluster(t *testing.T) {
+	root, cleanup := Mktemp(t)
+	defer cleanup()
+
+	builder := mock.NewBuilder()
+	client := fn.New(fn.WithBuilder(builder))
+	f, err := client.Init(fn.Function{Runtime: "go", Root: root, Registry: TestRegistry})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Build.Type = fn.BuildTypeOnCluster
+	if _, err = client.Build(context.Background(), f); !errors.Is(err, fn.ErrBuildOnCluster) {
+		t.Fatalf("expected ErrBuildOnCluster, got %v", err)
+	}
+	if builder.BuildInvoked {
+		t.Fatal("the builder should not be invoked for functions built on-cluster")
+	}
+}
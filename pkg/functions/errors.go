@@ -23,6 +23,8 @@ var (
 	ErrTemplateNotFound          = errors.New("template not found")
 	ErrTemplatesNotFound         = errors.New("templates path (runtimes) not found")
 	ErrContextCanceled           = errors.New("the operation was canceled")
+	ErrBuildProfileNotFound      = errors.New("build profile not found")
+	ErrBuildOnCluster            = errors.New("function is configured to be built on-cluster")
 
 	// TODO: change the wording of this error to not be CLI-specific;
 	// eg "registry required".  Then catch the error in the CLI and add the
@@ -127,6 +127,17 @@ type BuildSpec struct {
 	//   s2i: example.com/user/my-s2i-node-builder
 	BuilderImages map[string]string `yaml:"builderImages,omitempty"`
 
+	// PlatformBuilderImages optionally pin the builder image to use when
+	// targeting a specific platform, keyed first by the builder's short name
+	// and then by platform.  This is typically used to record exact digests
+	// for reproducible multi-architecture builds.  For example:
+	// platformBuilderImages:
+	//   s2i:
+	//     linux/amd64: example.com/user/builder@sha256:...
+	//     linux/arm64: example.com/user/builder@sha256:...
+	// Platforms not listed fall back to the builder image selected as usual.
+	PlatformBuilderImages map[string]map[string]string `yaml:"platformBuilderImages,omitempty"`
+
 	// Optional list of buildpacks to use when building the function
 	Buildpacks []string `yaml:"buildpacks,omitempty"`
 
@@ -134,6 +145,10 @@ type BuildSpec struct {
 	// build (pack, s2i, etc)
 	Builder string `yaml:"builder,omitempty" jsonschema:"enum=pack,enum=s2i"`
 
+	// Type of the build: local (the default) or on-cluster, in which case the
+	// function is built remotely by a pipeline rather than by a local builder.
+	Type string `yaml:"type,omitempty" jsonschema:"enum=local,enum=on-cluster"`
+
 	// Build Env variables to be set
 	BuildEnvs Envs `yaml:"buildEnvs,omitempty"`
 
@@ -141,11 +156,79 @@ type BuildSpec struct {
 	// when using deployment and remote build process (only relevant when Remote is true).
 	PVCSize string `yaml:"pvcSize,omitempty"`
 
+	// Profiles are named sets of overrides which can be layered over the
+	// build settings, for example to use a different builder image during
+	// development than in CI.  For example:
+	// profiles:
+	//   dev:
+	//     builderImages:
+	//       s2i: example.com/user/my-dev-builder
+	Profiles map[string]BuildProfile `yaml:"profiles,omitempty"`
+
 	// Image stores last built image name NOT in func.yaml, but instead
 	// in .func/built-image
 	Image string `yaml:"-"`
 }
 
+// Types of builds (see BuildSpec.Type).
+const (
+	BuildTypeLocal     = "local"
+	BuildTypeOnCluster = "on-cluster"
+)
+
+// validateBuildType returns an error if the build type is neither empty nor
+// one of the known types.
+func validateBuildType(t string) (errors []string) {
+	switch t {
+	case "", BuildTypeLocal, BuildTypeOnCluster:
+		return
+	}
+	return []string{fmt.Sprintf("build type %q is not valid: must be %q or %q", t, BuildTypeLocal, BuildTypeOnCluster)}
+}
+
+// BuildProfile defines overrides of build settings.
+type BuildProfile struct {
+	// BuilderImages override those of the build by builder short name.
+	BuilderImages map[string]string `yaml:"builderImages,omitempty"`
+
+	// BuildEnvs override those of the build by name.
+	BuildEnvs Envs `yaml:"buildEnvs,omitempty"`
+}
+
+// WithProfile returns the build settings with the overrides of the named
+// profile layered over them.  The original settings are not modified.
+func (b BuildSpec) WithProfile(name string) (BuildSpec, error) {
+	p, ok := b.Profiles[name]
+	if !ok {
+		return b, fmt.Errorf("%w: %q", ErrBuildProfileNotFound, name)
+	}
+
+	images := make(map[string]string, len(b.BuilderImages)+len(p.BuilderImages))
+	for k, v := range b.BuilderImages {
+		images[k] = v
+	}
+	for k, v := range p.BuilderImages {
+		images[k] = v
+	}
+	b.BuilderImages = images
+
+	envs := make(Envs, 0, len(b.BuildEnvs)+len(p.BuildEnvs))
+	overridden := make(map[string]bool, len(p.BuildEnvs))
+	for _, e := range p.BuildEnvs {
+		if e.Name != nil {
+			overridden[*e.Name] = true
+		}
+	}
+	for _, e := range b.BuildEnvs {
+		if e.Name == nil || !overridden[*e.Name] {
+			envs = append(envs, e)
+		}
+	}
+	b.BuildEnvs = append(envs, p.BuildEnvs...)
+
+	return b, nil
+}
+
 // RunSpec
 type RunSpec struct {
 	// List of volumes to be mounted to the function
@@ -274,6 +357,15 @@ func NewFunction(root string) (f Function, err error) {
 	if marshallingErr := yaml.Unmarshal(bb, &f); marshallingErr != nil {
 		functionMarshallingError = formatUnmarshalError(marshallingErr) // human-friendly unmarshalling errors
 	}
+	// The build section of a function already at the latest spec version is
+	// validated strictly such that typos are not silently ignored.  Earlier
+	// versions may legitimately differ, and are brought up to date by the
+	// migrations.
+	if f.Migrated() {
+		if err = validateBuildSection(bb); err != nil {
+			return
+		}
+	}
 	if f, err = f.Migrate(); err != nil {
 		functionMigrationError = err
 	}
@@ -314,6 +406,7 @@ func (f Function) Validate() error {
 		validateOptions(f.Deploy.Options),
 		ValidateLabels(f.Deploy.Labels),
 		validateGit(f.Build.Git),
+		validateBuildType(f.Build.Type),
 	}
 
 	var b strings.Builder
@@ -636,6 +729,28 @@ func formatUnmarshalError(err error) error {
 	return errors.New(e)
 }
 
+// validateBuildSection of the given serialized function, returning an error
+// listing any unknown keys or values of the wrong type along with the line
+// on which they were found.
+func validateBuildSection(bb []byte) error {
+	var v struct {
+		Build BuildSpec              `yaml:"build"`
+		Other map[string]interface{} `yaml:",inline"` // not validated
+	}
+	var typeErr *yaml.TypeError
+	if err := yaml.UnmarshalStrict(bb, &v); !errors.As(err, &typeErr) {
+		return nil // syntax errors are reported when unmarshalling the function
+	}
+
+	rxp := regexp.MustCompile(`field (\S+) not found in type .*`)
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("'%v' build section is not valid:", FunctionFile))
+	for _, e := range typeErr.Errors {
+		b.WriteString("\n  " + rxp.ReplaceAllString(e, "unknown field \"$1\""))
+	}
+	return errors.New(b.String())
+}
+
 // Regex used during instantiation and validation of various function fields
 // by labels, envs, options, etc.
 var (
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"gopkg.in/yaml.v2"
@@ -80,6 +81,56 @@ func TestFunction_Validate(t *testing.T) {
 
 }
 
+// TestFunction_BuildSectionValidated ensures that unknown keys and values of
+// the wrong type in the build section of a current func.yaml are reported
+// with their line numbers when the function is loaded.
+func TestFunction_BuildSectionValidated(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   string
+		wantErr []string
+	}{
+		{
+			name:  "valid",
+			build: "build:\n  builder: s2i\n  builderImages:\n    s2i: example.com/builder\n",
+		},
+		{
+			name:    "unknown key",
+			build:   "build:\n  builder: s2i\n  builderImage: example.com/builder\n",
+			wantErr: []string{"line 6", `unknown field "builderImage"`},
+		},
+		{
+			name:    "wrong type",
+			build:   "build:\n  buildpacks: example.com/buildpack\n",
+			wantErr: []string{"line 5", "cannot unmarshal"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			content := "specVersion: " + LastSpecVersion() + "\nname: f\nruntime: go\n" + tt.build
+			if err := os.WriteFile(filepath.Join(root, FunctionFile), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := NewFunction(root)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error loading the function")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
 func TestFunction_ImageWithDigest(t *testing.T) {
 	type fields struct {
 		Image       string
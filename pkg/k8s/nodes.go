@@ -0,0 +1,52 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	fn "knative.dev/func/pkg/functions"
+)
+
+// NodePlatforms returns the distinct platforms of the schedulable nodes of
+// the cluster, sorted by os/arch, such that images can be built for the
+// nodes on which they will run.  The platform of a node is that which its
+// kubelet reports, or that of its kubernetes.io/os and kubernetes.io/arch
+// labels.
+func NodePlatforms(ctx context.Context, client kubernetes.Interface) ([]fn.Platform, error) {
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the nodes of the cluster: %w", err)
+	}
+	var platforms []fn.Platform
+	for _, n := range nodes.Items {
+		if n.Spec.Unschedulable {
+			continue
+		}
+		p, ok := nodePlatform(n)
+		if ok && !slices.Contains(platforms, p) {
+			platforms = append(platforms, p)
+		}
+	}
+	slices.SortFunc(platforms, func(a, b fn.Platform) int {
+		return strings.Compare(a.OS+"/"+a.Architecture, b.OS+"/"+b.Architecture)
+	})
+	return platforms, nil
+}
+
+// nodePlatform returns the platform of the node, if known.
+func nodePlatform(n corev1.Node) (fn.Platform, bool) {
+	p := fn.Platform{OS: n.Status.NodeInfo.OperatingSystem, Architecture: n.Status.NodeInfo.Architecture}
+	if p.OS == "" {
+		p.OS = n.Labels[corev1.LabelOSStable]
+	}
+	if p.Architecture == "" {
+		p.Architecture = n.Labels[corev1.LabelArchStable]
+	}
+	return p, p.OS != "" && p.Architecture != ""
+}
@@ -0,0 +1,42 @@
+package k8s_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	fn "knative.dev/func/pkg/functions"
+	"knative.dev/func/pkg/k8s"
+)
+
+// TestNodePlatforms ensures that the platforms of the schedulable nodes are
+// listed once each, from the kubelet or the labels of the node.
+func TestNodePlatforms(t *testing.T) {
+	node := func(name, os, arch string, labels map[string]string, unschedulable bool) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{OperatingSystem: os, Architecture: arch}},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		node("a", "linux", "arm64", nil, false),
+		node("b", "linux", "amd64", nil, false),
+		node("c", "linux", "arm64", nil, false),
+		node("d", "", "", map[string]string{corev1.LabelOSStable: "linux", corev1.LabelArchStable: "s390x"}, false),
+		node("e", "linux", "ppc64le", nil, true),
+	)
+
+	platforms, err := k8s.NodePlatforms(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []fn.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}, {OS: "linux", Architecture: "s390x"}}
+	if !reflect.DeepEqual(platforms, want) {
+		t.Fatalf("expected platforms %v, got %v", want, platforms)
+	}
+}
@@ -3,7 +3,7 @@ package k8s
 import (
 	"github.com/Masterminds/semver"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/discovery"
 )
 
 var oneTwentyFour = semver.MustParse("1.24")
@@ -50,7 +50,30 @@ func defaultPodSecurityContext() *corev1.PodSecurityContext {
 	}
 }
 
-func defaultSecurityContext(client *kubernetes.Clientset) *corev1.SecurityContext {
+func defaultSecurityContext(client discovery.ServerVersionInterface) *corev1.SecurityContext {
+	sc, _ := securityContext(client)
+	return sc
+}
+
+// SecurityContextDecision records the inputs from which the security context
+// of the pods created by func was derived, for diagnosing their rejection by
+// a cluster.
+type SecurityContextDecision struct {
+	// ServerVersion of the cluster, or "" if it could not be determined.
+	ServerVersion string
+	// SeccompProfile set, or "" if none, which is the case for clusters older
+	// than 1.24 or of unknown version.
+	SeccompProfile corev1.SeccompProfileType
+}
+
+// DefaultSecurityContext returns the security context of the pods created by
+// func along with the decision from which it was derived.
+func DefaultSecurityContext(client discovery.ServerVersionInterface) (*corev1.SecurityContext, SecurityContextDecision) {
+	return securityContext(client)
+}
+
+func securityContext(client discovery.ServerVersionInterface) (*corev1.SecurityContext, SecurityContextDecision) {
+	var decision SecurityContextDecision
 	runAsNonRoot := false
 	zero := int64(0)
 
@@ -64,10 +87,12 @@ func defaultSecurityContext(client *kubernetes.Clientset) *corev1.SecurityContex
 	}
 
 	if info, err := client.ServerVersion(); err == nil {
+		decision.ServerVersion = info.String()
 		if v, err := semver.NewVersion(info.String()); err == nil && v.Compare(oneTwentyFour) >= 0 {
 			sc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+			decision.SeccompProfile = sc.SeccompProfile.Type
 		}
 	}
 
-	return sc
+	return sc, decision
 }
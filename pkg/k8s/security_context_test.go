@@ -0,0 +1,38 @@
+package k8s
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSecurityContextDecision(t *testing.T) {
+	tests := []struct {
+		version string
+		seccomp corev1.SeccompProfileType
+	}{
+		{version: "v1.23.17", seccomp: ""},
+		{version: "v1.25.16", seccomp: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			client := &fakediscovery.FakeDiscovery{
+				Fake:               &k8stesting.Fake{},
+				FakedServerVersion: &version.Info{GitVersion: tt.version},
+			}
+			sc, decision := securityContext(client)
+			if decision.ServerVersion != tt.version {
+				t.Errorf("expected server version %q, got %q", tt.version, decision.ServerVersion)
+			}
+			if decision.SeccompProfile != tt.seccomp {
+				t.Errorf("expected seccomp profile %q, got %q", tt.seccomp, decision.SeccompProfile)
+			}
+			if got := sc.SeccompProfile != nil; got != (tt.seccomp != "") {
+				t.Errorf("expected the security context to agree with the decision, got %+v", sc.SeccompProfile)
+			}
+		})
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 
+	"knative.dev/func/pkg/builders"
 	fn "knative.dev/func/pkg/functions"
 	"knative.dev/func/pkg/scaffolding"
 )
@@ -33,11 +35,30 @@ type Builder struct {
 
 	onDone  func()               // optionally provide a function to be notified on done
 	buildFn languageLayerBuilder // optionally provide a custom build impl
+
+	format builders.ImageFormat // media types of the image (default OCI)
+}
+
+// Option configures a Builder.
+type Option func(*Builder)
+
+// WithImageFormat sets the media types of the built image: those of the OCI
+// image spec (builders.OCI, the default) or of the Docker image manifest
+// v2, schema 2 (builders.DockerV2).  The layout on disk is an OCI layout in
+// either case.
+func WithImageFormat(format builders.ImageFormat) Option {
+	return func(b *Builder) {
+		b.format = format
+	}
 }
 
 // NewBuilder creates a builder instance.
-func NewBuilder(name string, verbose bool) *Builder {
-	return &Builder{name, verbose, nil, nil}
+func NewBuilder(name string, verbose bool, options ...Option) *Builder {
+	b := &Builder{name: name, verbose: verbose}
+	for _, o := range options {
+		o(b)
+	}
+	return b
 }
 
 func newBuildConfig(ctx context.Context, b *Builder, f fn.Function, platforms []fn.Platform) *buildConfig {
@@ -51,6 +72,7 @@ func newBuildConfig(ctx context.Context, b *Builder, f fn.Function, platforms []
 		toPlatforms(platforms),
 		b.onDone,
 		b.buildFn,
+		b.format,
 	}
 	// If the client did not specifically request a certain set of platforms,
 	// use the func core defined set of suggested defaults.
@@ -69,6 +91,9 @@ func newBuildConfig(ctx context.Context, b *Builder, f fn.Function, platforms []
 //
 //	.func/builds/last
 func (b *Builder) Build(ctx context.Context, f fn.Function, pp []fn.Platform) (err error) {
+	if b.format != "" && b.format != builders.OCI && b.format != builders.DockerV2 {
+		return fmt.Errorf("unsupported image format %q", b.format)
+	}
 	cfg := newBuildConfig(ctx, b, f, pp)
 
 	if err = setup(cfg); err != nil {
@@ -120,6 +145,16 @@ type buildConfig struct {
 	platforms []v1.Platform
 	onDone    func()               // optionally provide a function to be notified on done
 	buildFn   languageLayerBuilder // optionally provide a custom build impl
+	format    builders.ImageFormat // media types of the image
+}
+
+// mediaTypes returns the media types of the layers, config, image manifests
+// and index of the image.
+func (c *buildConfig) mediaTypes() (layer, config, manifest, index types.MediaType) {
+	if c.format == builders.DockerV2 {
+		return types.DockerLayer, types.DockerConfigJSON, types.DockerManifestSchema2, types.DockerManifestList
+	}
+	return types.OCILayer, types.OCIConfigJSON, types.OCIManifestSchema1, types.OCIImageIndex
 }
 
 func (c *buildConfig) hash() string {
@@ -19,6 +19,10 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"knative.dev/func/pkg/builders"
 	fn "knative.dev/func/pkg/functions"
 	. "knative.dev/func/pkg/testing"
 )
@@ -186,6 +190,77 @@ func TestBuilder_Concurrency(t *testing.T) {
 	wg.Wait()
 }
 
+// TestBuilder_ImageFormat ensures that the media types of the image written
+// are those of the requested format.
+func TestBuilder_ImageFormat(t *testing.T) {
+	tests := []struct {
+		format                         builders.ImageFormat
+		layer, config, manifest, index types.MediaType
+	}{
+		{"", types.OCILayer, types.OCIConfigJSON, types.OCIManifestSchema1, types.OCIImageIndex},
+		{builders.OCI, types.OCILayer, types.OCIConfigJSON, types.OCIManifestSchema1, types.OCIImageIndex},
+		{builders.DockerV2, types.DockerLayer, types.DockerConfigJSON, types.DockerManifestSchema2, types.DockerManifestList},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			root, done := Mktemp(t)
+			defer done()
+
+			f, err := fn.New().Init(fn.Function{Root: root, Runtime: "go"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			builder := NewBuilder("", false, WithImageFormat(tt.format))
+			builder.buildFn = func(cfg *buildConfig, p v1.Platform) (d v1.Descriptor, l v1.Layer, err error) {
+				l = static.NewLayer([]byte("exec"), types.OCILayer)
+				d, err = newDescriptor(cfg, l)
+				return
+			}
+			if err = builder.Build(context.Background(), f, TestPlatforms); err != nil {
+				t.Fatal(err)
+			}
+
+			last := path(f.Root, fn.RunDataDir, "builds", "last", "oci")
+			index, err := layout.ImageIndexFromPath(last)
+			if err != nil {
+				t.Fatal(err)
+			}
+			indexManifest, err := index.IndexManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if indexManifest.MediaType != tt.index {
+				t.Errorf("expected index media type %q, got %q", tt.index, indexManifest.MediaType)
+			}
+			for _, desc := range indexManifest.Manifests {
+				if desc.MediaType != tt.manifest {
+					t.Errorf("expected manifest descriptor media type %q, got %q", tt.manifest, desc.MediaType)
+				}
+				bb, err := os.ReadFile(filepath.Join(last, "blobs", desc.Digest.Algorithm, desc.Digest.Hex))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var manifest v1.Manifest
+				if err = json.Unmarshal(bb, &manifest); err != nil {
+					t.Fatal(err)
+				}
+				if manifest.MediaType != tt.manifest {
+					t.Errorf("expected manifest media type %q, got %q", tt.manifest, manifest.MediaType)
+				}
+				if manifest.Config.MediaType != tt.config {
+					t.Errorf("expected config media type %q, got %q", tt.config, manifest.Config.MediaType)
+				}
+				for _, l := range manifest.Layers {
+					if l.MediaType != tt.layer {
+						t.Errorf("expected layer media type %q, got %q", tt.layer, l.MediaType)
+					}
+				}
+			}
+		})
+	}
+}
+
 func isFirstBuild(cfg *buildConfig, current v1.Platform) bool {
 	first := cfg.platforms[0]
 	return current.OS == first.OS &&
@@ -16,7 +16,6 @@ import (
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
-	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/pkg/errors"
 )
 
@@ -114,7 +113,7 @@ func newDataLayer(cfg *buildConfig) (desc v1.Descriptor, layer v1.Layer, err err
 	}
 
 	// Descriptor
-	if desc, err = newDescriptor(layer); err != nil {
+	if desc, err = newDescriptor(cfg, layer); err != nil {
 		return
 	}
 
@@ -246,7 +245,7 @@ func newCertsLayer(cfg *buildConfig) (desc v1.Descriptor, layer v1.Layer, err er
 	}
 
 	// Descriptor
-	if desc, err = newDescriptor(layer); err != nil {
+	if desc, err = newDescriptor(cfg, layer); err != nil {
 		return
 	}
 
@@ -311,7 +310,7 @@ func newCertsTarball(source, target string, ignored []string, verbose bool) erro
 	return nil
 }
 
-func newDescriptor(layer v1.Layer) (desc v1.Descriptor, err error) {
+func newDescriptor(cfg *buildConfig, layer v1.Layer) (desc v1.Descriptor, err error) {
 	size, err := layer.Size()
 	if err != nil {
 		return
@@ -320,8 +319,9 @@ func newDescriptor(layer v1.Layer) (desc v1.Descriptor, err error) {
 	if err != nil {
 		return
 	}
+	mediaType, _, _, _ := cfg.mediaTypes()
 	return v1.Descriptor{
-		MediaType: types.OCILayer,
+		MediaType: mediaType,
 		Size:      size,
 		Digest:    digest,
 	}, nil
@@ -348,9 +348,10 @@ func newImage(cfg *buildConfig, dataDesc v1.Descriptor, dataLayer v1.Layer, cert
 	}
 
 	// Image Manifest
+	_, _, manifestType, _ := cfg.mediaTypes()
 	image := v1.Manifest{
 		SchemaVersion: 2,
-		MediaType:     types.OCIManifestSchema1,
+		MediaType:     manifestType,
 		Config:        configDesc,
 		Layers:        []v1.Descriptor{dataDesc, certsDesc, execDesc},
 	}
@@ -380,7 +381,7 @@ func newImage(cfg *buildConfig, dataDesc v1.Descriptor, dataLayer v1.Layer, cert
 		return
 	}
 	imageDesc = v1.Descriptor{
-		MediaType: types.OCIManifestSchema1,
+		MediaType: manifestType,
 		Digest:    hash,
 		Size:      size,
 		Platform:  &p,
@@ -466,8 +467,9 @@ func newConfig(cfg *buildConfig, p v1.Platform, layers ...v1.Layer) (desc v1.Des
 	if err != nil {
 		return
 	}
+	_, configType, _, _ := cfg.mediaTypes()
 	desc = v1.Descriptor{
-		MediaType: types.OCIConfigJSON,
+		MediaType: configType,
 		Digest:    hash,
 		Size:      size,
 	}
@@ -525,9 +527,10 @@ func newConfigEnvs(cfg *buildConfig) []string {
 }
 
 func newImageIndex(cfg *buildConfig, imageDescs []v1.Descriptor) (index v1.IndexManifest, err error) {
+	_, _, _, indexType := cfg.mediaTypes()
 	index = v1.IndexManifest{
 		SchemaVersion: 2,
-		MediaType:     types.OCIImageIndex,
+		MediaType:     indexType,
 		Manifests:     imageDescs,
 	}
 
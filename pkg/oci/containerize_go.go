@@ -39,7 +39,7 @@ func buildGoLayer(cfg *buildConfig, p v1.Platform) (desc v1.Descriptor, layer v1
 	}
 
 	// Descriptor
-	if desc, err = newDescriptor(layer); err != nil {
+	if desc, err = newDescriptor(cfg, layer); err != nil {
 		return
 	}
 	desc.Platform = &p